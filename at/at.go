@@ -0,0 +1,312 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package at implements an engine for issuing AT (Hayes, V.250)
+// commands to modems, and receiving their responses, over serial
+// ports.
+//
+// A command is sent terminated with a CR. Its response consists of
+// information lines, followed by a final result: OK, CONNECT, ERROR,
+// +CME ERROR, +CMS ERROR, NO CARRIER, BUSY, NO ANSWER, NO DIALTONE,
+// or any custom result given with the command (see Cmd). The echo of
+// the command, if the modem has echo enabled, is skipped. Command
+// responses must be in verbose form (ATV1).
+//
+// Unsolicited result codes
+//
+// Modems send unsolicited result codes (URCs, e.g. RING, or +CREG:
+// network registration reports) at any time, even in the middle of
+// command responses. Handlers for them are registered, by prefix,
+// with Engine.Handle. Lines received that match a registered prefix
+// are passed to the respective handler, instead of being added to
+// the response of the command in flight. The exception is lines
+// matching the command's own response prefix (e.g. +CREG: for the
+// AT+CREG? command), which are always considered part of the
+// response. URCs are received while commands are in flight, and
+// while Engine.Poll is called.
+package at
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Port is the interface of the ports the engine operates on. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// DefaultTimeout is the default command timeout
+const DefaultTimeout = 5 * time.Second
+
+// MaxLine is the max length of response lines. Longer lines are
+// split.
+const MaxLine = 1024
+
+// ErrTimeout is returned if a command's final result is not received
+// in time.
+var ErrTimeout = errors.New("at: command timed out")
+
+// Error is returned for commands that complete with a final result
+// signifying an error (ERROR, +CME ERROR, +CMS ERROR, NO CARRIER,
+// BUSY, NO ANSWER, or NO DIALTONE).
+type Error struct {
+	Result string // The final result, e.g. "+CME ERROR: 10"
+	Code   int    // Numeric +CME or +CMS error code, or -1
+}
+
+func (e *Error) Error() string {
+	return "at: " + e.Result
+}
+
+// Response is the response to a command
+type Response struct {
+	Lines  []string // Information lines
+	Result string   // Final result, e.g. "OK", or "CONNECT 9600"
+}
+
+// Cmd is a command, along with its options
+type Cmd struct {
+	Text    string        // Command (e.g. "AT+CSQ"), without the CR
+	Timeout time.Duration // Zero means Engine.Timeout
+
+	// Additional final results (prefixes) that complete the
+	// command successfully.
+	Final []string
+}
+
+// Handler handles unsolicited result codes. It is called with the
+// line received.
+type Handler func(line string)
+
+type urc struct {
+	prefix string
+	h      Handler
+}
+
+// Final results (prefixes) signifying errors
+var errResults = []string{
+	"ERROR", "+CME ERROR", "+CMS ERROR",
+	"NO CARRIER", "BUSY", "NO ANSWER", "NO DIALTONE",
+}
+
+// Interval for checking the context
+const pollInterval = 100 * time.Millisecond
+
+// Engine issues AT commands over a port. Fields can be changed
+// between commands. Commands can be issued concurrently; they are
+// carried out one at a time. Handlers are called from the goroutine
+// issuing the command (or calling Poll); they must not call Engine
+// methods.
+type Engine struct {
+	Timeout time.Duration // Zero means DefaultTimeout
+
+	port Port
+	mu   sync.Mutex
+	urcs []urc // Sorted by decreasing prefix length
+	rbuf []byte
+	pend []byte // Received, not yet consumed
+	cr   bool   // Last line ended with a CR
+}
+
+// NewEngine returns an Engine that issues commands over port p
+func NewEngine(p Port) *Engine {
+	return &Engine{port: p, rbuf: make([]byte, MaxLine)}
+}
+
+// Handle registers h as the handler for unsolicited result codes
+// starting with prefix (e.g. "+CREG:", or "RING"). If prefixes
+// overlap, the longest one that matches is used. If h is nil, the
+// handler for prefix is removed.
+func (e *Engine) Handle(prefix string, h Handler) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for i, u := range e.urcs {
+		if u.prefix == prefix {
+			e.urcs = append(e.urcs[:i], e.urcs[i+1:]...)
+			break
+		}
+	}
+	if h == nil {
+		return
+	}
+	i := 0
+	for i < len(e.urcs) && len(e.urcs[i].prefix) >= len(prefix) {
+		i++
+	}
+	e.urcs = append(e.urcs, urc{})
+	copy(e.urcs[i+1:], e.urcs[i:])
+	e.urcs[i] = urc{prefix, h}
+}
+
+// handler returns the handler for line l, or nil. Must be called
+// with e.mu held.
+func (e *Engine) handler(l string) Handler {
+	for _, u := range e.urcs {
+		if strings.HasPrefix(l, u.prefix) {
+			return u.h
+		}
+	}
+	return nil
+}
+
+// Buffered returns the data received, but not consumed as response
+// lines, and removes them from the engine. After a command
+// completing with CONNECT, these are the first data received from
+// the remote end.
+func (e *Engine) Buffered() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.cr && len(e.pend) > 0 && e.pend[0] == '\n' {
+		e.pend = e.pend[1:]
+	}
+	e.cr = false
+	b := append([]byte(nil), e.pend...)
+	e.pend = e.pend[:0]
+	return b
+}
+
+// respPrefix returns the prefix of the information lines of the
+// response to command cmd (e.g. "+CSQ:" for "AT+CSQ"), or "" if the
+// command is not an extended one.
+func respPrefix(cmd string) string {
+	if len(cmd) < 3 || !strings.EqualFold(cmd[:2], "AT") {
+		return ""
+	}
+	cmd = cmd[2:]
+	if c := cmd[0]; c != '+' && c != '$' && c != '^' && c != '%' &&
+		c != '*' {
+		return ""
+	}
+	if i := strings.IndexAny(cmd, "=?;"); i >= 0 {
+		cmd = cmd[:i]
+	}
+	return strings.ToUpper(cmd) + ":"
+}
+
+// final checks if line l is a final result for command c. If it is
+// an error result, it returns the respective *Error.
+func (c *Cmd) final(l string) (bool, error) {
+	if l == "OK" || strings.HasPrefix(l, "CONNECT") {
+		return true, nil
+	}
+	for _, p := range c.Final {
+		if strings.HasPrefix(l, p) {
+			return true, nil
+		}
+	}
+	for _, p := range errResults {
+		if !strings.HasPrefix(l, p) {
+			continue
+		}
+		err := &Error{Result: l, Code: -1}
+		if i := strings.IndexByte(l, ':'); i >= 0 && p[0] == '+' {
+			s := strings.TrimSpace(l[i+1:])
+			if v, e := strconv.Atoi(s); e == nil {
+				err.Code = v
+			}
+		}
+		return true, err
+	}
+	return false, nil
+}
+
+// Command issues the command with text cmd (e.g. "AT+CSQ"), with the
+// default timeout, and returns its response. If the final result is
+// an error, the response is returned along with an *Error.
+func (e *Engine) Command(cmd string) (*Response, error) {
+	return e.Exec(context.Background(), &Cmd{Text: cmd})
+}
+
+// Exec issues command c, and returns its response. If the final
+// result is an error, the response is returned along with an *Error.
+// If the context is canceled before the final result is received,
+// the context's error is returned.
+func (e *Engine) Exec(ctx context.Context, c *Cmd) (*Response, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tmo := c.Timeout
+	if tmo <= 0 {
+		tmo = e.Timeout
+	}
+	if tmo <= 0 {
+		tmo = DefaultTimeout
+	}
+	dl := time.Now().Add(tmo)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	// Dispatch URCs received since the last command, and drop
+	// anything else (e.g. late responses to timed-out commands).
+	if err := e.dispatch(ctx, time.Now()); err != nil {
+		return nil, err
+	}
+	e.port.SetWriteDeadline(dl)
+	if _, err := e.port.Write([]byte(c.Text + "\r")); err != nil {
+		return nil, err
+	}
+	prefix := respPrefix(c.Text)
+	r := &Response{}
+	for {
+		l, err := e.readLine(ctx, dl)
+		if err != nil {
+			return nil, err
+		}
+		if len(r.Lines) == 0 && l == c.Text {
+			// Echo
+			continue
+		}
+		if final, err := c.final(l); final {
+			r.Result = l
+			return r, err
+		}
+		if prefix == "" || !strings.HasPrefix(l, prefix) {
+			if h := e.handler(l); h != nil {
+				h(l)
+				continue
+			}
+		}
+		r.Lines = append(r.Lines, l)
+	}
+}
+
+// Poll receives unsolicited result codes, and passes them to the
+// respective handlers, for duration d, or until the context is
+// canceled. Lines received with no handler registered for them are
+// dropped. Poll returns nil after d elapses, or the context's error.
+func (e *Engine) Poll(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dispatch(ctx, time.Now().Add(d))
+}
+
+// dispatch receives lines until dl, passing the URCs to their
+// handlers, and dropping other lines. Must be called with e.mu held.
+func (e *Engine) dispatch(ctx context.Context, dl time.Time) error {
+	for {
+		l, err := e.readLine(ctx, dl)
+		if err == ErrTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h := e.handler(l); h != nil {
+			h(l)
+		}
+	}
+}
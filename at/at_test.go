@@ -0,0 +1,169 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package at
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+var bg = context.Background()
+
+// modem emulates a modem on p, with echo on. For each command
+// received, it sends the response in script (if any).
+func modem(p *pipe.End, script map[string]string) {
+	var cmd []byte
+	b := make([]byte, 64)
+	for {
+		n, err := p.Read(b)
+		if err != nil {
+			return
+		}
+		for _, c := range b[:n] {
+			if c != '\r' {
+				cmd = append(cmd, c)
+				continue
+			}
+			r, ok := script[string(cmd)]
+			p.Write(append(cmd, '\r'))
+			cmd = cmd[:0]
+			if ok {
+				p.Write([]byte(r))
+			}
+		}
+	}
+}
+
+func TestCommand(t *testing.T) {
+	pe, pm := pipe.New()
+	go modem(pm, map[string]string{
+		"AT":        "\r\nOK\r\n",
+		"ATI":       "\r\nACME Modem\r\nRev 1.0\r\n\r\nOK\r\n",
+		"AT+CSQ":    "\r\n+CREG: 1\r\n+CSQ: 20,99\r\n\r\nOK\r\n",
+		"AT+CREG?":  "\r\n+CREG: 0,1\r\nRING\r\n\r\nOK\r\n",
+		"AT+CPIN?":  "\r\n+CME ERROR: 10\r\n",
+		"AT+COPS=?": "\r\n+CME ERROR: SIM not inserted\r\n",
+		"ATX":       "\r\nERROR\r\n",
+		"AT+CMGS":   "\r\n+CMGS: 12\r\n\r\nSENT\r\n",
+	})
+	e := NewEngine(pe)
+	var urcs []string
+	e.Handle("+CREG:", func(l string) { urcs = append(urcs, l) })
+	e.Handle("RING", func(l string) { urcs = append(urcs, l) })
+
+	for _, tst := range []struct {
+		cmd    string
+		lines  []string
+		result string
+		err    *Error
+	}{
+		{"AT", nil, "OK", nil},
+		{"ATI", []string{"ACME Modem", "Rev 1.0"}, "OK", nil},
+		{"AT+CSQ", []string{"+CSQ: 20,99"}, "OK", nil},
+		{"AT+CREG?", []string{"+CREG: 0,1"}, "OK", nil},
+		{"AT+CPIN?", nil, "+CME ERROR: 10",
+			&Error{"+CME ERROR: 10", 10}},
+		{"AT+COPS=?", nil, "+CME ERROR: SIM not inserted",
+			&Error{"+CME ERROR: SIM not inserted", -1}},
+		{"ATX", nil, "ERROR", &Error{"ERROR", -1}},
+	} {
+		r, err := e.Command(tst.cmd)
+		if tst.err != nil {
+			if e, ok := err.(*Error); !ok || *e != *tst.err {
+				t.Fatalf("%s: Error: %v", tst.cmd, err)
+			}
+		} else if err != nil {
+			t.Fatalf("%s: %v", tst.cmd, err)
+		}
+		lines := strings.Join(r.Lines, "|")
+		if r.Result != tst.result ||
+			lines != strings.Join(tst.lines, "|") {
+			t.Fatalf("%s: Response: %+v", tst.cmd, r)
+		}
+	}
+	if strings.Join(urcs, "|") != "+CREG: 1|RING" {
+		t.Fatalf("URCs: %q", urcs)
+	}
+
+	// Custom final result
+	r, err := e.Exec(bg, &Cmd{Text: "AT+CMGS", Final: []string{"SENT"}})
+	if err != nil || r.Result != "SENT" ||
+		len(r.Lines) != 1 || r.Lines[0] != "+CMGS: 12" {
+		t.Fatalf("Custom final: %+v, %v", r, err)
+	}
+
+	// Timeout
+	c := &Cmd{Text: "AT+NONE", Timeout: 200 * time.Millisecond}
+	if _, err = e.Exec(bg, c); err != ErrTimeout {
+		t.Fatalf("Timeout: %v", err)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	pe, pm := pipe.New()
+	e := NewEngine(pe)
+	var urcs []string
+	e.Handle("+C", func(l string) { urcs = append(urcs, "C:"+l) })
+	e.Handle("+CMTI:", func(l string) { urcs = append(urcs, l) })
+	pm.Write([]byte("\r\n+CMTI: \"SM\",3\r\nJUNK\r\n+CREG: 5\r\n"))
+	if err := e.Poll(bg, 100*time.Millisecond); err != nil {
+		t.Fatal("Poll:", err)
+	}
+	if strings.Join(urcs, "|") != "+CMTI: \"SM\",3|C:+CREG: 5" {
+		t.Fatalf("URCs: %q", urcs)
+	}
+
+	// Canceled
+	ctx, cancel := context.WithCancel(bg)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if err := e.Poll(ctx, time.Minute); err != context.Canceled {
+		t.Fatalf("Poll: %v", err)
+	}
+}
+
+func TestConnect(t *testing.T) {
+	pe, pm := pipe.New()
+	go modem(pm, map[string]string{
+		"ATD123": "\r\nCONNECT 9600\r\nhello",
+		"ATD456": "\r\nBUSY\r\n",
+	})
+	e := NewEngine(pe)
+	_, err := e.Command("ATD456")
+	if e, ok := err.(*Error); !ok || e.Result != "BUSY" {
+		t.Fatalf("Dial: %v", err)
+	}
+	r, err := e.Command("ATD123")
+	if err != nil || r.Result != "CONNECT 9600" {
+		t.Fatalf("Dial: %+v, %v", r, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	b := e.Buffered()
+	var rb [16]byte
+	pe.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	n, _ := pe.Read(rb[:])
+	if b = append(b, rb[:n]...); string(b) != "hello" {
+		t.Fatalf("Data: %q", b)
+	}
+}
+
+func TestRespPrefix(t *testing.T) {
+	for _, tst := range []struct{ cmd, prefix string }{
+		{"AT+CSQ", "+CSQ:"},
+		{"at+creg?", "+CREG:"},
+		{"AT+COPS=?", "+COPS:"},
+		{"AT^SYSINFO", "^SYSINFO:"},
+		{"ATI", ""},
+		{"AT", ""},
+	} {
+		if p := respPrefix(tst.cmd); p != tst.prefix {
+			t.Errorf("%s: %q", tst.cmd, p)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package at
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// readLine returns the next non-empty line received, without the
+// terminating CR or LF. It returns ErrTimeout if no line is received
+// until dl, or the context's error if it is canceled. Must be called
+// with e.mu held.
+func (e *Engine) readLine(ctx context.Context, dl time.Time) (string,
+	error) {
+
+	for {
+		if e.cr && len(e.pend) > 0 && e.pend[0] == '\n' {
+			// LF of a CR-LF pair
+			e.pend = e.pend[1:]
+		}
+		if len(e.pend) > 0 {
+			e.cr = false
+		}
+		if i := bytes.IndexAny(e.pend, "\r\n"); i >= 0 {
+			l := string(e.pend[:i])
+			e.cr = e.pend[i] == '\r'
+			e.pend = e.pend[i+1:]
+			if l != "" {
+				return l, nil
+			}
+			continue
+		}
+		if len(e.pend) >= MaxLine {
+			l := string(e.pend[:MaxLine])
+			e.pend = e.pend[MaxLine:]
+			return l, nil
+		}
+		if err := e.fill(ctx, dl); err != nil {
+			return "", err
+		}
+	}
+}
+
+// fill reads from the port, appending to the pending data. It waits
+// until dl at most, checking the context periodically.
+func (e *Engine) fill(ctx context.Context, dl time.Time) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Poll once, even if dl has expired
+		now := time.Now()
+		pdl := now.Add(pollInterval)
+		if dl.Before(pdl) {
+			pdl = dl
+		}
+		e.port.SetReadDeadline(pdl)
+		n, err := e.port.Read(e.rbuf)
+		if n > 0 {
+			e.pend = append(e.pend, e.rbuf[:n]...)
+			return nil
+		}
+		if err != nil && !ioerr.IsTimeout(err) {
+			return err
+		}
+		if !time.Now().Before(dl) {
+			return ErrTimeout
+		}
+	}
+}
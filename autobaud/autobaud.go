@@ -0,0 +1,307 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package autobaud detects the baudrate and character format
+// (databits, parity, stopbits) of the traffic received on a serial
+// port.
+//
+// A Detector configures the port with each of a list of candidate
+// configurations in turn, listens for a while (optionally after
+// sending a probe, for devices that only talk when spoken to), and
+// scores each candidate by the data it received: by the framing,
+// parity, and break errors counted by the port's driver (see
+// serial.Port.GetCounters), and by the fraction of printable
+// characters received (unless the traffic is binary). Data received
+// with the wrong settings contain errors, and mostly non-printable
+// characters.
+//
+// Scoring
+//
+// The score of a candidate is the product of:
+//
+//   1 - (errors / bytes received)
+//   The fraction of printable bytes (or 1, for binary traffic)
+//   min(1, bytes received / MinBytes)
+//
+// Printable bytes are ASCII graphic characters, space, tab, CR, and
+// LF. If the port does not support counters, errors are not taken
+// into account. The confidence of the result is the difference of
+// the best score from the second best (0 to 1). Candidates that
+// cannot be distinguished by the data received (e.g. 8N1 and 7E1,
+// for ASCII traffic, if the port has no counters) have equal scores;
+// of them, the one given first in the candidates list is selected.
+package autobaud
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Port is the interface of the ports detectors operate on. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	GetConf() (serial.Conf, error)
+	ConfSome(conf serial.Conf, flags serial.ConfFlags) error
+	FlushIn() error
+	GetCounters() (serial.Counters, error)
+}
+
+// Defaults for Detector fields
+const (
+	DefaultListen   = time.Second
+	DefaultMinBytes = 32
+)
+
+// Max number of bytes received kept in Score.Sample
+const sampleLen = 64
+
+// DefaultBaudrates are the baudrates tried by default, in order
+var DefaultBaudrates = []int{
+	115200, 57600, 38400, 19200, 9600, 4800, 2400, 1200,
+}
+
+// DefaultFormats are the character formats tried by default (with
+// every baudrate), in order. Only the Databits, Parity, and Stopbits
+// fields are used.
+var DefaultFormats = []serial.Conf{
+	{Databits: 8, Parity: serial.ParityNone, Stopbits: 1},
+	{Databits: 7, Parity: serial.ParityEven, Stopbits: 1},
+	{Databits: 7, Parity: serial.ParityOdd, Stopbits: 1},
+	{Databits: 8, Parity: serial.ParityEven, Stopbits: 1},
+}
+
+// ErrNoData is returned by Detector.Detect if no data were received
+// with any of the candidate configurations.
+var ErrNoData = errors.New("autobaud: no data received")
+
+// Flags of the configuration parameters set by the Detector
+const confFlags = serial.ConfBaudrate | serial.ConfDatabits |
+	serial.ConfParity | serial.ConfStopbits
+
+// Interval for checking for context cancelation while listening
+const pollInterval = 100 * time.Millisecond
+
+// Candidates returns the candidate configurations for every
+// combination of the given baudrates and formats. Baudrates vary
+// slowest.
+func Candidates(baudrates []int, formats []serial.Conf) []serial.Conf {
+	var cc []serial.Conf
+	for _, b := range baudrates {
+		for _, f := range formats {
+			c := serial.Conf{Baudrate: b, Databits: f.Databits,
+				Parity: f.Parity, Stopbits: f.Stopbits}
+			cc = append(cc, c)
+		}
+	}
+	return cc
+}
+
+// Score is the result of listening with a candidate configuration
+type Score struct {
+	Conf      serial.Conf
+	Bytes     int     // Bytes received
+	Errors    int     // Framing, parity, and break errors; -1 if unknown
+	Printable float64 // Fraction of printable bytes received
+	Score     float64
+	Sample    []byte // The first bytes received
+}
+
+// byScore sorts scores, best first
+type byScore []Score
+
+func (s byScore) Len() int           { return len(s) }
+func (s byScore) Less(i, j int) bool { return s[i].Score > s[j].Score }
+func (s byScore) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// Result is the result of a detection
+type Result struct {
+	Conf       serial.Conf // Best candidate
+	Confidence float64     // 0 to 1
+	Scores     []Score     // All candidates, best first
+}
+
+// Detector detects the configuration of the traffic on a port
+type Detector struct {
+	// Configurations to try, in order. If nil, Candidates(
+	// DefaultBaudrates, DefaultFormats) are tried.
+	Candidates []serial.Conf
+	// Time to listen with each candidate. Zero means
+	// DefaultListen.
+	Listen time.Duration
+	// If not nil, sent after configuring each candidate, before
+	// listening.
+	Probe []byte
+	// Bytes to receive for a candidate to be fully scored. Zero
+	// means DefaultMinBytes.
+	MinBytes int
+	// If set, the traffic is binary, and the printable bytes
+	// received are not taken into account.
+	Binary bool
+
+	port Port
+}
+
+// NewDetector returns a Detector for port p
+func NewDetector(p Port) *Detector {
+	return &Detector{port: p}
+}
+
+func (d *Detector) candidates() []serial.Conf {
+	if d.Candidates == nil {
+		return Candidates(DefaultBaudrates, DefaultFormats)
+	}
+	return d.Candidates
+}
+
+func (d *Detector) listen() time.Duration {
+	if d.Listen == 0 {
+		return DefaultListen
+	}
+	return d.Listen
+}
+
+func (d *Detector) minBytes() int {
+	if d.MinBytes == 0 {
+		return DefaultMinBytes
+	}
+	return d.MinBytes
+}
+
+func isPrintable(c byte) bool {
+	return c >= 0x20 && c < 0x7f || c == '\t' || c == '\r' || c == '\n'
+}
+
+// errCount returns the errors counted between counters c0 and c1
+func errCount(c0, c1 serial.Counters) int {
+	return (c1.Frame - c0.Frame) + (c1.Parity - c0.Parity) +
+		(c1.Break - c0.Break)
+}
+
+// try configures the port with candidate c, listens, and scores the
+// data received.
+func (d *Detector) try(ctx context.Context, c serial.Conf) (Score, error) {
+	s := Score{Conf: c, Errors: -1}
+	p := d.port
+	if err := p.ConfSome(c, confFlags); err != nil {
+		return s, err
+	}
+	if err := p.FlushIn(); err != nil && err != serial.ErrUnsupported {
+		return s, err
+	}
+	c0, cerr := p.GetCounters()
+	if d.Probe != nil {
+		if _, err := p.Write(d.Probe); err != nil {
+			return s, err
+		}
+	}
+	printable := 0
+	b := make([]byte, 256)
+	end := time.Now().Add(d.listen())
+	for {
+		if err := ctx.Err(); err != nil {
+			return s, err
+		}
+		now := time.Now()
+		if !now.Before(end) {
+			break
+		}
+		dl := end
+		if t := now.Add(pollInterval); t.Before(dl) {
+			dl = t
+		}
+		if err := p.SetReadDeadline(dl); err != nil {
+			return s, err
+		}
+		n, err := p.Read(b)
+		for _, c := range b[:n] {
+			if isPrintable(c) {
+				printable++
+			}
+		}
+		if len(s.Sample) < sampleLen {
+			m := sampleLen - len(s.Sample)
+			if m > n {
+				m = n
+			}
+			s.Sample = append(s.Sample, b[:m]...)
+		}
+		s.Bytes += n
+		if err != nil && !ioerr.IsTimeout(err) {
+			return s, err
+		}
+	}
+	if cerr == nil {
+		if c1, err := p.GetCounters(); err == nil {
+			s.Errors = errCount(c0, c1)
+		}
+	}
+	if s.Bytes == 0 {
+		return s, nil
+	}
+	s.Printable = float64(printable) / float64(s.Bytes)
+	s.Score = 1
+	if s.Errors > 0 {
+		e := float64(s.Errors) / float64(s.Bytes)
+		if e > 1 {
+			e = 1
+		}
+		s.Score *= 1 - e
+	}
+	if !d.Binary {
+		s.Score *= s.Printable
+	}
+	if v := float64(s.Bytes) / float64(d.minBytes()); v < 1 {
+		s.Score *= v
+	}
+	return s, nil
+}
+
+// Detect tries the candidate configurations, and returns the one that
+// scores best. It leaves the port configured with it. If no data are
+// received with any candidate, Detect restores the port's original
+// configuration and returns ErrNoData. Detect can be canceled using
+// ctx (in which case the port's original configuration is also
+// restored). A nil ctx is never canceled.
+func (d *Detector) Detect(ctx context.Context) (*Result, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	orig, err := d.port.GetConf()
+	if err != nil {
+		return nil, err
+	}
+	restore := func() { d.port.ConfSome(orig, confFlags) }
+	r := &Result{}
+	for _, c := range d.candidates() {
+		s, err := d.try(ctx, c)
+		if err != nil {
+			restore()
+			return nil, err
+		}
+		r.Scores = append(r.Scores, s)
+	}
+	sort.Stable(byScore(r.Scores))
+	if len(r.Scores) == 0 || r.Scores[0].Bytes == 0 {
+		restore()
+		return nil, ErrNoData
+	}
+	r.Conf = r.Scores[0].Conf
+	r.Confidence = r.Scores[0].Score
+	if len(r.Scores) > 1 {
+		r.Confidence -= r.Scores[1].Score
+	}
+	if err := d.port.ConfSome(r.Conf, confFlags); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
@@ -0,0 +1,317 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package autobaud
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+var bg = context.Background()
+
+// line is the signal on a serial line: levels (true: mark), starting
+// at times (in seconds).
+type line struct {
+	times  []float64
+	levels []bool
+	end    float64
+}
+
+func (l *line) add(level bool, d float64) {
+	l.times = append(l.times, l.end)
+	l.levels = append(l.levels, level)
+	l.end += d
+}
+
+func (l *line) level(t float64) bool {
+	i := sort.SearchFloat64s(l.times, t)
+	if i == len(l.times) || l.times[i] > t {
+		i--
+	}
+	return l.levels[i]
+}
+
+func parityBit(c byte, p serial.ParityMode) bool {
+	n := 0
+	for ; c != 0; c >>= 1 {
+		n += int(c & 1)
+	}
+	switch p {
+	case serial.ParityEven:
+		return n%2 == 1
+	case serial.ParityOdd:
+		return n%2 == 0
+	case serial.ParityMark:
+		return true
+	}
+	return false
+}
+
+// encode returns the signal for data, sent with conf. Characters are
+// separated by one bit-time of idle line.
+func encode(data []byte, conf serial.Conf) *line {
+	l := &line{}
+	bt := 1 / float64(conf.Baudrate)
+	l.add(true, 10*bt)
+	for _, c := range data {
+		c &= 1<<uint(conf.Databits) - 1
+		l.add(false, bt)
+		for i := 0; i < conf.Databits; i++ {
+			l.add(c>>uint(i)&1 != 0, bt)
+		}
+		if conf.Parity != serial.ParityNone {
+			l.add(parityBit(c, conf.Parity), bt)
+		}
+		l.add(true, float64(conf.Stopbits+1)*bt)
+	}
+	l.add(true, 10*bt)
+	return l
+}
+
+// decode emulates a UART receiving signal l with conf. It returns
+// the characters received, and the framing and parity errors.
+func decode(l *line, conf serial.Conf) (data []byte, frame, parity int) {
+	bt := 1 / float64(conf.Baudrate)
+	t := 0.0
+	for {
+		// Hunt for the start bit
+		for t < l.end && l.level(t) {
+			t += bt / 16
+		}
+		end := t + bt*float64(conf.Databits+conf.Stopbits+2)
+		if end >= l.end {
+			return
+		}
+		if l.level(t + bt/2) {
+			t += bt / 2
+			continue
+		}
+		var c byte
+		for i := 0; i < conf.Databits; i++ {
+			if l.level(t + bt*(1.5+float64(i))) {
+				c |= 1 << uint(i)
+			}
+		}
+		t += bt * (1.5 + float64(conf.Databits))
+		if conf.Parity != serial.ParityNone {
+			if l.level(t) != parityBit(c, conf.Parity) {
+				parity++
+			}
+			t += bt
+		}
+		if !l.level(t) {
+			frame++
+		}
+		data = append(data, c)
+	}
+}
+
+// device is a Port, connected to a device sending text with conf.
+// If probe is not empty, the device sends only after it receives the
+// probe.
+type device struct {
+	conf     serial.Conf
+	text     []byte
+	probe    string
+	counters bool
+
+	mu      sync.Mutex
+	cur     serial.Conf
+	pend    []byte
+	cnt     serial.Counters
+	rdl     time.Time
+	flushed bool
+}
+
+func newDevice(conf serial.Conf, text string) *device {
+	return &device{conf: conf, text: []byte(text), counters: true,
+		cur: serial.Conf{Baudrate: 300, Databits: 8, Stopbits: 1}}
+}
+
+// send makes the device send its text, as received with the current
+// port configuration.
+func (d *device) send() {
+	data, frame, parity := decode(encode(d.text, d.conf), d.cur)
+	d.pend = append(d.pend, data...)
+	d.cnt.Rx += len(data)
+	d.cnt.Frame += frame
+	d.cnt.Parity += parity
+}
+
+func (d *device) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	if len(d.pend) == 0 && d.probe == "" && !d.flushed {
+		d.send()
+		d.flushed = true
+	}
+	if len(d.pend) > 0 {
+		n := copy(b, d.pend)
+		d.pend = d.pend[n:]
+		d.mu.Unlock()
+		return n, nil
+	}
+	dl := d.rdl
+	d.mu.Unlock()
+	time.Sleep(dl.Sub(time.Now()))
+	return 0, serial.ErrTimeout
+}
+
+func (d *device) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The probe is received correctly only if the port is
+	// configured as the device.
+	if d.probe != "" && d.cur == d.conf && string(b) == d.probe {
+		d.send()
+	}
+	return len(b), nil
+}
+
+func (d *device) SetReadDeadline(t time.Time) error {
+	d.mu.Lock()
+	d.rdl = t
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *device) GetConf() (serial.Conf, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cur, nil
+}
+
+func (d *device) ConfSome(c serial.Conf, flags serial.ConfFlags) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.cur.Baudrate, d.cur.Databits = c.Baudrate, c.Databits
+	d.cur.Parity, d.cur.Stopbits = c.Parity, c.Stopbits
+	return nil
+}
+
+func (d *device) FlushIn() error {
+	d.mu.Lock()
+	d.pend, d.flushed = nil, false
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *device) GetCounters() (serial.Counters, error) {
+	if !d.counters {
+		return serial.Counters{}, serial.ErrUnsupported
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cnt, nil
+}
+
+var text = strings.Repeat("The quick brown fox jumps over the lazy dog; "+
+	"0123456789.\r\n", 3)
+
+func conf(baud, databits int, parity serial.ParityMode) serial.Conf {
+	return serial.Conf{Baudrate: baud, Databits: databits,
+		Parity: parity, Stopbits: 1}
+}
+
+func TestDecode(t *testing.T) {
+	c := conf(9600, 7, serial.ParityEven)
+	data, frame, parity := decode(encode([]byte(text), c), c)
+	if string(data) != text || frame != 0 || parity != 0 {
+		t.Fatalf("Decoded: %q, %d, %d", data, frame, parity)
+	}
+	_, frame, parity = decode(encode([]byte(text), c),
+		conf(9600, 7, serial.ParityOdd))
+	if frame != 0 || parity != len(text) {
+		t.Fatalf("Parity: %d, %d", frame, parity)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, c := range []serial.Conf{
+		conf(9600, 8, serial.ParityNone),
+		conf(19200, 7, serial.ParityEven),
+		conf(115200, 7, serial.ParityOdd),
+		conf(2400, 8, serial.ParityEven),
+	} {
+		dev := newDevice(c, text)
+		d := NewDetector(dev)
+		d.Listen = 10 * time.Millisecond
+		r, err := d.Detect(bg)
+		if err != nil {
+			t.Fatalf("%v: Detect: %v", c, err)
+		}
+		if r.Conf != c || r.Confidence < 0.1 {
+			t.Errorf("%v: Detected %v (%.2f)", c, r.Conf,
+				r.Confidence)
+		}
+		if len(r.Scores) != len(DefaultBaudrates)*len(DefaultFormats) {
+			t.Errorf("%d scores", len(r.Scores))
+		}
+		if cur, _ := dev.GetConf(); cur != c {
+			t.Errorf("%v: Port left with %v", c, cur)
+		}
+	}
+}
+
+func TestNoCounters(t *testing.T) {
+	// Without counters, 8N1 and 7E1 cannot be told apart for ASCII
+	// text. The baudrate is still detected.
+	c := conf(38400, 8, serial.ParityNone)
+	dev := newDevice(c, text)
+	dev.counters = false
+	d := NewDetector(dev)
+	d.Listen = 10 * time.Millisecond
+	r, err := d.Detect(bg)
+	if err != nil {
+		t.Fatal("Detect:", err)
+	}
+	if r.Conf != c || r.Confidence != 0 || r.Scores[0].Errors != -1 {
+		t.Fatalf("Detected %v (%.2f)", r.Conf, r.Confidence)
+	}
+	if r.Scores[1].Conf.Baudrate != 38400 {
+		t.Fatalf("Second: %v", r.Scores[1].Conf)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	c := conf(4800, 7, serial.ParityEven)
+	dev := newDevice(c, text)
+	dev.probe = "?\r"
+	d := NewDetector(dev)
+	d.Listen = 10 * time.Millisecond
+	d.Probe = []byte("?\r")
+	d.Binary = true
+	r, err := d.Detect(bg)
+	if err != nil {
+		t.Fatal("Detect:", err)
+	}
+	if r.Conf != c || r.Confidence != 1 {
+		t.Fatalf("Detected %v (%.2f)", r.Conf, r.Confidence)
+	}
+
+	// No probe, no data; a nil context is never canceled
+	orig, _ := dev.GetConf()
+	d.Probe = nil
+	d.Candidates = Candidates([]int{4800, 9600}, DefaultFormats)
+	if _, err := d.Detect(nil); err != ErrNoData {
+		t.Fatalf("Detect: %v", err)
+	}
+	if cur, _ := dev.GetConf(); cur != orig {
+		t.Fatalf("Port left with %v", cur)
+	}
+
+	// Canceled
+	ctx, cancel := context.WithCancel(bg)
+	cancel()
+	if _, err := d.Detect(ctx); err != context.Canceled {
+		t.Fatalf("Detect canceled: %v", err)
+	}
+}
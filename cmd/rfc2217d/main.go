@@ -0,0 +1,98 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command rfc2217d exports a local serial port over the network,
+// using the Telnet Com Port Control Option (RFC 2217). Usage:
+//
+//   rfc2217d [-l addr] [-takeover] [-poll duration] device
+//
+// Flags:
+//
+//   -l addr
+//         Address to listen on (default ":2217")
+//   -takeover
+//         When a new client connects, disconnect the current one
+//         (default is to reject new clients while one is served)
+//   -poll duration
+//         Interval for polling the port's modem lines (default 100ms)
+//   -sig string
+//         Signature sent to clients that ask for it
+//
+// The port is opened once, at startup, and remains open while the
+// server runs. Its configuration is changed only at the request of
+// the clients.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/rfc2217"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"Usage: %s [flags] device\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func main() {
+	addr := flag.String("l", ":2217", "Address to listen on")
+	takeover := flag.Bool("takeover", false,
+		"New clients take over the port from the current one")
+	poll := flag.Duration("poll", rfc2217.DefaultPollInterval,
+		"Modem lines polling interval")
+	sig := flag.String("sig", "rfc2217d", "Server signature")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	log.SetFlags(log.LstdFlags)
+	log.SetPrefix("rfc2217d: ")
+
+	port, err := serial.Open(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+	defer port.Close()
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		port.Close()
+		log.Fatal(err)
+	}
+
+	srv := &rfc2217.Server{
+		Port:         port,
+		PollInterval: *poll,
+		Signature:    *sig,
+	}
+	if *takeover {
+		srv.Policy = rfc2217.PolicyTakeover
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigc
+		srv.Close()
+	}()
+
+	log.Printf("serving %s on %s (%v)", flag.Arg(0), ln.Addr(),
+		srv.Policy)
+	err = srv.Serve(ln)
+	if err != rfc2217.ErrServerClosed {
+		port.Close()
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command serexpect runs expect scripts over serial ports. Usage:
+//
+//   serexpect [-c conf] [-t timeout] [-echo] [-q] [-log file]
+//             port script
+//
+// Flags:
+//
+//   -c conf
+//         Port configuration, in the form accepted by
+//         serial.ParseConf (e.g. "115200,8N1,rtscts"). Settings not
+//         given are left unchanged.
+//   -echo
+//         The device echoes the data sent; data sent are not logged
+//         separately.
+//   -log file
+//         Write the session's transcript to file.
+//   -q    Do not print the session's transcript to stdout.
+//   -t timeout
+//         Default timeout for expect commands (default 10s).
+//
+// Argument port can be the name of a local serial-port device, or
+// any URL accepted by serial.Open (e.g. "tcp://host:port",
+// "rfc2217://host:port"). Argument script is the script file to run,
+// or "-" for stdin. See package github.com/npat-efault/serial/expect
+// (type Script) for the script format.
+//
+// Serexpect exits with status 0 if the script runs to completion, or
+// prints the error (with the script line that caused it) and exits
+// with status 1.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/expect"
+	_ "github.com/npat-efault/serial/rfc2217"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] port script\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "serexpect: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	confStr := flag.String("c", "", "Port configuration (e.g. 115200,8N1)")
+	timeout := flag.Duration("t", expect.DefaultTimeout,
+		"Default expect timeout")
+	echo := flag.Bool("echo", false, "Device echoes data sent")
+	quiet := flag.Bool("q", false, "Do not print transcript")
+	logFile := flag.String("log", "", "Write transcript to file")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+	}
+	name, scriptName := flag.Arg(0), flag.Arg(1)
+
+	var r io.Reader = os.Stdin
+	if scriptName != "-" {
+		f, err := os.Open(scriptName)
+		if err != nil {
+			fatal("%v", err)
+		}
+		defer f.Close()
+		r = f
+	}
+	sc, err := expect.ParseScript(r)
+	if err != nil {
+		fatal("%s: %v", scriptName, err)
+	}
+
+	var logs []io.Writer
+	if !*quiet {
+		logs = append(logs, os.Stdout)
+	}
+	var lf *os.File
+	if *logFile != "" {
+		lf, err = os.Create(*logFile)
+		if err != nil {
+			fatal("%v", err)
+		}
+		logs = append(logs, lf)
+	}
+
+	port, err := serial.Open(name)
+	if err != nil {
+		fatal("%s: %v", name, err)
+	}
+	if *confStr != "" {
+		conf, flags, err := serial.ParseConf(*confStr)
+		if err != nil {
+			port.Close()
+			fatal("%v", err)
+		}
+		if err := port.ConfSome(conf, flags); err != nil {
+			port.Close()
+			fatal("%s: %v", name, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+	go func() {
+		<-sigc
+		cancel()
+	}()
+
+	s := expect.NewSession(port)
+	s.Timeout = *timeout
+	s.Echo = *echo
+	if len(logs) != 0 {
+		s.Log = io.MultiWriter(logs...)
+	}
+	err = sc.Run(ctx, s)
+	port.Close()
+	if lf != nil {
+		if cerr := lf.Close(); err == nil && cerr != nil {
+			fatal("%v", cerr)
+		}
+	}
+	if err != nil {
+		if !*quiet {
+			fmt.Println()
+		}
+		fatal("%s: %v", scriptName, err)
+	}
+}
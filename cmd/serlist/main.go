@@ -0,0 +1,217 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command serlist lists and describes the serial ports available on
+// the system. Usage:
+//
+//   serlist [flags]
+//
+// Flags:
+//
+//   -a    Include placeholder ports with no hardware behind them
+//   -driver name
+//         List only ports handled by driver (e.g. "ftdi_sio")
+//   -json
+//         Print the list in JSON form
+//   -pid id
+//         List only USB ports with product-id (hex, e.g. "6001")
+//   -serial string
+//         List only USB ports with serial-number
+//   -vid id
+//         List only USB ports with vendor-id (hex, e.g. "0403")
+//
+// For every port, serlist prints the device name, the driver that
+// handles it, the USB identity of the device (for USB ports), the
+// persistent symlinks pointing to it (in /dev/serial), and the
+// processes that have it open. For example:
+//
+//   /dev/ttyUSB0
+//     driver:  ftdi_sio (usb-serial)
+//     usb:     0403:6001 FTDI FT232R USB UART, serial A600dRnM,
+//              location 1-1.2, interface 00
+//     links:   /dev/serial/by-id/usb-FTDI_FT232R_USB_UART_A600dRnM-if00-port0
+//              /dev/serial/by-path/pci-0000:00:14.0-usb-0:1.2:1.0-port0
+//     open by: 812 (gpsd)
+//
+// Processes belonging to other users are only reported when serlist
+// runs as root. Serlist is currently supported only on Linux (it
+// relies on sysfs and procfs).
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// portInfo describes a serial port
+type portInfo struct {
+	Name      string     `json:"name"`
+	Device    string     `json:"device"`
+	Driver    string     `json:"driver,omitempty"`
+	Subsystem string     `json:"subsystem,omitempty"`
+	USB       *usbInfo   `json:"usb,omitempty"`
+	Links     []string   `json:"links,omitempty"`
+	OpenBy    []procInfo `json:"open_by,omitempty"`
+}
+
+// usbInfo is the USB identity of a port
+type usbInfo struct {
+	VID          string `json:"vid"`
+	PID          string `json:"pid"`
+	Manufacturer string `json:"manufacturer,omitempty"`
+	Product      string `json:"product,omitempty"`
+	Serial       string `json:"serial,omitempty"`
+	Location     string `json:"location,omitempty"`  // Bus-port path
+	Interface    string `json:"interface,omitempty"` // Interface number
+}
+
+// procInfo identifies a process that has a port open
+type procInfo struct {
+	PID     int    `json:"pid"`
+	Command string `json:"command"`
+}
+
+// filter selects ports. Empty fields match everything.
+type filter struct {
+	vid, pid, driver, serial string
+}
+
+// hexID normalizes a hex USB id for comparison
+func hexID(s string) string {
+	s = strings.ToLower(s)
+	s = strings.TrimPrefix(s, "0x")
+	for len(s) < 4 {
+		s = "0" + s
+	}
+	return s
+}
+
+func (f filter) match(p *portInfo) bool {
+	if f.driver != "" && p.Driver != f.driver {
+		return false
+	}
+	if f.vid == "" && f.pid == "" && f.serial == "" {
+		return true
+	}
+	if p.USB == nil {
+		return false
+	}
+	if f.vid != "" && hexID(p.USB.VID) != hexID(f.vid) {
+		return false
+	}
+	if f.pid != "" && hexID(p.USB.PID) != hexID(f.pid) {
+		return false
+	}
+	if f.serial != "" && p.USB.Serial != f.serial {
+		return false
+	}
+	return true
+}
+
+func writeText(w io.Writer, ports []*portInfo) error {
+	const indent = "           "
+	for _, p := range ports {
+		if _, err := fmt.Fprintf(w, "%s\n", p.Device); err != nil {
+			return err
+		}
+		if p.Driver != "" {
+			fmt.Fprintf(w, "  driver:  %s", p.Driver)
+			if p.Subsystem != "" {
+				fmt.Fprintf(w, " (%s)", p.Subsystem)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		if u := p.USB; u != nil {
+			fmt.Fprintf(w, "  usb:     %s:%s", u.VID, u.PID)
+			for _, s := range []string{u.Manufacturer, u.Product} {
+				if s != "" {
+					fmt.Fprintf(w, " %s", s)
+				}
+			}
+			if u.Serial != "" {
+				fmt.Fprintf(w, ", serial %s", u.Serial)
+			}
+			fmt.Fprintf(w, ",\n%slocation %s", indent, u.Location)
+			if u.Interface != "" {
+				fmt.Fprintf(w, ", interface %s", u.Interface)
+			}
+			fmt.Fprintf(w, "\n")
+		}
+		for i, l := range p.Links {
+			if i == 0 {
+				fmt.Fprintf(w, "  links:   %s\n", l)
+			} else {
+				fmt.Fprintf(w, "%s%s\n", indent, l)
+			}
+		}
+		if len(p.OpenBy) != 0 {
+			var l []string
+			for _, pr := range p.OpenBy {
+				l = append(l, fmt.Sprintf("%d (%s)", pr.PID, pr.Command))
+			}
+			fmt.Fprintf(w, "  open by: %s\n", strings.Join(l, ", "))
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, ports []*portInfo) error {
+	b, err := json.MarshalIndent(ports, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "serlist: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	var f filter
+	all := flag.Bool("a", false, "Include placeholder ports")
+	jsonOut := flag.Bool("json", false, "Print list in JSON form")
+	flag.StringVar(&f.vid, "vid", "", "USB vendor-id (hex)")
+	flag.StringVar(&f.pid, "pid", "", "USB product-id (hex)")
+	flag.StringVar(&f.driver, "driver", "", "Driver name")
+	flag.StringVar(&f.serial, "serial", "", "USB serial-number")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 0 {
+		usage()
+	}
+
+	ports, err := listPorts(*all)
+	if err != nil {
+		fatal("%v", err)
+	}
+	sel := []*portInfo{}
+	for _, p := range ports {
+		if f.match(p) {
+			sel = append(sel, p)
+		}
+	}
+	if *jsonOut {
+		err = writeJSON(os.Stdout, sel)
+	} else {
+		err = writeText(os.Stdout, sel)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package main
+
+import "testing"
+
+func TestHexID(t *testing.T) {
+	for _, x := range []struct {
+		s, id string
+	}{
+		{"0403", "0403"},
+		{"403", "0403"},
+		{"0x403", "0403"},
+		{"0X6001", "6001"},
+		{"10C4", "10c4"},
+		{"ea60", "ea60"},
+		{"1", "0001"},
+		{"", "0000"},
+	} {
+		if id := hexID(x.s); id != x.id {
+			t.Errorf("hexID %q: %q != %q", x.s, id, x.id)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	ftdi := &portInfo{Device: "/dev/ttyUSB0", Driver: "ftdi_sio",
+		USB: &usbInfo{VID: "0403", PID: "6001", Serial: "A600dRnM"}}
+	cp210x := &portInfo{Device: "/dev/ttyUSB1", Driver: "cp210x",
+		USB: &usbInfo{VID: "10c4", PID: "ea60"}}
+	uart := &portInfo{Device: "/dev/ttyS0", Driver: "serial8250"}
+
+	for _, x := range []struct {
+		f    filter
+		p    *portInfo
+		want bool
+	}{
+		{filter{}, ftdi, true},
+		{filter{}, uart, true},
+		{filter{vid: "0403"}, ftdi, true},
+		{filter{vid: "403"}, ftdi, true},
+		{filter{vid: "0x0403"}, ftdi, true},
+		{filter{vid: "0403"}, cp210x, false},
+		{filter{vid: "10C4"}, cp210x, true},
+		{filter{vid: "0403"}, uart, false},
+		{filter{pid: "6001"}, ftdi, true},
+		{filter{pid: "6015"}, ftdi, false},
+		{filter{vid: "0403", pid: "6001"}, ftdi, true},
+		{filter{vid: "0403", pid: "ea60"}, ftdi, false},
+		{filter{serial: "A600dRnM"}, ftdi, true},
+		{filter{serial: "a600drnm"}, ftdi, false},
+		{filter{serial: "A600dRnM"}, cp210x, false},
+		{filter{driver: "ftdi_sio"}, ftdi, true},
+		{filter{driver: "ftdi_sio"}, cp210x, false},
+		{filter{driver: "serial8250"}, uart, true},
+		{filter{driver: "cp210x", vid: "10c4"}, cp210x, true},
+		{filter{driver: "ftdi_sio", vid: "10c4"}, cp210x, false},
+	} {
+		if m := x.f.match(x.p); m != x.want {
+			t.Errorf("%+v match %s: %v", x.f, x.p.Device, m)
+		}
+	}
+}
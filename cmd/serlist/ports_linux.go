@@ -0,0 +1,204 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// +build linux
+
+// Serial-port enumeration for Linux, using sysfs and procfs.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	sysTTY  = "/sys/class/tty"
+	devDir  = "/dev"
+	procDir = "/proc"
+)
+
+// Directories with persistent symlinks to serial devices (maintained
+// by udev).
+var linkDirs = []string{"/dev/serial/by-id", "/dev/serial/by-path"}
+
+// listPorts returns the serial ports present in the system. Unless
+// all is true, placeholder ports of the 8250 driver that have no
+// hardware behind them are omitted.
+func listPorts(all bool) ([]*portInfo, error) {
+	ents, err := ioutil.ReadDir(sysTTY)
+	if err != nil {
+		return nil, err
+	}
+	ports := []*portInfo{}
+	for _, e := range ents {
+		p := sysPort(e.Name())
+		if p == nil {
+			continue
+		}
+		if !all && p.Driver == "serial8250" &&
+			readAttr(filepath.Join(sysTTY, p.Name, "type")) == "0" {
+			continue
+		}
+		ports = append(ports, p)
+	}
+	addLinks(ports)
+	addOpeners(ports)
+	return ports, nil
+}
+
+// sysPort returns information about tty name, or nil if it is not
+// backed by a device (e.g. virtual consoles, ptys).
+func sysPort(name string) *portInfo {
+	dir := filepath.Join(sysTTY, name)
+	dev, err := filepath.EvalSymlinks(filepath.Join(dir, "device"))
+	if err != nil {
+		return nil
+	}
+	// Since Linux 6.5, serial-core interposes "serial-base" port
+	// and controller devices between the tty and the hardware
+	// device. Skip them.
+	for linkBase(filepath.Join(dev, "subsystem")) == "serial-base" {
+		dev = filepath.Dir(dev)
+	}
+	p := &portInfo{
+		Name:      name,
+		Device:    filepath.Join(devDir, name),
+		Driver:    linkBase(filepath.Join(dev, "driver")),
+		Subsystem: linkBase(filepath.Join(dev, "subsystem")),
+	}
+	p.USB = usbInfoFor(dev)
+	return p
+}
+
+// usbInfoFor walks up the sysfs device hierarchy, starting from dev,
+// looking for a USB interface and device. Returns nil if dev is not a
+// USB device.
+func usbInfoFor(dev string) *usbInfo {
+	var iface string
+	for d := dev; d != "/" && d != "."; d = filepath.Dir(d) {
+		if iface == "" {
+			if _, err := os.Stat(filepath.Join(d, "bInterfaceNumber")); err == nil {
+				iface = d
+			}
+		}
+		if _, err := os.Stat(filepath.Join(d, "idVendor")); err != nil {
+			continue
+		}
+		u := &usbInfo{
+			VID:          readAttr(filepath.Join(d, "idVendor")),
+			PID:          readAttr(filepath.Join(d, "idProduct")),
+			Manufacturer: readAttr(filepath.Join(d, "manufacturer")),
+			Product:      readAttr(filepath.Join(d, "product")),
+			Serial:       readAttr(filepath.Join(d, "serial")),
+			Location:     filepath.Base(d),
+		}
+		if iface != "" {
+			u.Interface = readAttr(filepath.Join(iface, "bInterfaceNumber"))
+		}
+		return u
+	}
+	return nil
+}
+
+// addLinks finds the persistent symlinks that point to each port
+func addLinks(ports []*portInfo) {
+	byDev := make(map[string]*portInfo)
+	for _, p := range ports {
+		byDev[p.Device] = p
+	}
+	for _, dir := range linkDirs {
+		ents, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range ents {
+			l := filepath.Join(dir, e.Name())
+			t, err := filepath.EvalSymlinks(l)
+			if err != nil {
+				continue
+			}
+			if p := byDev[t]; p != nil {
+				p.Links = append(p.Links, l)
+			}
+		}
+	}
+}
+
+// addOpeners finds the processes that have each port open, by
+// scanning their file descriptors. Processes whose file descriptors
+// cannot be read (e.g. belonging to other users, when not running as
+// root) are silently skipped.
+func addOpeners(ports []*portInfo) {
+	byDev := make(map[string]*portInfo)
+	for _, p := range ports {
+		byDev[p.Device] = p
+	}
+	ents, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return
+	}
+	for _, e := range ents {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procDir, e.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		seen := make(map[*portInfo]bool)
+		for _, fd := range fds {
+			t, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			p := byDev[t]
+			if p == nil || seen[p] {
+				continue
+			}
+			seen[p] = true
+			p.OpenBy = append(p.OpenBy, procInfo{
+				PID:     pid,
+				Command: readAttr(filepath.Join(procDir, e.Name(), "comm")),
+			})
+		}
+	}
+	for _, p := range ports {
+		sort.Sort(byPID(p.OpenBy))
+	}
+}
+
+type byPID []procInfo
+
+func (s byPID) Len() int           { return len(s) }
+func (s byPID) Less(i, j int) bool { return s[i].PID < s[j].PID }
+func (s byPID) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// readAttr returns the contents of a sysfs (or procfs) attribute
+// file, with surrounding whitespace removed. Returns "" if the file
+// cannot be read.
+func readAttr(name string) string {
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// linkBase returns the last element of the target of symlink
+// name. Returns "" if name is not a symlink.
+func linkBase(name string) string {
+	t, err := os.Readlink(name)
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(t)
+}
@@ -0,0 +1,14 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// +build !linux
+
+package main
+
+import "errors"
+
+func listPorts(all bool) ([]*portInfo, error) {
+	return nil, errors.New("port enumeration not supported on this system")
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command sersniff is a passive serial-line sniffer. It sits between
+// two devices connected to two serial ports, forwards data between
+// them, and shows (and optionally records) the traffic. Usage:
+//
+//   sersniff [-c conf] [-w file] [-pcap file [-gap dur]] [-q] [-nomodem]
+//            portA portB
+//
+// Flags:
+//
+//   -c conf
+//         Port configuration, in the form accepted by
+//         serial.ParseConf (e.g. "19200,8E1"). It is applied to portA,
+//         and then portB is configured identically to portA.
+//   -gap dur
+//         Idle gap separating packets in the pcapng capture (e.g.
+//         "4ms"). If zero, every chunk of data read becomes a packet.
+//   -nomodem
+//         Do not mirror modem lines between the ports.
+//   -pcap file
+//         Write data to file in pcapng format (see package pcapng).
+//         Data from portA to portB are recorded as outbound, and from
+//         portB to portA as inbound.
+//   -q    Do not print the live hex / ASCII view.
+//   -w file
+//         Write captured events to file (see package sniff for the
+//         format).
+//
+// Sersniff runs until interrupted (e.g. with C-c), or until one of
+// the ports fails. See package github.com/npat-efault/serial/sniff
+// for details.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/pcapng"
+	_ "github.com/npat-efault/serial/rfc2217"
+	"github.com/npat-efault/serial/sniff"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] portA portB\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "sersniff: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	confStr := flag.String("c", "", "Port configuration (e.g. 19200,8E1)")
+	capFile := flag.String("w", "", "Write capture to file")
+	quiet := flag.Bool("q", false, "Do not print live view")
+	pcapFile := flag.String("pcap", "", "Write pcapng capture to file")
+	gap := flag.Duration("gap", 0, "Idle gap between pcapng packets")
+	noModem := flag.Bool("nomodem", false, "Do not mirror modem lines")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 2 {
+		usage()
+	}
+
+	var conf serial.Conf
+	var flags serial.ConfFlags
+	if *confStr != "" {
+		var err error
+		conf, flags, err = serial.ParseConf(*confStr)
+		if err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	var rec []sniff.Recorder
+	if !*quiet {
+		rec = append(rec, sniff.NewHexView(os.Stdout))
+	}
+	var files []*os.File
+	if *capFile != "" {
+		f, err := os.Create(*capFile)
+		if err != nil {
+			fatal("%v", err)
+		}
+		files = append(files, f)
+		rec = append(rec, sniff.NewWriter(f))
+	}
+	var pw *pcapng.Writer
+	if *pcapFile != "" {
+		f, err := os.Create(*pcapFile)
+		if err != nil {
+			fatal("%v", err)
+		}
+		files = append(files, f)
+		pw = pcapng.NewWriter(f)
+		pw.IfName = flag.Arg(0) + " <-> " + flag.Arg(1)
+		pw.IdleGap = *gap
+		rec = append(rec, pw)
+	}
+
+	pa, pb, err := sniff.Open(flag.Arg(0), flag.Arg(1), conf, flags)
+	if err != nil {
+		fatal("%v", err)
+	}
+	s := &sniff.Sniffer{
+		A:        pa,
+		B:        pb,
+		Recorder: sniff.MultiRecorder(rec...),
+		NoModem:  *noModem,
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+	go func() {
+		<-sigc
+		s.Close()
+	}()
+
+	cfg, _ := pa.GetConf()
+	fmt.Fprintf(os.Stderr, "sersniff: A=%s B=%s (%v)\n",
+		pa.Name, pb.Name, cfg)
+	err = s.Run()
+	pa.Close()
+	pb.Close()
+	if pw != nil {
+		if cerr := pw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for _, f := range files {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command serstty is an stty-like inspector and configurator for
+// serial ports. Usage:
+//
+//   serstty [-json] [-c conf] port [setting ...]
+//
+// Flags:
+//
+//   -c conf
+//         Port configuration to apply, in the form accepted by
+//         serial.ParseConf (e.g. "115200,8N1,rtscts").
+//   -json
+//         Print port state in JSON form.
+//
+// Argument port can be the name of a local serial-port device, or
+// any URL accepted by serial.Open (e.g. "rfc2217://host:port").
+//
+// Serstty first applies the configuration given with -c (if any),
+// then the stty-style settings given as arguments (if any), and
+// finally prints the resulting port state: configuration, raw termios
+// mode-flags and control characters (local ports only), modem lines,
+// and error counters. Stty-style settings are:
+//
+//   <flag>          Set termios mode-flag (e.g. "crtscts", "cs7")
+//   -<flag>         Clear termios mode-flag (e.g. "-echo")
+//   <cc> <value>    Set control character (e.g. "intr ^C", "min 1")
+//   <speed>         Set input and output baudrate (e.g. "9600")
+//   ispeed <speed>  Set input baudrate
+//   ospeed <speed>  Set output baudrate
+//   raw             Set raw mode
+//
+// Flag and control-character names are those printed by serstty
+// (and, mostly, those used by stty). All changes are verified by
+// reading back the port's settings. If a setting is invalid, or is
+// not supported by the system or the device, serstty prints a
+// message identifying it, and exits with a non-zero status. Changes
+// made before the failing setting remain in effect.
+//
+// Serstty leaves the port with the settings it applied (or, if none
+// given, with the settings the port had before serstty run).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/npat-efault/serial"
+	_ "github.com/npat-efault/serial/rfc2217"
+	"github.com/npat-efault/serial/termios"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr,
+		"Usage: %s [flags] port [setting ...]\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "serstty: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	confStr := flag.String("c", "", "Port configuration (e.g. 115200,8N1)")
+	jsonOut := flag.Bool("json", false, "Print port state in JSON form")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	name, settings := flag.Arg(0), flag.Args()[1:]
+
+	var conf serial.Conf
+	var flags serial.ConfFlags
+	if *confStr != "" {
+		var err error
+		conf, flags, err = serial.ParseConf(*confStr)
+		if err != nil {
+			fatal("%v", err)
+		}
+	}
+
+	st, err := run(name, conf, flags, settings)
+	if err != nil {
+		fatal("%s: %v", name, err)
+	}
+	if *jsonOut {
+		err = st.writeJSON(os.Stdout)
+	} else {
+		err = st.writeText(os.Stdout)
+	}
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+// run opens the port, applies the changes, and returns the resulting
+// port state.
+func run(name string, conf serial.Conf, flags serial.ConfFlags,
+	settings []string) (*state, error) {
+
+	if _, _, ok := serial.SplitURL(name); ok {
+		if len(settings) != 0 {
+			return nil, fmt.Errorf("stty-style settings not supported "+
+				"for non-local ports: %s", settings[0])
+		}
+		port, err := serial.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer port.Close()
+		if err := applyConf(port, conf, flags); err != nil {
+			return nil, err
+		}
+		return getState(port, nil), nil
+	}
+
+	d, err := openDev(name)
+	if err != nil {
+		return nil, err
+	}
+	defer d.close()
+	if err := applyConf(d.port, conf, flags); err != nil {
+		return nil, err
+	}
+	if len(settings) != 0 {
+		if err := d.apply(settings); err != nil {
+			return nil, err
+		}
+	}
+	var tios termios.Termios
+	if err := tios.GetFd(d.fd); err != nil {
+		return nil, fmt.Errorf("tcgetattr: %v", err)
+	}
+	return getState(d.port, &tios), nil
+}
+
+// applyConf applies the configuration parameters in conf selected by
+// flags to port, and verifies that they took effect.
+func applyConf(port *serial.Port, conf serial.Conf, flags serial.ConfFlags) error {
+	if flags == 0 {
+		return nil
+	}
+	if err := port.ConfSome(conf, flags); err != nil {
+		return fmt.Errorf("cannot set %v: %v", conf.StringSome(flags), err)
+	}
+	c, err := port.GetConf()
+	if err != nil {
+		return err
+	}
+	c.NoReset = conf.NoReset // Not a device setting
+	if mask(c, flags) != mask(conf, flags) {
+		return fmt.Errorf("%v not supported by device (got %v)",
+			conf.StringSome(flags), c.StringSome(flags))
+	}
+	return nil
+}
+
+// mask returns a copy of conf with the parameters not selected by
+// flags zeroed.
+func mask(conf serial.Conf, flags serial.ConfFlags) serial.Conf {
+	var c serial.Conf
+	if flags&serial.ConfBaudrate != 0 {
+		c.Baudrate = conf.Baudrate
+	}
+	if flags&serial.ConfDatabits != 0 {
+		c.Databits = conf.Databits
+	}
+	if flags&serial.ConfStopbits != 0 {
+		c.Stopbits = conf.Stopbits
+	}
+	if flags&serial.ConfParity != 0 {
+		c.Parity = conf.Parity
+	}
+	if flags&serial.ConfFlow != 0 {
+		c.Flow = conf.Flow
+	}
+	if flags&serial.ConfNoReset != 0 {
+		c.NoReset = conf.NoReset
+	}
+	return c
+}
+
+// dev is a local serial-port device, opened both as a serial.Port,
+// and as a plain file-descriptor used to access the raw termios
+// settings.
+//
+// Opening the serial.Port puts the device in raw mode, and closing it
+// (unless Conf.NoReset is set) resets the device to the settings it
+// had when opened. Dev undoes both, so that the device is inspected
+// with (and left with) the settings it had before, plus those applied
+// by serstty.
+type dev struct {
+	port *serial.Port
+	fd   int
+}
+
+func openDev(name string) (*dev, error) {
+	fd, err := syscall.Open(name,
+		syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, fmt.Errorf("open: %v", err)
+	}
+	var orig termios.Termios
+	if err := orig.GetFd(fd); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("tcgetattr: %v", err)
+	}
+	port, err := serial.Open(name)
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	// Undo raw mode, set by serial.Open
+	if err := orig.SetFd(fd, termios.TCSANOW); err != nil {
+		port.Close()
+		syscall.Close(fd)
+		return nil, fmt.Errorf("tcsetattr: %v", err)
+	}
+	return &dev{port: port, fd: fd}, nil
+}
+
+// apply applies the stty-style settings to the device, and verifies
+// that they took effect.
+func (d *dev) apply(settings []string) error {
+	var tios termios.Termios
+	if err := tios.GetFd(d.fd); err != nil {
+		return fmt.Errorf("tcgetattr: %v", err)
+	}
+	checks, err := applySettings(&tios, settings)
+	if err != nil {
+		return err
+	}
+	if err := tios.SetFd(d.fd, termios.TCSANOW); err != nil {
+		return fmt.Errorf("tcsetattr: %v", err)
+	}
+	// Tcsetattr succeeds if *any* of the changes was performed, so
+	// read back the settings and check them one by one.
+	if err := tios.GetFd(d.fd); err != nil {
+		return fmt.Errorf("tcgetattr: %v", err)
+	}
+	for _, c := range checks {
+		if !c.ok(&tios) {
+			return &settingError{c.setting,
+				"setting not supported by device"}
+		}
+	}
+	return nil
+}
+
+// close closes the device, preserving its current settings
+func (d *dev) close() {
+	var tios termios.Termios
+	err := tios.GetFd(d.fd)
+	d.port.Close()
+	if err == nil {
+		tios.SetFd(d.fd, termios.TCSANOW)
+	}
+	syscall.Close(d.fd)
+}
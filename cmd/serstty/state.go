@@ -0,0 +1,242 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Collection and output of port state.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/termios"
+)
+
+var parityNames = [...]string{
+	serial.ParityNone: "none", serial.ParityEven: "even",
+	serial.ParityOdd: "odd", serial.ParityMark: "mark",
+	serial.ParitySpace: "space",
+}
+
+var flowNames = [...]string{
+	serial.FlowNone: "none", serial.FlowRTSCTS: "rtscts",
+	serial.FlowXONXOFF: "xonxoff", serial.FlowOther: "other",
+}
+
+// modemLines lists the modem-control lines, in display order
+var modemLines = []serial.ModemLines{
+	serial.ModemDTR, serial.ModemRTS, serial.ModemCTS,
+	serial.ModemDSR, serial.ModemDCD, serial.ModemRI,
+}
+
+// state is the full state of a port, as printed by serstty. Sections
+// that could not be retrieved are nil, and the respective error is
+// recorded in Errors, keyed by section name. Section termios is
+// missing for non-local ports.
+type state struct {
+	Port     string            `json:"port"`
+	Conf     *confState        `json:"conf,omitempty"`
+	Termios  *tiosState        `json:"termios,omitempty"`
+	Modem    map[string]bool   `json:"modem,omitempty"`
+	Counters *counterState     `json:"counters,omitempty"`
+	Errors   map[string]string `json:"errors,omitempty"`
+}
+
+type confState struct {
+	String   string `json:"string"`
+	Baudrate int    `json:"baudrate"`
+	Databits int    `json:"databits"`
+	Parity   string `json:"parity"`
+	Stopbits int    `json:"stopbits"`
+	Flow     string `json:"flow"`
+	NoReset  bool   `json:"noreset"`
+}
+
+type tiosState struct {
+	ISpeed int                   `json:"ispeed"`
+	OSpeed int                   `json:"ospeed"`
+	Flags  map[string]*flagState `json:"flags"` // Keyed by field name
+	Cc     map[string]string     `json:"cc"`
+}
+
+type flagState struct {
+	Value uint64          `json:"value"` // Raw field value
+	Flags map[string]bool `json:"flags"`
+}
+
+type counterState struct {
+	Rx         int `json:"rx"`
+	Tx         int `json:"tx"`
+	Frame      int `json:"frame"`
+	Overrun    int `json:"overrun"`
+	Parity     int `json:"parity"`
+	Break      int `json:"break"`
+	BufOverrun int `json:"bufoverrun"`
+	CTS        int `json:"cts"`
+	DSR        int `json:"dsr"`
+	DCD        int `json:"dcd"`
+	RI         int `json:"ri"`
+}
+
+func (s *state) setErr(section string, err error) {
+	if s.Errors == nil {
+		s.Errors = make(map[string]string)
+	}
+	s.Errors[section] = err.Error()
+}
+
+// getState collects the state of port. If tios is not nil, it is
+// the port's termios structure (local ports only).
+func getState(port *serial.Port, tios *termios.Termios) *state {
+	s := &state{Port: port.Name}
+
+	if c, err := port.GetConf(); err != nil {
+		s.setErr("conf", err)
+	} else {
+		s.Conf = &confState{
+			String:   c.String(),
+			Baudrate: c.Baudrate,
+			Databits: c.Databits,
+			Parity:   parityNames[c.Parity],
+			Stopbits: c.Stopbits,
+			Flow:     flowNames[c.Flow],
+			NoReset:  c.NoReset,
+		}
+	}
+
+	if tios != nil {
+		s.Termios = getTios(tios)
+	}
+
+	if m, err := port.GetModem(); err != nil {
+		s.setErr("modem", err)
+	} else {
+		s.Modem = make(map[string]bool)
+		for _, l := range modemLines {
+			s.Modem[l.String()] = m&l != 0
+		}
+	}
+
+	if c, err := port.GetCounters(); err != nil {
+		s.setErr("counters", err)
+	} else {
+		cs := counterState(c)
+		s.Counters = &cs
+	}
+	return s
+}
+
+func getTios(t *termios.Termios) *tiosState {
+	ts := &tiosState{
+		Flags: make(map[string]*flagState),
+		Cc:    make(map[string]string),
+	}
+	// Errors here mean speeds that cannot be represented; leave 0.
+	ts.ISpeed, _ = t.GetISpeed()
+	ts.OSpeed, _ = t.GetOSpeed()
+	for _, f := range []flagField{iflag, oflag, cflag, lflag} {
+		ts.Flags[f.String()] = &flagState{
+			Value: uint64(f.of(t).Val()),
+			Flags: make(map[string]bool),
+		}
+	}
+	for _, f := range tflags {
+		if f.mask == 0 {
+			continue
+		}
+		ts.Flags[f.field.String()].Flags[f.name] = f.isSet(t)
+	}
+	for _, c := range tccs {
+		if c.idx < 0 {
+			continue
+		}
+		ts.Cc[c.name] = c.format(t)
+	}
+	return ts
+}
+
+// writeJSON writes s to w, in JSON form
+func (s *state) writeJSON(w io.Writer) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	_, err = w.Write(b)
+	return err
+}
+
+// writeText writes s to w, in human-readable form
+func (s *state) writeText(w io.Writer) error {
+	var b bytes.Buffer
+	line := func(name, format string, a ...interface{}) {
+		fmt.Fprintf(&b, "%-9s ", name+":")
+		fmt.Fprintf(&b, format, a...)
+		b.WriteByte('\n')
+	}
+	section := func(name string, ok bool, f func()) {
+		if !ok {
+			line(name, "%s", s.Errors[name])
+			return
+		}
+		f()
+	}
+
+	line("port", "%s", s.Port)
+	section("conf", s.Conf != nil, func() {
+		line("conf", "%s", s.Conf.String)
+	})
+	if t := s.Termios; t != nil {
+		line("speed", "ispeed %d, ospeed %d", t.ISpeed, t.OSpeed)
+		for _, f := range []flagField{iflag, oflag, cflag, lflag} {
+			fs := t.Flags[f.String()]
+			var l []string
+			for _, tf := range tflags {
+				if tf.field != f || tf.mask == 0 {
+					continue
+				}
+				switch {
+				case fs.Flags[tf.name]:
+					l = append(l, tf.name)
+				case tf.bool():
+					l = append(l, "-"+tf.name)
+				}
+			}
+			line(f.String(), "0x%08x %s", fs.Value, strings.Join(l, " "))
+		}
+		var l []string
+		for _, c := range tccs {
+			if v, ok := t.Cc[c.name]; ok {
+				l = append(l, c.name+" = "+v)
+			}
+		}
+		line("cc", "%s", strings.Join(l, "; "))
+	}
+	section("modem", s.Modem != nil, func() {
+		var l []string
+		for _, ml := range modemLines {
+			n := ml.String()
+			if !s.Modem[n] {
+				n = "-" + n
+			}
+			l = append(l, n)
+		}
+		line("modem", "%s", strings.Join(l, " "))
+	})
+	section("counters", s.Counters != nil, func() {
+		c := s.Counters
+		line("counters", "rx %d, tx %d, frame %d, overrun %d, "+
+			"parity %d, break %d, bufoverrun %d, "+
+			"cts %d, dsr %d, dcd %d, ri %d",
+			c.Rx, c.Tx, c.Frame, c.Overrun, c.Parity, c.Break,
+			c.BufOverrun, c.CTS, c.DSR, c.DCD, c.RI)
+	})
+	_, err := b.WriteTo(w)
+	return err
+}
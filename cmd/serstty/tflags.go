@@ -0,0 +1,367 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Termios mode-flag and control-character tables, and stty-style
+// settings.
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/npat-efault/serial/termios"
+)
+
+// flagField selects one of the termios mode-flag fields
+type flagField int
+
+const (
+	iflag flagField = iota
+	oflag
+	cflag
+	lflag
+)
+
+var fieldNames = [...]string{
+	iflag: "iflag", oflag: "oflag", cflag: "cflag", lflag: "lflag",
+}
+
+func (f flagField) String() string { return fieldNames[f] }
+
+// of returns a pointer to the field f of t
+func (f flagField) of(t *termios.Termios) *termios.TcFlag {
+	switch f {
+	case iflag:
+		return t.IFlag()
+	case oflag:
+		return t.OFlag()
+	case cflag:
+		return t.CFlag()
+	default:
+		return t.LFlag()
+	}
+}
+
+// tflag describes a termios mode-flag setting. For single-bit flags
+// val == mask. For multi-bit fields (like CSIZE) each possible value
+// has its own entry, marked as multi. Non-standard flags that are not
+// available have mask == 0.
+type tflag struct {
+	name  string
+	field flagField
+	mask  termios.TcFlag
+	val   termios.TcFlag
+	multi bool
+}
+
+// bool returns true if f is a single-bit flag (that can be negated)
+func (f tflag) bool() bool { return !f.multi }
+
+// isSet returns true if setting f is in effect in t
+func (f tflag) isSet(t *termios.Termios) bool {
+	return f.field.of(t).Msk(f.mask) == f.val
+}
+
+func bflag(name string, field flagField, m termios.TcFlag) tflag {
+	return tflag{name, field, m, m, false}
+}
+
+func mflag(name string, field flagField, m, v termios.TcFlag) tflag {
+	return tflag{name, field, m, v, true}
+}
+
+// tflags lists the termios mode-flags known to serstty, in display
+// order.
+var tflags = []tflag{
+	bflag("ignbrk", iflag, termios.IGNBRK),
+	bflag("brkint", iflag, termios.BRKINT),
+	bflag("ignpar", iflag, termios.IGNPAR),
+	bflag("parmrk", iflag, termios.PARMRK),
+	bflag("inpck", iflag, termios.INPCK),
+	bflag("istrip", iflag, termios.ISTRIP),
+	bflag("inlcr", iflag, termios.INLCR),
+	bflag("igncr", iflag, termios.IGNCR),
+	bflag("icrnl", iflag, termios.ICRNL),
+	bflag("ixon", iflag, termios.IXON),
+	bflag("ixoff", iflag, termios.IXOFF),
+	bflag("ixany", iflag, termios.IXANY),
+	bflag("imaxbel", iflag, termios.IMAXBEL),
+	bflag("iuclc", iflag, termios.IUCLC),
+	bflag("iutf8", iflag, termios.IUTF8),
+
+	bflag("opost", oflag, termios.OPOST),
+
+	mflag("cs5", cflag, termios.CSIZE, termios.CS5),
+	mflag("cs6", cflag, termios.CSIZE, termios.CS6),
+	mflag("cs7", cflag, termios.CSIZE, termios.CS7),
+	mflag("cs8", cflag, termios.CSIZE, termios.CS8),
+	bflag("cstopb", cflag, termios.CSTOPB),
+	bflag("cread", cflag, termios.CREAD),
+	bflag("parenb", cflag, termios.PARENB),
+	bflag("parodd", cflag, termios.PARODD),
+	bflag("cmspar", cflag, termios.CMSPAR),
+	bflag("hupcl", cflag, termios.HUPCL),
+	bflag("clocal", cflag, termios.CLOCAL),
+	bflag("crtscts", cflag, termios.CRTSCTS),
+
+	bflag("isig", lflag, termios.ISIG),
+	bflag("icanon", lflag, termios.ICANON),
+	bflag("iexten", lflag, termios.IEXTEN),
+	bflag("echo", lflag, termios.ECHO),
+	bflag("echoe", lflag, termios.ECHOE),
+	bflag("echok", lflag, termios.ECHOK),
+	bflag("echonl", lflag, termios.ECHONL),
+	bflag("noflsh", lflag, termios.NOFLSH),
+	bflag("tostop", lflag, termios.TOSTOP),
+	bflag("echoctl", lflag, termios.ECHOCTL),
+	bflag("echoprt", lflag, termios.ECHOPRT),
+	bflag("echoke", lflag, termios.ECHOKE),
+	bflag("flusho", lflag, termios.FLUSHO),
+	bflag("pendin", lflag, termios.PENDIN),
+	bflag("extproc", lflag, termios.EXTPROC),
+}
+
+// tcc describes a control character. Non-standard control characters
+// that are not available have idx == -1.
+type tcc struct {
+	name string
+	idx  int
+	num  bool // Numeric value (min, time)
+}
+
+// tccs lists the control characters known to serstty, in display
+// order.
+var tccs = []tcc{
+	{"intr", termios.VINTR, false},
+	{"quit", termios.VQUIT, false},
+	{"erase", termios.VERASE, false},
+	{"kill", termios.VKILL, false},
+	{"eof", termios.VEOF, false},
+	{"eol", termios.VEOL, false},
+	{"eol2", termios.VEOL2, false},
+	{"start", termios.VSTART, false},
+	{"stop", termios.VSTOP, false},
+	{"susp", termios.VSUSP, false},
+	{"rprnt", termios.VREPRINT, false},
+	{"werase", termios.VWERASE, false},
+	{"lnext", termios.VLNEXT, false},
+	{"discard", termios.VDISCARD, false},
+	{"min", termios.VMIN, true},
+	{"time", termios.VTIME, true},
+}
+
+// format returns the value of control character c in t, in the form
+// used by stty (e.g. "^C", "^?", "<undef>").
+func (c tcc) format(t *termios.Termios) string {
+	v := t.Cc(c.idx)
+	switch {
+	case c.num:
+		return strconv.Itoa(int(v))
+	case v == 0:
+		return "<undef>"
+	case v < 0x20:
+		return "^" + string(rune(v+'@'))
+	case v == 0x7f:
+		return "^?"
+	case v < 0x7f:
+		return string(rune(v))
+	default:
+		return fmt.Sprintf("0x%02x", int(v))
+	}
+}
+
+// parse parses s as a value for control character c. It accepts the
+// forms produced by format, as well as "undef", "^-", and numbers
+// (decimal, octal, or hex).
+func (c tcc) parse(s string) (termios.Cc, bool) {
+	if !c.num {
+		switch {
+		case s == "undef" || s == "<undef>" || s == "^-":
+			return 0, true
+		case s == "^?":
+			return 0x7f, true
+		case len(s) == 2 && s[0] == '^':
+			return termios.Cc(s[1] & 0x1f), true
+		case len(s) == 1:
+			return termios.Cc(s[0]), true
+		}
+	}
+	v, err := strconv.ParseUint(s, 0, 8)
+	if err != nil {
+		return 0, false
+	}
+	return termios.Cc(v), true
+}
+
+// settingError reports a failure to apply an stty-style setting
+type settingError struct {
+	setting string
+	msg     string
+}
+
+func (e *settingError) Error() string {
+	return e.msg + ": " + e.setting
+}
+
+// check records a setting applied to a Termios structure, so that it
+// can be verified after the structure is written to the device.
+type check struct {
+	setting string
+	ok      func(t *termios.Termios) bool
+}
+
+// applySettings applies the stty-style settings in args to t. Each
+// setting is one of:
+//
+//   <flag>         Set the mode-flag (e.g. "crtscts", "cs7")
+//   -<flag>        Clear the mode-flag (e.g. "-echo")
+//   <cc> <value>   Set control character (e.g. "intr ^C", "min 1")
+//   <speed>        Set input and output baudrate (e.g. "9600")
+//   ispeed <speed> Set input baudrate
+//   ospeed <speed> Set output baudrate
+//   raw            Set raw mode (see termios.MakeRaw)
+//
+// It returns a list of checks to be performed once t is written to
+// the device, in order to verify that the settings took effect.
+func applySettings(t *termios.Termios, args []string) ([]check, error) {
+	var checks []check
+	for i := 0; i < len(args); i++ {
+		s := args[i]
+		value := func() (string, error) {
+			if i+1 >= len(args) {
+				return "", &settingError{s, "missing value for setting"}
+			}
+			i++
+			return args[i], nil
+		}
+
+		if s == "raw" {
+			t.MakeRaw()
+			continue
+		}
+		if sp, err := strconv.Atoi(s); err == nil {
+			if err := setSpeed(t, s, sp, true, true); err != nil {
+				return nil, err
+			}
+			checks = append(checks, speedCheck(s, sp, true, true))
+			continue
+		}
+		if s == "ispeed" || s == "ospeed" {
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			sp, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, &settingError{s + " " + v, "invalid speed"}
+			}
+			in, out := s == "ispeed", s == "ospeed"
+			if err := setSpeed(t, s+" "+v, sp, in, out); err != nil {
+				return nil, err
+			}
+			checks = append(checks, speedCheck(s+" "+v, sp, in, out))
+			continue
+		}
+		if c, ok := findCc(s); ok {
+			v, err := value()
+			if err != nil {
+				return nil, err
+			}
+			if c.idx < 0 {
+				return nil, &settingError{s, "control character not supported on this system"}
+			}
+			cv, ok := c.parse(v)
+			if !ok {
+				return nil, &settingError{s + " " + v, "invalid control-character value"}
+			}
+			t.CcSet(c.idx, cv)
+			c := c
+			checks = append(checks, check{s + " " + v,
+				func(t *termios.Termios) bool { return t.Cc(c.idx) == cv }})
+			continue
+		}
+
+		name, neg := s, false
+		if len(name) > 1 && name[0] == '-' {
+			name, neg = name[1:], true
+		}
+		f, ok := findFlag(name)
+		if !ok {
+			return nil, &settingError{s, "unknown setting"}
+		}
+		if neg && !f.bool() {
+			return nil, &settingError{s, "setting cannot be negated"}
+		}
+		if f.mask == 0 {
+			return nil, &settingError{s, "setting not supported on this system"}
+		}
+		fp := f.field.of(t)
+		if neg {
+			fp.Clr(f.mask)
+		} else {
+			fp.Clr(f.mask).Set(f.val)
+		}
+		checks = append(checks, check{s,
+			func(t *termios.Termios) bool { return f.isSet(t) != neg }})
+	}
+	return checks, nil
+}
+
+func setSpeed(t *termios.Termios, s string, sp int, in, out bool) error {
+	if out {
+		if err := t.SetOSpeed(sp); err != nil {
+			return &settingError{s, "speed not supported on this system"}
+		}
+	}
+	if in {
+		if err := t.SetISpeed(sp); err != nil {
+			return &settingError{s, "speed not supported on this system"}
+		}
+	}
+	return nil
+}
+
+func speedCheck(s string, sp int, in, out bool) check {
+	return check{s, func(t *termios.Termios) bool {
+		if out {
+			if o, err := t.GetOSpeed(); err != nil || o != sp {
+				return false
+			}
+		}
+		if in {
+			// Input speed 0 means "same as output speed"
+			i, err := t.GetISpeed()
+			if err != nil {
+				return false
+			}
+			if i == 0 {
+				i, _ = t.GetOSpeed()
+			}
+			if i != sp {
+				return false
+			}
+		}
+		return true
+	}}
+}
+
+func findFlag(name string) (tflag, bool) {
+	for _, f := range tflags {
+		if f.name == name {
+			return f, true
+		}
+	}
+	return tflag{}, false
+}
+
+func findCc(name string) (tcc, bool) {
+	for _, c := range tccs {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return tcc{}, false
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/termios"
+)
+
+func TestCcFormat(t *testing.T) {
+	intr, _ := findCc("intr")
+	min, _ := findCc("min")
+	for _, x := range []struct {
+		c tcc
+		v termios.Cc
+		s string
+	}{
+		{intr, 0x03, "^C"},
+		{intr, 0x7f, "^?"},
+		{intr, 0, "<undef>"},
+		{intr, 'x', "x"},
+		{intr, 0xe4, "0xe4"},
+		{min, 0, "0"},
+		{min, 42, "42"},
+	} {
+		var ti termios.Termios
+		ti.CcSet(x.c.idx, x.v)
+		s := x.c.format(&ti)
+		if s != x.s {
+			t.Errorf("format %s %#x: %q != %q", x.c.name, x.v,
+				s, x.s)
+			continue
+		}
+		// Formatted values parse back
+		if v, ok := x.c.parse(s); !ok || v != x.v {
+			t.Errorf("parse %s %q: %#x, %v", x.c.name, s, v, ok)
+		}
+	}
+}
+
+func TestCcParse(t *testing.T) {
+	intr, _ := findCc("intr")
+	min, _ := findCc("min")
+	for _, x := range []struct {
+		c  tcc
+		s  string
+		v  termios.Cc
+		ok bool
+	}{
+		{intr, "^c", 0x03, true},
+		{intr, "undef", 0, true},
+		{intr, "^-", 0, true},
+		{intr, "3", '3', true},
+		{intr, "0x1c", 0x1c, true},
+		{intr, "034", 034, true},
+		{intr, "256", 0, false},
+		{intr, "ab", 0, false},
+		{min, "5", 5, true},
+		{min, "^C", 0, false},
+		{min, "undef", 0, false},
+	} {
+		v, ok := x.c.parse(x.s)
+		if ok != x.ok || ok && v != x.v {
+			t.Errorf("parse %s %q: %#x, %v", x.c.name, x.s, v, ok)
+		}
+	}
+}
+
+func TestApplySettings(t *testing.T) {
+	for _, x := range []struct {
+		args string
+		ok   func(t *termios.Termios) bool
+	}{
+		{"crtscts -echo", func(t *termios.Termios) bool {
+			return t.CFlag().All(termios.CRTSCTS) &&
+				!t.LFlag().Any(termios.ECHO)
+		}},
+		{"cs7 parenb parodd", func(t *termios.Termios) bool {
+			return t.CFlag().Msk(termios.CSIZE) == termios.CS7 &&
+				t.CFlag().All(termios.PARENB|termios.PARODD)
+		}},
+		{"intr ^X min 4 time 0x10", func(t *termios.Termios) bool {
+			return t.Cc(termios.VINTR) == 0x18 &&
+				t.Cc(termios.VMIN) == 4 &&
+				t.Cc(termios.VTIME) == 16
+		}},
+		{"19200", func(t *termios.Termios) bool {
+			i, _ := t.GetISpeed()
+			o, _ := t.GetOSpeed()
+			return i == 19200 && o == 19200
+		}},
+		{"ospeed 4800", func(t *termios.Termios) bool {
+			o, _ := t.GetOSpeed()
+			return o == 4800
+		}},
+		{"raw", func(t *termios.Termios) bool {
+			return t.Cc(termios.VMIN) == 1 &&
+				!t.LFlag().Any(termios.ICANON)
+		}},
+	} {
+		var ti termios.Termios
+		ti.LFlag().Set(termios.ECHO | termios.ICANON)
+		checks, err := applySettings(&ti, strings.Fields(x.args))
+		if err != nil {
+			t.Errorf("%q: %v", x.args, err)
+			continue
+		}
+		if !x.ok(&ti) {
+			t.Errorf("%q: not applied", x.args)
+		}
+		for _, c := range checks {
+			if !c.ok(&ti) {
+				t.Errorf("%q: check %q failed", x.args,
+					c.setting)
+			}
+		}
+	}
+}
+
+func TestApplySettingsError(t *testing.T) {
+	for _, x := range []struct {
+		args    string
+		setting string
+		msg     string
+	}{
+		{"echo foo", "foo", "unknown setting"},
+		{"-cs8", "-cs8", "setting cannot be negated"},
+		{"intr", "intr", "missing value for setting"},
+		{"ispeed", "ispeed", "missing value for setting"},
+		{"ispeed fast", "ispeed fast", "invalid speed"},
+		{"erase ^^^", "erase ^^^", "invalid control-character value"},
+	} {
+		var ti termios.Termios
+		_, err := applySettings(&ti, strings.Fields(x.args))
+		se, ok := err.(*settingError)
+		if !ok || se.setting != x.setting || se.msg != x.msg {
+			t.Errorf("%q: %v", x.args, err)
+		}
+	}
+}
+
+func TestMask(t *testing.T) {
+	c := serial.Conf{Baudrate: 9600, Databits: 7,
+		Parity: serial.ParityEven, Stopbits: 2, Flow: serial.FlowRTSCTS}
+	for _, x := range []struct {
+		flags serial.ConfFlags
+		s     string
+	}{
+		{serial.ConfBaudrate, "9600"},
+		{serial.ConfFormat, "7E2"},
+		{serial.ConfAll, "9600,7E2,rtscts,reset"},
+	} {
+		if s := c.StringSome(x.flags); s != x.s {
+			t.Errorf("StringSome %b: %q != %q", x.flags, s, x.s)
+		}
+		// The parameters selected are parsed back
+		p, flags, err := serial.ParseConf(x.s)
+		if err != nil || flags&^x.flags != 0 ||
+			mask(p, x.flags) != mask(c, x.flags) {
+			t.Errorf("ParseConf %q: %+v, %b, %v", x.s, p,
+				flags, err)
+		}
+	}
+}
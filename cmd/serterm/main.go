@@ -0,0 +1,136 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Command serterm is a simple interactive serial-terminal
+// program. Usage:
+//
+//   serterm [-c conf] [-e char] port
+//
+// Flags:
+//
+//   -c conf
+//         Port configuration, in the form accepted by
+//         serial.ParseConf (e.g. "115200,8N1,rtscts"). Settings not
+//         given are left unchanged.
+//   -e char
+//         Escape character. A letter, meaning the respective control
+//         character (default "a", i.e. C-a).
+//
+// Argument port can be the name of a local serial-port device, or
+// any URL accepted by serial.Open (e.g. "tcp://host:port",
+// "rfc2217://host:port").
+//
+// Serterm puts the local terminal in raw mode and relays bytes
+// between it and the serial port. To issue commands to serterm, type
+// the escape character followed by one of the command keys below:
+//
+//   C-<esc>  Send the escape character itself
+//   q, x     Quit
+//   b        Set baudrate (prompts for the value)
+//   u, d     Increase / decrease baudrate
+//   p        Cycle parity (none, even, odd)
+//   f        Cycle flow-control (none, rtscts, xonxoff)
+//   c        Set configuration string (prompts for it)
+//   k        Send break
+//   t        Toggle DTR
+//   r        Toggle RTS
+//   s        Show port status
+//   h, ?     Show help
+//
+// On exit, both the local terminal and the serial port are restored
+// to the settings they had at startup (unless "noreset" is given in
+// the configuration).
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/npat-efault/serial"
+	_ "github.com/npat-efault/serial/rfc2217"
+	"github.com/npat-efault/serial/termios"
+)
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] port\n", os.Args[0])
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+func fatal(format string, a ...interface{}) {
+	fmt.Fprintf(os.Stderr, "serterm: "+format+"\n", a...)
+	os.Exit(1)
+}
+
+func main() {
+	confStr := flag.String("c", "", "Port configuration (e.g. 115200,8N1)")
+	escStr := flag.String("e", "a", "Escape character (C-<char>)")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+	if len(*escStr) != 1 || !isLetter((*escStr)[0]) {
+		fatal("invalid escape character: %q", *escStr)
+	}
+	esc := (*escStr)[0] & 0x1f
+
+	port, err := serial.Open(flag.Arg(0))
+	if err != nil {
+		fatal("%s: %v", flag.Arg(0), err)
+	}
+	if *confStr != "" {
+		conf, flags, err := serial.ParseConf(*confStr)
+		if err != nil {
+			port.Close()
+			fatal("%v", err)
+		}
+		if err := port.ConfSome(conf, flags); err != nil {
+			port.Close()
+			fatal("%s: %v", flag.Arg(0), err)
+		}
+	}
+
+	var tiosOrig termios.Termios
+	if err := tiosOrig.GetFd(0); err != nil {
+		port.Close()
+		fatal("stdin: %v", err)
+	}
+	tios := tiosOrig
+	tios.MakeRaw()
+	if err := tios.SetFd(0, termios.TCSAFLUSH); err != nil {
+		port.Close()
+		fatal("stdin: %v", err)
+	}
+	restore := func() {
+		tiosOrig.SetFd(0, termios.TCSAFLUSH)
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGHUP, os.Interrupt)
+	go func() {
+		s := <-sigc
+		port.Close()
+		restore()
+		fatal("%v", s)
+	}()
+
+	t := newTerm(port, os.Stdin, os.Stdout, esc)
+	t.printf("serterm: %s, escape is C-%c (C-%c h for help)\n",
+		flag.Arg(0), esc|0x60, esc|0x60)
+	err = t.run()
+	port.Close()
+	restore()
+	if err != nil {
+		fatal("%v", err)
+	}
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
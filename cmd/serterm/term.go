@@ -0,0 +1,327 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/npat-efault/serial"
+)
+
+// Baudrates stepped through by the baudrate up / down commands
+var baudrates = []int{
+	300, 600, 1200, 2400, 4800, 9600, 19200, 38400, 57600,
+	115200, 230400, 460800, 921600,
+}
+
+const helpText = `*** serterm commands (type C-%c followed by):
+***   C-%c    send C-%c
+***   q, x   quit
+***   b      set baudrate
+***   u, d   baudrate up / down
+***   p      cycle parity (none, even, odd)
+***   f      cycle flow-control (none, rtscts, xonxoff)
+***   c      set configuration string
+***   k      send break
+***   t      toggle DTR
+***   r      toggle RTS
+***   s      show port status
+***   h, ?   show this help
+`
+
+// errQuit is returned by command when the user asks to quit
+var errQuit = errors.New("quit")
+
+// term relays data between the local terminal and the serial port,
+// and handles escape commands.
+type term struct {
+	port *serial.Port
+	in   io.Reader
+	out  io.Writer
+	esc  byte
+	keys chan byte // Bytes read from in
+
+	omu sync.Mutex // Serializes writes to out
+}
+
+func newTerm(port *serial.Port, in io.Reader, out io.Writer, esc byte) *term {
+	return &term{port: port, in: in, out: out, esc: esc,
+		keys: make(chan byte, 256)}
+}
+
+// printf prints a message to the local terminal. Since the terminal
+// is in raw mode, newlines are converted to CR-LF.
+func (t *term) printf(format string, a ...interface{}) {
+	s := fmt.Sprintf(format, a...)
+	s = strings.Replace(s, "\n", "\r\n", -1)
+	t.omu.Lock()
+	io.WriteString(t.out, s)
+	t.omu.Unlock()
+}
+
+// portToTerm copies data from the port to the terminal, until the
+// port is closed or fails.
+func (t *term) portToTerm(errc chan<- error) {
+	b := make([]byte, 1024)
+	for {
+		n, err := t.port.Read(b)
+		if n > 0 {
+			t.omu.Lock()
+			t.out.Write(b[:n])
+			t.omu.Unlock()
+		}
+		if err != nil {
+			if err == serial.ErrClosed {
+				err = nil
+			}
+			errc <- err
+			return
+		}
+	}
+}
+
+// readInput reads from the terminal and sends the bytes read to
+// channel t.keys. It closes the channel on error or EOF.
+func (t *term) readInput() {
+	b := make([]byte, 256)
+	for {
+		n, err := t.in.Read(b)
+		for _, c := range b[:n] {
+			t.keys <- c
+		}
+		if err != nil {
+			close(t.keys)
+			return
+		}
+	}
+}
+
+// run relays data until the user quits, or an error occurs.
+func (t *term) run() error {
+	errc := make(chan error, 1)
+	go t.portToTerm(errc)
+	go t.readInput()
+
+	escaped := false
+	for {
+		var c byte
+		var ok bool
+		select {
+		case err := <-errc:
+			if err == serial.ErrEOF {
+				t.printf("\n*** connection closed by peer\n")
+				return nil
+			}
+			return err
+		case c, ok = <-t.keys:
+			if !ok {
+				return nil
+			}
+		}
+		if !escaped && c == t.esc {
+			escaped = true
+			continue
+		}
+		if !escaped || c == t.esc {
+			escaped = false
+			if _, err := t.port.Write([]byte{c}); err != nil {
+				return err
+			}
+			continue
+		}
+		escaped = false
+		if err := t.command(c); err != nil {
+			if err == errQuit {
+				t.printf("\n*** exit\n")
+				return nil
+			}
+			t.printf("\n*** %v\n", err)
+		}
+	}
+}
+
+// command executes the escape command c
+func (t *term) command(c byte) error {
+	switch c {
+	case 'q', 'x', 'q' & 0x1f, 'x' & 0x1f:
+		return errQuit
+	case 'h', '?':
+		e := t.esc | 0x60
+		t.printf("\n"+helpText, e, e, e)
+	case 'b':
+		s, ok := t.prompt("baudrate: ")
+		if !ok {
+			return nil
+		}
+		b, err := strconv.Atoi(s)
+		if err != nil || b <= 0 {
+			return fmt.Errorf("invalid baudrate: %q", s)
+		}
+		return t.conf(serial.Conf{Baudrate: b}, serial.ConfBaudrate)
+	case 'u', 'd':
+		conf, err := t.port.GetConf()
+		if err != nil {
+			return err
+		}
+		conf.Baudrate = stepBaud(conf.Baudrate, c == 'u')
+		return t.conf(conf, serial.ConfBaudrate)
+	case 'p':
+		conf, err := t.port.GetConf()
+		if err != nil {
+			return err
+		}
+		switch conf.Parity {
+		case serial.ParityNone:
+			conf.Parity = serial.ParityEven
+		case serial.ParityEven:
+			conf.Parity = serial.ParityOdd
+		default:
+			conf.Parity = serial.ParityNone
+		}
+		return t.conf(conf, serial.ConfParity)
+	case 'f':
+		conf, err := t.port.GetConf()
+		if err != nil {
+			return err
+		}
+		switch conf.Flow {
+		case serial.FlowNone:
+			conf.Flow = serial.FlowRTSCTS
+		case serial.FlowRTSCTS:
+			conf.Flow = serial.FlowXONXOFF
+		default:
+			conf.Flow = serial.FlowNone
+		}
+		return t.conf(conf, serial.ConfFlow)
+	case 'c':
+		s, ok := t.prompt("configuration: ")
+		if !ok {
+			return nil
+		}
+		conf, flags, err := serial.ParseConf(s)
+		if err != nil {
+			return err
+		}
+		return t.conf(conf, flags)
+	case 'k':
+		if err := t.port.SendBreak(); err != nil {
+			return err
+		}
+		t.printf("\n*** break sent\n")
+	case 't':
+		return t.toggle(serial.ModemDTR)
+	case 'r':
+		return t.toggle(serial.ModemRTS)
+	case 's':
+		return t.status()
+	default:
+		return fmt.Errorf("unknown command: %q (C-%c h for help)",
+			c, t.esc|0x60)
+	}
+	return nil
+}
+
+// conf configures the port and reports the resulting configuration.
+func (t *term) conf(conf serial.Conf, flags serial.ConfFlags) error {
+	if err := t.port.ConfSome(conf, flags); err != nil {
+		return err
+	}
+	conf, err := t.port.GetConf()
+	if err != nil {
+		return err
+	}
+	t.printf("\n*** config: %v\n", conf)
+	return nil
+}
+
+// toggle toggles the modem-control line l
+func (t *term) toggle(l serial.ModemLines) error {
+	m, err := t.port.GetModem()
+	if err != nil {
+		return err
+	}
+	if err := t.port.SetModem(m^l, l); err != nil {
+		return err
+	}
+	state := "down"
+	if m&l == 0 {
+		state = "up"
+	}
+	t.printf("\n*** %v %s\n", l, state)
+	return nil
+}
+
+// status prints the port's configuration and modem lines
+func (t *term) status() error {
+	t.printf("\n*** port:   %s\n", t.port.Name)
+	if conf, err := t.port.GetConf(); err != nil {
+		t.printf("*** config: %v\n", err)
+	} else {
+		t.printf("*** config: %v\n", conf)
+	}
+	if m, err := t.port.GetModem(); err != nil {
+		t.printf("*** modem:  %v\n", err)
+	} else {
+		t.printf("*** modem:  %v\n", m)
+	}
+	return nil
+}
+
+// stepBaud returns the standard baudrate that follows (if up) or
+// precedes b.
+func stepBaud(b int, up bool) int {
+	if up {
+		for _, s := range baudrates {
+			if s > b {
+				return s
+			}
+		}
+		return baudrates[len(baudrates)-1]
+	}
+	for i := len(baudrates) - 1; i >= 0; i-- {
+		if baudrates[i] < b {
+			return baudrates[i]
+		}
+	}
+	return baudrates[0]
+}
+
+// prompt prints p and reads a line from the terminal, with
+// rudimentary editing. Returns false if the user cancels the prompt
+// (with ESC or C-c).
+func (t *term) prompt(p string) (string, bool) {
+	t.printf("\n*** %s", p)
+	var line []byte
+	for {
+		c, ok := <-t.keys
+		if !ok {
+			return "", false
+		}
+		switch c {
+		case '\r', '\n':
+			t.printf("\n")
+			return string(line), true
+		case 0x1b, 'c' & 0x1f:
+			t.printf("\n")
+			return "", false
+		case 0x7f, 0x08:
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+				t.printf("\b \b")
+			}
+		default:
+			if c >= 0x20 && c < 0x7f {
+				line = append(line, c)
+				t.printf("%c", c)
+			}
+		}
+	}
+}
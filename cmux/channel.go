@@ -0,0 +1,269 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package cmux
+
+import (
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/deadline"
+)
+
+// Channel is a multiplexer channel (DLC). Its API mirrors the
+// respective methods of serial.Port.
+type Channel struct {
+	m    *Mux
+	dlci int
+
+	// Protected by m.mu
+	rbuf      []byte        // Received data not yet read
+	suspended chan struct{} // Non-nil while the remote sent FC
+	throttled bool          // We have sent FC
+	disc      bool          // Disconnected by remote
+
+	rready    chan struct{} // Signaled when rbuf or state changes
+	rdl, wdl  *deadline.Deadline
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newChannel(m *Mux, dlci int) *Channel {
+	return &Channel{m: m, dlci: dlci,
+		rready: make(chan struct{}, 1),
+		rdl:    deadline.New(),
+		wdl:    deadline.New(),
+		closed: make(chan struct{})}
+}
+
+// DLCI returns the channel's DLCI
+func (c *Channel) DLCI() int {
+	return c.dlci
+}
+
+func (c *Channel) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendSignals sends our V.24 signals to the remote end, with an MSC
+// command. If fc is set, the remote is asked to stop sending.
+func (c *Channel) sendSignals(fc bool) error {
+	v := []byte{byte(c.dlci<<2) | cr | ea, sigRTC | sigRTR | ea}
+	if fc {
+		v[1] |= sigFC
+	}
+	return c.m.sendMsg(msgMSC, true, v)
+}
+
+// setSignals handles V.24 signals v, received from the remote end
+func (c *Channel) setSignals(v byte) {
+	c.m.mu.Lock()
+	defer c.m.mu.Unlock()
+	if v&sigFC != 0 {
+		if c.suspended == nil {
+			c.suspended = make(chan struct{})
+		}
+	} else if c.suspended != nil {
+		close(c.suspended)
+		c.suspended = nil
+	}
+}
+
+// deliver appends data received to the receive buffer. If too much
+// data are buffered, the remote end is asked to stop sending.
+func (c *Channel) deliver(b []byte) {
+	c.m.mu.Lock()
+	c.rbuf = append(c.rbuf, b...)
+	fc := !c.throttled && len(c.rbuf) > rxHigh
+	if fc {
+		c.throttled = true
+	}
+	c.m.mu.Unlock()
+	signal(c.rready)
+	if fc {
+		c.sendSignals(true)
+	}
+}
+
+// disconnect marks the channel as disconnected by the remote end
+func (c *Channel) disconnect() {
+	c.m.mu.Lock()
+	c.disc = true
+	if c.suspended != nil {
+		close(c.suspended)
+		c.suspended = nil
+	}
+	c.m.mu.Unlock()
+	signal(c.rready)
+}
+
+// Read is compatible with the Read method of the io.Reader
+// interface. In addition Read honors the timeout set by
+// Channel.SetDeadline and Channel.SetReadDeadline. If no data are
+// read before the timeout expires Read returns with err ==
+// serial.ErrTimeout (and n == 0). After the remote end disconnects
+// the channel, and all received data have been read, Read returns
+// serial.ErrEOF.
+func (c *Channel) Read(b []byte) (n int, err error) {
+	m := c.m
+	for {
+		if c.isClosed() {
+			return 0, serial.ErrClosed
+		}
+		m.mu.Lock()
+		if len(c.rbuf) > 0 {
+			n = copy(b, c.rbuf)
+			c.rbuf = c.rbuf[n:]
+			if len(c.rbuf) == 0 {
+				c.rbuf = nil
+			}
+			resume := c.throttled && len(c.rbuf) < rxLow
+			if resume {
+				c.throttled = false
+			}
+			m.mu.Unlock()
+			if resume {
+				c.sendSignals(false)
+			}
+			return n, nil
+		}
+		disc, rerr := c.disc, m.rerr
+		m.mu.Unlock()
+		if disc {
+			return 0, serial.ErrEOF
+		}
+		if rerr != nil {
+			return 0, rerr
+		}
+		select {
+		case <-c.rready:
+		case <-c.rdl.Wait():
+			return 0, serial.ErrTimeout
+		case <-c.closed:
+			return 0, serial.ErrClosed
+		}
+	}
+}
+
+// blocked returns a channel to wait on while writes are suspended by
+// flow control, or nil if they are not. It returns an error if the
+// channel is disconnected.
+func (c *Channel) blocked() (chan struct{}, error) {
+	m := c.m
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c.disc {
+		return nil, ErrDisconnected
+	}
+	if m.rerr != nil {
+		return nil, m.rerr
+	}
+	if m.fcoff != nil {
+		return m.fcoff, nil
+	}
+	return c.suspended, nil
+}
+
+// Write is compatible with the Write method of the io.Writer
+// interface. In addition Write honors the timeout set by
+// Channel.SetDeadline and Channel.SetWriteDeadline. If less than
+// len(b) data are written before the timeout expires Write returns
+// with err == serial.ErrTimeout (and n < len(b)). While the remote
+// end has suspended the transmission of data (with flow control),
+// Write blocks.
+func (c *Channel) Write(b []byte) (n int, err error) {
+	fs := c.m.frameSize()
+	for len(b) > 0 {
+		for {
+			if c.isClosed() {
+				return n, serial.ErrClosed
+			}
+			s, err := c.blocked()
+			if err != nil {
+				return n, err
+			}
+			if s == nil {
+				break
+			}
+			select {
+			case <-s:
+			case <-c.wdl.Wait():
+				return n, serial.ErrTimeout
+			case <-c.closed:
+				return n, serial.ErrClosed
+			}
+		}
+		if c.wdl.Expired() {
+			return n, serial.ErrTimeout
+		}
+		l := len(b)
+		if l > fs {
+			l = fs
+		}
+		if err := c.m.send(c.dlci, true, ctlUIH, b[:l]); err != nil {
+			return n, err
+		}
+		n += l
+		b = b[l:]
+	}
+	return n, nil
+}
+
+// SetDeadline sets the deadline for both Read and Write operations.
+// See serial.Port.SetDeadline for details.
+func (c *Channel) SetDeadline(t time.Time) error {
+	c.rdl.Set(t)
+	c.wdl.Set(t)
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read operations. See
+// serial.Port.SetReadDeadline for details.
+func (c *Channel) SetReadDeadline(t time.Time) error {
+	c.rdl.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write operations. See
+// serial.Port.SetWriteDeadline for details.
+func (c *Channel) SetWriteDeadline(t time.Time) error {
+	c.wdl.Set(t)
+	return nil
+}
+
+// Close closes the channel: It is disconnected (with DISC), unless
+// the remote end has already done so. Read and Write operations in
+// progress return serial.ErrClosed. The DLCI can then be opened
+// again.
+func (c *Channel) Close() error {
+	first := false
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		first = true
+	})
+	if !first {
+		return serial.ErrClosed
+	}
+	m := c.m
+	m.mu.Lock()
+	disc := c.disc || m.rerr != nil
+	m.mu.Unlock()
+	var err error
+	if !disc {
+		err = m.request(c.dlci, ctlDISC)
+	}
+	m.mu.Lock()
+	if m.chans[c.dlci] == c {
+		m.chans[c.dlci] = nil
+	}
+	m.mu.Unlock()
+	return err
+}
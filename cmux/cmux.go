@@ -0,0 +1,485 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package cmux implements the GSM 07.10 (3GPP TS 27.010) multiplexer
+// protocol, in userspace, over serial ports. It allows several
+// independent streams (channels, or DLCs) to run concurrently over
+// the serial line to a cellular modem: e.g. one for AT commands, and
+// one for PPP.
+//
+// Only the basic option (mode) is supported, and the Mux acts as the
+// initiator. The modem must first be switched to multiplexer mode
+// with the AT+CMUX command (e.g. "AT+CMUX=0", see package at). Then,
+// Mux.Start establishes the control channel (DLCI 0), and Mux.Open
+// opens channels. Each Channel is a stream with the same Read /
+// Write / deadline methods as serial.Port.
+//
+// Flow control
+//
+// Flow control is per channel, using modem status commands (MSC).
+// When the modem signals that it cannot accept data for a channel,
+// writes to it block. When too much received data accumulate on a
+// channel, because it is not read, the modem is asked to stop
+// sending on it, until the data are read. Aggregate flow control
+// (FCon / FCoff) is also honored.
+package cmux
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Port is the interface of the ports the multiplexer runs over. It
+// is satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Defaults for Mux fields, and protocol limits
+const (
+	DefaultFrameSize = 31              // N1, for the basic option
+	DefaultTimeout   = 1 * time.Second // T1, response timer
+	DefaultRetries   = 3               // N2
+	MaxFrameSize     = 32768
+	MaxDLCI          = 63
+)
+
+// Errors returned by multiplexers and channels
+var (
+	ErrNoResponse   = errors.New("cmux: no response")
+	ErrRefused      = errors.New("cmux: channel refused")
+	ErrDLCI         = errors.New("cmux: invalid DLCI")
+	ErrInUse        = errors.New("cmux: channel already open")
+	ErrNotStarted   = errors.New("cmux: multiplexer not started")
+	ErrDisconnected = errors.New("cmux: disconnected by remote")
+)
+
+// Receive buffer limits, for flow control. When more than rxHigh
+// bytes are buffered for a channel, the remote end is asked to stop
+// sending. When they fall below rxLow, it is asked to resume.
+const (
+	rxHigh = 16384
+	rxLow  = 4096
+)
+
+// Interval for checking if the mux is closed
+const pollInterval = 100 * time.Millisecond
+
+// Mux is a multiplexer, running over a port. Fields must be set
+// before calling Start. It is safe to call Mux and Channel methods
+// concurrently.
+type Mux struct {
+	// Max information-field length of the frames sent; zero means
+	// DefaultFrameSize. Must not exceed the max frame size (N1)
+	// configured in the modem.
+	FrameSize int
+	Timeout   time.Duration // Zero means DefaultTimeout
+	Retries   int           // Zero means DefaultRetries
+
+	port Port
+	wmu  sync.Mutex // Serializes writes to port
+	cmu  sync.Mutex // Serializes control-channel requests
+
+	mu      sync.Mutex
+	started bool
+	chans   [MaxDLCI + 1]*Channel
+	acks    [MaxDLCI + 1]chan byte // Waiting for UA / DM
+	fcoff   chan struct{}          // Non-nil while FCoff by remote
+	rerr    error                  // Sticky receive error
+
+	replies   chan []byte // Control-message responses
+	closed    chan struct{}
+	closeOnce sync.Once
+	done      chan struct{} // Closed when receiver exits
+}
+
+// NewMux returns a multiplexer that runs over port p
+func NewMux(p Port) *Mux {
+	return &Mux{port: p, replies: make(chan []byte, 4),
+		closed: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (m *Mux) frameSize() int {
+	if m.FrameSize <= 0 {
+		return DefaultFrameSize
+	}
+	if m.FrameSize > MaxFrameSize {
+		return MaxFrameSize
+	}
+	return m.FrameSize
+}
+
+func (m *Mux) timeout() time.Duration {
+	if m.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return m.Timeout
+}
+
+func (m *Mux) retries() int {
+	if m.Retries <= 0 {
+		return DefaultRetries
+	}
+	return m.Retries
+}
+
+func (m *Mux) isClosed() bool {
+	select {
+	case <-m.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// Start starts the multiplexer: It establishes the control channel
+// (DLCI 0) with the modem. The modem must already be in multiplexer
+// mode.
+func (m *Mux) Start() error {
+	m.mu.Lock()
+	if m.started || m.isClosed() {
+		m.mu.Unlock()
+		return serial.ErrClosed
+	}
+	m.started = true
+	m.mu.Unlock()
+	go m.receiver()
+	if err := m.request(0, ctlSABM); err != nil {
+		m.stop()
+		return err
+	}
+	return nil
+}
+
+// Open opens the channel with the given DLCI (1 to MaxDLCI).
+func (m *Mux) Open(dlci int) (*Channel, error) {
+	if dlci < 1 || dlci > MaxDLCI {
+		return nil, ErrDLCI
+	}
+	m.mu.Lock()
+	if !m.started {
+		m.mu.Unlock()
+		return nil, ErrNotStarted
+	}
+	if m.rerr != nil {
+		m.mu.Unlock()
+		return nil, m.rerr
+	}
+	if m.chans[dlci] != nil {
+		m.mu.Unlock()
+		return nil, ErrInUse
+	}
+	c := newChannel(m, dlci)
+	m.chans[dlci] = c
+	m.mu.Unlock()
+
+	if err := m.request(dlci, ctlSABM); err != nil {
+		m.mu.Lock()
+		m.chans[dlci] = nil
+		m.mu.Unlock()
+		return nil, err
+	}
+	// Signal that we are ready
+	if err := c.sendSignals(false); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes all open channels, and the multiplexer. The modem is
+// sent a close-down command (CLD), after which it returns to AT
+// command mode. The port is not closed.
+func (m *Mux) Close() error {
+	err := serial.ErrClosed
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		started, rerr := m.started, m.rerr
+		chans := m.chans
+		m.mu.Unlock()
+		if !started {
+			close(m.closed)
+			err = nil
+			return
+		}
+		for _, c := range chans {
+			if c != nil {
+				c.Close()
+			}
+		}
+		err = nil
+		if rerr == nil {
+			_, err = m.ctlRequest(msgCLD, nil)
+		}
+		m.stop()
+	})
+	return err
+}
+
+// stop stops the receiver
+func (m *Mux) stop() {
+	select {
+	case <-m.closed:
+	default:
+		close(m.closed)
+	}
+	<-m.done
+}
+
+// err returns the sticky receive error
+func (m *Mux) err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rerr == nil {
+		return serial.ErrClosed
+	}
+	return m.rerr
+}
+
+// send sends a frame
+func (m *Mux) send(dlci int, cmd bool, ctrl byte, info []byte) error {
+	b := appendFrame(nil, dlci, cmd, ctrl, info)
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	m.port.SetWriteDeadline(time.Now().Add(m.timeout()))
+	_, err := m.port.Write(b)
+	return err
+}
+
+// sendMsg sends a control-channel message
+func (m *Mux) sendMsg(typ byte, cmd bool, v []byte) error {
+	b := []byte{typ | ea, byte(len(v)<<1) | ea}
+	if cmd {
+		b[0] |= cr
+	}
+	return m.send(0, true, ctlUIH, append(b, v...))
+}
+
+// request sends a SABM or DISC frame for dlci, and waits for the
+// response (UA, or DM), retrying on timeouts.
+func (m *Mux) request(dlci int, ctrl byte) error {
+	ack := make(chan byte, 1)
+	m.mu.Lock()
+	m.acks[dlci] = ack
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.acks[dlci] = nil
+		m.mu.Unlock()
+	}()
+	for try := 0; try <= m.retries(); try++ {
+		if err := m.send(dlci, true, ctrl|pf, nil); err != nil {
+			return err
+		}
+		timer := time.NewTimer(m.timeout())
+		select {
+		case r := <-ack:
+			timer.Stop()
+			if r == ctlDM {
+				return ErrRefused
+			}
+			return nil
+		case <-timer.C:
+		case <-m.done:
+			timer.Stop()
+			return m.err()
+		}
+	}
+	return ErrNoResponse
+}
+
+// ctlRequest sends a control-channel command, and waits for the
+// response. It returns the response's value.
+func (m *Mux) ctlRequest(typ byte, v []byte) ([]byte, error) {
+	m.cmu.Lock()
+	defer m.cmu.Unlock()
+	// Discard stale responses to previous (timed-out) requests
+	for len(m.replies) > 0 {
+		<-m.replies
+	}
+	for try := 0; try <= m.retries(); try++ {
+		if err := m.sendMsg(typ, true, v); err != nil {
+			return nil, err
+		}
+		timer := time.NewTimer(m.timeout())
+		for wait := true; wait; {
+			select {
+			case r := <-m.replies:
+				if r[0] == typ {
+					timer.Stop()
+					return r[1:], nil
+				}
+			case <-timer.C:
+				wait = false
+			case <-m.done:
+				timer.Stop()
+				return nil, m.err()
+			}
+		}
+	}
+	return nil, ErrNoResponse
+}
+
+// channel returns the open channel with the given dlci, or nil
+func (m *Mux) channel(dlci int) *Channel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.chans[dlci]
+}
+
+// receiver runs as a separate goroutine. It reads from the port,
+// decodes the frames received, and dispatches them.
+func (m *Mux) receiver() {
+	defer close(m.done)
+	d := decoder{max: MaxFrameSize}
+	buf := make([]byte, 1024)
+	for !m.isClosed() {
+		m.port.SetReadDeadline(time.Now().Add(pollInterval))
+		n, err := m.port.Read(buf)
+		for _, c := range buf[:n] {
+			if f, ok := d.feed(c); ok {
+				m.handle(f)
+			}
+		}
+		if err != nil && !ioerr.IsTimeout(err) {
+			m.fail(err)
+			return
+		}
+	}
+	m.fail(serial.ErrClosed)
+}
+
+// fail records the receive error err, and wakes up the channels
+func (m *Mux) fail(err error) {
+	m.mu.Lock()
+	if m.rerr == nil {
+		m.rerr = err
+	}
+	chans := m.chans
+	m.mu.Unlock()
+	for _, c := range chans {
+		if c != nil {
+			signal(c.rready)
+		}
+	}
+}
+
+// handle handles received frame f
+func (m *Mux) handle(f *frame) {
+	dlci := f.dlci()
+	switch f.ctrl &^ pf {
+	case ctlUA, ctlDM:
+		m.mu.Lock()
+		ack := m.acks[dlci]
+		m.mu.Unlock()
+		if ack != nil {
+			select {
+			case ack <- f.ctrl &^ pf:
+			default:
+			}
+		}
+	case ctlSABM:
+		// We are the initiator; we do not accept channels.
+		m.send(dlci, false, ctlDM|pf, nil)
+	case ctlDISC:
+		m.send(dlci, false, ctlUA|pf, nil)
+		if dlci == 0 {
+			m.disconnect()
+		} else if c := m.channel(dlci); c != nil {
+			c.disconnect()
+		}
+	case ctlUIH, ctlUI:
+		if dlci == 0 {
+			m.control(f.info)
+		} else if c := m.channel(dlci); c != nil {
+			c.deliver(f.info)
+		}
+	}
+}
+
+// disconnect marks the multiplexer, and all channels, as
+// disconnected by the remote end.
+func (m *Mux) disconnect() {
+	m.mu.Lock()
+	if m.rerr == nil {
+		m.rerr = ErrDisconnected
+	}
+	chans := m.chans
+	m.mu.Unlock()
+	for _, c := range chans {
+		if c != nil {
+			c.disconnect()
+		}
+	}
+}
+
+// control handles control-channel message b
+func (m *Mux) control(b []byte) {
+	if len(b) < 2 {
+		return
+	}
+	typ, n, i := b[0], int(b[1]>>1), 2
+	if b[1]&ea == 0 {
+		if len(b) < 3 {
+			return
+		}
+		n |= int(b[2]) << 7
+		i = 3
+	}
+	if len(b) < i+n {
+		return
+	}
+	v := b[i : i+n]
+	t := typ &^ (cr | ea)
+	if typ&cr == 0 {
+		// Response to one of our commands
+		select {
+		case m.replies <- append([]byte{t}, v...):
+		default:
+		}
+		return
+	}
+	switch t {
+	case msgMSC:
+		if len(v) >= 2 {
+			if c := m.channel(int(v[0] >> 2)); c != nil {
+				c.setSignals(v[1])
+			}
+		}
+	case msgFCon, msgFCoff:
+		m.mu.Lock()
+		if t == msgFCoff && m.fcoff == nil {
+			m.fcoff = make(chan struct{})
+		} else if t == msgFCon && m.fcoff != nil {
+			close(m.fcoff)
+			m.fcoff = nil
+		}
+		m.mu.Unlock()
+	case msgCLD:
+		m.sendMsg(t, false, v)
+		m.disconnect()
+		return
+	case msgTest, msgPN, msgPSC:
+	default:
+		m.sendMsg(msgNSC, false, []byte{typ})
+		return
+	}
+	// Respond, with the same value
+	m.sendMsg(t, false, v)
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
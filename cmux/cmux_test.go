@@ -0,0 +1,321 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package cmux
+
+import (
+	"bytes"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+// fakeModem emulates a modem in multiplexer mode. It echoes the data
+// received on every channel.
+type fakeModem struct {
+	p      *pipe.End
+	refuse int // DLCI to refuse
+
+	mu   sync.Mutex
+	open map[int]bool
+	fc   map[int]bool // Flow control, as signaled by the mux
+	cld  bool         // Close-down received
+}
+
+func newModem(p *pipe.End) *fakeModem {
+	m := &fakeModem{p: p, refuse: -1,
+		open: map[int]bool{}, fc: map[int]bool{}}
+	go m.run()
+	return m
+}
+
+func (m *fakeModem) send(dlci int, ctrl byte, info []byte) {
+	m.p.Write(appendFrame(nil, dlci, true, ctrl, info))
+}
+
+// msg sends a control-channel message
+func (m *fakeModem) msg(typ byte, cmd bool, v ...byte) {
+	b := []byte{typ | ea, byte(len(v)<<1) | ea}
+	if cmd {
+		b[0] |= cr
+	}
+	m.send(0, ctlUIH, append(b, v...))
+}
+
+func (m *fakeModem) state(dlci int) (open, fc bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.open[dlci], m.fc[dlci]
+}
+
+func (m *fakeModem) run() {
+	d := decoder{max: MaxFrameSize}
+	b := make([]byte, 256)
+	for {
+		n, err := m.p.Read(b)
+		if err != nil {
+			return
+		}
+		for _, c := range b[:n] {
+			f, ok := d.feed(c)
+			if !ok {
+				continue
+			}
+			dlci := f.dlci()
+			switch f.ctrl &^ pf {
+			case ctlSABM:
+				if dlci == m.refuse {
+					m.send(dlci, ctlDM|pf, nil)
+					break
+				}
+				m.mu.Lock()
+				m.open[dlci] = true
+				m.mu.Unlock()
+				m.send(dlci, ctlUA|pf, nil)
+			case ctlDISC:
+				m.mu.Lock()
+				m.open[dlci] = false
+				m.mu.Unlock()
+				m.send(dlci, ctlUA|pf, nil)
+			case ctlUIH:
+				if dlci != 0 {
+					m.send(dlci, ctlUIH, f.info)
+					break
+				}
+				typ, v := f.info[0], f.info[2:]
+				if typ&cr == 0 {
+					break
+				}
+				m.mu.Lock()
+				switch typ &^ (cr | ea) {
+				case msgMSC:
+					m.fc[int(v[0]>>2)] = v[1]&sigFC != 0
+				case msgCLD:
+					m.cld = true
+				}
+				m.mu.Unlock()
+				m.msg(typ&^cr, false, v...)
+			}
+		}
+	}
+}
+
+func TestFrame(t *testing.T) {
+	b := appendFrame(nil, 0, true, ctlSABM|pf, nil)
+	if !bytes.Equal(b, []byte{0xF9, 0x03, 0x3F, 0x01, 0x1C, 0xF9}) {
+		t.Fatalf("SABM: % x", b)
+	}
+	d := decoder{max: MaxFrameSize}
+	// Garbage, UA for DLCI 0, UIH with a long information field
+	in := []byte{0x01, 0xF9, 0xF9, 0x03, 0x73, 0x01, 0xD7, 0xF9}
+	info := bytes.Repeat([]byte{0xF9, 0x01}, 100)
+	in = appendFrame(in, 2, false, ctlUIH, info)
+	var frames []frame
+	for _, c := range in {
+		if f, ok := d.feed(c); ok {
+			frames = append(frames, frame{f.addr, f.ctrl,
+				append([]byte(nil), f.info...)})
+		}
+	}
+	if len(frames) != 2 {
+		t.Fatalf("Decoded %d frames", len(frames))
+	}
+	if f := frames[0]; f.dlci() != 0 || f.ctrl != ctlUA|pf ||
+		len(f.info) != 0 {
+		t.Fatalf("UA: %+v", f)
+	}
+	if f := frames[1]; f.dlci() != 2 || f.ctrl != ctlUIH ||
+		!bytes.Equal(f.info, info) {
+		t.Fatalf("UIH: %+v", f)
+	}
+
+	// Bad FCS
+	in = appendFrame(nil, 1, true, ctlUIH, []byte("hello"))
+	in[len(in)-2] ^= 1
+	for _, c := range in {
+		if _, ok := d.feed(c); ok {
+			t.Fatal("Frame with bad FCS accepted")
+		}
+	}
+}
+
+func TestMux(t *testing.T) {
+	pm, pd := pipe.New()
+	fm := newModem(pd)
+	fm.refuse = 5
+	m := NewMux(pm)
+	m.Timeout = 200 * time.Millisecond
+	if _, err := m.Open(1); err != ErrNotStarted {
+		t.Fatalf("Open before start: %v", err)
+	}
+	if err := m.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	if _, err := m.Open(0); err != ErrDLCI {
+		t.Fatalf("Open 0: %v", err)
+	}
+	if _, err := m.Open(5); err != ErrRefused {
+		t.Fatalf("Open 5: %v", err)
+	}
+	var chans [2]*Channel
+	for i := range chans {
+		c, err := m.Open(i + 1)
+		if err != nil {
+			t.Fatal("Open:", err)
+		}
+		chans[i] = c
+	}
+	if _, err := m.Open(1); err != ErrInUse {
+		t.Fatalf("Open again: %v", err)
+	}
+
+	// Concurrent transfers, echoed back
+	var wg sync.WaitGroup
+	for i, c := range chans {
+		wg.Add(1)
+		go func(i int, c *Channel) {
+			defer wg.Done()
+			data := make([]byte, 5000)
+			rand.New(rand.NewSource(int64(i))).Read(data)
+			if _, err := c.Write(data); err != nil {
+				t.Error("Write:", err)
+				return
+			}
+			c.SetReadDeadline(time.Now().Add(5 * time.Second))
+			rcv := make([]byte, 0, len(data))
+			b := make([]byte, 512)
+			for len(rcv) < len(data) {
+				n, err := c.Read(b)
+				if err != nil {
+					t.Error("Read:", err)
+					return
+				}
+				rcv = append(rcv, b[:n]...)
+			}
+			if !bytes.Equal(rcv, data) {
+				t.Errorf("Channel %d: Data mismatch", c.DLCI())
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	if err := chans[1].Close(); err != nil {
+		t.Fatal("Close channel:", err)
+	}
+	if open, _ := fm.state(2); open {
+		t.Fatal("Channel 2 still open")
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	fm.mu.Lock()
+	open, cld := fm.open[1], fm.cld
+	fm.mu.Unlock()
+	if open || !cld {
+		t.Fatalf("After close: open: %v, CLD: %v", open, cld)
+	}
+	_, err := chans[0].Read(make([]byte, 1))
+	if err != serial.ErrClosed {
+		t.Fatalf("Read after close: %v", err)
+	}
+}
+
+func TestFlowControl(t *testing.T) {
+	pm, pd := pipe.New()
+	fm := newModem(pd)
+	m := NewMux(pm)
+	if err := m.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer m.Close()
+	c, err := m.Open(1)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+
+	// Stopped by the modem
+	fm.msg(msgMSC, true, 1<<2|cr|ea, sigFC|sigRTC|sigRTR|ea)
+	time.Sleep(50 * time.Millisecond)
+	c.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := c.Write([]byte("x"))
+	if n != 0 || err != serial.ErrTimeout {
+		t.Fatalf("Write while stopped: %d, %v", n, err)
+	}
+	c.SetWriteDeadline(time.Time{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		fm.msg(msgMSC, true, 1<<2|cr|ea, sigRTC|sigRTR|ea)
+	})
+	if _, err := c.Write([]byte("x")); err != nil {
+		t.Fatal("Write:", err)
+	}
+
+	// Stopping the modem. The echo fills our receive buffer.
+	data := make([]byte, rxHigh+1000)
+	if _, err := c.Write(data); err != nil {
+		t.Fatal("Write:", err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for _, fc := fm.state(1); !fc; _, fc = fm.state(1) {
+		if time.Now().After(deadline) {
+			t.Fatal("Modem not stopped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 1024)
+	for n := 0; n < len(data)+1; {
+		i, err := c.Read(b)
+		if err != nil {
+			t.Fatal("Read:", err)
+		}
+		n += i
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, fc := fm.state(1); fc {
+		t.Fatal("Modem not resumed")
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	pm, pd := pipe.New()
+	fm := newModem(pd)
+	m := NewMux(pm)
+	if err := m.Start(); err != nil {
+		t.Fatal("Start:", err)
+	}
+	defer m.Close()
+	c, err := m.Open(3)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	fm.send(3, ctlUIH, []byte("bye"))
+	fm.send(3, ctlDISC|pf, nil)
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	var rcv []byte
+	b := make([]byte, 16)
+	for {
+		n, err := c.Read(b)
+		rcv = append(rcv, b[:n]...)
+		if err == serial.ErrEOF {
+			break
+		}
+		if err != nil {
+			t.Fatal("Read:", err)
+		}
+	}
+	if string(rcv) != "bye" {
+		t.Fatalf("Received: %q", rcv)
+	}
+	if _, err := c.Write([]byte("x")); err != ErrDisconnected {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+}
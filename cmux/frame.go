@@ -0,0 +1,206 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package cmux
+
+// Basic-mode frame fields
+const (
+	flag = 0xF9 // Opening and closing flag
+	ea   = 0x01 // Extension bit (set in the last octet of a field)
+	cr   = 0x02 // Command / response bit
+	pf   = 0x10 // Poll / final bit
+)
+
+// Frame types (control field, without the P/F bit)
+const (
+	ctlSABM = 0x2F // Set asynchronous balanced mode
+	ctlUA   = 0x63 // Unnumbered acknowledgement
+	ctlDM   = 0x0F // Disconnected mode
+	ctlDISC = 0x43 // Disconnect
+	ctlUIH  = 0xEF // Unnumbered information, FCS over header
+	ctlUI   = 0x03 // Unnumbered information
+)
+
+// Control-channel message types (without the C/R and EA bits)
+const (
+	msgPN    = 0x80 // DLC parameter negotiation
+	msgPSC   = 0x40 // Power saving control
+	msgCLD   = 0xC0 // Multiplexer close down
+	msgTest  = 0x20 // Test
+	msgFCon  = 0xA0 // Flow control on (aggregate)
+	msgFCoff = 0x60 // Flow control off (aggregate)
+	msgMSC   = 0xE0 // Modem status command
+	msgNSC   = 0x10 // Non-supported command response
+)
+
+// V.24 signals, in MSC messages
+const (
+	sigFC  = 0x02 // Flow control: sender cannot accept frames
+	sigRTC = 0x04 // Ready to communicate (DTR / DSR)
+	sigRTR = 0x08 // Ready to receive (RTS / CTS)
+	sigIC  = 0x40 // Incoming call (RI)
+	sigDV  = 0x80 // Data valid (DCD)
+)
+
+// fcsTable is the table for the reversed CRC-8 (polynomial x^8 +
+// x^2 + x + 1) used as the frame check sequence.
+var fcsTable [256]byte
+
+// Value of the FCS computed over a correct header and its FCS
+const fcsGood = 0xCF
+
+func init() {
+	for i := range fcsTable {
+		c := byte(i)
+		for j := 0; j < 8; j++ {
+			if c&1 != 0 {
+				c = c>>1 ^ 0xE0
+			} else {
+				c >>= 1
+			}
+		}
+		fcsTable[i] = c
+	}
+}
+
+// fcs updates f with the bytes in b
+func fcs(f byte, b []byte) byte {
+	for _, c := range b {
+		f = fcsTable[f^c]
+	}
+	return f
+}
+
+// frame is a received basic-mode frame
+type frame struct {
+	addr, ctrl byte
+	info       []byte
+}
+
+func (f *frame) dlci() int {
+	return int(f.addr >> 2)
+}
+
+// appendFrame appends to dst a frame for dlci, with control field
+// ctrl and information field info. Command frames (for the
+// initiator) have the C/R bit set.
+func appendFrame(dst []byte, dlci int, cmd bool, ctrl byte,
+	info []byte) []byte {
+
+	addr := byte(dlci<<2) | ea
+	if cmd {
+		addr |= cr
+	}
+	start := len(dst) + 1
+	dst = append(dst, flag, addr, ctrl)
+	if n := len(info); n <= 127 {
+		dst = append(dst, byte(n<<1)|ea)
+	} else {
+		dst = append(dst, byte(n<<1), byte(n>>7))
+	}
+	f := fcs(0xFF, dst[start:])
+	if ctrl&^pf != ctlUIH {
+		f = fcs(f, info)
+	}
+	dst = append(dst, info...)
+	return append(dst, 0xFF-f, flag)
+}
+
+// decoder states
+const (
+	stHunt  = iota // Waiting for a flag
+	stAddr         // Address (or more flags)
+	stCtrl         // Control
+	stLen          // Length, first octet
+	stLen2         // Length, second octet
+	stInfo         // Information
+	stFCS          // Frame check sequence
+	stClose        // Closing flag
+)
+
+// decoder assembles frames from the bytes received. Since the
+// basic mode provides no transparency, frames are delimited using
+// their length, not the flags.
+type decoder struct {
+	state int
+	max   int // Max information field length
+	hdr   []byte
+	n     int // Length of the information field
+	f     frame
+}
+
+// feed feeds byte c to the decoder. It returns true when a valid
+// frame has been received. The frame is valid until the next call.
+func (d *decoder) feed(c byte) (*frame, bool) {
+	switch d.state {
+	case stHunt:
+		if c == flag {
+			d.state = stAddr
+		}
+	case stAddr:
+		if c == flag {
+			break
+		}
+		if c&ea == 0 {
+			d.state = stHunt
+			break
+		}
+		d.hdr = append(d.hdr[:0], c)
+		d.f.addr = c
+		d.state = stCtrl
+	case stCtrl:
+		d.hdr = append(d.hdr, c)
+		d.f.ctrl = c
+		d.state = stLen
+	case stLen:
+		d.hdr = append(d.hdr, c)
+		d.n = int(c >> 1)
+		if c&ea == 0 {
+			d.state = stLen2
+			break
+		}
+		d.startInfo()
+	case stLen2:
+		d.hdr = append(d.hdr, c)
+		d.n |= int(c) << 7
+		d.startInfo()
+	case stInfo:
+		d.f.info = append(d.f.info, c)
+		if len(d.f.info) == d.n {
+			d.state = stFCS
+		}
+	case stFCS:
+		f := fcs(0xFF, d.hdr)
+		if d.f.ctrl&^pf != ctlUIH {
+			f = fcs(f, d.f.info)
+		}
+		if fcs(f, []byte{c}) != fcsGood {
+			d.state = stHunt
+			break
+		}
+		d.state = stClose
+	case stClose:
+		if c != flag {
+			d.state = stHunt
+			break
+		}
+		// The closing flag may also open the next frame
+		d.state = stAddr
+		return &d.f, true
+	}
+	return nil, false
+}
+
+func (d *decoder) startInfo() {
+	d.f.info = d.f.info[:0]
+	switch {
+	case d.n > d.max:
+		d.state = stHunt
+	case d.n == 0:
+		d.state = stFCS
+	default:
+		d.state = stInfo
+	}
+}
@@ -0,0 +1,330 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package cobs implements Consistent Overhead Byte Stuffing (COBS),
+// and its reduced variant (COBS/R), and a message-oriented framer
+// that uses them over serial ports.
+//
+// Encoding
+//
+// COBS encodes a message so that it contains no zero bytes, with an
+// overhead of one byte per 254 bytes of data (or part thereof). The
+// encoded message is split in blocks, each starting with a code byte
+// giving the distance to the next code byte; a code byte smaller
+// than 0xFF implies a zero byte at the end of its block (except for
+// the last block). COBS/R additionally saves the final byte in many
+// cases: if the last data byte is not smaller than the last code
+// byte, it replaces it. Encoded messages are delimited by zero bytes.
+//
+// Framing
+//
+// A Framer wraps a port, and sends and receives whole messages
+// (frames). Each frame is sent encoded, followed by a zero
+// delimiter. Optionally, a CRC of the message is appended to it
+// before encoding, and checked on reception.
+//
+// Received frames are delimited by zero bytes; empty frames
+// (consecutive delimiters) are ignored. ReadFrame returns ErrEncoding
+// for frames that are not validly encoded (e.g. garbage received
+// before the first delimiter), ErrShort for frames shorter than the
+// CRC, ErrCRC for frames with a bad CRC, and ErrTooLong for frames
+// longer than Framer.MaxFrame (in which case the rest of the frame,
+// up to the next delimiter, is discarded). These errors affect only
+// the offending frame; the next call to ReadFrame returns the next
+// frame. Errors from the underlying port (e.g. serial.ErrTimeout) are
+// returned as-is, and a partially received frame is retained, so
+// that a subsequent call can complete it.
+package cobs
+
+import (
+	"errors"
+	"hash/crc32"
+	"sync"
+)
+
+// Port is the interface of the ports the Framer can wrap. It is
+// satisfied by *serial.Port. Read and write deadlines, if required,
+// are set directly on the port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+}
+
+// Errors returned for bad frames
+var (
+	ErrEncoding = errors.New("cobs: invalid encoding")
+	ErrShort    = errors.New("cobs: frame shorter than CRC")
+	ErrCRC      = errors.New("cobs: bad CRC")
+	ErrTooLong  = errors.New("cobs: frame too long")
+)
+
+// Encode appends the COBS encoding of src to dst and returns the
+// extended buffer. The zero delimiter is not appended.
+func Encode(dst, src []byte) []byte {
+	return encode(dst, src, false)
+}
+
+// EncodeR is like Encode, but uses COBS/R
+func EncodeR(dst, src []byte) []byte {
+	return encode(dst, src, true)
+}
+
+// Decode appends the message decoded from COBS-encoded src to dst,
+// and returns the extended buffer. Src must not include the zero
+// delimiter. If src is not validly encoded, Decode returns
+// ErrEncoding.
+func Decode(dst, src []byte) ([]byte, error) {
+	return decode(dst, src, false)
+}
+
+// DecodeR is like Decode, but for COBS/R-encoded src
+func DecodeR(dst, src []byte) ([]byte, error) {
+	return decode(dst, src, true)
+}
+
+// MaxEncodedLen returns the maximum length of the encoding of an
+// n-byte message (excluding the delimiter).
+func MaxEncodedLen(n int) int {
+	return n + n/254 + 1
+}
+
+func encode(dst, src []byte, reduced bool) []byte {
+	ci := len(dst) // Index of current code byte
+	dst = append(dst, 0)
+	code := byte(1)
+	for i, c := range src {
+		if c != 0 {
+			dst = append(dst, c)
+			code++
+			if code != 0xff || i == len(src)-1 {
+				continue
+			}
+		}
+		dst[ci] = code
+		ci = len(dst)
+		dst = append(dst, 0)
+		code = 1
+	}
+	if last := dst[len(dst)-1]; reduced && code > 1 && last >= code {
+		dst[ci] = last
+		return dst[:len(dst)-1]
+	}
+	dst[ci] = code
+	return dst
+}
+
+func decode(dst, src []byte, reduced bool) ([]byte, error) {
+	for i := 0; i < len(src); {
+		code := int(src[i])
+		i++
+		if code == 0 {
+			return dst, ErrEncoding
+		}
+		n := code - 1
+		if n > len(src)-i {
+			if !reduced {
+				return dst, ErrEncoding
+			}
+			// COBS/R: The code is the last data byte
+			dst = append(dst, src[i:]...)
+			return append(dst, byte(code)), nil
+		}
+		for _, c := range src[i : i+n] {
+			if c == 0 {
+				return dst, ErrEncoding
+			}
+		}
+		dst = append(dst, src[i:i+n]...)
+		i += n
+		if code != 0xff && i < len(src) {
+			dst = append(dst, 0)
+		}
+	}
+	return dst, nil
+}
+
+// CRC selects the CRC appended to frames
+type CRC int
+
+const (
+	CRCNone CRC = iota // No CRC (the default)
+	CRC16              // CRC-16/CCITT-FALSE, sent big-endian
+	CRC32              // CRC-32 (IEEE), sent little-endian
+)
+
+// Len returns the length of the CRC in bytes
+func (c CRC) Len() int {
+	switch c {
+	case CRC16:
+		return 2
+	case CRC32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// append appends the CRC of b to b
+func (c CRC) append(b []byte) []byte {
+	switch c {
+	case CRC16:
+		v := crc16(b)
+		return append(b, byte(v>>8), byte(v))
+	case CRC32:
+		v := crc32.ChecksumIEEE(b)
+		return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	}
+	return b
+}
+
+// check checks the CRC at the end of b, and returns b without it
+func (c CRC) check(b []byte) ([]byte, error) {
+	n := len(b) - c.Len()
+	if n < 0 {
+		return nil, ErrShort
+	}
+	if string(c.append(b[:n:n])[n:]) != string(b[n:]) {
+		return nil, ErrCRC
+	}
+	return b[:n], nil
+}
+
+// crc16 returns the CRC-16/CCITT-FALSE (polynomial 0x1021, initial
+// value 0xFFFF) of b.
+func crc16(b []byte) uint16 {
+	v := uint16(0xffff)
+	for _, c := range b {
+		v ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if v&0x8000 != 0 {
+				v = v<<1 ^ 0x1021
+			} else {
+				v <<= 1
+			}
+		}
+	}
+	return v
+}
+
+// DefaultMaxFrame is the default maximum frame length (excluding the
+// CRC).
+const DefaultMaxFrame = 1024
+
+// Framer sends and receives COBS-encoded frames over a port. Fields
+// must be set (if required) before the first frame is sent or
+// received. ReadFrame and WriteFrame can be called concurrently with
+// each other, but not with themselves.
+type Framer struct {
+	Reduced  bool // Use COBS/R
+	CRC      CRC  // CRC appended to frames
+	MaxFrame int  // Max frame length; zero means DefaultMaxFrame
+
+	port Port
+
+	// Receiver state
+	rbuf  []byte // Bytes read from port
+	roff  int    // Next byte to process in rbuf
+	frame []byte // Frame being received (encoded)
+	hunt  bool   // Discarding frame, until next delimiter
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewFramer returns a Framer that sends and receives frames over
+// port p, using COBS, without a CRC, and with DefaultMaxFrame.
+func NewFramer(p Port) *Framer {
+	return &Framer{port: p}
+}
+
+func (f *Framer) maxFrame() int {
+	if f.MaxFrame <= 0 {
+		return DefaultMaxFrame
+	}
+	return f.MaxFrame
+}
+
+// ReadFrame receives the next frame, and returns its contents
+// (decoded, and without the CRC). See the package documentation for
+// the errors returned.
+func (f *Framer) ReadFrame() ([]byte, error) {
+	if f.rbuf == nil {
+		f.rbuf = make([]byte, 0, 512)
+	}
+	max := MaxEncodedLen(f.maxFrame() + f.CRC.Len())
+	for {
+		for f.roff < len(f.rbuf) {
+			c := f.rbuf[f.roff]
+			f.roff++
+			if c == 0 {
+				b, err := f.endFrame()
+				if b != nil || err != nil {
+					return b, err
+				}
+				continue
+			}
+			if f.hunt {
+				continue
+			}
+			if len(f.frame) >= max {
+				f.frame = f.frame[:0]
+				f.hunt = true
+				return nil, ErrTooLong
+			}
+			f.frame = append(f.frame, c)
+		}
+		n, err := f.port.Read(f.rbuf[:cap(f.rbuf)])
+		f.rbuf, f.roff = f.rbuf[:n], 0
+		if n == 0 && err != nil {
+			return nil, err
+		}
+	}
+}
+
+// endFrame is called when a delimiter is received. It returns the
+// frame received (if any), or an error for a bad frame. It returns
+// nil, nil for empty frames.
+func (f *Framer) endFrame() ([]byte, error) {
+	frame, hunt := f.frame, f.hunt
+	f.frame, f.hunt = f.frame[:0], false
+	if hunt || len(frame) == 0 {
+		return nil, nil
+	}
+	b, err := decode(nil, frame, f.Reduced)
+	if err != nil {
+		return nil, err
+	}
+	if b, err = f.CRC.check(b); err != nil {
+		return nil, err
+	}
+	if len(b) > f.maxFrame() {
+		return nil, ErrTooLong
+	}
+	if b == nil {
+		b = []byte{}
+	}
+	return b, nil
+}
+
+// WriteFrame sends a frame with contents b. The frame, complete with
+// its delimiter, is sent with a single Write to the port; the error
+// returned by the port (e.g. serial.ErrTimeout, if a write deadline
+// is set) is returned as-is. If b is longer than MaxFrame,
+// WriteFrame returns ErrTooLong without sending anything.
+func (f *Framer) WriteFrame(b []byte) error {
+	if len(b) > f.maxFrame() {
+		return ErrTooLong
+	}
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	if f.CRC != CRCNone {
+		b = f.CRC.append(append([]byte(nil), b...))
+	}
+	w := encode(f.wbuf[:0], b, f.Reduced)
+	w = append(w, 0)
+	f.wbuf = w
+	_, err := f.port.Write(w)
+	return err
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package cobs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/porttest"
+)
+
+func seq(from, to int) []byte {
+	var b []byte
+	for i := from; i <= to; i++ {
+		b = append(b, byte(i))
+	}
+	return b
+}
+
+func cat(bs ...[]byte) []byte {
+	return bytes.Join(bs, nil)
+}
+
+var vectors = []struct {
+	dec, enc, encR []byte
+}{
+	{[]byte{}, []byte{1}, []byte{1}},
+	{[]byte{0}, []byte{1, 1}, []byte{1, 1}},
+	{[]byte{0, 0}, []byte{1, 1, 1}, []byte{1, 1, 1}},
+	{[]byte{0, 0x11, 0}, []byte{1, 2, 0x11, 1}, []byte{1, 2, 0x11, 1}},
+	{[]byte{0x11, 0x22, 0, 0x33}, []byte{3, 0x11, 0x22, 2, 0x33},
+		[]byte{3, 0x11, 0x22, 0x33}},
+	{[]byte{0x11, 0x22, 0x33, 0x44}, []byte{5, 0x11, 0x22, 0x33, 0x44},
+		[]byte{0x44, 0x11, 0x22, 0x33}},
+	{[]byte{0x11, 0, 0, 0}, []byte{2, 0x11, 1, 1, 1},
+		[]byte{2, 0x11, 1, 1, 1}},
+	{[]byte{2}, []byte{2, 2}, []byte{2}},
+	{[]byte{1}, []byte{2, 1}, []byte{2, 1}},
+	{seq(1, 254), cat([]byte{0xff}, seq(1, 254)),
+		cat([]byte{0xff}, seq(1, 254))},
+	{seq(0, 254), cat([]byte{1, 0xff}, seq(1, 254)),
+		cat([]byte{1, 0xff}, seq(1, 254))},
+	{seq(1, 255), cat([]byte{0xff}, seq(1, 254), []byte{2, 0xff}),
+		cat([]byte{0xff}, seq(1, 254), []byte{0xff})},
+	{cat(seq(2, 255), []byte{0}),
+		cat([]byte{0xff}, seq(2, 255), []byte{1, 1}),
+		cat([]byte{0xff}, seq(2, 255), []byte{1, 1})},
+	{cat(seq(3, 255), []byte{0, 1}),
+		cat([]byte{0xfe}, seq(3, 255), []byte{2, 1}),
+		cat([]byte{0xfe}, seq(3, 255), []byte{2, 1})},
+}
+
+func TestEncode(t *testing.T) {
+	for i, v := range vectors {
+		if e := Encode(nil, v.dec); !bytes.Equal(e, v.enc) {
+			t.Fatalf("%d: Encode: % x", i, e)
+		}
+		if e := EncodeR(nil, v.dec); !bytes.Equal(e, v.encR) {
+			t.Fatalf("%d: EncodeR: % x", i, e)
+		}
+		if len(v.enc) > MaxEncodedLen(len(v.dec)) {
+			t.Fatalf("%d: MaxEncodedLen: %d", i, MaxEncodedLen(len(v.dec)))
+		}
+		d, err := Decode(nil, v.enc)
+		if err != nil || !bytes.Equal(d, v.dec) {
+			t.Fatalf("%d: Decode: % x, %v", i, d, err)
+		}
+		d, err = DecodeR(nil, v.encR)
+		if err != nil || !bytes.Equal(d, v.dec) {
+			t.Fatalf("%d: DecodeR: % x, %v", i, d, err)
+		}
+	}
+	for _, e := range [][]byte{{3, 1}, {2, 0}, {0}} {
+		if _, err := Decode(nil, e); err != ErrEncoding {
+			t.Fatalf("Decode % x: %v", e, err)
+		}
+	}
+}
+
+func TestFramer(t *testing.T) {
+	for _, crc := range []CRC{CRCNone, CRC16, CRC32} {
+		for _, reduced := range []bool{false, true} {
+			p := &porttest.Port{}
+			f := NewFramer(p)
+			f.CRC, f.Reduced = crc, reduced
+			frames := []string{"hello", "\x00\x01\x00", "", "xyz\xff"}
+			for _, fr := range frames {
+				f.WriteFrame([]byte(fr))
+			}
+			tx := p.Tx.Bytes()
+			p.Rx = [][]byte{tx[:4], nil, tx[4:]}
+			for i := 0; i < len(frames); i++ {
+				b, err := f.ReadFrame()
+				if err == serial.ErrTimeout {
+					b, err = f.ReadFrame()
+				}
+				if err != nil || b == nil || string(b) != frames[i] {
+					t.Fatalf("CRC %d, reduced %v: ReadFrame: %q, %v",
+						crc, reduced, b, err)
+				}
+			}
+			if _, err := f.ReadFrame(); err != io.EOF {
+				t.Fatalf("ReadFrame at end: %v", err)
+			}
+		}
+	}
+}
+
+func TestCRC(t *testing.T) {
+	// Check values for "123456789"
+	if v := crc16([]byte("123456789")); v != 0x29b1 {
+		t.Fatalf("CRC-16: %04x", v)
+	}
+	p := &porttest.Port{}
+	f := NewFramer(p)
+	f.CRC = CRC16
+	f.WriteFrame([]byte("123456789"))
+	exp := "\x0c123456789\x29\xb1\x00"
+	if p.Tx.String() != exp {
+		t.Fatalf("Sent %q, expected %q", p.Tx.String(), exp)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	p := &porttest.Port{}
+	f := NewFramer(p)
+	f.CRC = CRC16
+	f.MaxFrame = 8
+	good := func(s string) []byte {
+		return append(Encode(nil, CRC16.append([]byte(s))), 0)
+	}
+	var rx []byte
+	rx = append(rx, "\x05garbage\x00"...)
+	rx = append(rx, good("one")...)
+	rx = append(rx, "\x04bad\x00"...)
+	rx = append(rx, "\x02x\x00"...)
+	rx = append(rx, good("0123456789abcdef")...)
+	rx = append(rx, "\x00\x00"...)
+	rx = append(rx, good("two")...)
+	p.Rx = [][]byte{rx}
+
+	exp := []struct {
+		frame string
+		err   error
+	}{
+		{"", ErrEncoding},
+		{"one", nil},
+		{"", ErrCRC},
+		{"", ErrShort},
+		{"", ErrTooLong},
+		{"two", nil},
+		{"", io.EOF},
+	}
+	for i, e := range exp {
+		b, err := f.ReadFrame()
+		if string(b) != e.frame || err != e.err {
+			t.Fatalf("%d: ReadFrame: %q, %v", i, b, err)
+		}
+	}
+	if err := f.WriteFrame(make([]byte, 9)); err != ErrTooLong {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// Conversion of Conf structures to and from strings.
+
+package serial
+
+import (
+	"strconv"
+	"strings"
+)
+
+var parityChars = [...]byte{
+	ParityNone: 'N', ParityEven: 'E', ParityOdd: 'O',
+	ParityMark: 'M', ParitySpace: 'S',
+}
+
+var flowNames = [...]string{
+	FlowNone: "none", FlowRTSCTS: "rtscts", FlowXONXOFF: "xonxoff",
+	FlowOther: "other",
+}
+
+// String returns the configuration parameters in c in the form
+// accepted by ParseConf. E.g: "115200,8N1,rtscts" or
+// "9600,7E2,none,noreset".
+func (c Conf) String() string {
+	flags := ConfAll &^ ConfNoReset
+	if c.NoReset {
+		flags |= ConfNoReset
+	}
+	return c.StringSome(flags)
+}
+
+// StringSome is like String, but includes only the parameters
+// selected by flags (as passed to Port.ConfSome). The format
+// (databits, parity, and stopbits) is included only if flags select
+// all of it (ConfFormat), since ParseConf accepts no partial
+// format. Values that cannot be represented (e.g. FlowOther) are
+// formatted as "?" or "other", which ParseConf does not accept.
+func (c Conf) StringSome(flags ConfFlags) string {
+	var f []string
+	if flags&ConfBaudrate != 0 {
+		f = append(f, strconv.Itoa(c.Baudrate))
+	}
+	if flags&ConfFormat == ConfFormat {
+		par := byte('?')
+		if c.Parity >= 0 && int(c.Parity) < len(parityChars) {
+			par = parityChars[c.Parity]
+		}
+		f = append(f, strconv.Itoa(c.Databits)+string(par)+
+			strconv.Itoa(c.Stopbits))
+	}
+	if flags&ConfFlow != 0 {
+		flow := "?"
+		if c.Flow >= 0 && int(c.Flow) < len(flowNames) {
+			flow = flowNames[c.Flow]
+		}
+		f = append(f, flow)
+	}
+	if flags&ConfNoReset != 0 {
+		if c.NoReset {
+			f = append(f, "noreset")
+		} else {
+			f = append(f, "reset")
+		}
+	}
+	return strings.Join(f, ",")
+}
+
+// ParseConf parses a string containing serial-port configuration
+// parameters and returns them as a Conf structure, along with flags
+// indicating which parameters were given (suitable for passing to
+// Port.ConfSome). The string consists of one or more of the following
+// items, separated by commas or spaces, in any order:
+//
+//   <baudrate>   Numeric baudrate in bits per second (e.g. 115200)
+//   <format>     Databits, parity, and stopbits, e.g. 8N1, 7E2.
+//                Databits: 5-8. Parity: N (none), E (even), O (odd),
+//                M (mark), S (space). Stopbits: 1 or 2
+//   <flow>       Flow-control mode: none, rtscts, or xonxoff
+//   noreset      Set Conf.NoReset
+//   reset        Clear Conf.NoReset
+//
+// Items are case-insensitive. For example:
+//
+//   conf, flags, err := serial.ParseConf("115200,8N1,rtscts")
+//   ...
+//   err = port.ConfSome(conf, flags)
+func ParseConf(s string) (conf Conf, flags ConfFlags, err error) {
+	f := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+	if len(f) == 0 {
+		return conf, 0, newErr("empty configuration string")
+	}
+	for _, t := range f {
+		t = strings.ToLower(t)
+		var fl ConfFlags
+		switch t {
+		case "none":
+			conf.Flow, fl = FlowNone, ConfFlow
+		case "rtscts":
+			conf.Flow, fl = FlowRTSCTS, ConfFlow
+		case "xonxoff":
+			conf.Flow, fl = FlowXONXOFF, ConfFlow
+		case "noreset":
+			conf.NoReset, fl = true, ConfNoReset
+		case "reset":
+			conf.NoReset, fl = false, ConfNoReset
+		default:
+			if b, err := strconv.Atoi(t); err == nil && b > 0 {
+				conf.Baudrate, fl = b, ConfBaudrate
+			} else if parseFormat(t, &conf) {
+				fl = ConfFormat
+			} else {
+				return Conf{}, 0,
+					newErr("invalid configuration item: " + t)
+			}
+		}
+		if flags&fl != 0 {
+			return Conf{}, 0,
+				newErr("duplicate configuration item: " + t)
+		}
+		flags |= fl
+	}
+	return conf, flags, nil
+}
+
+// parseFormat parses a character format item (e.g. "8n1") into
+// conf. Returns false if t is not a valid format item.
+func parseFormat(t string, conf *Conf) bool {
+	if len(t) != 3 {
+		return false
+	}
+	if t[0] < '5' || t[0] > '8' || (t[2] != '1' && t[2] != '2') {
+		return false
+	}
+	for i, c := range parityChars {
+		if t[1] == c|0x20 { // lower-case
+			conf.Databits = int(t[0] - '0')
+			conf.Parity = ParityMode(i)
+			conf.Stopbits = int(t[2] - '0')
+			return true
+		}
+	}
+	return false
+}
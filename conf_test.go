@@ -0,0 +1,72 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+package serial
+
+import "testing"
+
+func TestParseConf(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		conf  Conf
+		flags ConfFlags
+	}{
+		{"115200", Conf{Baudrate: 115200}, ConfBaudrate},
+		{"9600,8N1", Conf{Baudrate: 9600, Databits: 8, Stopbits: 1},
+			ConfBaudrate | ConfFormat},
+		{"7e2 xonxoff", Conf{Databits: 7, Parity: ParityEven,
+			Stopbits: 2, Flow: FlowXONXOFF}, ConfFormat | ConfFlow},
+		{"RTSCTS,noreset,5S1", Conf{Databits: 5, Parity: ParitySpace,
+			Stopbits: 1, Flow: FlowRTSCTS, NoReset: true},
+			ConfFormat | ConfFlow | ConfNoReset},
+	} {
+		conf, flags, err := ParseConf(c.s)
+		if err != nil {
+			t.Fatalf("ParseConf %q: %v", c.s, err)
+		}
+		if conf != c.conf || flags != c.flags {
+			t.Fatalf("ParseConf %q: %+v, %b != %+v, %b",
+				c.s, conf, flags, c.conf, c.flags)
+		}
+	}
+
+	for _, s := range []string{"", "9N1", "8X1", "8N3", "fast",
+		"9600,19200", "-1"} {
+		if _, _, err := ParseConf(s); err == nil {
+			t.Fatalf("ParseConf %q: no error", s)
+		}
+	}
+
+	c := Conf{Baudrate: 57600, Databits: 7, Parity: ParityOdd,
+		Stopbits: 2, Flow: FlowRTSCTS, NoReset: true}
+	s := c.String()
+	if s != "57600,7O2,rtscts,noreset" {
+		t.Fatalf("String: %q", s)
+	}
+	c1, flags, err := ParseConf(s)
+	if err != nil || c1 != c || flags != ConfAll {
+		t.Fatalf("ParseConf %q: %+v, %b, %v", s, c1, flags, err)
+	}
+
+	for _, x := range []struct {
+		flags ConfFlags
+		s     string
+	}{
+		{ConfBaudrate, "57600"},
+		{ConfFormat | ConfFlow, "7O2,rtscts"},
+		{ConfDatabits, ""},
+		{ConfBaudrate | ConfParity | ConfStopbits, "57600"},
+		{ConfBaudrate | ConfNoReset, "57600,noreset"},
+		{0, ""},
+	} {
+		if s := c.StringSome(x.flags); s != x.s {
+			t.Errorf("StringSome %b: %q != %q", x.flags, s, x.s)
+		}
+	}
+	c.Flow = FlowOther
+	if s := c.StringSome(ConfFlow); s != "other" {
+		t.Errorf("StringSome FlowOther: %q", s)
+	}
+}
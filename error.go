@@ -53,10 +53,18 @@ var (
 	// timeout or deadline has expired. ErrTimeout has Timeout()
 	// == true and Temporary() == true
 	ErrTimeout = mkErr(efTimeout, "timeout/deadline expired")
+	// ErrUnsupported is returned by Port methods to indicate that
+	// the requested operation or setting is not supported by the
+	// system or the device.
+	ErrUnsupported = newErr("operation not supported")
 	// ErrEOF is returned by Port method Read, in accordance with
 	// the io.Reader interface.
 	ErrEOF = io.EOF
 	// ErrUnexpectedEOF is returned by Port method Write, in
 	// accordance with the io.Writer interface.
 	ErrUnexpectedEOF = io.ErrUnexpectedEOF
+	// ErrTooLong is returned by LineReader methods to indicate
+	// that no delimiter was found within the maximum length
+	// allowed.
+	ErrTooLong = newErr("line too long")
 )
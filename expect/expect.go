@@ -0,0 +1,235 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package expect scripts interactions with devices over serial ports
+// (consoles, modems, bootloaders), in the manner of the expect(1)
+// program: Strings are sent to the device, and its output is matched
+// against patterns (regular expressions or literals), waiting for any
+// of several of them, with per-step timeouts.
+//
+// Interactions are scripted either in Go, using the Session methods,
+// or with script files (see Script), which command serexpect runs.
+//
+// Output buffering
+//
+// Output received from the device is buffered by the Session, and is
+// consumed only by the Expect calls that match it: Output that
+// arrives before (or between) Expect calls is matched by the next
+// call, and output following a match is retained for the next call.
+// The buffer is bounded (see Session.MaxBuffer); if it grows larger,
+// the oldest output is discarded.
+//
+// Patterns are matched against the buffered output as it arrives.
+// Patterns that can match prefixes of the intended output (e.g.
+// `\d+`) may therefore match before the whole of it has arrived.
+// Such patterns should be anchored to something that follows (e.g.
+// `(\d+)\r\n`).
+package expect
+
+import (
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Port is the interface of the ports sessions run over. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+}
+
+// Defaults for Session fields
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultMaxBuffer = 64 * 1024
+)
+
+// ErrTimeout is returned by Session.Expect if no pattern is matched
+// before the timeout expires.
+var ErrTimeout = errors.New("expect: timeout")
+
+// Interval for checking for context cancelation while waiting for
+// output.
+const pollInterval = 100 * time.Millisecond
+
+// Literal returns a regular expression matching s literally
+func Literal(s string) *regexp.Regexp {
+	return regexp.MustCompile(regexp.QuoteMeta(s))
+}
+
+// Match describes the output matched by Session.Expect
+type Match struct {
+	Index  int      // Index of the pattern matched
+	Groups []string // Match (Groups[0]) and submatches
+	Before string   // Output preceding the match
+}
+
+// Session is an interactive session with a device
+type Session struct {
+	// Default timeout for Expect. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// Max output buffered. Zero means DefaultMaxBuffer.
+	MaxBuffer int
+	// If not nil, the session's transcript is written to Log: all
+	// data received, as they are received, and all data sent.
+	Log io.Writer
+	// If set, the device echoes the data sent; data are then
+	// logged only as received.
+	Echo bool
+
+	port Port
+	rmu  sync.Mutex // Serializes Expect calls
+	wmu  sync.Mutex // Serializes Send calls
+	mu   sync.Mutex // Protects buf, and writes to Log
+	buf  []byte     // Received, not yet consumed
+	rbuf []byte
+}
+
+// NewSession returns a Session over port p
+func NewSession(p Port) *Session {
+	return &Session{port: p, rbuf: make([]byte, 1024)}
+}
+
+func (s *Session) timeout() time.Duration {
+	if s.Timeout == 0 {
+		return DefaultTimeout
+	}
+	return s.Timeout
+}
+
+func (s *Session) maxBuffer() int {
+	if s.MaxBuffer == 0 {
+		return DefaultMaxBuffer
+	}
+	return s.MaxBuffer
+}
+
+// Send sends string str to the device
+func (s *Session) Send(str string) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if s.Log != nil && !s.Echo {
+		s.mu.Lock()
+		s.Log.Write([]byte(str))
+		s.mu.Unlock()
+	}
+	_, err := s.port.Write([]byte(str))
+	return err
+}
+
+// Buffered returns the output buffered, not yet matched
+func (s *Session) Buffered() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return string(s.buf)
+}
+
+// Discard discards the output buffered
+func (s *Session) Discard() {
+	s.mu.Lock()
+	s.buf = nil
+	s.mu.Unlock()
+}
+
+// recv adds data received to the buffer, and logs them
+func (s *Session) recv(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Log != nil {
+		s.Log.Write(b)
+	}
+	s.buf = append(s.buf, b...)
+	if max := s.maxBuffer(); len(s.buf) > max {
+		s.buf = append(s.buf[:0], s.buf[len(s.buf)-max:]...)
+	}
+}
+
+// match matches the buffered output against pats. Of the patterns
+// matching, the one matching earliest is selected (or, if more than
+// one match at the same position, the first in pats). The output up
+// to the end of the match is consumed.
+func (s *Session) match(pats []*regexp.Regexp) *Match {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var loc []int
+	idx := -1
+	for i, re := range pats {
+		l := re.FindSubmatchIndex(s.buf)
+		if l != nil && (loc == nil || l[0] < loc[0]) {
+			loc, idx = l, i
+		}
+	}
+	if loc == nil {
+		return nil
+	}
+	m := &Match{Index: idx, Before: string(s.buf[:loc[0]])}
+	m.Groups = make([]string, len(loc)/2)
+	for i := range m.Groups {
+		if loc[2*i] >= 0 {
+			m.Groups[i] = string(s.buf[loc[2*i]:loc[2*i+1]])
+		}
+	}
+	s.buf = s.buf[loc[1]:]
+	if len(s.buf) == 0 {
+		s.buf = nil
+	}
+	return m
+}
+
+// Expect waits for output matching any of the patterns pats. If
+// timeout is zero, Session.Timeout is used. It returns the match, or
+// ErrTimeout if none of the patterns is matched before the timeout
+// expires. If ctx is canceled, Expect returns ctx.Err(). Errors
+// returned by the port (e.g. serial.ErrEOF) are returned as they
+// are, after the output received before them has been matched.
+func (s *Session) Expect(ctx context.Context, timeout time.Duration,
+	pats ...*regexp.Regexp) (*Match, error) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	s.rmu.Lock()
+	defer s.rmu.Unlock()
+	if timeout == 0 {
+		timeout = s.timeout()
+	}
+	dl := time.Now().Add(timeout)
+	for {
+		if m := s.match(pats); m != nil {
+			return m, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		now := time.Now()
+		if !now.Before(dl) {
+			return nil, ErrTimeout
+		}
+		d := dl
+		if t := now.Add(pollInterval); t.Before(d) {
+			d = t
+		}
+		if err := s.port.SetReadDeadline(d); err != nil {
+			return nil, err
+		}
+		n, err := s.port.Read(s.rbuf)
+		if n > 0 {
+			s.recv(s.rbuf[:n])
+		}
+		if err != nil && !ioerr.IsTimeout(err) {
+			if m := s.match(pats); m != nil {
+				return m, nil
+			}
+			return nil, err
+		}
+	}
+}
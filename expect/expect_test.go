@@ -0,0 +1,229 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package expect
+
+import (
+	"bytes"
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+var bg = context.Background()
+
+// device emulates a device answering the commands (terminated by
+// '\r') it receives. It records the commands.
+type device struct {
+	p    *pipe.End
+	resp map[string]string
+
+	mu   sync.Mutex
+	cmds []string
+}
+
+func newDevice(p *pipe.End, resp map[string]string) *device {
+	d := &device{p: p, resp: resp}
+	go d.run()
+	return d
+}
+
+func (d *device) run() {
+	var line []byte
+	b := make([]byte, 64)
+	for {
+		n, err := d.p.Read(b)
+		if err != nil {
+			return
+		}
+		for _, c := range b[:n] {
+			if c != '\r' {
+				line = append(line, c)
+				continue
+			}
+			cmd := string(line)
+			line = line[:0]
+			d.mu.Lock()
+			d.cmds = append(d.cmds, cmd)
+			d.mu.Unlock()
+			if r, ok := d.resp[cmd]; ok {
+				d.p.Write([]byte(r))
+			}
+		}
+	}
+}
+
+func (d *device) commands() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.cmds...)
+}
+
+func TestExpect(t *testing.T) {
+	ps, pd := pipe.New()
+	newDevice(pd, map[string]string{
+		"":     "\r\nlogin: ",
+		"root": "Password: ",
+		"pw":   "\r\nWelcome\r\nuptime 42 days\r\n# ",
+	})
+	var log bytes.Buffer
+	s := NewSession(ps)
+	s.Timeout = time.Second
+	s.Log = &log
+
+	if err := s.Send("\r"); err != nil {
+		t.Fatal("Send:", err)
+	}
+	m, err := s.Expect(bg, 0, Literal("login: "))
+	if err != nil || m.Index != 0 || m.Before != "\r\n" {
+		t.Fatalf("Expect login: %+v, %v", m, err)
+	}
+	s.Send("root\r")
+	s.Expect(bg, 0, Literal("Password: "))
+	s.Send("pw\r")
+
+	// Several patterns; the earliest match is selected
+	m, err = s.Expect(bg, 0, Literal("# "),
+		regexp.MustCompile(`uptime (\d+) (\w+)`), Literal("Welcome"))
+	if err != nil || m.Index != 2 {
+		t.Fatalf("Expect welcome: %+v, %v", m, err)
+	}
+	// The output following the match is retained
+	m, err = s.Expect(bg, 0, regexp.MustCompile(`uptime (\d+) (\w+)`))
+	if err != nil || len(m.Groups) != 3 || m.Groups[1] != "42" ||
+		m.Groups[2] != "days" {
+		t.Fatalf("Expect uptime: %+v, %v", m, err)
+	}
+
+	// Timeout; the output remains buffered
+	start := time.Now()
+	_, err = s.Expect(bg, 200*time.Millisecond, Literal("$ "))
+	if err != ErrTimeout {
+		t.Fatalf("Expect: %v", err)
+	}
+	d := time.Since(start)
+	if d < 200*time.Millisecond || d > time.Second {
+		t.Fatalf("Timeout after %v", d)
+	}
+	if b := s.Buffered(); b != "\r\n# " {
+		t.Fatalf("Buffered: %q", b)
+	}
+	if _, err := s.Expect(bg, 0, Literal("# ")); err != nil {
+		t.Fatal("Expect prompt:", err)
+	}
+
+	// Cancelation
+	ctx, cancel := context.WithCancel(bg)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	_, err = s.Expect(ctx, 0, Literal("$ "))
+	if err != context.Canceled {
+		t.Fatalf("Expect canceled: %v", err)
+	}
+
+	want := "\r\r\nlogin: root\rPassword: pw\r\r\nWelcome\r\n" +
+		"uptime 42 days\r\n# "
+	if log.String() != want {
+		t.Fatalf("Log: %q", log.String())
+	}
+}
+
+func TestMaxBuffer(t *testing.T) {
+	ps, pd := pipe.New()
+	s := NewSession(ps)
+	s.MaxBuffer = 8
+	pd.Write([]byte("0123456789abcdef"))
+	_, err := s.Expect(bg, 100*time.Millisecond, Literal("x"))
+	if err != ErrTimeout || s.Buffered() != "89abcdef" {
+		t.Fatalf("Expect: %v, buffered: %q", err, s.Buffered())
+	}
+	s.Discard()
+	pd.Write([]byte("end"))
+	pd.CloseWrite()
+	m, err := s.Expect(bg, time.Second, Literal("end"))
+	if err != nil || m.Before != "" {
+		t.Fatalf("Expect: %+v, %v", m, err)
+	}
+	_, err = s.Expect(bg, time.Second, Literal("x"))
+	if err != serial.ErrEOF {
+		t.Fatalf("Expect at EOF: %v", err)
+	}
+}
+
+const modemScript = `
+# Check signal quality
+timeout 1s
+send "AT\r"
+expect "OK\r\n" !"ERROR"    # comment
+send "AT+CSQ\r"
+expect -t 2s /\+CSQ: (\d+),(\d+)\r\n\r\nOK/ !/ERROR|\/fail/
+sleep 10ms
+send "AT+X=${1}," "${2}${3}\r"
+expect "OK" !"ERROR"
+`
+
+func TestScript(t *testing.T) {
+	sc, err := ParseScript(strings.NewReader(modemScript))
+	if err != nil {
+		t.Fatal("ParseScript:", err)
+	}
+	ps, pd := pipe.New()
+	d := newDevice(pd, map[string]string{
+		"AT":         "\r\nOK\r\n",
+		"AT+CSQ":     "\r\n+CSQ: 17,99\r\n\r\nOK\r\n",
+		"AT+X=17,99": "\r\nERROR\r\n",
+	})
+	s := NewSession(ps)
+	err = sc.Run(bg, s)
+	if se, ok := err.(*ScriptError); !ok || se.Line != 10 ||
+		se.Err != ErrFailed || se.Match != "ERROR" {
+		t.Fatalf("Run: %v", err)
+	}
+	cmds := []string{"AT", "AT+CSQ", "AT+X=17,99"}
+	c := d.commands()
+	if strings.Join(c, ";") != strings.Join(cmds, ";") {
+		t.Fatalf("Commands: %q", c)
+	}
+
+	// Timeout; a nil context is never canceled
+	sc, _ = ParseScript(strings.NewReader("send \"ATZ\\r\"\n" +
+		"sleep 10ms\nexpect -t 100ms \"OK\""))
+	err = sc.Run(nil, s)
+	if se, ok := err.(*ScriptError); !ok || se.Line != 3 ||
+		se.Err != ErrTimeout {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func TestParseScript(t *testing.T) {
+	bad := []struct {
+		script string
+		line   int
+	}{
+		{"send \"AT\\r\"\nfoo", 2},
+		{"\n\nsend AT", 3},
+		{"send \"AT", 1},
+		{"expect", 1},
+		{"expect -t", 1},
+		{"expect -t 1x \"OK\"", 1},
+		{"expect /(/", 1},
+		{"expect OK", 1},
+		{"expect !OK", 1},
+		{"timeout", 1},
+		{"sleep 1s 2s", 1},
+		{"\"send\"", 1},
+	}
+	for _, b := range bad {
+		_, err := ParseScript(strings.NewReader(b.script))
+		if se, ok := err.(*ScriptError); !ok || se.Line != b.line {
+			t.Errorf("ParseScript %q: %v", b.script, err)
+		}
+	}
+}
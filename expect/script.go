@@ -0,0 +1,305 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package expect
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrFailed is returned (in ScriptError) when a script's failure
+// pattern is matched.
+var ErrFailed = errors.New("expect: failure pattern matched")
+
+// ScriptError is returned for errors parsing or running scripts
+type ScriptError struct {
+	Line  int // Script line number
+	Err   error
+	Match string // For ErrFailed, the output matched
+}
+
+func (e *ScriptError) Error() string {
+	if e.Err == ErrFailed {
+		return fmt.Sprintf("script line %d: %v: %q",
+			e.Line, e.Err, e.Match)
+	}
+	return fmt.Sprintf("script line %d: %v", e.Line, e.Err)
+}
+
+// Script is an expect script. Scripts are text, with one command per
+// line. Empty lines, and text following a '#' (outside strings and
+// patterns), are ignored. The commands are:
+//
+//   send STRING...          Send the strings
+//   expect [-t DUR] PAT...  Wait for output matching any of the
+//                           patterns, with timeout DUR
+//   timeout DUR             Set the timeout for the following expect
+//                           commands (the default is Session.Timeout)
+//   sleep DUR               Pause for DUR
+//
+// Strings are double-quoted, with Go escape sequences (e.g. "AT\r").
+// In sent strings, ${N} is replaced by submatch N of the last match
+// (${0} is the whole match). Patterns are literal strings, or regular
+// expressions (in Go syntax) delimited by slashes (e.g. /CSQ: (\d+)/),
+// in which slashes are escaped as "\/". Patterns prefixed with '!'
+// are failure patterns: If they match, the script fails with
+// ErrFailed. Durations are in the form accepted by
+// time.ParseDuration (e.g. "1.5s"). For example:
+//
+//   timeout 2s
+//   send "AT\r"
+//   expect "OK\r\n" !"ERROR"
+//   send "AT+CSQ\r"
+//   expect -t 5s /\+CSQ: (\d+),/ !"ERROR"
+//   send "AT+CSQ=${1}\r"   # Silly, but demonstrates submatches
+type Script struct {
+	steps []step
+}
+
+type step struct {
+	line int
+	cmd  string
+	strs []string         // send
+	pats []*regexp.Regexp // expect
+	fail []bool           // expect: failure patterns
+	d    time.Duration    // expect -t, timeout, sleep
+}
+
+// token kinds
+const (
+	tkWord = iota
+	tkString
+	tkRegexp
+)
+
+type token struct {
+	kind int
+	text string
+	neg  bool // Prefixed with '!'
+}
+
+// scan splits line into tokens
+func scan(line string) ([]token, error) {
+	var toks []token
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" || line[0] == '#' {
+			return toks, nil
+		}
+		var t token
+		if line[0] == '!' {
+			t.neg = true
+			line = line[1:]
+			if line == "" || (line[0] != '"' && line[0] != '/') {
+				return nil, errors.New("expect: '!' not " +
+					"followed by pattern")
+			}
+		}
+		switch line[0] {
+		case '"', '/':
+			q := line[0]
+			i := 1
+			for i < len(line) && line[i] != q {
+				if line[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(line) {
+				return nil, errors.New("expect: unterminated " +
+					string(q))
+			}
+			if q == '"' {
+				s, err := strconv.Unquote(line[:i+1])
+				if err != nil {
+					return nil, fmt.Errorf("expect: bad "+
+						"string: %s", line[:i+1])
+				}
+				t.kind, t.text = tkString, s
+			} else {
+				t.kind = tkRegexp
+				t.text = strings.Replace(line[1:i],
+					`\/`, "/", -1)
+			}
+			line = line[i+1:]
+		default:
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			t.kind, t.text = tkWord, line[:i]
+			line = line[i:]
+		}
+		toks = append(toks, t)
+	}
+}
+
+// parseStep parses the tokens of a script line into st
+func parseStep(st *step, toks []token) error {
+	args := toks[1:]
+	duration := func() error {
+		if len(args) == 0 || args[0].kind != tkWord {
+			return errors.New("expect: missing duration")
+		}
+		d, err := time.ParseDuration(args[0].text)
+		if err != nil || d < 0 {
+			return errors.New("expect: bad duration: " +
+				args[0].text)
+		}
+		st.d, args = d, args[1:]
+		return nil
+	}
+	switch st.cmd {
+	case "send":
+		for _, t := range args {
+			if t.kind != tkString || t.neg {
+				return errors.New("expect: send: " +
+					"expected string")
+			}
+			st.strs = append(st.strs, t.text)
+		}
+	case "expect":
+		if len(args) > 0 && args[0].kind == tkWord &&
+			args[0].text == "-t" {
+			args = args[1:]
+			if err := duration(); err != nil {
+				return err
+			}
+		}
+		if len(args) == 0 {
+			return errors.New("expect: expect: missing pattern")
+		}
+		for _, t := range args {
+			var re *regexp.Regexp
+			switch t.kind {
+			case tkString:
+				re = Literal(t.text)
+			case tkRegexp:
+				var err error
+				re, err = regexp.Compile(t.text)
+				if err != nil {
+					return fmt.Errorf("expect: %v", err)
+				}
+			default:
+				return errors.New("expect: expect: " +
+					"expected pattern: " + t.text)
+			}
+			st.pats = append(st.pats, re)
+			st.fail = append(st.fail, t.neg)
+		}
+	case "timeout", "sleep":
+		if err := duration(); err != nil {
+			return err
+		}
+	default:
+		return errors.New("expect: unknown command: " + st.cmd)
+	}
+	if st.cmd != "send" && st.cmd != "expect" && len(args) > 0 {
+		return errors.New("expect: " + st.cmd + ": extra arguments")
+	}
+	return nil
+}
+
+// ParseScript parses a script, read from r. Syntax errors are
+// reported as *ScriptError.
+func ParseScript(r io.Reader) (*Script, error) {
+	sc := &Script{}
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		toks, err := scan(s.Text())
+		if err != nil {
+			return nil, &ScriptError{Line: n, Err: err}
+		}
+		if len(toks) == 0 {
+			continue
+		}
+		if toks[0].kind != tkWord || toks[0].neg {
+			return nil, &ScriptError{Line: n,
+				Err: errors.New("expect: expected command")}
+		}
+		st := step{line: n, cmd: toks[0].text}
+		if err := parseStep(&st, toks); err != nil {
+			return nil, &ScriptError{Line: n, Err: err}
+		}
+		sc.steps = append(sc.steps, st)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+var subRe = regexp.MustCompile(`\$\{(\d+)\}`)
+
+// subst replaces the ${N} references in str with groups[N]
+func subst(str string, groups []string) string {
+	return subRe.ReplaceAllStringFunc(str, func(r string) string {
+		i, _ := strconv.Atoi(r[2 : len(r)-1])
+		if i < len(groups) {
+			return groups[i]
+		}
+		return ""
+	})
+}
+
+// Run runs the script over session s. It stops at the first error,
+// which it returns as *ScriptError. If ctx is canceled, Run stops
+// with ctx.Err() (in ScriptError).
+func (sc *Script) Run(ctx context.Context, s *Session) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var groups []string
+	var timeout time.Duration
+	for _, st := range sc.steps {
+		var err error
+		switch st.cmd {
+		case "send":
+			for _, str := range st.strs {
+				err = s.Send(subst(str, groups))
+				if err != nil {
+					break
+				}
+			}
+		case "expect":
+			d := st.d
+			if d == 0 {
+				d = timeout
+			}
+			var m *Match
+			m, err = s.Expect(ctx, d, st.pats...)
+			if err == nil {
+				groups = m.Groups
+				if st.fail[m.Index] {
+					return &ScriptError{
+						Line: st.line, Err: ErrFailed,
+						Match: m.Groups[0]}
+				}
+			}
+		case "timeout":
+			timeout = st.d
+		case "sleep":
+			t := time.NewTimer(st.d)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				err = ctx.Err()
+			}
+		}
+		if err != nil {
+			return &ScriptError{Line: st.line, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package framing
+
+import (
+	"sync"
+	"time"
+)
+
+// ASCII control characters used by DLE framing
+const (
+	dle = 0x10
+	stx = 0x02
+	etx = 0x03
+)
+
+// DLE sends and receives frames delimited by DLE STX and DLE ETX.
+// Within frames, DLE bytes are doubled. Bytes received outside frames
+// are discarded. A DLE STX received within a frame aborts it (and
+// starts a new one); ReadFrame then returns ErrAborted. A DLE followed
+// by anything other than STX, ETX, or DLE is an encoding error.
+// Unlike SLIP, empty frames (DLE STX DLE ETX) are returned.
+//
+// Fields must be set (if required) before the first frame is sent or
+// received. ReadFrame and WriteFrame can be called concurrently with
+// each other, but not with themselves.
+type DLE struct {
+	MaxFrame     int  // Max frame length; zero means DefaultMaxFrame
+	ResetOnError bool // Discard partial frames on port errors
+
+	rx
+	in  bool // Within a frame
+	esc bool // Previous byte was a DLE
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewDLE returns a DLE/STX/ETX framer that sends and receives frames
+// over port p.
+func NewDLE(p Port) *DLE {
+	return &DLE{rx: rx{port: p}}
+}
+
+// SetReadDeadline sets the read deadline of the port
+func (d *DLE) SetReadDeadline(t time.Time) error {
+	return d.port.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the port
+func (d *DLE) SetWriteDeadline(t time.Time) error {
+	return d.port.SetWriteDeadline(t)
+}
+
+// ReadFrame receives the next frame, and returns its contents. See
+// the package documentation, and the DLE type documentation, for the
+// errors returned.
+func (d *DLE) ReadFrame() ([]byte, error) {
+	max := maxFrame(d.MaxFrame)
+	for {
+		c, err := d.next()
+		if err != nil {
+			if d.ResetOnError {
+				d.frame, d.in, d.esc = d.frame[:0], false, false
+			}
+			return nil, err
+		}
+		if !d.esc && c == dle {
+			d.esc = true
+			continue
+		}
+		wasDLE := d.esc
+		d.esc = false
+		if !d.in {
+			if wasDLE && c == stx {
+				d.in = true
+			}
+			continue
+		}
+		if wasDLE {
+			switch c {
+			case etx:
+				d.in = false
+				return d.take(), nil
+			case stx:
+				d.frame = d.frame[:0]
+				return nil, ErrAborted
+			case dle:
+			default:
+				d.frame, d.in = d.frame[:0], false
+				return nil, ErrEncoding
+			}
+		}
+		if len(d.frame) >= max {
+			d.frame, d.in = d.frame[:0], false
+			return nil, ErrTooLong
+		}
+		d.frame = append(d.frame, c)
+	}
+}
+
+// WriteFrame sends a frame with contents b. The frame is sent with a
+// single Write to the port; the error returned by the port (e.g.
+// serial.ErrTimeout, if a write deadline is set) is returned as-is.
+// If b is longer than MaxFrame, WriteFrame returns ErrTooLong without
+// sending anything.
+func (d *DLE) WriteFrame(b []byte) error {
+	if len(b) > maxFrame(d.MaxFrame) {
+		return ErrTooLong
+	}
+	d.wmu.Lock()
+	defer d.wmu.Unlock()
+	w := append(d.wbuf[:0], dle, stx)
+	for _, c := range b {
+		if c == dle {
+			w = append(w, dle)
+		}
+		w = append(w, c)
+	}
+	w = append(w, dle, etx)
+	d.wbuf = w
+	_, err := d.port.Write(w)
+	return err
+}
@@ -0,0 +1,124 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package framing defines a common interface for frame-oriented
+// protocols run over serial ports, and implements the simple
+// byte-stuffing framings: SLIP (RFC 1055), its KISS variant (used by
+// amateur-radio TNCs), and DLE/STX/ETX framing with DLE doubling.
+//
+// The framers in packages github.com/npat-efault/serial/hdlc and
+// github.com/npat-efault/serial/cobs also implement the interface,
+// so code written against it can use any of them.
+//
+// Deadlines
+//
+// Framers read and write through the port they wrap, so the port's
+// deadlines apply to ReadFrame and WriteFrame. Deadlines can be set
+// on the port directly, or through the framer's SetReadDeadline and
+// SetWriteDeadline methods. When a deadline expires, the port's
+// error (e.g. serial.ErrTimeout) is returned as-is.
+//
+// Partial frames
+//
+// If ReadFrame fails because of a port error (e.g. a deadline expired
+// in the middle of a frame), the part of the frame already received
+// is normally retained, and a subsequent call can complete it. If
+// ResetOnError is set, the partial frame is discarded instead, and
+// the next call waits for a new frame to start; this avoids gluing
+// the head of a frame whose sender stopped midway to the frame that
+// follows. Frames received with invalid encoding are reported with
+// ErrEncoding, and frames longer than MaxFrame with ErrTooLong; in
+// both cases the rest of the frame is discarded, and the next call
+// to ReadFrame returns the next frame.
+package framing
+
+import (
+	"errors"
+	"time"
+)
+
+// Reader is the interface implemented by frame readers. ReadFrame
+// returns the contents of the next frame received.
+type Reader interface {
+	ReadFrame() ([]byte, error)
+}
+
+// Writer is the interface implemented by frame writers. WriteFrame
+// sends a frame with contents b.
+type Writer interface {
+	WriteFrame(b []byte) error
+}
+
+// ReadWriter groups the Reader and Writer interfaces
+type ReadWriter interface {
+	Reader
+	Writer
+}
+
+// Port is the interface of the ports the framers in this package can
+// wrap. It is satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// DefaultMaxFrame is the default maximum frame length
+const DefaultMaxFrame = 1024
+
+// Errors returned for bad frames
+var (
+	ErrEncoding = errors.New("framing: invalid encoding")
+	ErrTooLong  = errors.New("framing: frame too long")
+	ErrAborted  = errors.New("framing: frame aborted")
+)
+
+// rx is the receiving end of a framer: it buffers data read from the
+// port, and collects the frame being received.
+type rx struct {
+	port  Port
+	buf   []byte // Bytes read from port
+	off   int    // Next byte to process in buf
+	frame []byte // Frame being received
+}
+
+// next returns the next byte received from the port
+func (r *rx) next() (byte, error) {
+	if r.off == len(r.buf) {
+		if r.buf == nil {
+			r.buf = make([]byte, 0, 512)
+		}
+		n, err := r.port.Read(r.buf[:cap(r.buf)])
+		r.buf, r.off = r.buf[:n], 0
+		if n == 0 {
+			if err == nil {
+				err = errNoProgress
+			}
+			return 0, err
+		}
+	}
+	c := r.buf[r.off]
+	r.off++
+	return c, nil
+}
+
+// take returns (a copy of) the frame received, and resets it
+func (r *rx) take() []byte {
+	b := append([]byte{}, r.frame...)
+	r.frame = r.frame[:0]
+	return b
+}
+
+// errNoProgress is returned by rx.next if the port's Read returns no
+// data and no error.
+var errNoProgress = errors.New("framing: port read returned no data")
+
+func maxFrame(max int) int {
+	if max <= 0 {
+		return DefaultMaxFrame
+	}
+	return max
+}
@@ -0,0 +1,170 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package framing
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/cobs"
+	"github.com/npat-efault/serial/hdlc"
+	"github.com/npat-efault/serial/internal/porttest"
+)
+
+// The other framers implement the interface
+var (
+	_ ReadWriter = (*hdlc.Framer)(nil)
+	_ ReadWriter = (*cobs.Framer)(nil)
+	_ ReadWriter = (*SLIP)(nil)
+	_ ReadWriter = (*KISS)(nil)
+	_ ReadWriter = (*DLE)(nil)
+)
+
+type result struct {
+	frame string
+	err   error
+}
+
+func readAll(t *testing.T, r Reader, exp []result) {
+	for i, e := range exp {
+		b, err := r.ReadFrame()
+		if string(b) != e.frame || err != e.err ||
+			err == nil && b == nil {
+			t.Fatalf("%d: ReadFrame: %q, %v", i, b, err)
+		}
+	}
+}
+
+func TestSLIP(t *testing.T) {
+	p := &porttest.Port{}
+	s := NewSLIP(p)
+	s.WriteFrame([]byte("a\xc0b\xdbc"))
+	if exp := "\xc0a\xdb\xdcb\xdb\xddc\xc0"; p.Tx.String() != exp {
+		t.Fatalf("Sent %q, expected %q", p.Tx.String(), exp)
+	}
+	s.NoLeadingEnd = true
+	p.Tx.Reset()
+	s.WriteFrame([]byte("x"))
+	if exp := "x\xc0"; p.Tx.String() != exp {
+		t.Fatalf("Sent %q, expected %q", p.Tx.String(), exp)
+	}
+
+	s.MaxFrame = 4
+	if err := s.WriteFrame([]byte("12345")); err != ErrTooLong {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+	p.Rx = [][]byte{
+		[]byte("\xc0a\xdb\xdcb\xc0\xc0\xc0"),
+		[]byte("bad\xdbx\xc0"),
+		[]byte("12345678\xc0"),
+		[]byte("bad\xdb\xc0"),
+		[]byte("pa"), nil, []byte("rt\xc0"),
+	}
+	readAll(t, s, []result{
+		{"a\xc0b", nil},
+		{"", ErrEncoding},
+		{"", ErrTooLong},
+		{"", ErrEncoding},
+		{"", serial.ErrTimeout},
+		{"part", nil},
+		{"", io.EOF},
+	})
+}
+
+func TestResetOnError(t *testing.T) {
+	p := &porttest.Port{}
+	s := NewSLIP(p)
+	s.ResetOnError = true
+	p.Rx = [][]byte{[]byte("head"), nil, []byte("\xc0next\xc0")}
+	readAll(t, s, []result{
+		{"", serial.ErrTimeout},
+		{"next", nil},
+	})
+
+	d := NewDLE(p)
+	d.ResetOnError = true
+	p.Rx = [][]byte{[]byte("\x10\x02head"), nil,
+		[]byte("tail\x10\x03\x10\x02next\x10\x03")}
+	readAll(t, d, []result{
+		{"", serial.ErrTimeout},
+		{"next", nil},
+	})
+}
+
+func TestKISS(t *testing.T) {
+	p := &porttest.Port{}
+	k := NewKISS(p)
+	k.TNCPort = 1
+	k.WriteFrame([]byte("data\xc0"))
+	k.WriteCommand(KISSTxDelay, []byte{50})
+	k.WriteCommand(KISSReturn, nil)
+	exp := "\xc0\x10data\xdb\xdc\xc0" + "\xc0\x11\x32\xc0" + "\xc0\xff\xc0"
+	if p.Tx.String() != exp {
+		t.Fatalf("Sent %q, expected %q", p.Tx.String(), exp)
+	}
+
+	p.Rx = [][]byte{[]byte("\xc0\x00port0\xc0\xc0\x16hw\xc0" +
+		"\xc0\x10port1\xc0")}
+	port, cmd, data, err := k.ReadKISS()
+	if port != 0 || cmd != KISSData || string(data) != "port0" ||
+		err != nil {
+		t.Fatalf("ReadKISS: %d, %d, %q, %v", port, cmd, data, err)
+	}
+	readAll(t, k, []result{
+		{"port1", nil},
+		{"", io.EOF},
+	})
+}
+
+func TestDLE(t *testing.T) {
+	p := &porttest.Port{}
+	d := NewDLE(p)
+	d.WriteFrame([]byte("a\x10b"))
+	d.WriteFrame(nil)
+	exp := "\x10\x02a\x10\x10b\x10\x03" + "\x10\x02\x10\x03"
+	if p.Tx.String() != exp {
+		t.Fatalf("Sent %q, expected %q", p.Tx.String(), exp)
+	}
+
+	d.MaxFrame = 4
+	p.Rx = [][]byte{
+		[]byte("noise\x10\x03\x10\x02a\x10\x10b\x10\x03"),
+		[]byte("\x10\x02\x10\x03"),
+		[]byte("\x10\x02lost\x10\x02new\x10\x03"),
+		[]byte("\x10\x02bad\x10x\x10\x03"),
+		[]byte("\x10\x0212345\x10\x03"),
+		[]byte("\x10\x02pa"), nil, []byte("rt\x10\x03"),
+	}
+	readAll(t, d, []result{
+		{"a\x10b", nil},
+		{"", nil},
+		{"", ErrAborted},
+		{"new", nil},
+		{"", ErrEncoding},
+		{"", ErrTooLong},
+		{"", serial.ErrTimeout},
+		{"part", nil},
+		{"", io.EOF},
+	})
+}
+
+func TestDeadlines(t *testing.T) {
+	p := &porttest.Port{}
+	t0 := time.Now()
+	for _, f := range []interface {
+		SetReadDeadline(time.Time) error
+		SetWriteDeadline(time.Time) error
+	}{NewSLIP(p), NewKISS(p), NewDLE(p)} {
+		p.Rdl, p.Wdl = time.Time{}, time.Time{}
+		f.SetReadDeadline(t0)
+		f.SetWriteDeadline(t0.Add(time.Second))
+		if !p.Rdl.Equal(t0) || !p.Wdl.Equal(t0.Add(time.Second)) {
+			t.Fatalf("%T: Deadlines not set on port", f)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package framing
+
+// KISS commands (the low nibble of the frame's type byte)
+const (
+	KISSData        = 0x00 // Data frame
+	KISSTxDelay     = 0x01 // Transmitter keyup delay (10ms units)
+	KISSPersist     = 0x02 // Persistence parameter
+	KISSSlotTime    = 0x03 // Slot interval (10ms units)
+	KISSTxTail      = 0x04 // Time to hold after transmission (obsolete)
+	KISSFullDuplex  = 0x05 // Full duplex (0: off, else on)
+	KISSSetHardware = 0x06 // Hardware-specific
+	KISSReturn      = 0xFF // Exit KISS mode (sent as a full type byte)
+)
+
+// KISS sends and receives frames using the KISS protocol, spoken by
+// amateur-radio TNCs. KISS uses SLIP framing (FEND, FESC, TFEND, and
+// TFESC are SLIP's End, Esc, EscEnd, and EscEsc), with a type byte at
+// the start of each frame: the high nibble selects the TNC port
+// (0-15), and the low nibble the command. The fields of the embedded
+// SLIP framer configure framing; MaxFrame includes the type byte.
+//
+// ReadFrame and WriteFrame exchange data frames with TNC port
+// TNCPort; ReadKISS and WriteCommand give access to all frames.
+type KISS struct {
+	SLIP
+	TNCPort int // TNC port for data frames (0-15)
+}
+
+// NewKISS returns a KISS framer that sends and receives frames over
+// port p, on TNC port 0.
+func NewKISS(p Port) *KISS {
+	return &KISS{SLIP: SLIP{rx: rx{port: p}}}
+}
+
+// ReadKISS receives the next frame, and returns the TNC port and
+// command from its type byte, and its data. See the package
+// documentation for the errors returned.
+func (k *KISS) ReadKISS() (port, cmd int, data []byte, err error) {
+	b, err := k.SLIP.ReadFrame()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	return int(b[0] >> 4), int(b[0] & 0x0f), b[1:], nil
+}
+
+// ReadFrame receives the next data frame for TNC port TNCPort, and
+// returns its contents. Other frames are discarded.
+func (k *KISS) ReadFrame() ([]byte, error) {
+	for {
+		port, cmd, data, err := k.ReadKISS()
+		if err != nil {
+			return nil, err
+		}
+		if port == k.TNCPort && cmd == KISSData {
+			return data, nil
+		}
+	}
+}
+
+// WriteFrame sends b as a data frame to TNC port TNCPort
+func (k *KISS) WriteFrame(b []byte) error {
+	return k.WriteCommand(KISSData, b)
+}
+
+// WriteCommand sends a frame with command cmd, for TNC port TNCPort,
+// and data b. For KISSReturn, the type byte is 0xFF regardless of
+// TNCPort.
+func (k *KISS) WriteCommand(cmd int, b []byte) error {
+	typ := byte(k.TNCPort<<4 | cmd&0x0f)
+	if cmd == KISSReturn {
+		typ = KISSReturn
+	}
+	return k.SLIP.WriteFrame(append([]byte{typ}, b...))
+}
@@ -0,0 +1,150 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package framing
+
+import (
+	"sync"
+	"time"
+)
+
+// SLIP special bytes (RFC 1055)
+const (
+	SLIPEnd    = 0xC0 // Frame end
+	SLIPEsc    = 0xDB // Escape
+	SLIPEscEnd = 0xDC // Escaped End
+	SLIPEscEsc = 0xDD // Escaped Esc
+)
+
+// SLIP sends and receives SLIP frames (RFC 1055) over a port. Frames
+// are terminated by an End byte; End and Esc bytes within frames are
+// transmitted escaped. Empty frames (consecutive End bytes) are
+// ignored. An Esc followed by anything other than EscEnd or EscEsc
+// is an encoding error.
+//
+// Fields must be set (if required) before the first frame is sent or
+// received. ReadFrame and WriteFrame can be called concurrently with
+// each other, but not with themselves.
+type SLIP struct {
+	MaxFrame     int  // Max frame length; zero means DefaultMaxFrame
+	ResetOnError bool // Discard partial frames on port errors
+	NoLeadingEnd bool // Do not send an End byte before each frame
+
+	rx
+	esc  bool // Previous byte was an Esc
+	hunt bool // Discarding frame, until next End
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewSLIP returns a SLIP framer that sends and receives frames over
+// port p. By default, an End byte is sent before, as well as after
+// each frame, to flush any line noise received by the peer.
+func NewSLIP(p Port) *SLIP {
+	return &SLIP{rx: rx{port: p}}
+}
+
+// SetReadDeadline sets the read deadline of the port
+func (s *SLIP) SetReadDeadline(t time.Time) error {
+	return s.port.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the write deadline of the port
+func (s *SLIP) SetWriteDeadline(t time.Time) error {
+	return s.port.SetWriteDeadline(t)
+}
+
+// reset discards the frame being received
+func (s *SLIP) reset() {
+	s.frame, s.esc, s.hunt = s.frame[:0], false, false
+}
+
+// ReadFrame receives the next frame, and returns its contents. See
+// the package documentation for the errors returned.
+func (s *SLIP) ReadFrame() ([]byte, error) {
+	max := maxFrame(s.MaxFrame)
+	for {
+		c, err := s.next()
+		if err != nil {
+			if s.ResetOnError {
+				s.reset()
+			}
+			return nil, err
+		}
+		if c == SLIPEnd {
+			esc, hunt := s.esc, s.hunt
+			if esc || hunt || len(s.frame) == 0 {
+				s.reset()
+				if esc {
+					return nil, ErrEncoding
+				}
+				continue
+			}
+			s.esc = false
+			return s.take(), nil
+		}
+		if s.hunt {
+			continue
+		}
+		if s.esc {
+			s.esc = false
+			switch c {
+			case SLIPEscEnd:
+				c = SLIPEnd
+			case SLIPEscEsc:
+				c = SLIPEsc
+			default:
+				s.hunt = true
+				return nil, ErrEncoding
+			}
+		} else if c == SLIPEsc {
+			s.esc = true
+			continue
+		}
+		if len(s.frame) >= max {
+			s.hunt = true
+			return nil, ErrTooLong
+		}
+		s.frame = append(s.frame, c)
+	}
+}
+
+// WriteFrame sends a frame with contents b. The frame is sent with a
+// single Write to the port; the error returned by the port (e.g.
+// serial.ErrTimeout, if a write deadline is set) is returned as-is.
+// If b is longer than MaxFrame, WriteFrame returns ErrTooLong without
+// sending anything.
+func (s *SLIP) WriteFrame(b []byte) error {
+	if len(b) > maxFrame(s.MaxFrame) {
+		return ErrTooLong
+	}
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	w := s.wbuf[:0]
+	if !s.NoLeadingEnd {
+		w = append(w, SLIPEnd)
+	}
+	w = slipEscape(w, b)
+	w = append(w, SLIPEnd)
+	s.wbuf = w
+	_, err := s.port.Write(w)
+	return err
+}
+
+// slipEscape appends b to w, escaping End and Esc bytes
+func slipEscape(w, b []byte) []byte {
+	for _, c := range b {
+		switch c {
+		case SLIPEnd:
+			w = append(w, SLIPEsc, SLIPEscEnd)
+		case SLIPEsc:
+			w = append(w, SLIPEsc, SLIPEscEsc)
+		default:
+			w = append(w, c)
+		}
+	}
+	return w
+}
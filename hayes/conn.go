@@ -0,0 +1,222 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package hayes
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/at"
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Conn is a connection (call) placed by a Dialer. Read and Write can
+// be called concurrently with each other, and with Close.
+type Conn struct {
+	Result string // The CONNECT result, e.g. "CONNECT 28800/ARQ"
+	Rate   int    // The rate reported with CONNECT; zero if none
+
+	d    *Dialer
+	rmu  sync.Mutex // Serializes reads, protects pend
+	pend []byte     // Received with the CONNECT result
+	wmu  sync.Mutex // Serializes writes
+
+	mu       sync.Mutex
+	rdl, wdl time.Time
+	last     time.Time // Time of last write
+	err      error     // Sticky error (ErrNoCarrier or ErrClosed)
+	closed   bool
+}
+
+// check returns the sticky error, if any, or ErrNoCarrier if the
+// carrier is lost.
+func (c *Conn) check() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err != nil || c.d.IgnoreDCD {
+		return c.err
+	}
+	m, err := c.d.port.GetModem()
+	if err != nil {
+		return err
+	}
+	if m&serial.ModemDCD == 0 {
+		c.err = ErrNoCarrier
+	}
+	return c.err
+}
+
+// Read reads data received from the remote end. It returns
+// ErrNoCarrier if the carrier is lost, and ErrClosed if the
+// connection is closed. If the read deadline expires, the port's
+// timeout error (e.g. serial.ErrTimeout) is returned.
+func (c *Conn) Read(b []byte) (n int, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if len(c.pend) > 0 {
+		n = copy(b, c.pend)
+		c.pend = c.pend[n:]
+		return n, nil
+	}
+	for {
+		if err := c.check(); err != nil {
+			return 0, err
+		}
+		c.mu.Lock()
+		dl := c.rdl
+		c.mu.Unlock()
+		// Wake up periodically to check the carrier
+		pdl := time.Now().Add(pollInterval)
+		if !dl.IsZero() && dl.Before(pdl) {
+			pdl = dl
+		}
+		c.d.port.SetReadDeadline(pdl)
+		n, err = c.d.port.Read(b)
+		if n > 0 || err == nil {
+			return n, nil
+		}
+		if !ioerr.IsTimeout(err) {
+			return 0, err
+		}
+		if !dl.IsZero() && !time.Now().Before(dl) {
+			return 0, err
+		}
+	}
+}
+
+// Write sends data to the remote end. It returns ErrNoCarrier if the
+// carrier is lost, and ErrClosed if the connection is closed.
+func (c *Conn) Write(b []byte) (n int, err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if err := c.check(); err != nil {
+		return 0, err
+	}
+	c.mu.Lock()
+	dl := c.wdl
+	c.mu.Unlock()
+	c.d.port.SetWriteDeadline(dl)
+	n, err = c.d.port.Write(b)
+	c.mu.Lock()
+	c.last = time.Now()
+	c.mu.Unlock()
+	return n, err
+}
+
+// SetDeadline sets the deadline for both Read and Write operations
+// on the connection. See serial.Port.SetDeadline.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl, c.wdl = t, t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read operations on the
+// connection. It also affects Read operations in progress.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write operations on the
+// connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdl = t
+	c.mu.Unlock()
+	return nil
+}
+
+// Close hangs up the call (unless the carrier is already lost),
+// using the dialer's hangup method. Read and Write operations in
+// progress return ErrClosed. Afterwards, the dialer can place another
+// call. Calling Close again returns ErrClosed.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.closed = true
+	lost := c.err == ErrNoCarrier
+	c.err = ErrClosed
+	c.mu.Unlock()
+	if lost {
+		// Already on-hook
+		return nil
+	}
+	// Wait for reads and writes in progress to notice
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if c.d.Hangup == HangupEscape {
+		return c.escape()
+	}
+	p := c.d.port
+	if err := p.SetModem(0, serial.ModemDTR); err != nil {
+		return err
+	}
+	time.Sleep(c.d.dtrDrop())
+	return p.SetModem(serial.ModemDTR, serial.ModemDTR)
+}
+
+// escape returns the modem to command mode with the "+++" escape
+// sequence, and hangs up with ATH.
+func (c *Conn) escape() error {
+	g := c.d.guardTime()
+	c.mu.Lock()
+	last := c.last
+	c.mu.Unlock()
+	time.Sleep(last.Add(g).Sub(time.Now()))
+	p := c.d.port
+	p.SetWriteDeadline(time.Now().Add(g))
+	if _, err := p.Write([]byte("+++")); err != nil {
+		return err
+	}
+	// The modem responds with OK after the guard time. Data
+	// received in the meantime are discarded.
+	tmo := c.d.Timeout
+	if tmo <= 0 {
+		tmo = at.DefaultTimeout
+	}
+	if err := c.waitOK(time.Now().Add(g + tmo)); err != nil {
+		return err
+	}
+	hup := &at.Cmd{Text: "ATH", Timeout: c.d.Timeout}
+	_, err := c.d.e.Exec(context.Background(), hup)
+	return err
+}
+
+// waitOK reads from the port until an OK result is received, or
+// until dl.
+func (c *Conn) waitOK(dl time.Time) error {
+	p := c.d.port
+	var buf []byte
+	b := make([]byte, 64)
+	for !bytes.Contains(buf, []byte("OK\r")) {
+		if !time.Now().Before(dl) {
+			return at.ErrTimeout
+		}
+		if len(buf) > len(b) {
+			buf = buf[len(buf)-3:]
+		}
+		p.SetReadDeadline(dl)
+		n, err := p.Read(b)
+		buf = append(buf, b[:n]...)
+		if err != nil && !ioerr.IsTimeout(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package hayes places calls through Hayes-compatible (AT command)
+// modems, attached to serial ports.
+//
+// A Dialer initializes the modem, dials (ATD), and waits for it to
+// connect. The connection's data stream is exposed as a Conn, which
+// has the same Read / Write / deadline methods as serial.Port. While
+// connected, the Data Carrier Detect line is supervised: if the
+// carrier is lost, Conn methods return ErrNoCarrier. For this, the
+// modem must be configured so that DCD follows the carrier (&C1), as
+// DefaultInit does.
+//
+// Hanging up
+//
+// Calls are hung up either by dropping the Data Terminal Ready line
+// (which requires the modem to be configured with &D2), or with the
+// "+++" escape sequence, followed by the ATH command. The escape
+// sequence must be preceded and followed by a period of silence (the
+// guard time, modem register S12), for the modem to recognize it.
+package hayes
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/at"
+)
+
+// Port is the interface of the ports the dialer operates on. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	GetModem() (serial.ModemLines, error)
+	SetModem(lines, mask serial.ModemLines) error
+}
+
+// Defaults for Dialer fields
+const (
+	DefaultDialTimeout = 60 * time.Second
+	DefaultGuardTime   = 1 * time.Second
+	DefaultDTRDrop     = 500 * time.Millisecond
+)
+
+// DefaultInit are the default modem initialization commands: Reset to
+// factory defaults, disable echo, select verbose results, make DCD
+// follow the carrier, and hang up when DTR drops.
+var DefaultInit = []string{"AT&F", "ATE0V1&C1&D2"}
+
+// Errors returned by dialers and connections
+var (
+	ErrNoCarrier = errors.New("hayes: carrier lost")
+	ErrClosed    = errors.New("hayes: connection closed")
+)
+
+// HangupMethod selects the way calls are hung up
+type HangupMethod int
+
+const (
+	HangupDTR    HangupMethod = iota // Drop DTR (the default)
+	HangupEscape                     // Send "+++", then ATH
+)
+
+// Interval for checking the carrier (and the context)
+const pollInterval = 100 * time.Millisecond
+
+// Dialer places calls through a modem. Fields must be set before
+// calling Dial.
+type Dialer struct {
+	// Initialization commands, sent before dialing. Nil means
+	// DefaultInit. Set to an empty slice to send none.
+	Init []string
+	// Timeout for the initialization commands; zero means
+	// at.DefaultTimeout
+	Timeout time.Duration
+	// Time to wait for the modem to connect; zero means
+	// DefaultDialTimeout
+	DialTimeout time.Duration
+
+	Hangup HangupMethod
+	// Silence before and after the escape sequence; zero means
+	// DefaultGuardTime. Must be longer than the modem's guard
+	// time (S12).
+	GuardTime time.Duration
+	// Time DTR is held dropped; zero means DefaultDTRDrop
+	DTRDrop time.Duration
+	// Do not supervise the carrier. Set this for modems (or
+	// cables) that do not provide DCD.
+	IgnoreDCD bool
+
+	port Port
+	e    *at.Engine
+}
+
+// NewDialer returns a Dialer that places calls through the modem on
+// port p.
+func NewDialer(p Port) *Dialer {
+	return &Dialer{port: p, e: at.NewEngine(p)}
+}
+
+// Engine returns the AT command engine the dialer uses. It can be
+// used to issue additional commands to the modem, while no call is
+// in progress.
+func (d *Dialer) Engine() *at.Engine {
+	return d.e
+}
+
+func (d *Dialer) guardTime() time.Duration {
+	if d.GuardTime <= 0 {
+		return DefaultGuardTime
+	}
+	return d.GuardTime
+}
+
+func (d *Dialer) dtrDrop() time.Duration {
+	if d.DTRDrop <= 0 {
+		return DefaultDTRDrop
+	}
+	return d.DTRDrop
+}
+
+// parseRate returns the rate reported in CONNECT result r (e.g.
+// "CONNECT 28800/ARQ/V34"), or zero if there is none.
+func parseRate(r string) int {
+	s := strings.TrimSpace(strings.TrimPrefix(r, "CONNECT"))
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	v, _ := strconv.Atoi(s[:i])
+	return v
+}
+
+// Dial asserts DTR (if the port has modem lines), initializes the
+// modem, and dials number (with ATD). It returns the connection when
+// the modem reports CONNECT. If the modem reports an error (e.g.
+// BUSY, NO CARRIER), it is returned as an *at.Error. If the context
+// is canceled while dialing, the call is aborted, and the context's
+// error is returned.
+func (d *Dialer) Dial(ctx context.Context, number string) (*Conn,
+	error) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err := d.port.SetModem(serial.ModemDTR, serial.ModemDTR)
+	if err != nil && err != serial.ErrUnsupported {
+		return nil, err
+	}
+	init := d.Init
+	if init == nil {
+		init = DefaultInit
+	}
+	for _, s := range init {
+		_, err := d.e.Exec(ctx, &at.Cmd{Text: s, Timeout: d.Timeout})
+		if err != nil {
+			return nil, err
+		}
+	}
+	tmo := d.DialTimeout
+	if tmo <= 0 {
+		tmo = DefaultDialTimeout
+	}
+	dial := &at.Cmd{Text: "ATD" + number, Timeout: tmo}
+	r, err := d.e.Exec(ctx, dial)
+	if err != nil {
+		if err == ctx.Err() || err == at.ErrTimeout {
+			// Any character aborts dialing
+			d.port.SetWriteDeadline(time.Now().Add(time.Second))
+			d.port.Write([]byte("\r"))
+		}
+		return nil, err
+	}
+	c := &Conn{Result: r.Result, Rate: parseRate(r.Result), d: d,
+		pend: d.e.Buffered(), last: time.Now()}
+	return c, nil
+}
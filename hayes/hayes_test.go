@@ -0,0 +1,308 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package hayes
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/at"
+)
+
+var bg = context.Background()
+
+// fakeModem emulates a modem. Its Port is the serial port it is
+// attached to.
+type fakeModem struct {
+	rx, tx chan []byte // From / to the port
+	guard  time.Duration
+
+	mu      sync.Mutex
+	dtr     bool
+	dcd     bool
+	data    bool      // In data mode
+	escaped bool      // Escape sequence recognized
+	cmds    []string  // Commands received
+	last    time.Time // Last data received
+}
+
+func newModem(guard time.Duration) *fakeModem {
+	m := &fakeModem{rx: make(chan []byte, 256),
+		tx: make(chan []byte, 256), guard: guard}
+	go m.run()
+	return m
+}
+
+func (m *fakeModem) send(s string) {
+	m.tx <- []byte(s)
+}
+
+func (m *fakeModem) setDCD(on bool) {
+	m.mu.Lock()
+	m.dcd = on
+	m.mu.Unlock()
+}
+
+func (m *fakeModem) online() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dcd && m.data
+}
+
+func (m *fakeModem) command(cmd string) {
+	m.mu.Lock()
+	m.cmds = append(m.cmds, cmd)
+	m.mu.Unlock()
+	switch {
+	case cmd == "ATD555":
+		m.send("\r\nBUSY\r\n")
+	case cmd == "ATD123":
+		m.mu.Lock()
+		m.dcd, m.data = true, true
+		m.last = time.Now()
+		m.mu.Unlock()
+		m.send("\r\nCONNECT 28800/ARQ\r\nhi")
+	case cmd == "ATH":
+		m.mu.Lock()
+		m.dcd, m.data = false, false
+		m.mu.Unlock()
+		m.send("\r\nOK\r\n")
+	case strings.HasPrefix(cmd, "AT"):
+		m.send("\r\nOK\r\n")
+	}
+}
+
+func (m *fakeModem) run() {
+	var cmd []byte
+	for b := range m.rx {
+		if m.online() {
+			// Data mode: Echo data, and watch for the escape
+			m.mu.Lock()
+			esc := string(b) == "+++" &&
+				time.Since(m.last) >= m.guard
+			m.last = time.Now()
+			m.escaped = m.escaped || esc
+			m.data = !esc
+			m.mu.Unlock()
+			if !esc {
+				m.tx <- b
+				continue
+			}
+			time.Sleep(m.guard)
+			m.send("\r\nOK\r\n")
+			continue
+		}
+		for _, c := range b {
+			if c != '\r' {
+				cmd = append(cmd, c)
+				continue
+			}
+			m.command(string(cmd))
+			cmd = cmd[:0]
+		}
+	}
+}
+
+// port is the port the modem is attached to
+type port struct {
+	m    *fakeModem
+	mu   sync.Mutex
+	rdl  time.Time
+	pend []byte
+}
+
+func (p *port) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	dl := p.rdl
+	if len(p.pend) > 0 {
+		n := copy(b, p.pend)
+		p.pend = p.pend[n:]
+		p.mu.Unlock()
+		return n, nil
+	}
+	p.mu.Unlock()
+	d := dl.Sub(time.Now())
+	if d < 0 {
+		d = 0
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	var c []byte
+	select {
+	case c = <-p.m.tx:
+	case <-t.C:
+		return 0, serial.ErrTimeout
+	}
+	n := copy(b, c)
+	p.mu.Lock()
+	p.pend = c[n:]
+	p.mu.Unlock()
+	return n, nil
+}
+
+func (p *port) Write(b []byte) (int, error) {
+	p.m.rx <- append([]byte(nil), b...)
+	return len(b), nil
+}
+
+func (p *port) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.rdl = t
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *port) SetWriteDeadline(t time.Time) error { return nil }
+
+func (p *port) GetModem() (serial.ModemLines, error) {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	var l serial.ModemLines
+	if p.m.dtr {
+		l |= serial.ModemDTR
+	}
+	if p.m.dcd {
+		l |= serial.ModemDCD
+	}
+	return l, nil
+}
+
+func (p *port) SetModem(lines, mask serial.ModemLines) error {
+	p.m.mu.Lock()
+	defer p.m.mu.Unlock()
+	if mask&serial.ModemDTR != 0 {
+		p.m.dtr = lines&serial.ModemDTR != 0
+		if !p.m.dtr {
+			// &D2: Hang up
+			p.m.dcd = false
+		}
+	}
+	return nil
+}
+
+func TestDial(t *testing.T) {
+	m := newModem(time.Second)
+	d := NewDialer(&port{m: m})
+	d.DTRDrop = 50 * time.Millisecond
+	_, err := d.Dial(bg, "555")
+	if e, ok := err.(*at.Error); !ok || e.Result != "BUSY" {
+		t.Fatalf("Dial busy: %v", err)
+	}
+	c, err := d.Dial(bg, "123")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	if c.Result != "CONNECT 28800/ARQ" || c.Rate != 28800 {
+		t.Fatalf("Connected: %q, %d", c.Result, c.Rate)
+	}
+	m.mu.Lock()
+	cmds := strings.Join(m.cmds, "|")
+	m.mu.Unlock()
+	if cmds != "AT&F|ATE0V1&C1&D2|ATD555|AT&F|ATE0V1&C1&D2|ATD123" {
+		t.Fatalf("Commands: %s", cmds)
+	}
+
+	// Data, echoed back by the modem
+	var b [16]byte
+	var rcv []byte
+	if _, err := c.Write([]byte("ping")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	c.SetReadDeadline(time.Now().Add(time.Second))
+	for len(rcv) < 6 {
+		n, err := c.Read(b[:])
+		if err != nil {
+			t.Fatal("Read:", err)
+		}
+		rcv = append(rcv, b[:n]...)
+	}
+	if string(rcv) != "hiping" {
+		t.Fatalf("Received: %q", rcv)
+	}
+	c.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := c.Read(b[:]); err != serial.ErrTimeout {
+		t.Fatalf("Read: %v", err)
+	}
+
+	// Hang up by dropping DTR
+	if err := c.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	if l, _ := d.port.GetModem(); l != serial.ModemDTR {
+		t.Fatalf("Lines after hangup: %v", l)
+	}
+	if _, err := c.Read(b[:]); err != ErrClosed {
+		t.Fatalf("Read after close: %v", err)
+	}
+	if err := c.Close(); err != ErrClosed {
+		t.Fatalf("Close again: %v", err)
+	}
+}
+
+func TestCarrierLoss(t *testing.T) {
+	m := newModem(time.Second)
+	d := NewDialer(&port{m: m})
+	c, err := d.Dial(bg, "123")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	time.AfterFunc(100*time.Millisecond, func() { m.setDCD(false) })
+	var b [16]byte
+	for err == nil {
+		_, err = c.Read(b[:])
+	}
+	if err != ErrNoCarrier {
+		t.Fatalf("Read: %v", err)
+	}
+	if _, err := c.Write([]byte("x")); err != ErrNoCarrier {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+}
+
+func TestEscape(t *testing.T) {
+	m := newModem(100 * time.Millisecond)
+	d := NewDialer(&port{m: m})
+	d.Hangup, d.GuardTime = HangupEscape, 150*time.Millisecond
+	c, err := d.Dial(bg, "123")
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	if _, err := c.Write([]byte("data")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.escaped || m.dcd || m.cmds[len(m.cmds)-1] != "ATH" {
+		t.Fatalf("Escaped: %v, DCD: %v, Commands: %q", m.escaped,
+			m.dcd, m.cmds)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for _, tst := range []struct {
+		r    string
+		rate int
+	}{
+		{"CONNECT", 0},
+		{"CONNECT 9600", 9600},
+		{"CONNECT 33600/V.42", 33600},
+		{"CONNECT FAX", 0},
+	} {
+		if v := parseRate(tst.r); v != tst.rate {
+			t.Errorf("%s: %d", tst.r, v)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package hdlc implements PPP-style asynchronous HDLC framing (RFC
+// 1662) over serial ports. A Framer wraps a port, and sends and
+// receives whole frames.
+//
+// Frame format
+//
+// Frames are delimited by flag bytes (0x7E). Within a frame, flag
+// and control-escape (0x7D) bytes, as well as the control characters
+// (0x00 to 0x1F) selected by the Async-Control-Character-Map (ACCM),
+// are transmitted as 0x7D followed by the byte xor-ed with 0x20. Each
+// frame ends with a Frame Check Sequence: a 16-bit (FCS-16) or 32-bit
+// (FCS-32) CRC of the frame contents, transmitted least-significant
+// byte first. Alternatively, frames can be sent and received without
+// an FCS.
+//
+// Received frames
+//
+// Bytes received before the first flag are discarded; empty frames
+// (consecutive flags) are ignored. ReadFrame returns ErrFCS for
+// frames with a bad FCS, ErrShort for frames shorter than the FCS,
+// ErrAbort for frames aborted by the sender (by a 0x7D followed by a
+// flag), and ErrTooLong for frames longer than Framer.MaxFrame (in
+// which case the rest of the frame, up to the next flag, is
+// discarded). These errors affect only the offending frame; the next
+// call to ReadFrame returns the next frame. Errors from the
+// underlying port (e.g. serial.ErrTimeout) are returned as-is, and a
+// partially received frame is retained, so that a subsequent call
+// can complete it.
+package hdlc
+
+import (
+	"errors"
+	"hash/crc32"
+	"sync"
+)
+
+// Port is the interface of the ports the Framer can wrap. It is
+// satisfied by *serial.Port. Read and write deadlines, if required,
+// are set directly on the port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+}
+
+// FCS selects the Frame Check Sequence
+type FCS int
+
+const (
+	FCS16   FCS = iota // 16-bit FCS (the default)
+	FCS32              // 32-bit FCS
+	FCSNone            // No FCS
+)
+
+// Len returns the length of the FCS in bytes
+func (f FCS) Len() int {
+	switch f {
+	case FCS16:
+		return 2
+	case FCS32:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// Special bytes
+const (
+	Flag   = 0x7E // Frame delimiter
+	Escape = 0x7D // Control escape
+)
+
+// DefaultACCM is the default Async-Control-Character-Map: all control
+// characters are escaped.
+const DefaultACCM = 0xffffffff
+
+// DefaultMaxFrame is the default maximum frame length (excluding the
+// FCS).
+const DefaultMaxFrame = 1500
+
+// Errors returned for bad frames
+var (
+	ErrFCS     = errors.New("hdlc: bad FCS")
+	ErrShort   = errors.New("hdlc: frame shorter than FCS")
+	ErrAbort   = errors.New("hdlc: frame aborted")
+	ErrTooLong = errors.New("hdlc: frame too long")
+)
+
+// FCS-16 residue, and FCS-32 residue, calculated over a good frame
+// including its FCS.
+const (
+	goodFCS16 = 0xf0b8
+	goodFCS32 = 0xdebb20e3
+)
+
+// Framer sends and receives HDLC frames over a port. Fields must be
+// set (if required) before the first frame is sent or received.
+// ReadFrame and WriteFrame can be called concurrently with each
+// other, but not with themselves.
+type Framer struct {
+	FCS      FCS    // Frame Check Sequence
+	TxACCM   uint32 // Control characters to escape when sending
+	RxACCM   uint32 // Control characters to discard when receiving
+	MaxFrame int    // Max frame length; zero means DefaultMaxFrame
+
+	port Port
+
+	// Receiver state
+	rbuf  []byte // Bytes read from port
+	roff  int    // Next byte to process in rbuf
+	frame []byte // Frame being received
+	sync  bool   // Seen a flag
+	esc   bool   // Previous byte was an Escape
+	hunt  bool   // Discarding frame, until next flag
+
+	wmu  sync.Mutex
+	wbuf []byte
+}
+
+// NewFramer returns a Framer that sends and receives frames over
+// port p, with FCS-16, DefaultACCM for both directions, and
+// DefaultMaxFrame.
+func NewFramer(p Port) *Framer {
+	return &Framer{
+		FCS:    FCS16,
+		TxACCM: DefaultACCM,
+		RxACCM: DefaultACCM,
+		port:   p,
+	}
+}
+
+func (f *Framer) maxFrame() int {
+	if f.MaxFrame <= 0 {
+		return DefaultMaxFrame
+	}
+	return f.MaxFrame
+}
+
+// ReadFrame receives the next frame, and returns its contents
+// (without the FCS). See the package documentation for the errors
+// returned.
+func (f *Framer) ReadFrame() ([]byte, error) {
+	if f.rbuf == nil {
+		f.rbuf = make([]byte, 0, 512)
+	}
+	max := f.maxFrame() + f.FCS.Len()
+	for {
+		for f.roff < len(f.rbuf) {
+			c := f.rbuf[f.roff]
+			f.roff++
+			if c == Flag {
+				b, err := f.endFrame()
+				if b != nil || err != nil {
+					return b, err
+				}
+				continue
+			}
+			if !f.sync || f.hunt {
+				continue
+			}
+			if c < 0x20 && f.RxACCM&(1<<c) != 0 {
+				// Inserted by DCE; discard.
+				continue
+			}
+			if c == Escape {
+				f.esc = true
+				continue
+			}
+			if f.esc {
+				c ^= 0x20
+				f.esc = false
+			}
+			if len(f.frame) >= max {
+				f.frame = f.frame[:0]
+				f.hunt = true
+				return nil, ErrTooLong
+			}
+			f.frame = append(f.frame, c)
+		}
+		n, err := f.port.Read(f.rbuf[:cap(f.rbuf)])
+		f.rbuf, f.roff = f.rbuf[:n], 0
+		if n == 0 && err != nil {
+			return nil, err
+		}
+	}
+}
+
+// endFrame is called when a flag is received. It returns the frame
+// received (if any), or an error for a bad frame. It returns nil, nil
+// for empty frames.
+func (f *Framer) endFrame() (b []byte, err error) {
+	frame, esc, hunt := f.frame, f.esc, f.hunt
+	f.frame, f.esc, f.hunt = f.frame[:0], false, false
+	f.sync = true
+	switch {
+	case hunt:
+		return nil, nil
+	case esc:
+		return nil, ErrAbort
+	case len(frame) == 0:
+		return nil, nil
+	case len(frame) < f.FCS.Len():
+		return nil, ErrShort
+	}
+	switch f.FCS {
+	case FCS16:
+		if fcs16(0xffff, frame) != goodFCS16 {
+			return nil, ErrFCS
+		}
+	case FCS32:
+		if ^crc32.Update(0, crc32.IEEETable, frame) != goodFCS32 {
+			return nil, ErrFCS
+		}
+	}
+	n := len(frame) - f.FCS.Len()
+	return append([]byte(nil), frame[:n]...), nil
+}
+
+// WriteFrame sends a frame with contents b. The frame, complete with
+// its flags, is sent with a single Write to the port; the error
+// returned by the port (e.g. serial.ErrTimeout, if a write deadline
+// is set) is returned as-is. If b is longer than MaxFrame,
+// WriteFrame returns ErrTooLong without sending anything.
+func (f *Framer) WriteFrame(b []byte) error {
+	if len(b) > f.maxFrame() {
+		return ErrTooLong
+	}
+	f.wmu.Lock()
+	defer f.wmu.Unlock()
+	w := append(f.wbuf[:0], Flag)
+	w = f.escape(w, b)
+	switch f.FCS {
+	case FCS16:
+		fcs := ^fcs16(0xffff, b)
+		w = f.escape(w, []byte{byte(fcs), byte(fcs >> 8)})
+	case FCS32:
+		fcs := crc32.ChecksumIEEE(b)
+		w = f.escape(w, []byte{byte(fcs), byte(fcs >> 8),
+			byte(fcs >> 16), byte(fcs >> 24)})
+	}
+	w = append(w, Flag)
+	f.wbuf = w
+	_, err := f.port.Write(w)
+	return err
+}
+
+// escape appends b to w, escaping bytes as required
+func (f *Framer) escape(w, b []byte) []byte {
+	for _, c := range b {
+		if c == Flag || c == Escape ||
+			c < 0x20 && f.TxACCM&(1<<c) != 0 {
+			w = append(w, Escape, c^0x20)
+		} else {
+			w = append(w, c)
+		}
+	}
+	return w
+}
+
+// fcs16tab is the table for the FCS-16 calculation (RFC 1662,
+// Appendix C.2)
+var fcs16tab = func() (t [256]uint16) {
+	for b := range t {
+		v := uint16(b)
+		for i := 0; i < 8; i++ {
+			if v&1 != 0 {
+				v = v>>1 ^ 0x8408
+			} else {
+				v >>= 1
+			}
+		}
+		t[b] = v
+	}
+	return t
+}()
+
+// fcs16 updates FCS-16 value fcs with the bytes in b
+func fcs16(fcs uint16, b []byte) uint16 {
+	for _, c := range b {
+		fcs = fcs>>8 ^ fcs16tab[byte(fcs)^c]
+	}
+	return fcs
+}
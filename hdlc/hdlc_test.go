@@ -0,0 +1,130 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package hdlc
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/porttest"
+)
+
+func TestWrite(t *testing.T) {
+	tests := []struct {
+		fcs  FCS
+		accm uint32
+		data string
+		exp  string
+	}{
+		{FCS16, DefaultACCM, "123456789",
+			"\x7e123456789\x6e\x90\x7e"},
+		{FCS32, DefaultACCM, "123456789",
+			"\x7e123456789\x26\x39\xf4\xcb\x7e"},
+		{FCSNone, DefaultACCM, "a\x7eb\x7dc\x01\x11",
+			"\x7ea\x7d\x5eb\x7d\x5dc\x7d\x21\x7d\x31\x7e"},
+		{FCSNone, 1 << 0x11, "\x01\x11",
+			"\x7e\x01\x7d\x31\x7e"},
+	}
+	for i, tst := range tests {
+		p := &porttest.Port{}
+		f := NewFramer(p)
+		f.FCS, f.TxACCM = tst.fcs, tst.accm
+		if err := f.WriteFrame([]byte(tst.data)); err != nil {
+			t.Fatalf("%d: WriteFrame: %v", i, err)
+		}
+		if p.Tx.String() != tst.exp {
+			t.Fatalf("%d: Sent %q, expected %q", i, p.Tx.String(), tst.exp)
+		}
+	}
+	f := NewFramer(&porttest.Port{})
+	f.MaxFrame = 4
+	if err := f.WriteFrame([]byte("12345")); err != ErrTooLong {
+		t.Fatalf("WriteFrame: %v != %v", err, ErrTooLong)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, fcs := range []FCS{FCS16, FCS32, FCSNone} {
+		p := &porttest.Port{}
+		f := NewFramer(p)
+		f.FCS = fcs
+		frames := []string{"hello", "\x7e\x7d\x00\x1f\xff", "x"}
+		for _, fr := range frames {
+			f.WriteFrame([]byte(fr))
+		}
+		p.Rx = [][]byte{p.Tx.Bytes()}
+		for _, fr := range frames {
+			b, err := f.ReadFrame()
+			if err != nil || string(b) != fr {
+				t.Fatalf("FCS %d: ReadFrame: %q, %v", fcs, b, err)
+			}
+		}
+		if _, err := f.ReadFrame(); err != io.EOF {
+			t.Fatalf("FCS %d: ReadFrame at end: %v", fcs, err)
+		}
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	p := &porttest.Port{}
+	f := NewFramer(p)
+	f.MaxFrame = 8
+	good := func(s string) []byte {
+		fcs := ^fcs16(0xffff, []byte(s))
+		return f.escape(nil, append([]byte(s), byte(fcs), byte(fcs>>8)))
+	}
+	var rx []byte
+	rx = append(rx, "garbage\x7e\x7e"...)
+	rx = append(rx, good("one")...)
+	rx = append(rx, "\x7ebad\x00\x00\x7e"...)
+	rx = append(rx, "x\x7e"...)
+	rx = append(rx, "abc\x7d\x7e"...)
+	rx = append(rx, "0123456789abcdef\x7e"...)
+	rx = append(rx, good("two")...)
+	rx = append(rx, '\x7e')
+	// Unescaped control characters (in RxACCM) are discarded.
+	rx = bytes.Replace(rx, []byte("two"), []byte("\x11t\x13wo"), 1)
+	p.Rx = [][]byte{rx}
+
+	exp := []struct {
+		frame string
+		err   error
+	}{
+		{"one", nil},
+		{"", ErrFCS},
+		{"", ErrShort},
+		{"", ErrAbort},
+		{"", ErrTooLong},
+		{"two", nil},
+		{"", io.EOF},
+	}
+	for i, e := range exp {
+		b, err := f.ReadFrame()
+		if string(b) != e.frame || err != e.err {
+			t.Fatalf("%d: ReadFrame: %q, %v", i, b, err)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	p := &porttest.Port{}
+	f := NewFramer(p)
+	f.FCS = FCS32
+	f.WriteFrame([]byte("split frame"))
+	tx := p.Tx.Bytes()
+	p.Rx = [][]byte{tx[:3], nil, tx[3:7], nil, tx[7:]}
+	if _, err := f.ReadFrame(); err != serial.ErrTimeout {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if _, err := f.ReadFrame(); err != serial.ErrTimeout {
+		t.Fatalf("ReadFrame: %v", err)
+	}
+	if b, err := f.ReadFrame(); err != nil || string(b) != "split frame" {
+		t.Fatalf("ReadFrame: %q, %v", b, err)
+	}
+}
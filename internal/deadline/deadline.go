@@ -0,0 +1,86 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package deadline provides a deadline (timeout) primitive, for use
+// by packages that implement Port-like streams on top of goroutines
+// and channels, and need to honor SetDeadline-style semantics:
+// Changing a deadline affects operations already blocked on it, and a
+// zero time removes the deadline.
+package deadline
+
+import (
+	"sync"
+	"time"
+)
+
+// Deadline is a resettable deadline. The zero value is not usable;
+// use New to create Deadlines.
+type Deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{} // closed when the deadline expires
+}
+
+// New returns a new Deadline with no deadline set.
+func New() *Deadline {
+	return &Deadline{cancel: make(chan struct{})}
+}
+
+// Set sets the deadline to t. A zero value for t removes the
+// deadline. A value of t in the past expires the deadline
+// immediately.
+func (d *Deadline) Set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.cancel // Wait for the timer callback to finish.
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// Wait returns a channel that is closed when the deadline expires.
+// The channel must be re-acquired (by calling Wait again) after every
+// call to Set.
+func (d *Deadline) Wait() <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.cancel
+}
+
+// Expired returns true if the deadline has expired.
+func (d *Deadline) Expired() bool {
+	return isClosed(d.Wait())
+}
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package ioerr classifies the errors returned by ports. It accepts
+// the errors of any port implementation (serial ports, network
+// connections, test fixtures), not only those of package serial.
+package ioerr
+
+// IsTimeout returns true for timeout errors (e.g. serial.ErrTimeout)
+func IsTimeout(err error) bool {
+	t, ok := err.(interface {
+		Timeout() bool
+	})
+	return ok && t.Timeout()
+}
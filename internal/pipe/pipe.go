@@ -0,0 +1,146 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package pipe provides an in-memory line connecting two Port-like
+// endpoints, for use by the tests of packages that operate on
+// serial ports. Every Write at one end is received as a single chunk
+// at the other end (a Read returns data from at most one chunk).
+// Reads honor read deadlines, returning serial.ErrTimeout when they
+// expire. Writes never block (unless a very large number of chunks
+// is pending), and ignore write deadlines.
+package pipe
+
+import (
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// Max number of chunks written and not yet read
+const chunks = 4096
+
+// End is one end of a line
+type End struct {
+	// If not nil, Corrupt is called with every chunk written,
+	// before it is sent. It may modify the chunk.
+	Corrupt func(b []byte)
+
+	rx, tx chan []byte
+
+	mu   sync.Mutex
+	rdl  time.Time
+	pend []byte
+
+	wmu    sync.Mutex
+	closed bool // Closed for writing
+}
+
+// New returns the two ends of a new line
+func New() (*End, *End) {
+	a, b := make(chan []byte, chunks), make(chan []byte, chunks)
+	return &End{rx: a, tx: b}, &End{rx: b, tx: a}
+}
+
+// Read reads data written at the other end. If the other end is
+// closed for writing, and all data have been read, Read returns
+// serial.ErrEOF. Data already written are received, even if the
+// deadline has expired.
+func (p *End) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	dl := p.rdl
+	if len(p.pend) > 0 {
+		n := copy(b, p.pend)
+		p.pend = p.pend[n:]
+		p.mu.Unlock()
+		return n, nil
+	}
+	p.mu.Unlock()
+	var c []byte
+	var ok bool
+	select {
+	case c, ok = <-p.rx:
+	default:
+		if dl.IsZero() {
+			c, ok = <-p.rx
+			break
+		}
+		d := dl.Sub(time.Now())
+		if d <= 0 {
+			return 0, serial.ErrTimeout
+		}
+		t := time.NewTimer(d)
+		defer t.Stop()
+		select {
+		case c, ok = <-p.rx:
+		case <-t.C:
+			return 0, serial.ErrTimeout
+		}
+	}
+	if !ok {
+		return 0, serial.ErrEOF
+	}
+	n := copy(b, c)
+	p.mu.Lock()
+	p.pend = c[n:]
+	p.mu.Unlock()
+	return n, nil
+}
+
+// Write sends a copy of b, as a single chunk, to the other end. After
+// CloseWrite it returns serial.ErrClosed.
+func (p *End) Write(b []byte) (int, error) {
+	c := append([]byte(nil), b...)
+	if p.Corrupt != nil {
+		p.Corrupt(c)
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if p.closed {
+		return 0, serial.ErrClosed
+	}
+	p.tx <- c
+	return len(b), nil
+}
+
+// CloseWrite closes the end for writing. Once the other end reads all
+// data written before CloseWrite, its reads return serial.ErrEOF.
+func (p *End) CloseWrite() error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if p.closed {
+		return serial.ErrClosed
+	}
+	p.closed = true
+	close(p.tx)
+	return nil
+}
+
+// FlushIn discards the data written at the other end and not yet
+// read.
+func (p *End) FlushIn() error {
+	p.mu.Lock()
+	p.pend = nil
+	p.mu.Unlock()
+	for {
+		select {
+		case <-p.rx:
+		default:
+			return nil
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for Read operations. It does not
+// affect Reads already blocked.
+func (p *End) SetReadDeadline(t time.Time) error {
+	p.mu.Lock()
+	p.rdl = t
+	p.mu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing; Writes do not block.
+func (p *End) SetWriteDeadline(t time.Time) error { return nil }
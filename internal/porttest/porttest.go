@@ -0,0 +1,65 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package porttest provides a scripted port, for use by the tests of
+// packages that read and write frames. The data a Port returns, and
+// where its reads time out, are arranged in advance, so tests can
+// check how partial reads and timeouts in the middle of a frame are
+// handled.
+package porttest
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// Port returns the chunks in Rx, one per Read (a Read returns data
+// from at most one chunk); nil chunks return serial.ErrTimeout. Once
+// all chunks are read, Read returns serial.ErrEOF. Writes are
+// collected in Tx. Deadlines are recorded in Rdl and Wdl, but have
+// no other effect.
+type Port struct {
+	Rx       [][]byte
+	Tx       bytes.Buffer
+	Rdl, Wdl time.Time
+}
+
+// Read returns data from the first chunk in Rx
+func (p *Port) Read(b []byte) (int, error) {
+	if len(p.Rx) == 0 {
+		return 0, serial.ErrEOF
+	}
+	c := p.Rx[0]
+	if c == nil {
+		p.Rx = p.Rx[1:]
+		return 0, serial.ErrTimeout
+	}
+	n := copy(b, c)
+	if n == len(c) {
+		p.Rx = p.Rx[1:]
+	} else {
+		p.Rx[0] = c[n:]
+	}
+	return n, nil
+}
+
+// Write appends b to Tx
+func (p *Port) Write(b []byte) (int, error) {
+	return p.Tx.Write(b)
+}
+
+// SetReadDeadline records t in Rdl
+func (p *Port) SetReadDeadline(t time.Time) error {
+	p.Rdl = t
+	return nil
+}
+
+// SetWriteDeadline records t in Wdl
+func (p *Port) SetWriteDeadline(t time.Time) error {
+	p.Wdl = t
+	return nil
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package xfer provides the parts shared by the file-transfer
+// packages (xmodem, zmodem): the XMODEM CRC-16, and a buffered reader
+// that implements the protocol timeouts with port read deadlines,
+// while checking a Context for cancelation.
+package xfer
+
+import (
+	"context"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// PollInterval is the interval at which Readers check the context
+const PollInterval = 100 * time.Millisecond
+
+// CRC16 returns the XMODEM CRC-16 (polynomial 0x1021) of b, continuing
+// from v. The initial value is 0.
+func CRC16(v uint16, b []byte) uint16 {
+	for _, c := range b {
+		v ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if v&0x8000 != 0 {
+				v = v<<1 ^ 0x1021
+			} else {
+				v <<= 1
+			}
+		}
+	}
+	return v
+}
+
+// Port is the interface of the ports Readers read from
+type Port interface {
+	Read(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+}
+
+// Reader is a buffered reader. Its reads wait until a deadline, in
+// short port reads (of up to PollInterval), so that the context is
+// checked frequently.
+type Reader struct {
+	port       Port
+	ctx        context.Context
+	errTimeout error
+	buf        []byte
+	pend       []byte // Received, not yet consumed
+}
+
+// NewReader returns a Reader for port p, with a buffer of size
+// bytes. Its reads return errTimeout when their deadline expires, and
+// ctx.Err() when ctx is canceled. A nil ctx is never canceled.
+func NewReader(p Port, ctx context.Context, size int,
+	errTimeout error) *Reader {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Reader{port: p, ctx: ctx, errTimeout: errTimeout,
+		buf: make([]byte, size)}
+}
+
+// Read reads into b until deadline dl
+func (r *Reader) Read(b []byte, dl time.Time) (int, error) {
+	for {
+		if len(r.pend) > 0 {
+			n := copy(b, r.pend)
+			r.pend = r.pend[n:]
+			return n, nil
+		}
+		if err := r.ctx.Err(); err != nil {
+			return 0, err
+		}
+		now := time.Now()
+		if !now.Before(dl) {
+			return 0, r.errTimeout
+		}
+		pdl := now.Add(PollInterval)
+		if dl.Before(pdl) {
+			pdl = dl
+		}
+		if err := r.Fill(pdl); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// GetByte reads a byte until deadline dl
+func (r *Reader) GetByte(dl time.Time) (byte, error) {
+	var b [1]byte
+	_, err := r.Read(b[:], dl)
+	return b[0], err
+}
+
+// Fill reads once from the port, with deadline dl, if no input is
+// buffered. Port timeouts are not reported.
+func (r *Reader) Fill(dl time.Time) error {
+	if len(r.pend) > 0 {
+		return nil
+	}
+	r.port.SetReadDeadline(dl)
+	n, err := r.port.Read(r.buf)
+	r.pend = r.buf[:n]
+	if n == 0 && err != nil && !ioerr.IsTimeout(err) {
+		return err
+	}
+	return nil
+}
+
+// Buffered returns the number of bytes buffered
+func (r *Reader) Buffered() int {
+	return len(r.pend)
+}
+
+// Discard discards the buffered input
+func (r *Reader) Discard() {
+	r.pend = nil
+}
@@ -0,0 +1,67 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package xfer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+func TestCRC16(t *testing.T) {
+	if v := CRC16(0, []byte("123456789")); v != 0x31c3 {
+		t.Fatalf("CRC = %04x", v)
+	}
+	if v := CRC16(CRC16(0, []byte("1234")), []byte("56789")); v != 0x31c3 {
+		t.Fatalf("CRC continued = %04x", v)
+	}
+}
+
+var errTimeout = errors.New("timeout")
+
+func TestReader(t *testing.T) {
+	pa, pb := pipe.New()
+	ctx, cancel := context.WithCancel(context.Background())
+	r := NewReader(pa, ctx, 4, errTimeout)
+	pb.Write([]byte("abcdef"))
+	b := make([]byte, 3)
+	dl := time.Now().Add(time.Second)
+	if n, err := r.Read(b, dl); n != 3 || err != nil ||
+		string(b) != "abc" {
+		t.Fatalf("Read: %d, %v, %q", n, err, b)
+	}
+	if c, err := r.GetByte(dl); c != 'd' || err != nil {
+		t.Fatalf("GetByte: %q, %v", c, err)
+	}
+	if r.Buffered() != 0 {
+		t.Fatalf("Buffered: %d", r.Buffered())
+	}
+	if err := r.Fill(time.Now()); err != nil || r.Buffered() != 2 {
+		t.Fatalf("Fill: %v, %d buffered", err, r.Buffered())
+	}
+	r.Discard()
+
+	start := time.Now()
+	if _, err := r.Read(b, start.Add(150*time.Millisecond)); err !=
+		errTimeout {
+		t.Fatalf("Read: %v", err)
+	}
+	if d := time.Since(start); d < 150*time.Millisecond {
+		t.Fatalf("Timeout after %v", d)
+	}
+
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	if _, err := r.Read(b, start.Add(time.Hour)); err != context.Canceled {
+		t.Fatalf("Read: %v", err)
+	}
+	if d := time.Since(start); d > 2*PollInterval+50*time.Millisecond {
+		t.Fatalf("Canceled after %v", d)
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// Line-oriented and delimiter-based reads, with deadlines.
+
+package serial
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// DeadlineReader is the interface of the ports LineReader reads
+// from. It is satisfied by *Port, and by the ports and channels of
+// the protocol packages.
+type DeadlineReader interface {
+	io.Reader
+	SetReadDeadline(t time.Time) error
+}
+
+// LineReader reads lines, or data terminated by delimiters, from a
+// port. Unlike bufio.Reader, it can be used with port deadlines:
+// when the deadline expires before a delimiter is received, the data
+// read so far are retained, and are returned by a subsequent call.
+// Likewise, data received after a delimiter are retained for the
+// next call.
+//
+// The LineReader methods set the port's read deadline (to the
+// deadline argument) before reading from it.
+type LineReader struct {
+	r    DeadlineReader
+	buf  []byte // Read, not yet returned
+	rbuf []byte
+}
+
+// Reads returning no data and no error, before LineReader methods
+// give up with io.ErrNoProgress
+const maxEmptyReads = 100
+
+// NewLineReader returns a LineReader reading from port r
+func NewLineReader(r DeadlineReader) *LineReader {
+	return &LineReader{r: r, rbuf: make([]byte, 512)}
+}
+
+// Buffered returns the number of bytes read from the port, and not
+// yet returned.
+func (l *LineReader) Buffered() int {
+	return len(l.buf)
+}
+
+// Read is compatible with the Read method of the io.Reader
+// interface. It returns the buffered data, if any, or else reads
+// from the port. It does not set the port's deadline.
+func (l *LineReader) Read(b []byte) (n int, err error) {
+	if len(l.buf) > 0 {
+		n = copy(b, l.buf)
+		l.consume(n)
+		return n, nil
+	}
+	return l.r.Read(b)
+}
+
+func (l *LineReader) consume(n int) {
+	l.buf = l.buf[n:]
+	if len(l.buf) == 0 {
+		l.buf = nil
+	}
+}
+
+// take returns (a copy of) the first n buffered bytes, and removes
+// them from the buffer.
+func (l *LineReader) take(n int) []byte {
+	b := append([]byte(nil), l.buf[:n]...)
+	l.consume(n)
+	return b
+}
+
+// ReadUntil reads until the first occurrence of delim, and returns
+// the data read, including the delimiter. It is equivalent to
+// ReadUntilAny with a single delimiter.
+func (l *LineReader) ReadUntil(delim byte, max int,
+	deadline time.Time) ([]byte, error) {
+
+	b, _, err := l.ReadUntilAny([][]byte{{delim}}, max, deadline)
+	return b, err
+}
+
+// ReadUntilAny reads until the first occurrence of any of the byte
+// sequences in delims, and returns the data read, including the
+// delimiter, and the index of the delimiter in delims. If more than
+// one delimiters end at the same position, the first in delims is
+// selected. Data received after the delimiter are retained for the
+// next call.
+//
+// If max > 0 and no delimiter is found within the first max bytes,
+// ReadUntilAny returns them, with err == ErrTooLong. If deadline is
+// not zero, and it expires before a delimiter is received,
+// ReadUntilAny returns ErrTimeout, and no data; the data read so far
+// are retained. On other errors (e.g. ErrEOF) the data read so far
+// are returned along with the error.
+func (l *LineReader) ReadUntilAny(delims [][]byte, max int,
+	deadline time.Time) (b []byte, delim int, err error) {
+
+	i := 0     // End of the data checked
+	empty := 0 // Consecutive empty reads
+	for {
+		for ; i < len(l.buf); i++ {
+			end := i + 1
+			for j, d := range delims {
+				if len(d) > 0 && end >= len(d) &&
+					bytes.Equal(l.buf[end-len(d):end], d) {
+					return l.take(end), j, nil
+				}
+			}
+			if max > 0 && end >= max {
+				return l.take(end), -1, ErrTooLong
+			}
+		}
+		if err != nil {
+			if err == ErrTimeout {
+				return nil, -1, err
+			}
+			return l.take(len(l.buf)), -1, err
+		}
+		if err = l.r.SetReadDeadline(deadline); err != nil {
+			return nil, -1, err
+		}
+		var n int
+		n, err = l.r.Read(l.rbuf)
+		l.buf = append(l.buf, l.rbuf[:n]...)
+		if n == 0 && err == nil {
+			if empty++; empty == maxEmptyReads {
+				err = io.ErrNoProgress
+			}
+		} else {
+			empty = 0
+		}
+	}
+}
+
+// ReadLine reads a line terminated by "\n" (or "\r\n"), and returns
+// it without the terminator. Errors are returned as by ReadUntil; if
+// the line is too long, the first max bytes are returned (as they
+// are) with ErrTooLong.
+func (l *LineReader) ReadLine(max int, deadline time.Time) (string,
+	error) {
+
+	b, err := l.ReadUntil('\n', max, deadline)
+	if err != nil {
+		return string(b), err
+	}
+	b = b[:len(b)-1]
+	if n := len(b); n > 0 && b[n-1] == '\r' {
+		b = b[:n-1]
+	}
+	return string(b), nil
+}
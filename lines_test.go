@@ -0,0 +1,75 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+package serial
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestLineReader(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer ln.Close()
+	go echoServer(ln)
+	p, err := Open("tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	defer p.Close()
+	l := NewLineReader(p)
+	dl := func(d time.Duration) time.Time { return time.Now().Add(d) }
+
+	// Partial line, completed after a timeout
+	p.Write([]byte("hel"))
+	s, err := l.ReadLine(0, dl(100*time.Millisecond))
+	if s != "" || err != ErrTimeout {
+		t.Fatalf("ReadLine: %q, %v", s, err)
+	}
+	p.Write([]byte("lo\r\nworld\n+CSQ: 20,99\r\nOK\r\nsurplus"))
+	for _, want := range []string{"hello", "world"} {
+		s, err := l.ReadLine(0, dl(time.Second))
+		if s != want || err != nil {
+			t.Fatalf("ReadLine: %q, %v", s, err)
+		}
+	}
+
+	// Several delimiters
+	delims := [][]byte{[]byte("ERROR\r\n"), []byte("OK\r\n")}
+	b, i, err := l.ReadUntilAny(delims, 0, dl(time.Second))
+	if string(b) != "+CSQ: 20,99\r\nOK\r\n" || i != 1 || err != nil {
+		t.Fatalf("ReadUntilAny: %q, %d, %v", b, i, err)
+	}
+
+	// Surplus retained; max length
+	p.Write([]byte(" data;"))
+	b, err = l.ReadUntil(';', 4, dl(time.Second))
+	if string(b) != "surp" || err != ErrTooLong {
+		t.Fatalf("ReadUntil: %q, %v", b, err)
+	}
+	b, err = l.ReadUntil(';', 0, dl(time.Second))
+	if string(b) != "lus data;" || err != nil {
+		t.Fatalf("ReadUntil: %q, %v", b, err)
+	}
+	if l.Buffered() != 0 {
+		t.Fatalf("Buffered: %d", l.Buffered())
+	}
+
+	// Data following the delimiter, in the same read
+	p.Write([]byte("a;b"))
+	b, err = l.ReadUntil(';', 0, dl(time.Second))
+	if string(b) != "a;" || err != nil {
+		t.Fatalf("ReadUntil: %q, %v", b, err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	rb := make([]byte, 8)
+	if n, err := l.Read(rb); string(rb[:n]) != "b" || err != nil {
+		t.Fatalf("Read: %q, %v", rb[:n], err)
+	}
+}
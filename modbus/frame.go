@@ -0,0 +1,262 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package modbus
+
+import (
+	"encoding/hex"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Max ADU lengths (binary, for ASCII after hex-decoding)
+const (
+	maxRTU   = 256
+	maxASCII = 255
+)
+
+// encode returns the ADU for unit and pdu, as sent on the line
+func (m Mode) encode(unit byte, pdu []byte) []byte {
+	adu := append([]byte{unit}, pdu...)
+	if m == ASCII {
+		adu = append(adu, lrc(adu))
+		w := make([]byte, 0, 2*len(adu)+3)
+		w = append(w, ':')
+		for _, c := range adu {
+			w = append(w, "0123456789ABCDEF"[c>>4],
+				"0123456789ABCDEF"[c&0x0f])
+		}
+		return append(w, '\r', '\n')
+	}
+	crc := crc16(adu)
+	return append(adu, byte(crc), byte(crc>>8))
+}
+
+// decode verifies the checksum of ADU a, as returned by
+// frameReader.read, and returns the unit address and the PDU.
+func (m Mode) decode(a []byte) (unit byte, pdu []byte, err error) {
+	if m == ASCII {
+		if len(a) < 3 {
+			return 0, nil, ErrFrame
+		}
+		n := len(a) - 1
+		if lrc(a[:n]) != a[n] {
+			return 0, nil, ErrChecksum
+		}
+		return a[0], a[1:n], nil
+	}
+	if len(a) < 4 {
+		return 0, nil, ErrFrame
+	}
+	if crc16(a) != 0 {
+		return 0, nil, ErrChecksum
+	}
+	return a[0], a[1 : len(a)-2], nil
+}
+
+// respLen returns the length of the RTU response ADU that starts with
+// b. It returns 0 if more bytes are required to tell, and -1 if the
+// length cannot be calculated (unknown function).
+func respLen(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	fn := b[1]
+	if fn&0x80 != 0 {
+		return 5
+	}
+	switch fn {
+	case 0x01, 0x02, 0x03, 0x04, 0x0C, 0x11, 0x14, 0x15, 0x17:
+		if len(b) < 3 {
+			return 0
+		}
+		return 3 + int(b[2]) + 2
+	case 0x05, 0x06, 0x08, 0x0B, 0x0F, 0x10:
+		return 8
+	case 0x07:
+		return 5
+	case 0x16:
+		return 10
+	}
+	return -1
+}
+
+// reqLen returns the length of the RTU request ADU that starts with
+// b. It returns 0 if more bytes are required to tell, and -1 if the
+// length cannot be calculated (unknown function).
+func reqLen(b []byte) int {
+	if len(b) < 2 {
+		return 0
+	}
+	switch b[1] {
+	case 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x08:
+		return 8
+	case 0x07, 0x0B, 0x0C, 0x11:
+		return 4
+	case 0x0F, 0x10:
+		if len(b) < 7 {
+			return 0
+		}
+		return 7 + int(b[6]) + 2
+	case 0x14, 0x15:
+		if len(b) < 3 {
+			return 0
+		}
+		return 3 + int(b[2]) + 2
+	case 0x16:
+		return 10
+	case 0x17:
+		if len(b) < 11 {
+			return 0
+		}
+		return 11 + int(b[10]) + 2
+	}
+	return -1
+}
+
+// frameReader reads frames from a port. Partially received frames
+// are retained across calls to read, until reset is called.
+type frameReader struct {
+	port Port
+	mode Mode
+	// RTU: Silence ending a frame. ASCII: Max silence within a
+	// frame.
+	gap time.Duration
+
+	rbuf  []byte
+	pend  []byte    // Received bytes not yet processed (ASCII)
+	frame []byte    // Frame being received
+	in    bool      // Within a frame (ASCII)
+	last  time.Time // Time the last byte was received
+}
+
+func (r *frameReader) reset() {
+	r.pend, r.frame, r.in = nil, r.frame[:0], false
+}
+
+// take returns the first n bytes of the frame received (and resets
+// it).
+func (r *frameReader) take(n int) []byte {
+	b := append([]byte(nil), r.frame[:n]...)
+	r.frame = r.frame[:0]
+	return b
+}
+
+// read reads the next frame until deadline (or without a deadline, if
+// zero), and returns it in binary form: For ASCII frames, the
+// contents between ':' and CR-LF, hex-decoded. For RTU frames, flen
+// gives the length of the frame (see respLen).
+func (r *frameReader) read(deadline time.Time,
+	flen func([]byte) int) ([]byte, error) {
+
+	if r.rbuf == nil {
+		r.rbuf = make([]byte, 0, 512)
+	}
+	if r.mode == ASCII {
+		return r.readASCII(deadline)
+	}
+	return r.readRTU(deadline, flen)
+}
+
+// deadline returns the deadline for the next port read: the earlier
+// of dl, and the end of the inter-character gap (if within a frame).
+func (r *frameReader) deadline(dl time.Time, in bool) (time.Time, bool) {
+	if !in {
+		return dl, false
+	}
+	g := r.last.Add(r.gap)
+	if dl.IsZero() || g.Before(dl) {
+		return g, true
+	}
+	return dl, false
+}
+
+func (r *frameReader) readRTU(deadline time.Time,
+	flen func([]byte) int) ([]byte, error) {
+
+	for {
+		dl, byGap := r.deadline(deadline, len(r.frame) > 0)
+		r.port.SetReadDeadline(dl)
+		n, err := r.port.Read(r.rbuf[:cap(r.rbuf)])
+		if n > 0 {
+			r.frame = append(r.frame, r.rbuf[:n]...)
+			r.last = time.Now()
+			l := flen(r.frame)
+			if l > 0 && len(r.frame) >= l {
+				// Surplus bytes are garbage; drop them.
+				return r.take(l), nil
+			}
+			if len(r.frame) > maxRTU {
+				r.reset()
+				return nil, ErrFrame
+			}
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if byGap && ioerr.IsTimeout(err) {
+			// Frame ended by silence
+			l := flen(r.frame)
+			f := r.take(len(r.frame))
+			if l >= 0 {
+				return nil, ErrFrame
+			}
+			return f, nil
+		}
+		return nil, err
+	}
+}
+
+func (r *frameReader) readASCII(deadline time.Time) ([]byte, error) {
+	for {
+		for len(r.pend) > 0 {
+			c := r.pend[0]
+			r.pend = r.pend[1:]
+			switch {
+			case c == ':':
+				r.frame, r.in = r.frame[:0], true
+			case !r.in:
+			case c == '\n':
+				r.in = false
+				f := r.frame
+				r.frame = r.frame[:0]
+				if len(f) == 0 || f[len(f)-1] != '\r' {
+					return nil, ErrFrame
+				}
+				b := make([]byte, hex.DecodedLen(len(f)-1))
+				_, err := hex.Decode(b, f[:len(f)-1])
+				if err != nil {
+					return nil, ErrFrame
+				}
+				return b, nil
+			default:
+				if len(r.frame) >= 2*maxASCII+1 {
+					r.reset()
+					return nil, ErrFrame
+				}
+				r.frame = append(r.frame, c)
+			}
+		}
+		dl, byGap := r.deadline(deadline, r.in)
+		r.port.SetReadDeadline(dl)
+		n, err := r.port.Read(r.rbuf[:cap(r.rbuf)])
+		if n > 0 {
+			r.pend = r.rbuf[:n]
+			r.last = time.Now()
+			continue
+		}
+		if err == nil {
+			continue
+		}
+		if byGap && ioerr.IsTimeout(err) {
+			// Silence within a frame
+			r.reset()
+			return nil, ErrFrame
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,291 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Defaults for Master fields
+const (
+	DefaultTimeout     = 1 * time.Second        // Response timeout
+	DefaultTurnaround  = 100 * time.Millisecond // Delay after broadcasts
+	DefaultMinFrameGap = 20 * time.Millisecond  // Min RTU frame gap
+	DefaultASCIIGap    = 1 * time.Second        // ASCII char timeout
+)
+
+// ErrQuantity is returned for requests with a quantity of coils or
+// registers that is out of range.
+var ErrQuantity = errors.New("modbus: quantity out of range")
+
+// Master issues Modbus requests over a port. Fields can be changed
+// between requests. Requests can be issued concurrently; they are
+// carried out one at a time.
+type Master struct {
+	Mode    Mode
+	Timeout time.Duration // Response timeout; zero means DefaultTimeout
+	Retries int           // Times to retry a failed request
+
+	// Delay after broadcasts; zero means DefaultTurnaround
+	Turnaround time.Duration
+
+	// For RTU, the silence ending a response whose length cannot be
+	// calculated (or which is truncated). Zero means t3.5, but no
+	// less than DefaultMinFrameGap. For ASCII, the max silence
+	// within a response. Zero means DefaultASCIIGap.
+	FrameGap time.Duration
+
+	port Port
+	mu   sync.Mutex
+	r    frameReader
+	last time.Time // End of last frame on the line
+}
+
+// NewMaster returns a Master that issues requests over port p, in
+// RTU mode.
+func NewMaster(p Port) *Master {
+	return &Master{port: p, r: frameReader{port: p}}
+}
+
+// timing returns the character time and the frame gap
+func (m *Master) timing() (char, gap time.Duration) {
+	c, _ := m.port.GetConf()
+	_, t35 := Timing(c)
+	char = t35 * 2 / 7
+	gap = m.FrameGap
+	if gap <= 0 {
+		if m.Mode == ASCII {
+			gap = DefaultASCIIGap
+		} else {
+			gap = t35
+			if gap < DefaultMinFrameGap {
+				gap = DefaultMinFrameGap
+			}
+		}
+	}
+	return char, gap
+}
+
+// Request sends a request with PDU pdu to unit, and returns the PDU
+// of the response. If unit is 0 (broadcast), no response is expected,
+// and Request returns nil, nil after the turnaround delay. Failed
+// requests are retried as described in the package documentation.
+func (m *Master) Request(unit byte, pdu []byte) ([]byte, error) {
+	if len(pdu) == 0 || len(pdu) > MaxPDU {
+		return nil, ErrFrame
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for try := 0; ; try++ {
+		resp, err := m.transact(unit, pdu)
+		if err == nil || unit == 0 || try >= m.Retries {
+			return resp, err
+		}
+		switch err {
+		case ErrChecksum, ErrFrame, ErrUnexpected:
+		default:
+			if !ioerr.IsTimeout(err) {
+				return nil, err
+			}
+		}
+	}
+}
+
+func (m *Master) transact(unit byte, pdu []byte) ([]byte, error) {
+	char, gap := m.timing()
+	if m.Mode == RTU {
+		// Keep the line silent for t3.5 before sending
+		if d := m.last.Add(char * 7 / 2).Sub(time.Now()); d > 0 {
+			time.Sleep(d)
+		}
+	}
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	adu := m.Mode.encode(unit, pdu)
+	m.port.FlushIn()
+	m.r.mode, m.r.gap = m.Mode, gap
+	m.r.reset()
+	m.port.SetWriteDeadline(time.Now().Add(timeout))
+	if _, err := m.port.Write(adu); err != nil {
+		return nil, err
+	}
+	// Write may return before the request is transmitted
+	txEnd := time.Now().Add(time.Duration(len(adu)) * char)
+	if unit == 0 {
+		turn := m.Turnaround
+		if turn <= 0 {
+			turn = DefaultTurnaround
+		}
+		time.Sleep(txEnd.Add(turn).Sub(time.Now()))
+		m.last = time.Now()
+		return nil, nil
+	}
+	b, err := m.r.read(txEnd.Add(timeout), respLen)
+	m.last = time.Now()
+	if err != nil {
+		if ioerr.IsTimeout(err) && (len(m.r.frame) > 0 || m.r.in) {
+			// Response timed-out midway
+			err = ErrFrame
+		}
+		return nil, err
+	}
+	u, resp, err := m.Mode.decode(b)
+	if err != nil {
+		return nil, err
+	}
+	if u != unit || len(resp) == 0 || resp[0]&0x7f != pdu[0] {
+		return nil, ErrUnexpected
+	}
+	if resp[0]&0x80 != 0 {
+		if len(resp) != 2 {
+			return nil, ErrFrame
+		}
+		return nil, &Exception{Function: pdu[0],
+			Code: ExceptionCode(resp[1])}
+	}
+	return resp, nil
+}
+
+// readBits issues a read-coils or read-discrete-inputs request
+func (m *Master) readBits(fn, unit byte, addr, qty uint16) ([]bool, error) {
+	if qty < 1 || qty > MaxReadBits {
+		return nil, ErrQuantity
+	}
+	pdu := be16(be16([]byte{fn}, addr), qty)
+	resp, err := m.Request(unit, pdu)
+	if err != nil || unit == 0 {
+		return nil, err
+	}
+	n := (int(qty) + 7) / 8
+	if len(resp) != 2+n || int(resp[1]) != n {
+		return nil, ErrFrame
+	}
+	bits := make([]bool, qty)
+	for i := range bits {
+		bits[i] = resp[2+i/8]&(1<<uint(i%8)) != 0
+	}
+	return bits, nil
+}
+
+// readRegs issues a read-holding- or read-input-registers request
+func (m *Master) readRegs(fn, unit byte, addr, qty uint16) ([]uint16, error) {
+	if qty < 1 || qty > MaxReadRegisters {
+		return nil, ErrQuantity
+	}
+	pdu := be16(be16([]byte{fn}, addr), qty)
+	resp, err := m.Request(unit, pdu)
+	if err != nil || unit == 0 {
+		return nil, err
+	}
+	n := 2 * int(qty)
+	if len(resp) != 2+n || int(resp[1]) != n {
+		return nil, ErrFrame
+	}
+	regs := make([]uint16, qty)
+	for i := range regs {
+		regs[i] = getBE16(resp[2+2*i:])
+	}
+	return regs, nil
+}
+
+// ReadCoils reads qty coils, starting at addr, from unit
+func (m *Master) ReadCoils(unit byte, addr, qty uint16) ([]bool, error) {
+	return m.readBits(FuncReadCoils, unit, addr, qty)
+}
+
+// ReadDiscreteInputs reads qty discrete inputs, starting at addr,
+// from unit.
+func (m *Master) ReadDiscreteInputs(unit byte,
+	addr, qty uint16) ([]bool, error) {
+
+	return m.readBits(FuncReadDiscreteInputs, unit, addr, qty)
+}
+
+// ReadHoldingRegisters reads qty holding registers, starting at addr,
+// from unit.
+func (m *Master) ReadHoldingRegisters(unit byte,
+	addr, qty uint16) ([]uint16, error) {
+
+	return m.readRegs(FuncReadHoldingRegisters, unit, addr, qty)
+}
+
+// ReadInputRegisters reads qty input registers, starting at addr,
+// from unit.
+func (m *Master) ReadInputRegisters(unit byte,
+	addr, qty uint16) ([]uint16, error) {
+
+	return m.readRegs(FuncReadInputRegisters, unit, addr, qty)
+}
+
+// write issues a write request with PDU pdu, whose response must
+// echo the first 5 bytes of the request.
+func (m *Master) write(unit byte, pdu []byte) error {
+	resp, err := m.Request(unit, pdu)
+	if err != nil || unit == 0 {
+		return err
+	}
+	if len(resp) != 5 || string(resp) != string(pdu[:5]) {
+		return ErrUnexpected
+	}
+	return nil
+}
+
+// WriteSingleCoil sets the coil at addr, of unit, to v
+func (m *Master) WriteSingleCoil(unit byte, addr uint16, v bool) error {
+	var val uint16
+	if v {
+		val = 0xff00
+	}
+	return m.write(unit, be16(be16([]byte{FuncWriteSingleCoil}, addr), val))
+}
+
+// WriteSingleRegister sets the holding register at addr, of unit, to
+// v.
+func (m *Master) WriteSingleRegister(unit byte, addr, v uint16) error {
+	pdu := be16([]byte{FuncWriteSingleRegister}, addr)
+	return m.write(unit, be16(pdu, v))
+}
+
+// WriteMultipleCoils sets the coils starting at addr, of unit, to the
+// values in v.
+func (m *Master) WriteMultipleCoils(unit byte, addr uint16, v []bool) error {
+	if len(v) < 1 || len(v) > MaxWriteBits {
+		return ErrQuantity
+	}
+	pdu := be16(be16([]byte{FuncWriteMultipleCoils}, addr), uint16(len(v)))
+	n := (len(v) + 7) / 8
+	pdu = append(pdu, byte(n))
+	pdu = append(pdu, make([]byte, n)...)
+	for i, b := range v {
+		if b {
+			pdu[6+i/8] |= 1 << uint(i%8)
+		}
+	}
+	return m.write(unit, pdu)
+}
+
+// WriteMultipleRegisters sets the holding registers starting at
+// addr, of unit, to the values in v.
+func (m *Master) WriteMultipleRegisters(unit byte, addr uint16,
+	v []uint16) error {
+
+	if len(v) < 1 || len(v) > MaxWriteRegisters {
+		return ErrQuantity
+	}
+	pdu := be16([]byte{FuncWriteMultipleRegisters}, addr)
+	pdu = be16(pdu, uint16(len(v)))
+	pdu = append(pdu, byte(2*len(v)))
+	for _, r := range v {
+		pdu = be16(pdu, r)
+	}
+	return m.write(unit, pdu)
+}
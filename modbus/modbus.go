@@ -0,0 +1,201 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package modbus implements the Modbus serial-line protocol, in RTU
+// and ASCII modes, over serial ports. A Master issues requests to
+// devices (servers, or slaves) on the line. A Server emulates a
+// device, answering requests using a user-supplied Handler.
+//
+// Frames
+//
+// In RTU mode, a frame (ADU) consists of the unit (slave) address,
+// the PDU (function code and data), and a CRC-16, in binary. Frames
+// are separated by at least 3.5 character times of silence (t3.5),
+// and characters within a frame by no more than 1.5 character times
+// (t1.5). Both are derived from the port's configuration (see
+// function Timing). In ASCII mode, the address, PDU, and an LRC
+// checksum are sent as hex digits, between a ':' and a CR-LF.
+//
+// Since the operating system and the serial hardware (especially USB
+// adapters) can delay received data by more than t1.5, or even t3.5,
+// the Master does not rely on silence to detect the end of response
+// frames. Instead, it calculates the length of the expected response
+// from its contents, and uses silence (of at least FrameGap) only for
+// responses whose length cannot be calculated, or are truncated.
+//
+// Errors
+//
+// Exception responses are returned as *Exception errors. Responses
+// with a bad CRC or LRC are reported with ErrChecksum, malformed or
+// truncated responses with ErrFrame, and responses from the wrong
+// unit, or for a different function, with ErrUnexpected. If no
+// response arrives in time, the port's timeout error (e.g.
+// serial.ErrTimeout) is returned. The Master retries requests that
+// fail with any of these errors (except exceptions) up to
+// Master.Retries times.
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// Port is the interface of the ports Modbus masters and servers
+// operate on. It is satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	GetConf() (conf serial.Conf, err error)
+	FlushIn() error
+}
+
+// Mode is the serial transmission mode
+type Mode int
+
+const (
+	RTU   Mode = iota // Binary frames, with CRC (the default)
+	ASCII             // Hex frames, with LRC
+)
+
+func (m Mode) String() string {
+	if m == ASCII {
+		return "ASCII"
+	}
+	return "RTU"
+}
+
+// Function codes
+const (
+	FuncReadCoils              = 0x01
+	FuncReadDiscreteInputs     = 0x02
+	FuncReadHoldingRegisters   = 0x03
+	FuncReadInputRegisters     = 0x04
+	FuncWriteSingleCoil        = 0x05
+	FuncWriteSingleRegister    = 0x06
+	FuncWriteMultipleCoils     = 0x0F
+	FuncWriteMultipleRegisters = 0x10
+)
+
+// Protocol limits
+const (
+	MaxReadBits       = 2000 // Coils or discrete inputs per read
+	MaxReadRegisters  = 125  // Registers per read
+	MaxWriteBits      = 1968 // Coils per write
+	MaxWriteRegisters = 123  // Registers per write
+	MaxPDU            = 253  // PDU length
+)
+
+// ExceptionCode is the code of an exception response
+type ExceptionCode byte
+
+const (
+	IllegalFunction         ExceptionCode = 0x01
+	IllegalDataAddress      ExceptionCode = 0x02
+	IllegalDataValue        ExceptionCode = 0x03
+	ServerDeviceFailure     ExceptionCode = 0x04
+	Acknowledge             ExceptionCode = 0x05
+	ServerDeviceBusy        ExceptionCode = 0x06
+	MemoryParityError       ExceptionCode = 0x08
+	GatewayPathUnavailable  ExceptionCode = 0x0A
+	GatewayTargetNoResponse ExceptionCode = 0x0B
+)
+
+var exceptionNames = map[ExceptionCode]string{
+	IllegalFunction:         "illegal function",
+	IllegalDataAddress:      "illegal data address",
+	IllegalDataValue:        "illegal data value",
+	ServerDeviceFailure:     "server device failure",
+	Acknowledge:             "acknowledge",
+	ServerDeviceBusy:        "server device busy",
+	MemoryParityError:       "memory parity error",
+	GatewayPathUnavailable:  "gateway path unavailable",
+	GatewayTargetNoResponse: "gateway target device failed to respond",
+}
+
+func (c ExceptionCode) String() string {
+	if s, ok := exceptionNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("exception 0x%02x", byte(c))
+}
+
+// Exception is the error returned for exception responses
+type Exception struct {
+	Function byte          // Function code of the request
+	Code     ExceptionCode // Exception code
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("modbus: function 0x%02x: %v", e.Function, e.Code)
+}
+
+// Errors returned for bad frames
+var (
+	ErrChecksum   = errors.New("modbus: bad checksum")
+	ErrFrame      = errors.New("modbus: malformed frame")
+	ErrUnexpected = errors.New("modbus: unexpected response")
+)
+
+// Timing returns the RTU inter-character (t1.5) and inter-frame
+// (t3.5) times for serial-port configuration c. As recommended by the
+// Modbus serial-line specification, for baudrates above 19200 they
+// are fixed at 750us and 1.75ms respectively.
+func Timing(c serial.Conf) (t15, t35 time.Duration) {
+	if c.Baudrate <= 0 || c.Baudrate > 19200 {
+		return 750 * time.Microsecond, 1750 * time.Microsecond
+	}
+	bits := 1 + c.Databits + c.Stopbits
+	if c.Databits == 0 {
+		bits += 8
+	}
+	if c.Stopbits == 0 {
+		bits++
+	}
+	if c.Parity != serial.ParityNone {
+		bits++
+	}
+	half := time.Duration(bits) * time.Second / 2
+	return 3 * half / time.Duration(c.Baudrate),
+		7 * half / time.Duration(c.Baudrate)
+}
+
+// crc16 returns the Modbus CRC-16 (polynomial 0xA001 reflected,
+// initial value 0xFFFF) of b.
+func crc16(b []byte) uint16 {
+	v := uint16(0xffff)
+	for _, c := range b {
+		v ^= uint16(c)
+		for i := 0; i < 8; i++ {
+			if v&1 != 0 {
+				v = v>>1 ^ 0xa001
+			} else {
+				v >>= 1
+			}
+		}
+	}
+	return v
+}
+
+// lrc returns the Modbus LRC (two's complement of the sum) of b
+func lrc(b []byte) byte {
+	var s byte
+	for _, c := range b {
+		s += c
+	}
+	return -s
+}
+
+func be16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func getBE16(b []byte) uint16 {
+	return uint16(b[0])<<8 | uint16(b[1])
+}
@@ -0,0 +1,282 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package modbus
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// fakeDev is a Port connected to a fake device. Requests written are
+// passed to handler, which returns the response chunks the device
+// sends. Reads return serial.ErrTimeout immediately if no response is
+// pending.
+type fakeDev struct {
+	conf    serial.Conf
+	handler func(req []byte) [][]byte
+
+	mu  sync.Mutex
+	rx  [][]byte
+	tx  [][]byte
+	fls int // FlushIn calls
+}
+
+func (d *fakeDev) Read(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(d.rx) == 0 {
+		return 0, serial.ErrTimeout
+	}
+	n := copy(b, d.rx[0])
+	if n == len(d.rx[0]) {
+		d.rx = d.rx[1:]
+	} else {
+		d.rx[0] = d.rx[0][n:]
+	}
+	return n, nil
+}
+
+func (d *fakeDev) Write(b []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	req := append([]byte(nil), b...)
+	d.tx = append(d.tx, req)
+	if d.handler != nil {
+		d.rx = append(d.rx, d.handler(req)...)
+	}
+	return len(b), nil
+}
+
+func (d *fakeDev) SetReadDeadline(t time.Time) error  { return nil }
+func (d *fakeDev) SetWriteDeadline(t time.Time) error { return nil }
+func (d *fakeDev) GetConf() (serial.Conf, error)      { return d.conf, nil }
+
+func (d *fakeDev) FlushIn() error {
+	d.mu.Lock()
+	d.fls++
+	d.mu.Unlock()
+	return nil
+}
+
+// rtu returns an RTU frame with the given contents and CRC appended
+func rtu(b ...byte) []byte {
+	return RTU.encode(b[0], b[1:])
+}
+
+func TestTiming(t *testing.T) {
+	tests := []struct {
+		conf     serial.Conf
+		t15, t35 time.Duration
+	}{
+		{serial.Conf{Baudrate: 9600, Databits: 8, Stopbits: 1},
+			1562500, 3645833},
+		{serial.Conf{Baudrate: 19200, Databits: 8, Stopbits: 1,
+			Parity: serial.ParityEven}, 859375, 2005208},
+		{serial.Conf{Baudrate: 115200, Databits: 8, Stopbits: 1},
+			750 * time.Microsecond, 1750 * time.Microsecond},
+	}
+	for _, tst := range tests {
+		t15, t35 := Timing(tst.conf)
+		if t15 != tst.t15 || t35 != tst.t35 {
+			t.Fatalf("%v: t1.5 = %v, t3.5 = %v", tst.conf, t15, t35)
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	pdu := []byte{0x03, 0x00, 0x00, 0x00, 0x0a}
+	exp := []byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x0a, 0xc5, 0xcd}
+	if b := RTU.encode(1, pdu); !bytes.Equal(b, exp) {
+		t.Fatalf("RTU: % x", b)
+	}
+	if s := string(ASCII.encode(1, pdu)); s != ":01030000000AF2\r\n" {
+		t.Fatalf("ASCII: %q", s)
+	}
+	u, p, err := RTU.decode(exp)
+	if u != 1 || !bytes.Equal(p, pdu) || err != nil {
+		t.Fatalf("RTU decode: %d, % x, %v", u, p, err)
+	}
+	exp[7]++
+	if _, _, err := RTU.decode(exp); err != ErrChecksum {
+		t.Fatalf("RTU decode: %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	d := &fakeDev{conf: serial.Conf{Baudrate: 115200}}
+	d.handler = func(req []byte) [][]byte {
+		// Response in chunks
+		r := rtu(0x11, 0x03, 0x06, 0xae, 0x41, 0x56, 0x52, 0x43, 0x40)
+		return [][]byte{r[:2], r[2:5], r[5:]}
+	}
+	m := NewMaster(d)
+	regs, err := m.ReadHoldingRegisters(0x11, 0x006b, 3)
+	if err != nil {
+		t.Fatal("ReadHoldingRegisters:", err)
+	}
+	if !reflect.DeepEqual(regs, []uint16{0xae41, 0x5652, 0x4340}) {
+		t.Fatalf("Registers: %04x", regs)
+	}
+	exp0 := rtu(0x11, 0x03, 0x00, 0x6b, 0x00, 0x03)
+	if !bytes.Equal(d.tx[0], exp0) {
+		t.Fatalf("Request: % x", d.tx[0])
+	}
+	if d.fls != 1 {
+		t.Fatalf("FlushIn called %d times", d.fls)
+	}
+
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{rtu(0x11, 0x01, 0x02, 0xcd, 0x01)}
+	}
+	coils, err := m.ReadCoils(0x11, 0x13, 10)
+	exp := []bool{true, false, true, true, false, false, true, true,
+		true, false}
+	if err != nil || !reflect.DeepEqual(coils, exp) {
+		t.Fatalf("ReadCoils: %v, %v", coils, err)
+	}
+	if _, err := m.ReadCoils(0x11, 0, 0); err != ErrQuantity {
+		t.Fatalf("ReadCoils: %v", err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	d := &fakeDev{conf: serial.Conf{Baudrate: 9600, Databits: 8,
+		Stopbits: 1}}
+	d.handler = func(req []byte) [][]byte {
+		// Echo address and quantity / value
+		return [][]byte{rtu(req[:6]...)}
+	}
+	m := NewMaster(d)
+	if err := m.WriteSingleCoil(1, 0xac, true); err != nil {
+		t.Fatal("WriteSingleCoil:", err)
+	}
+	if err := m.WriteSingleRegister(1, 1, 3); err != nil {
+		t.Fatal("WriteSingleRegister:", err)
+	}
+	err := m.WriteMultipleCoils(1, 0x13, []bool{true, false, true, true,
+		false, false, true, true, true, false})
+	if err != nil {
+		t.Fatal("WriteMultipleCoils:", err)
+	}
+	err = m.WriteMultipleRegisters(1, 1, []uint16{0x0a, 0x0102})
+	if err != nil {
+		t.Fatal("WriteMultipleRegisters:", err)
+	}
+	exp := [][]byte{
+		rtu(1, 0x05, 0x00, 0xac, 0xff, 0x00),
+		rtu(1, 0x06, 0x00, 0x01, 0x00, 0x03),
+		rtu(1, 0x0f, 0x00, 0x13, 0x00, 0x0a, 0x02, 0xcd, 0x01),
+		rtu(1, 0x10, 0x00, 0x01, 0x00, 0x02, 0x04, 0x00, 0x0a,
+			0x01, 0x02),
+	}
+	if !reflect.DeepEqual(d.tx, exp) {
+		t.Fatalf("Requests: % x", d.tx)
+	}
+
+	// Bad echo
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{rtu(1, 0x06, 0x00, 0x01, 0x00, 0x04)}
+	}
+	if err := m.WriteSingleRegister(1, 1, 3); err != ErrUnexpected {
+		t.Fatalf("WriteSingleRegister: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	d := &fakeDev{}
+	m := NewMaster(d)
+	m.Timeout = 10 * time.Millisecond
+
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{rtu(1, 0x83, 0x02)}
+	}
+	_, err := m.ReadHoldingRegisters(1, 0, 1)
+	if e, ok := err.(*Exception); !ok || e.Function != 0x03 ||
+		e.Code != IllegalDataAddress {
+		t.Fatalf("Exception: %v", err)
+	}
+
+	d.handler = nil
+	if _, err := m.ReadHoldingRegisters(1, 0, 1); err != serial.ErrTimeout {
+		t.Fatalf("No response: %v", err)
+	}
+
+	// Truncated response, ended by silence
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{rtu(1, 0x03, 0x02, 0x00, 0x01)[:5]}
+	}
+	if _, err := m.ReadHoldingRegisters(1, 0, 1); err != ErrFrame {
+		t.Fatalf("Truncated: %v", err)
+	}
+
+	// Wrong unit
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{rtu(2, 0x03, 0x02, 0x00, 0x01)}
+	}
+	if _, err := m.ReadHoldingRegisters(1, 0, 1); err != ErrUnexpected {
+		t.Fatalf("Wrong unit: %v", err)
+	}
+
+	// Retries: Bad CRC, then good response
+	n := 0
+	d.handler = func(req []byte) [][]byte {
+		r := rtu(1, 0x03, 0x02, 0x00, 0x01)
+		if n++; n == 1 {
+			r[len(r)-1]++
+		}
+		return [][]byte{r}
+	}
+	if _, err := m.ReadHoldingRegisters(1, 0, 1); err != ErrChecksum {
+		t.Fatalf("Bad CRC: %v", err)
+	}
+	n = 0
+	m.Retries = 2
+	regs, err := m.ReadHoldingRegisters(1, 0, 1)
+	if err != nil || regs[0] != 1 || n != 2 {
+		t.Fatalf("Retry: %v, %v, %d tries", regs, err, n)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	d := &fakeDev{}
+	m := NewMaster(d)
+	m.Turnaround = 20 * time.Millisecond
+	start := time.Now()
+	if err := m.WriteSingleRegister(0, 1, 2); err != nil {
+		t.Fatal("WriteSingleRegister:", err)
+	}
+	if time.Since(start) < m.Turnaround {
+		t.Fatal("Turnaround delay not observed")
+	}
+}
+
+func TestASCII(t *testing.T) {
+	d := &fakeDev{}
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{[]byte("junk:0103020"), []byte("102F7\r\n")}
+	}
+	m := NewMaster(d)
+	m.Mode = ASCII
+	regs, err := m.ReadHoldingRegisters(1, 0, 1)
+	if err != nil || regs[0] != 0x0102 {
+		t.Fatalf("ReadHoldingRegisters: %v, %v", regs, err)
+	}
+	if s := string(d.tx[0]); s != ":010300000001FB\r\n" {
+		t.Fatalf("Request: %q", s)
+	}
+
+	d.handler = func(req []byte) [][]byte {
+		return [][]byte{[]byte(":01030201020A\r\n")}
+	}
+	if _, err := m.ReadHoldingRegisters(1, 0, 1); err != ErrChecksum {
+		t.Fatalf("Bad LRC: %v", err)
+	}
+}
@@ -0,0 +1,392 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Handler handles the requests received by a Server. Methods are
+// called with addresses and quantities already checked against the
+// protocol limits. Single-coil and single-register writes are passed
+// to WriteCoils and WriteRegisters respectively.
+//
+// To make the server respond with a specific exception code, methods
+// return an *Exception (its Function field is ignored). Any other
+// error results in a ServerDeviceFailure exception.
+type Handler interface {
+	ReadCoils(addr, qty uint16) ([]bool, error)
+	ReadDiscreteInputs(addr, qty uint16) ([]bool, error)
+	ReadHoldingRegisters(addr, qty uint16) ([]uint16, error)
+	ReadInputRegisters(addr, qty uint16) ([]uint16, error)
+	WriteCoils(addr uint16, v []bool) error
+	WriteRegisters(addr uint16, v []uint16) error
+}
+
+// ErrServerClosed is returned by Server.Serve after a call to
+// Server.Close.
+var ErrServerClosed = errors.New("modbus: server closed")
+
+// DefaultPollInterval is the default interval at which a Server
+// checks if it has been closed, while waiting for requests.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// Server receives Modbus requests from a port and answers them, as a
+// device (server, or slave) with unit address Unit, by calling the
+// methods of Handler. Requests addressed to other units are ignored.
+// Broadcast requests (to unit 0) are handled, but not answered.
+// Requests with a bad checksum, or malformed, are dropped. Requests
+// for unsupported functions, or with bad quantities or values, are
+// answered with the respective exception responses.
+//
+// In RTU mode, requests are delimited by silence of at least t3.5, or
+// FrameGap if not zero. If possible, the end of a request is also
+// detected from its length. Responses are sent after t3.5 of silence
+// on the line. For ports that delay received data by more than t3.5
+// (e.g. some USB adapters), FrameGap should be set accordingly. In
+// ASCII mode, FrameGap is the max silence within a request, and zero
+// means DefaultASCIIGap.
+type Server struct {
+	Unit         byte
+	Handler      Handler
+	Mode         Mode
+	FrameGap     time.Duration
+	PollInterval time.Duration // Zero means DefaultPollInterval
+
+	port   Port
+	r      frameReader
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// NewServer returns a Server that answers requests for unit,
+// received from port p, in RTU mode, using handler h.
+func NewServer(p Port, unit byte, h Handler) *Server {
+	return &Server{Unit: unit, Handler: h, port: p,
+		r: frameReader{port: p}}
+}
+
+// timing returns the inter-frame time (t3.5) and the frame gap
+func (s *Server) timing() (t35, gap time.Duration) {
+	c, _ := s.port.GetConf()
+	_, t35 = Timing(c)
+	gap = s.FrameGap
+	if gap <= 0 {
+		if s.Mode == ASCII {
+			gap = DefaultASCIIGap
+		} else {
+			gap = t35
+		}
+	}
+	return t35, gap
+}
+
+func (s *Server) pollInterval() time.Duration {
+	if s.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return s.PollInterval
+}
+
+// Serve receives and answers requests until the server is closed,
+// or a port error occurs. It always returns a non-nil error; after
+// Close it returns ErrServerClosed. Serve must not be called
+// concurrently, or again after it returns because of Close.
+func (s *Server) Serve() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.done = make(chan struct{})
+	s.mu.Unlock()
+	defer close(s.done)
+
+	for {
+		s.mu.Lock()
+		closed := s.closed
+		s.mu.Unlock()
+		if closed {
+			return ErrServerClosed
+		}
+		t35, gap := s.timing()
+		s.r.mode, s.r.gap = s.Mode, gap
+		b, err := s.r.read(time.Now().Add(s.pollInterval()), reqLen)
+		if err != nil {
+			if err == ErrFrame || ioerr.IsTimeout(err) {
+				continue
+			}
+			return err
+		}
+		unit, pdu, err := s.Mode.decode(b)
+		if err != nil || len(pdu) == 0 {
+			continue
+		}
+		if unit != s.Unit && unit != 0 {
+			continue
+		}
+		resp := s.handle(pdu)
+		if unit == 0 {
+			continue
+		}
+		if s.Mode == RTU {
+			if d := s.r.last.Add(t35).Sub(time.Now()); d > 0 {
+				time.Sleep(d)
+			}
+		}
+		adu := s.Mode.encode(unit, resp)
+		s.port.SetWriteDeadline(time.Now().Add(DefaultTimeout))
+		if _, err := s.port.Write(adu); err != nil {
+			return err
+		}
+	}
+}
+
+// Close stops the server, and waits for Serve to return. It does not
+// close the Port.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	done := s.done
+	s.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	return nil
+}
+
+// exception returns an exception-response PDU for function fn
+func exception(fn byte, c ExceptionCode) []byte {
+	return []byte{fn | 0x80, byte(c)}
+}
+
+// handlerException returns an exception-response PDU for function
+// fn, and error err returned by the Handler.
+func handlerException(fn byte, err error) []byte {
+	if e, ok := err.(*Exception); ok {
+		return exception(fn, e.Code)
+	}
+	return exception(fn, ServerDeviceFailure)
+}
+
+// checkRange checks the quantity, and the address range of a request
+func checkRange(addr, qty uint16, max int) ExceptionCode {
+	if qty < 1 || int(qty) > max {
+		return IllegalDataValue
+	}
+	if int(addr)+int(qty) > 0x10000 {
+		return IllegalDataAddress
+	}
+	return 0
+}
+
+// handle handles the request with PDU pdu, and returns the PDU of the
+// response.
+func (s *Server) handle(pdu []byte) []byte {
+	fn := pdu[0]
+	switch fn {
+	case FuncReadCoils, FuncReadDiscreteInputs,
+		FuncReadHoldingRegisters, FuncReadInputRegisters:
+	case FuncWriteSingleCoil, FuncWriteSingleRegister,
+		FuncWriteMultipleCoils, FuncWriteMultipleRegisters:
+	default:
+		return exception(fn, IllegalFunction)
+	}
+	if len(pdu) < 5 {
+		return exception(fn, IllegalDataValue)
+	}
+	// For single writes, qty is the value
+	addr, qty := getBE16(pdu[1:]), getBE16(pdu[3:])
+
+	switch fn {
+	case FuncReadCoils, FuncReadDiscreteInputs:
+		if len(pdu) != 5 {
+			return exception(fn, IllegalDataValue)
+		}
+		if c := checkRange(addr, qty, MaxReadBits); c != 0 {
+			return exception(fn, c)
+		}
+		var bits []bool
+		var err error
+		if fn == FuncReadCoils {
+			bits, err = s.Handler.ReadCoils(addr, qty)
+		} else {
+			bits, err = s.Handler.ReadDiscreteInputs(addr, qty)
+		}
+		if err == nil && len(bits) != int(qty) {
+			err = ErrQuantity
+		}
+		if err != nil {
+			return handlerException(fn, err)
+		}
+		n := (len(bits) + 7) / 8
+		resp := make([]byte, 2+n)
+		resp[0], resp[1] = fn, byte(n)
+		for i, b := range bits {
+			if b {
+				resp[2+i/8] |= 1 << uint(i%8)
+			}
+		}
+		return resp
+
+	case FuncReadHoldingRegisters, FuncReadInputRegisters:
+		if len(pdu) != 5 {
+			return exception(fn, IllegalDataValue)
+		}
+		if c := checkRange(addr, qty, MaxReadRegisters); c != 0 {
+			return exception(fn, c)
+		}
+		var regs []uint16
+		var err error
+		if fn == FuncReadHoldingRegisters {
+			regs, err = s.Handler.ReadHoldingRegisters(addr, qty)
+		} else {
+			regs, err = s.Handler.ReadInputRegisters(addr, qty)
+		}
+		if err == nil && len(regs) != int(qty) {
+			err = ErrQuantity
+		}
+		if err != nil {
+			return handlerException(fn, err)
+		}
+		resp := []byte{fn, byte(2 * len(regs))}
+		for _, r := range regs {
+			resp = be16(resp, r)
+		}
+		return resp
+
+	case FuncWriteSingleCoil:
+		if len(pdu) != 5 || qty != 0 && qty != 0xff00 {
+			return exception(fn, IllegalDataValue)
+		}
+		err := s.Handler.WriteCoils(addr, []bool{qty != 0})
+		if err != nil {
+			return handlerException(fn, err)
+		}
+
+	case FuncWriteSingleRegister:
+		if len(pdu) != 5 {
+			return exception(fn, IllegalDataValue)
+		}
+		err := s.Handler.WriteRegisters(addr, []uint16{qty})
+		if err != nil {
+			return handlerException(fn, err)
+		}
+
+	case FuncWriteMultipleCoils:
+		n := (int(qty) + 7) / 8
+		if len(pdu) != 6+n || int(pdu[5]) != n {
+			return exception(fn, IllegalDataValue)
+		}
+		if c := checkRange(addr, qty, MaxWriteBits); c != 0 {
+			return exception(fn, c)
+		}
+		bits := make([]bool, qty)
+		for i := range bits {
+			bits[i] = pdu[6+i/8]&(1<<uint(i%8)) != 0
+		}
+		if err := s.Handler.WriteCoils(addr, bits); err != nil {
+			return handlerException(fn, err)
+		}
+
+	case FuncWriteMultipleRegisters:
+		n := 2 * int(qty)
+		if len(pdu) != 6+n || int(pdu[5]) != n {
+			return exception(fn, IllegalDataValue)
+		}
+		if c := checkRange(addr, qty, MaxWriteRegisters); c != 0 {
+			return exception(fn, c)
+		}
+		regs := make([]uint16, qty)
+		for i := range regs {
+			regs[i] = getBE16(pdu[6+2*i:])
+		}
+		if err := s.Handler.WriteRegisters(addr, regs); err != nil {
+			return handlerException(fn, err)
+		}
+	}
+	// Write responses echo the address and quantity / value
+	return append([]byte(nil), pdu[:5]...)
+}
+
+// Memory is a Handler that keeps the coils, discrete inputs, and
+// registers of a device in memory. Addresses are indexes in the
+// respective slices; requests that access elements beyond their ends
+// are answered with IllegalDataAddress exceptions. Discrete inputs
+// and input registers cannot be written by the master. The fields
+// can be accessed while the server runs, with the lock held.
+type Memory struct {
+	sync.Mutex
+	Coils            []bool
+	DiscreteInputs   []bool
+	HoldingRegisters []uint16
+	InputRegisters   []uint16
+}
+
+var errAddress = &Exception{Code: IllegalDataAddress}
+
+func readBits(bits []bool, addr, qty uint16) ([]bool, error) {
+	if int(addr)+int(qty) > len(bits) {
+		return nil, errAddress
+	}
+	return append([]bool(nil), bits[addr:addr+qty]...), nil
+}
+
+func readRegs(regs []uint16, addr, qty uint16) ([]uint16, error) {
+	if int(addr)+int(qty) > len(regs) {
+		return nil, errAddress
+	}
+	return append([]uint16(nil), regs[addr:addr+qty]...), nil
+}
+
+func (m *Memory) ReadCoils(addr, qty uint16) ([]bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	return readBits(m.Coils, addr, qty)
+}
+
+func (m *Memory) ReadDiscreteInputs(addr, qty uint16) ([]bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	return readBits(m.DiscreteInputs, addr, qty)
+}
+
+func (m *Memory) ReadHoldingRegisters(addr, qty uint16) ([]uint16, error) {
+	m.Lock()
+	defer m.Unlock()
+	return readRegs(m.HoldingRegisters, addr, qty)
+}
+
+func (m *Memory) ReadInputRegisters(addr, qty uint16) ([]uint16, error) {
+	m.Lock()
+	defer m.Unlock()
+	return readRegs(m.InputRegisters, addr, qty)
+}
+
+func (m *Memory) WriteCoils(addr uint16, v []bool) error {
+	m.Lock()
+	defer m.Unlock()
+	if int(addr)+len(v) > len(m.Coils) {
+		return errAddress
+	}
+	copy(m.Coils[addr:], v)
+	return nil
+}
+
+func (m *Memory) WriteRegisters(addr uint16, v []uint16) error {
+	m.Lock()
+	defer m.Unlock()
+	if int(addr)+len(v) > len(m.HoldingRegisters) {
+		return errAddress
+	}
+	copy(m.HoldingRegisters[addr:], v)
+	return nil
+}
@@ -0,0 +1,175 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package modbus
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/ioerr"
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+// serialPort is a pipe end with the configuration of a serial port
+type serialPort struct {
+	*pipe.End
+}
+
+func (p serialPort) GetConf() (serial.Conf, error) {
+	return serial.Conf{Baudrate: 19200, Databits: 8, Stopbits: 1,
+		Parity: serial.ParityEven}, nil
+}
+
+// startServer starts a server for unit 1 with memory mem, and
+// returns a master connected to it.
+func startServer(mode Mode, mem *Memory) (*Master, *Server, chan error) {
+	pm, ps := pipe.New()
+	s := NewServer(serialPort{ps}, 1, mem)
+	s.Mode = mode
+	s.PollInterval = 10 * time.Millisecond
+	errc := make(chan error, 1)
+	go func() { errc <- s.Serve() }()
+	m := NewMaster(serialPort{pm})
+	m.Mode = mode
+	m.Timeout = 200 * time.Millisecond
+	return m, s, errc
+}
+
+func TestServer(t *testing.T) {
+	mem := &Memory{
+		Coils:            make([]bool, 20),
+		DiscreteInputs:   []bool{true, false, true},
+		HoldingRegisters: make([]uint16, 10),
+		InputRegisters:   []uint16{1, 2, 3},
+	}
+	m, s, errc := startServer(RTU, mem)
+
+	coils := []bool{true, false, true, true, false, false, true, true,
+		true, false}
+	if err := m.WriteMultipleCoils(1, 3, coils); err != nil {
+		t.Fatal("WriteMultipleCoils:", err)
+	}
+	if err := m.WriteSingleCoil(1, 19, true); err != nil {
+		t.Fatal("WriteSingleCoil:", err)
+	}
+	b, err := m.ReadCoils(1, 3, 17)
+	if err != nil || !reflect.DeepEqual(b[:10], coils) || !b[16] {
+		t.Fatalf("ReadCoils: %v, %v", b, err)
+	}
+	b, err = m.ReadDiscreteInputs(1, 0, 3)
+	if err != nil || !reflect.DeepEqual(b, mem.DiscreteInputs) {
+		t.Fatalf("ReadDiscreteInputs: %v, %v", b, err)
+	}
+
+	regs := []uint16{0x1234, 0xabcd, 7}
+	if err := m.WriteMultipleRegisters(1, 2, regs); err != nil {
+		t.Fatal("WriteMultipleRegisters:", err)
+	}
+	if err := m.WriteSingleRegister(1, 9, 0xffff); err != nil {
+		t.Fatal("WriteSingleRegister:", err)
+	}
+	r, err := m.ReadHoldingRegisters(1, 2, 8)
+	if err != nil || !reflect.DeepEqual(r[:3], regs) || r[7] != 0xffff {
+		t.Fatalf("ReadHoldingRegisters: %04x, %v", r, err)
+	}
+	r, err = m.ReadInputRegisters(1, 1, 2)
+	if err != nil || !reflect.DeepEqual(r, []uint16{2, 3}) {
+		t.Fatalf("ReadInputRegisters: %v, %v", r, err)
+	}
+
+	// Broadcast is handled, but not answered
+	if err := m.WriteSingleRegister(0, 0, 42); err != nil {
+		t.Fatal("Broadcast:", err)
+	}
+	mem.Lock()
+	v := mem.HoldingRegisters[0]
+	mem.Unlock()
+	if v != 42 {
+		t.Fatalf("Broadcast not handled: %d", v)
+	}
+
+	// Other units are ignored
+	_, err = m.ReadHoldingRegisters(2, 0, 1)
+	if !ioerr.IsTimeout(err) {
+		t.Fatalf("Unit 2: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	if err := <-errc; err != ErrServerClosed {
+		t.Fatalf("Serve: %v", err)
+	}
+}
+
+func TestServerExceptions(t *testing.T) {
+	mem := &Memory{HoldingRegisters: make([]uint16, 4)}
+	m, s, _ := startServer(RTU, mem)
+	defer s.Close()
+
+	tests := []struct {
+		pdu  []byte
+		code ExceptionCode
+	}{
+		// Unsupported function
+		{[]byte{0x2b, 0x0e, 0x01, 0x00}, IllegalFunction},
+		// Beyond end of memory
+		{[]byte{0x03, 0x00, 0x02, 0x00, 0x03}, IllegalDataAddress},
+		{[]byte{0x02, 0x00, 0x00, 0x00, 0x01}, IllegalDataAddress},
+		// Quantity zero, or too large
+		{[]byte{0x03, 0x00, 0x00, 0x00, 0x00}, IllegalDataValue},
+		{[]byte{0x04, 0x00, 0x00, 0x00, 0x7e}, IllegalDataValue},
+		// Bad coil value
+		{[]byte{0x05, 0x00, 0x00, 0x12, 0x34}, IllegalDataValue},
+		// Byte count mismatch
+		{[]byte{0x10, 0x00, 0x00, 0x00, 0x01, 0x04, 0x00, 0x01,
+			0x00, 0x02}, IllegalDataValue},
+		// Address range overflow
+		{[]byte{0x03, 0xff, 0xff, 0x00, 0x02}, IllegalDataAddress},
+	}
+	for _, tst := range tests {
+		_, err := m.Request(1, tst.pdu)
+		e, ok := err.(*Exception)
+		if !ok || e.Function != tst.pdu[0] || e.Code != tst.code {
+			t.Fatalf("% x: %v", tst.pdu, err)
+		}
+	}
+}
+
+func TestServerASCII(t *testing.T) {
+	mem := &Memory{HoldingRegisters: []uint16{0x0102, 0x0304}}
+	m, s, _ := startServer(ASCII, mem)
+	defer s.Close()
+
+	if err := m.WriteSingleRegister(1, 0, 0xbeef); err != nil {
+		t.Fatal("WriteSingleRegister:", err)
+	}
+	r, err := m.ReadHoldingRegisters(1, 0, 2)
+	if err != nil || !reflect.DeepEqual(r, []uint16{0xbeef, 0x0304}) {
+		t.Fatalf("ReadHoldingRegisters: %04x, %v", r, err)
+	}
+}
+
+func TestReqLen(t *testing.T) {
+	tests := []struct {
+		frame []byte
+		n     int
+	}{
+		{[]byte{0x01}, 0},
+		{[]byte{0x01, 0x03}, 8},
+		{[]byte{0x01, 0x10, 0x00, 0x00, 0x00, 0x02}, 0},
+		{[]byte{0x01, 0x10, 0x00, 0x00, 0x00, 0x02, 0x04}, 13},
+		{[]byte{0x01, 0x07}, 4},
+		{[]byte{0x01, 0x2b}, -1},
+	}
+	for _, tst := range tests {
+		if n := reqLen(tst.frame); n != tst.n {
+			t.Fatalf("% x: %d, expected %d", tst.frame, n, tst.n)
+		}
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package nmea
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnknown is returned by Sentence.Decode for sentence types it
+// cannot decode.
+var ErrUnknown = errors.New("nmea: unknown sentence type")
+
+// FieldError is returned by Sentence.Decode for fields that cannot
+// be parsed.
+type FieldError struct {
+	Type  string // Sentence type
+	Index int    // Index of the field in Sentence.Fields
+	Value string
+}
+
+func (e *FieldError) Error() string {
+	return "nmea: " + e.Type + ": bad field " + strconv.Itoa(e.Index) +
+		": " + strconv.Quote(e.Value)
+}
+
+// In the decoded sentences, empty (null) fields are represented as
+// NaN (floating point values), -1 (integers), zero times, or empty
+// strings. Latitudes and longitudes are in degrees, negative for
+// south and west.
+
+// GGA is the global positioning system fix data sentence
+type GGA struct {
+	Time       time.Duration // Time of day (UTC), since midnight
+	Lat, Lon   float64
+	Quality    int // 0: invalid, 1: GPS, 2: DGPS, ...
+	NumSats    int // Satellites in use
+	HDOP       float64
+	Altitude   float64 // Above mean sea level, meters
+	Separation float64 // Geoid separation, meters
+	DGPSAge    float64 // Age of differential data, seconds
+	DGPSSta    string  // Differential reference station ID
+}
+
+// RMC is the recommended minimum specific GNSS data sentence
+type RMC struct {
+	Time     time.Time // Date and time (UTC)
+	Valid    bool      // Status 'A'
+	Lat, Lon float64
+	Speed    float64 // Over ground, knots
+	Course   float64 // Over ground, degrees true
+	MagVar   float64 // Magnetic variation, degrees, negative west
+	Mode     string  // Mode indicator (NMEA 2.3 and later)
+}
+
+// GSA is the GNSS DOP and active satellites sentence
+type GSA struct {
+	Mode             string // "M": manual, "A": automatic
+	Fix              int    // 1: no fix, 2: 2D, 3: 3D
+	PRNs             []int  // Satellites used (non-empty fields)
+	PDOP, HDOP, VDOP float64
+}
+
+// Sat is the information for a satellite, in GSV sentences
+type Sat struct {
+	PRN       int
+	Elevation int // Degrees
+	Azimuth   int // Degrees true
+	SNR       int // dB-Hz; -1 if not tracking
+}
+
+// GSV is the GNSS satellites in view sentence. The satellites in view
+// are reported by a sequence of Total sentences.
+type GSV struct {
+	Total  int // Number of sentences in the sequence
+	Number int // Number of this sentence (1..Total)
+	InView int // Satellites in view
+	Sats   []Sat
+}
+
+// VTG is the course over ground and ground speed sentence
+type VTG struct {
+	Course    float64 // Degrees true
+	CourseMag float64 // Degrees magnetic
+	Knots     float64
+	Kmh       float64
+	Mode      string // Mode indicator (NMEA 2.3 and later)
+}
+
+// ZDA is the time and date sentence
+type ZDA struct {
+	Time        time.Time // Date and time (UTC)
+	ZoneHours   int       // Local zone offset, hours
+	ZoneMinutes int       // Local zone offset, minutes
+}
+
+// fields parses the fields of a sentence. The first error is
+// retained in err. Missing trailing fields are treated as empty.
+type fields struct {
+	typ string
+	f   []string
+	err error
+}
+
+func (p *fields) get(i int) string {
+	if i >= len(p.f) {
+		return ""
+	}
+	return p.f[i]
+}
+
+func (p *fields) fail(i int) {
+	if p.err == nil {
+		p.err = &FieldError{Type: p.typ, Index: i, Value: p.get(i)}
+	}
+}
+
+func (p *fields) float(i int) float64 {
+	s := p.get(i)
+	if s == "" {
+		return math.NaN()
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		p.fail(i)
+		return math.NaN()
+	}
+	return v
+}
+
+func (p *fields) int(i int) int {
+	s := p.get(i)
+	if s == "" {
+		return -1
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil || v < 0 {
+		p.fail(i)
+		return -1
+	}
+	return v
+}
+
+// signed returns the value of field i, negated if field i+1 is neg
+func (p *fields) signed(i int, neg string) float64 {
+	v := p.float(i)
+	if p.get(i+1) == neg {
+		v = -v
+	}
+	return v
+}
+
+// latlon parses a latitude or longitude ([d]ddmm.mmmm), at field i,
+// with the hemisphere at field i+1.
+func (p *fields) latlon(i int, pos, neg string) float64 {
+	s, h := p.get(i), p.get(i+1)
+	if s == "" {
+		return math.NaN()
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		p.fail(i)
+		return math.NaN()
+	}
+	deg := math.Floor(v / 100)
+	v = deg + (v-deg*100)/60
+	switch h {
+	case pos:
+	case neg:
+		v = -v
+	default:
+		p.fail(i + 1)
+		return math.NaN()
+	}
+	return v
+}
+
+// tod parses a time of day (hhmmss[.ss]) at field i
+func (p *fields) tod(i int) (time.Duration, bool) {
+	s := p.get(i)
+	if s == "" {
+		return 0, false
+	}
+	if len(s) < 6 {
+		p.fail(i)
+		return 0, false
+	}
+	h, err1 := strconv.Atoi(s[0:2])
+	m, err2 := strconv.Atoi(s[2:4])
+	sec, err3 := strconv.ParseFloat(s[4:], 64)
+	if err1 != nil || err2 != nil || err3 != nil ||
+		h > 23 || m > 59 || sec < 0 || sec >= 61 {
+		p.fail(i)
+		return 0, false
+	}
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+	return d + time.Duration(sec*float64(time.Second)+0.5), true
+}
+
+// zone parses a (possibly negative) local zone offset at field i
+func (p *fields) zone(i int) int {
+	s := p.get(i)
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(strings.TrimPrefix(s, "+"))
+	if err != nil {
+		p.fail(i)
+		return 0
+	}
+	return v
+}
+
+func (p *fields) str(i int) string {
+	return p.get(i)
+}
+
+// Decode decodes the sentence. It returns a *GGA, *RMC, *GSA, *GSV,
+// *VTG, or *ZDA, or ErrUnknown for other sentence types. Fields that
+// cannot be parsed are reported as *FieldError.
+func (s *Sentence) Decode() (interface{}, error) {
+	if s.Talker == "P" {
+		return nil, ErrUnknown
+	}
+	p := &fields{typ: s.Type, f: s.Fields}
+	var v interface{}
+	switch s.Type {
+	case "GGA":
+		v = decodeGGA(p)
+	case "RMC":
+		v = decodeRMC(p)
+	case "GSA":
+		v = decodeGSA(p)
+	case "GSV":
+		v = decodeGSV(p)
+	case "VTG":
+		v = decodeVTG(p)
+	case "ZDA":
+		v = decodeZDA(p)
+	default:
+		return nil, ErrUnknown
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return v, nil
+}
+
+func decodeGGA(p *fields) *GGA {
+	g := &GGA{}
+	g.Time, _ = p.tod(0)
+	g.Lat = p.latlon(1, "N", "S")
+	g.Lon = p.latlon(3, "E", "W")
+	g.Quality = p.int(5)
+	g.NumSats = p.int(6)
+	g.HDOP = p.float(7)
+	g.Altitude = p.float(8)
+	g.Separation = p.float(10)
+	g.DGPSAge = p.float(12)
+	g.DGPSSta = p.str(13)
+	return g
+}
+
+func decodeRMC(p *fields) *RMC {
+	r := &RMC{}
+	t, ok := p.tod(0)
+	r.Valid = p.str(1) == "A"
+	r.Lat = p.latlon(2, "N", "S")
+	r.Lon = p.latlon(4, "E", "W")
+	r.Speed = p.float(6)
+	r.Course = p.float(7)
+	if d := p.str(8); d != "" {
+		day, err := time.Parse("020106", d)
+		if err != nil {
+			p.fail(8)
+		} else if ok {
+			r.Time = day.Add(t)
+		}
+	}
+	r.MagVar = p.signed(9, "W")
+	r.Mode = p.str(11)
+	return r
+}
+
+func decodeGSA(p *fields) *GSA {
+	g := &GSA{}
+	g.Mode = p.str(0)
+	g.Fix = p.int(1)
+	for i := 2; i < 14; i++ {
+		if prn := p.int(i); prn >= 0 {
+			g.PRNs = append(g.PRNs, prn)
+		}
+	}
+	g.PDOP = p.float(14)
+	g.HDOP = p.float(15)
+	g.VDOP = p.float(16)
+	return g
+}
+
+func decodeGSV(p *fields) *GSV {
+	g := &GSV{}
+	g.Total = p.int(0)
+	g.Number = p.int(1)
+	g.InView = p.int(2)
+	// Up to 4 satellites, possibly followed by a signal ID (NMEA
+	// 4.1), which is ignored.
+	for i := 3; i+3 < len(p.f); i += 4 {
+		if p.str(i) == "" {
+			continue
+		}
+		g.Sats = append(g.Sats, Sat{PRN: p.int(i),
+			Elevation: p.int(i + 1), Azimuth: p.int(i + 2),
+			SNR: p.int(i + 3)})
+	}
+	return g
+}
+
+func decodeVTG(p *fields) *VTG {
+	v := &VTG{}
+	v.Course = p.float(0)
+	v.CourseMag = p.float(2)
+	v.Knots = p.float(4)
+	v.Kmh = p.float(6)
+	v.Mode = p.str(8)
+	return v
+}
+
+func decodeZDA(p *fields) *ZDA {
+	z := &ZDA{}
+	t, ok := p.tod(0)
+	day, month, year := p.int(1), p.int(2), p.int(3)
+	z.ZoneHours = p.zone(4)
+	z.ZoneMinutes = p.zone(5)
+	if ok && day > 0 && month > 0 && year >= 0 {
+		z.Time = time.Date(year, time.Month(month), day,
+			0, 0, 0, 0, time.UTC).Add(t)
+	}
+	return z
+}
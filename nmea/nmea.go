@@ -0,0 +1,162 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package nmea reads NMEA 0183 sentences, as sent by GPS receivers
+// and marine instruments, from serial ports.
+//
+// A sentence is a line of printable ASCII characters, starting with
+// '$' (or '!', for encapsulated sentences), followed by the address
+// (talker and sentence type, e.g. "GPGGA"), comma-separated data
+// fields, and an optional checksum ("*hh"). Proprietary sentences
+// have addresses starting with 'P', followed by the manufacturer
+// code and the sentence type (e.g. "PGRME").
+//
+// Reader.Next returns the sentences received, validating their
+// syntax and checksums. Malformed lines are reported as *LineError
+// errors, after which reading can continue. Sentence.Decode decodes
+// the common sentences (GGA, RMC, GSA, GSV, VTG, and ZDA) into the
+// respective structures.
+package nmea
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Port is the interface of the ports sentences are read from. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+}
+
+// MaxLine is the max length of the lines accepted. It is longer than
+// the standard's limit (82 characters), since many devices exceed it.
+const MaxLine = 256
+
+// Errors reported for malformed lines (in LineError)
+var (
+	ErrFormat   = errors.New("nmea: malformed sentence")
+	ErrChecksum = errors.New("nmea: checksum mismatch")
+	ErrTooLong  = errors.New("nmea: line too long")
+)
+
+// LineError is returned for lines that are not valid sentences
+type LineError struct {
+	Line string // The line (possibly truncated)
+	Err  error  // ErrFormat, ErrChecksum, or ErrTooLong
+}
+
+func (e *LineError) Error() string {
+	return e.Err.Error() + ": " + strconv.Quote(e.Line)
+}
+
+// Sentence is an NMEA sentence
+type Sentence struct {
+	Raw    string   // The sentence, without the line terminator
+	Talker string   // E.g. "GP"; "P" for proprietary sentences
+	Type   string   // E.g. "GGA"; for proprietary, e.g. "GRME"
+	Fields []string // Data fields, following the address
+}
+
+// checksum returns the checksum (XOR) of the characters in s
+func checksum(s string) byte {
+	var c byte
+	for i := 0; i < len(s); i++ {
+		c ^= s[i]
+	}
+	return c
+}
+
+// Parse parses line (without the line terminator) as a sentence. If
+// the line is not a valid sentence, it returns a *LineError.
+func Parse(line string) (*Sentence, error) {
+	bad := func(err error) (*Sentence, error) {
+		return nil, &LineError{Line: line, Err: err}
+	}
+	if len(line) > MaxLine {
+		return bad(ErrTooLong)
+	}
+	if len(line) < 2 || (line[0] != '$' && line[0] != '!') {
+		return bad(ErrFormat)
+	}
+	for i := 0; i < len(line); i++ {
+		if line[i] < 0x20 || line[i] > 0x7e {
+			return bad(ErrFormat)
+		}
+	}
+	body := line[1:]
+	if i := strings.LastIndexByte(body, '*'); i >= 0 {
+		v, err := strconv.ParseUint(body[i+1:], 16, 8)
+		if err != nil || len(body[i+1:]) != 2 {
+			return bad(ErrFormat)
+		}
+		body = body[:i]
+		if byte(v) != checksum(body) {
+			return bad(ErrChecksum)
+		}
+	}
+	f := strings.Split(body, ",")
+	s := &Sentence{Raw: line, Fields: f[1:]}
+	addr := f[0]
+	switch {
+	case len(addr) > 1 && addr[0] == 'P':
+		s.Talker, s.Type = "P", addr[1:]
+	case len(addr) == 5:
+		s.Talker, s.Type = addr[:2], addr[2:]
+	default:
+		return bad(ErrFormat)
+	}
+	for i := 0; i < len(addr); i++ {
+		if c := addr[i]; (c < 'A' || c > 'Z') && (c < '0' || c > '9') {
+			return bad(ErrFormat)
+		}
+	}
+	return s, nil
+}
+
+// Reader reads sentences from a port
+type Reader struct {
+	port Port
+	rbuf []byte
+	pend []byte // Received, not yet consumed
+	line []byte
+}
+
+// NewReader returns a Reader that reads sentences from port p
+func NewReader(p Port) *Reader {
+	return &Reader{port: p, rbuf: make([]byte, 512)}
+}
+
+// Next returns the next sentence received. If a malformed line is
+// received, Next returns a *LineError; the next call continues with
+// the following line. Errors returned by the port (including
+// timeouts) are returned as they are; partially received lines are
+// retained, and completed by the next call.
+func (r *Reader) Next() (*Sentence, error) {
+	for {
+		for len(r.pend) > 0 {
+			c := r.pend[0]
+			r.pend = r.pend[1:]
+			if c != '\n' && c != '\r' {
+				if len(r.line) < MaxLine+1 {
+					r.line = append(r.line, c)
+				}
+				continue
+			}
+			line := string(r.line)
+			r.line = r.line[:0]
+			if line == "" {
+				continue
+			}
+			return Parse(line)
+		}
+		n, err := r.port.Read(r.rbuf)
+		r.pend = r.rbuf[:n]
+		if n == 0 && err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package nmea
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// chunks is a Port returning the given chunks, one per Read, and
+// then serial.ErrTimeout.
+type chunks []string
+
+func (c *chunks) Read(b []byte) (int, error) {
+	if len(*c) == 0 {
+		return 0, serial.ErrTimeout
+	}
+	n := copy(b, (*c)[0])
+	(*c)[0] = (*c)[0][n:]
+	if (*c)[0] == "" {
+		*c = (*c)[1:]
+	}
+	return n, nil
+}
+
+func TestParse(t *testing.T) {
+	s, err := Parse("$GPGLL,4916.45,N,12311.12,W,225444,A,*1D")
+	if err != nil {
+		t.Fatal("Parse:", err)
+	}
+	fields := []string{"4916.45", "N", "12311.12", "W", "225444", "A", ""}
+	if s.Talker != "GP" || s.Type != "GLL" ||
+		!reflect.DeepEqual(s.Fields, fields) {
+		t.Fatalf("Parsed: %+v", s)
+	}
+	s, err = Parse("$PGRME,15.0,M,45.0,M,25.0,M")
+	if err != nil || s.Talker != "P" || s.Type != "GRME" {
+		t.Fatalf("Proprietary: %+v, %v", s, err)
+	}
+	bad := []struct {
+		line string
+		err  error
+	}{
+		{"$GPGLL,4916.45,N,12311.12,W,225444,A,*1E", ErrChecksum},
+		{"$GPGLL,4916.45*1", ErrFormat},
+		{"GPGLL,4916.45", ErrFormat},
+		{"$GPGL,4916.45", ErrFormat},
+		{"$gpgll,4916.45", ErrFormat},
+		{"$GPGLL,49\x0016.45", ErrFormat},
+	}
+	for _, b := range bad {
+		_, err := Parse(b.line)
+		if le, ok := err.(*LineError); !ok || le.Err != b.err {
+			t.Errorf("Parse %q: %v", b.line, err)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	long := "$GPTXT," + string(make([]byte, MaxLine)) + "\r\n"
+	p := &chunks{
+		"garbage\r\n$GPGGA,123519,4807.038,N,01131.000,E,1,08,0.9,",
+		"545.4,M,46.9,M,,*47\r\n\r\n",
+		"$GPRMC,123519,A,4807.038,N,01131.000,E,022.4,084.4,230394,",
+		"003.1,W*6A\r\n$GPGSA,A,3,04,05,,09,12,,,24,,,,,2.5,1.3,2.1*39",
+		"\r\n" + long + "$GPVTG,054.7,T,034.4,M,005.5,N,010.2,K*48\r\n",
+		"$GPZDA,201530.00,04,07,2002,00,00*60\r\n$GPRMC,12",
+	}
+	r := NewReader(p)
+	var got []interface{}
+	var errs []error
+	for {
+		s, err := r.Next()
+		if err == serial.ErrTimeout {
+			break
+		}
+		if err != nil {
+			errs = append(errs, err.(*LineError).Err)
+			continue
+		}
+		v, err := s.Decode()
+		if err != nil {
+			t.Fatalf("Decode %q: %v", s.Raw, err)
+		}
+		got = append(got, v)
+	}
+	if !reflect.DeepEqual(errs, []error{ErrFormat, ErrTooLong}) {
+		t.Fatalf("Errors: %v", errs)
+	}
+	if len(got) != 5 {
+		t.Fatalf("Decoded %d sentences", len(got))
+	}
+	near := func(a, b float64) bool { return math.Abs(a-b) < 1e-6 }
+	g := got[0].(*GGA)
+	if g.Time != 12*time.Hour+35*time.Minute+19*time.Second ||
+		!near(g.Lat, 48+7.038/60) || !near(g.Lon, 11+31.0/60) ||
+		g.Quality != 1 || g.NumSats != 8 || g.Altitude != 545.4 ||
+		!math.IsNaN(g.DGPSAge) || g.DGPSSta != "" {
+		t.Errorf("GGA: %+v", g)
+	}
+	rmc := got[1].(*RMC)
+	tm := time.Date(1994, 3, 23, 12, 35, 19, 0, time.UTC)
+	if !rmc.Time.Equal(tm) || !rmc.Valid || rmc.Speed != 22.4 ||
+		rmc.MagVar != -3.1 {
+		t.Errorf("RMC: %+v", rmc)
+	}
+	gsa := got[2].(*GSA)
+	prns := []int{4, 5, 9, 12, 24}
+	if gsa.Fix != 3 || !reflect.DeepEqual(gsa.PRNs, prns) ||
+		gsa.VDOP != 2.1 {
+		t.Errorf("GSA: %+v", gsa)
+	}
+	vtg := got[3].(*VTG)
+	if vtg.Course != 54.7 || vtg.Kmh != 10.2 || vtg.Mode != "" {
+		t.Errorf("VTG: %+v", vtg)
+	}
+	zda := got[4].(*ZDA)
+	tm = time.Date(2002, 7, 4, 20, 15, 30, 0, time.UTC)
+	if !zda.Time.Equal(tm) {
+		t.Errorf("ZDA: %+v", zda)
+	}
+
+	// The partial line is completed by the next call
+	*p = chunks{"3519,A,4807.038,S,01131.000,W,022.4,084.4,230394,",
+		"003.1,E*77\r\n"}
+	s, err := r.Next()
+	if err != nil {
+		t.Fatal("Next:", err)
+	}
+	v, err := s.Decode()
+	if err != nil {
+		t.Fatal("Decode:", err)
+	}
+	if rmc := v.(*RMC); rmc.Lat > 0 || rmc.Lon > 0 || rmc.MagVar != 3.1 {
+		t.Errorf("RMC: %+v", rmc)
+	}
+}
+
+func TestGSV(t *testing.T) {
+	s, err := Parse("$GPGSV,2,1,08,01,40,083,46,02,17,308,," +
+		"12,07,344,39,14,22,228,45*70")
+	if err != nil {
+		t.Fatal("Parse:", err)
+	}
+	v, err := s.Decode()
+	if err != nil {
+		t.Fatal("Decode:", err)
+	}
+	g := v.(*GSV)
+	sats := []Sat{{1, 40, 83, 46}, {2, 17, 308, -1}, {12, 7, 344, 39},
+		{14, 22, 228, 45}}
+	if g.Total != 2 || g.Number != 1 || g.InView != 8 ||
+		!reflect.DeepEqual(g.Sats, sats) {
+		t.Fatalf("GSV: %+v", g)
+	}
+
+	// Bad field
+	s, _ = Parse("$GPGSV,2,x,08")
+	_, err = s.Decode()
+	if fe, ok := err.(*FieldError); !ok || fe.Index != 1 {
+		t.Fatalf("Bad field: %v", err)
+	}
+	s, _ = Parse("$GPXYZ,1")
+	if _, err := s.Decode(); err != ErrUnknown {
+		t.Fatalf("Unknown: %v", err)
+	}
+}
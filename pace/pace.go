@@ -0,0 +1,252 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package pace paces the transmission of data to slow devices (old
+// terminals, PLCs, and the like) that drop characters sent at full
+// speed, and have no flow control.
+//
+// A Writer inserts delays after every character, and after every
+// line terminator. It can also wait, after each line, for the device
+// to send a prompt, or, after each character, for the device to echo
+// it, before sending more. Delays and waits honor the write deadline
+// (see Writer.SetWriteDeadline) and, with Writer.WriteContext,
+// context cancelation.
+package pace
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/ioerr"
+)
+
+// Port is the interface of the ports Writers write to. It is
+// satisfied by *serial.Port. Read and SetReadDeadline are used only
+// when waiting for prompts or echoes.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Defaults for Writer fields
+const (
+	DefaultEOL         = '\n'
+	DefaultWaitTimeout = 5 * time.Second
+)
+
+// ErrNoResponse is returned by Writer.Write if the prompt, or the echo
+// of a character, is not received within Writer.WaitTimeout.
+var ErrNoResponse = errors.New("pace: no prompt or echo received")
+
+// Interval for checking for context cancelation while waiting for
+// prompts or echoes.
+const pollInterval = 100 * time.Millisecond
+
+// Writer writes paced data to a port
+type Writer struct {
+	// Delay after every character
+	CharDelay time.Duration
+	// Delay after every line terminator (in addition to CharDelay)
+	LineDelay time.Duration
+	// Line terminator. Zero means DefaultEOL.
+	EOL byte
+	// If not nil, after every line terminator, wait for the
+	// device to send Prompt, before sending more.
+	Prompt []byte
+	// If set, after every character, wait for the device to echo
+	// it, before sending more.
+	Echo bool
+	// Max time to wait for a prompt or an echo. Zero means
+	// DefaultWaitTimeout.
+	WaitTimeout time.Duration
+	// If not nil, the data received while waiting for prompts and
+	// echoes are written to Output. Otherwise they are discarded.
+	Output io.Writer
+
+	port Port
+	mu   sync.Mutex
+	dlmu sync.Mutex
+	wdl  time.Time
+	win  []byte // Received, not yet matched by a prompt or echo
+	rbuf []byte
+}
+
+// NewWriter returns a Writer that writes to port p
+func NewWriter(p Port) *Writer {
+	return &Writer{port: p, rbuf: make([]byte, 256)}
+}
+
+func (w *Writer) eol() byte {
+	if w.EOL == 0 {
+		return DefaultEOL
+	}
+	return w.EOL
+}
+
+func (w *Writer) waitTimeout() time.Duration {
+	if w.WaitTimeout == 0 {
+		return DefaultWaitTimeout
+	}
+	return w.WaitTimeout
+}
+
+// SetWriteDeadline sets the deadline for Write operations, including
+// the delays and waits they incur. See serial.Port.SetWriteDeadline
+// for details.
+func (w *Writer) SetWriteDeadline(t time.Time) error {
+	w.dlmu.Lock()
+	w.wdl = t
+	w.dlmu.Unlock()
+	return nil
+}
+
+// Write is compatible with the Write method of the io.Writer
+// interface. It writes b to the port, paced as configured. If the
+// write deadline expires, Write returns serial.ErrTimeout, and the
+// number of bytes written. If a prompt or echo is not received in
+// time, it returns ErrNoResponse.
+func (w *Writer) Write(b []byte) (n int, err error) {
+	return w.WriteContext(context.Background(), b)
+}
+
+// WriteContext is like Write, but it can also be canceled using ctx,
+// in which case it returns ctx.Err(). A nil ctx is never canceled.
+func (w *Writer) WriteContext(ctx context.Context, b []byte) (n int,
+	err error) {
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dlmu.Lock()
+	dl := w.wdl
+	w.dlmu.Unlock()
+	if err := w.port.SetWriteDeadline(dl); err != nil {
+		return 0, err
+	}
+	eol := w.eol()
+	perChar := w.CharDelay > 0 || w.Echo
+	for len(b) > 0 {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		// Write a character, or up to the end of the line
+		l := 1
+		if !perChar {
+			if l = bytes.IndexByte(b, eol) + 1; l == 0 {
+				l = len(b)
+			}
+		}
+		nw, err := w.port.Write(b[:l])
+		n += nw
+		if err != nil {
+			return n, err
+		}
+		c := b[l-1]
+		b = b[l:]
+		if w.Echo {
+			if err := w.wait(ctx, dl, []byte{c}); err != nil {
+				return n, err
+			}
+		}
+		if err := w.sleep(ctx, dl, w.CharDelay); err != nil {
+			return n, err
+		}
+		if c != eol {
+			continue
+		}
+		if w.Prompt != nil {
+			if err := w.wait(ctx, dl, w.Prompt); err != nil {
+				return n, err
+			}
+		}
+		if err := w.sleep(ctx, dl, w.LineDelay); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// sleep pauses for d. If deadline dl expires first, it returns
+// serial.ErrTimeout.
+func (w *Writer) sleep(ctx context.Context, dl time.Time,
+	d time.Duration) error {
+
+	if d <= 0 {
+		return nil
+	}
+	var err error
+	if !dl.IsZero() {
+		if r := dl.Sub(time.Now()); r < d {
+			d, err = r, serial.ErrTimeout
+		}
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// wait reads from the port until pat is received. Data received
+// after pat are kept for the next wait. If pat is not received within
+// the wait timeout, it returns ErrNoResponse; if deadline dl expires
+// first, it returns serial.ErrTimeout.
+func (w *Writer) wait(ctx context.Context, dl time.Time,
+	pat []byte) error {
+
+	end := time.Now().Add(w.waitTimeout())
+	for {
+		if i := bytes.Index(w.win, pat); i >= 0 {
+			w.win = append(w.win[:0], w.win[i+len(pat):]...)
+			return nil
+		}
+		// Keep only what may be the start of pat
+		if k := len(w.win) - (len(pat) - 1); k > 0 {
+			w.win = append(w.win[:0], w.win[k:]...)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		now := time.Now()
+		if !dl.IsZero() && !now.Before(dl) {
+			return serial.ErrTimeout
+		}
+		if !now.Before(end) {
+			return ErrNoResponse
+		}
+		rdl := now.Add(pollInterval)
+		if end.Before(rdl) {
+			rdl = end
+		}
+		if !dl.IsZero() && dl.Before(rdl) {
+			rdl = dl
+		}
+		if err := w.port.SetReadDeadline(rdl); err != nil {
+			return err
+		}
+		n, err := w.port.Read(w.rbuf)
+		if n > 0 {
+			if w.Output != nil {
+				w.Output.Write(w.rbuf[:n])
+			}
+			w.win = append(w.win, w.rbuf[:n]...)
+		}
+		if err != nil && !ioerr.IsTimeout(err) {
+			return err
+		}
+	}
+}
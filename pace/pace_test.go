@@ -0,0 +1,267 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package pace
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+// arrival is a chunk of data received by a device, and its time
+type arrival struct {
+	t    time.Time
+	data string
+}
+
+// device records the data it receives. If echo is set, it echoes
+// every character (CR as CR LF). After every line (terminated by
+// CR), it waits for delay, and sends prompt. If delay is zero, the
+// echo of the CR and the prompt are sent together.
+type device struct {
+	p      *pipe.End
+	echo   bool
+	prompt string
+	delay  time.Duration
+
+	mu  sync.Mutex
+	rcv []arrival
+}
+
+func newDevice(p *pipe.End, echo bool, prompt string,
+	delay time.Duration) *device {
+
+	d := &device{p: p, echo: echo, prompt: prompt, delay: delay}
+	go d.run()
+	return d
+}
+
+func (d *device) run() {
+	b := make([]byte, 64)
+	for {
+		n, err := d.p.Read(b)
+		if err != nil {
+			return
+		}
+		d.mu.Lock()
+		d.rcv = append(d.rcv, arrival{time.Now(), string(b[:n])})
+		d.mu.Unlock()
+		for _, c := range b[:n] {
+			var out []byte
+			if d.echo {
+				out = append(out, c)
+				if c == '\r' {
+					out = append(out, '\n')
+				}
+			}
+			if c == '\r' && d.prompt != "" {
+				if d.delay > 0 {
+					if len(out) > 0 {
+						d.p.Write(out)
+						out = nil
+					}
+					time.Sleep(d.delay)
+				}
+				out = append(out, d.prompt...)
+			}
+			if len(out) > 0 {
+				d.p.Write(out)
+			}
+		}
+	}
+}
+
+func (d *device) received() []arrival {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]arrival(nil), d.rcv...)
+}
+
+func (d *device) data() string {
+	var s string
+	for _, a := range d.received() {
+		s += a.data
+	}
+	return s
+}
+
+// waitData waits for the device to receive n bytes
+func (d *device) waitData(t *testing.T, n int) string {
+	end := time.Now().Add(time.Second)
+	for len(d.data()) < n {
+		if time.Now().After(end) {
+			t.Fatalf("Received %q", d.data())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return d.data()
+}
+
+func TestDelays(t *testing.T) {
+	pw, pd := pipe.New()
+	d := newDevice(pd, false, "", 0)
+	w := NewWriter(pw)
+	w.CharDelay = 10 * time.Millisecond
+	w.LineDelay = 50 * time.Millisecond
+	w.EOL = '\r'
+	msg := "ab\rcd\r"
+	start := time.Now()
+	n, err := w.Write([]byte(msg))
+	if n != len(msg) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	// 6 character delays, 2 line delays
+	if el := time.Since(start); el < 160*time.Millisecond {
+		t.Fatalf("Write took %v", el)
+	}
+	if s := d.waitData(t, len(msg)); s != msg {
+		t.Fatalf("Received %q", s)
+	}
+	rcv := d.received()
+	if len(rcv) != len(msg) {
+		t.Fatalf("Received in %d chunks", len(rcv))
+	}
+	if gap := rcv[3].t.Sub(rcv[2].t); gap < 60*time.Millisecond {
+		t.Fatalf("Line gap %v", gap)
+	}
+
+	// Line delays only: lines are written whole
+	pw, pd = pipe.New()
+	d = newDevice(pd, false, "", 0)
+	w = NewWriter(pw)
+	w.LineDelay = 50 * time.Millisecond
+	msg = "line 1\nline 2\nrest"
+	if n, err := w.Write([]byte(msg)); n != len(msg) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	d.waitData(t, len(msg))
+	rcv = d.received()
+	if len(rcv) != 3 || rcv[1].data != "line 2\n" {
+		t.Fatalf("Received %v", rcv)
+	}
+	if gap := rcv[1].t.Sub(rcv[0].t); gap < 50*time.Millisecond {
+		t.Fatalf("Line gap %v", gap)
+	}
+}
+
+func TestPrompt(t *testing.T) {
+	pw, pd := pipe.New()
+	d := newDevice(pd, false, "> ", 50*time.Millisecond)
+	w := NewWriter(pw)
+	w.EOL = '\r'
+	w.Prompt = []byte("> ")
+	var out bytes.Buffer
+	w.Output = &out
+	msg := "10 PRINT\r20 GOTO 10\r"
+	start := time.Now()
+	if n, err := w.Write([]byte(msg)); n != len(msg) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	if el := time.Since(start); el < 100*time.Millisecond {
+		t.Fatalf("Write took %v", el)
+	}
+	if out.String() != "> > " {
+		t.Fatalf("Output: %q", out.String())
+	}
+	if s := d.waitData(t, len(msg)); s != msg {
+		t.Fatalf("Received %q", s)
+	}
+
+	// No prompt
+	w.Prompt = []byte("OK")
+	w.WaitTimeout = 100 * time.Millisecond
+	n, err := w.Write([]byte("a\rb\r"))
+	if n != 2 || err != ErrNoResponse {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	pw, pd := pipe.New()
+	d := newDevice(pd, true, "", 0)
+	w := NewWriter(pw)
+	w.Echo = true
+	w.EOL = '\r'
+	var out bytes.Buffer
+	w.Output = &out
+	msg := "dir\rls\r"
+	// A nil context is never canceled
+	n, err := w.WriteContext(nil, []byte(msg))
+	if n != len(msg) || err != nil {
+		t.Fatalf("WriteContext: %d, %v", n, err)
+	}
+	if out.String() != "dir\r\nls\r\n" {
+		t.Fatalf("Output: %q", out.String())
+	}
+	// Characters are sent one at a time
+	if rcv := d.received(); len(rcv) != len(msg) {
+		t.Fatalf("Received %v", rcv)
+	}
+}
+
+func TestEchoPrompt(t *testing.T) {
+	pw, pd := pipe.New()
+	d := newDevice(pd, true, "> ", 0)
+	w := NewWriter(pw)
+	w.Echo = true
+	w.EOL = '\r'
+	w.Prompt = []byte("> ")
+	w.WaitTimeout = 200 * time.Millisecond
+	var out bytes.Buffer
+	w.Output = &out
+	msg := "ab\rcd\r"
+	if n, err := w.Write([]byte(msg)); n != len(msg) || err != nil {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	if out.String() != "ab\r\n> cd\r\n> " {
+		t.Fatalf("Output: %q", out.String())
+	}
+	if s := d.waitData(t, len(msg)); s != msg {
+		t.Fatalf("Received %q", s)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	pw, pd := pipe.New()
+	d := newDevice(pd, false, "", 0)
+	w := NewWriter(pw)
+	w.CharDelay = 50 * time.Millisecond
+	w.SetWriteDeadline(time.Now().Add(120 * time.Millisecond))
+	start := time.Now()
+	n, err := w.Write([]byte("0123456789"))
+	if n != 3 || err != serial.ErrTimeout {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	if el := time.Since(start); el > 300*time.Millisecond {
+		t.Fatalf("Write took %v", el)
+	}
+	if s := d.waitData(t, 3); s != "012" {
+		t.Fatalf("Received %q", s)
+	}
+
+	// Waiting for a prompt
+	w.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	w.CharDelay = 0
+	w.Prompt = []byte("> ")
+	n, err = w.Write([]byte("x\ny\n"))
+	if n != 2 || err != serial.ErrTimeout {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+
+	// Canceled
+	w.SetWriteDeadline(time.Time{})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	n, err = w.WriteContext(ctx, []byte("x\ny\n"))
+	if n != 2 || err != context.Canceled {
+		t.Fatalf("WriteContext: %d, %v", n, err)
+	}
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package pcapng writes serial-port traffic to pcapng capture files,
+// which can be opened and dissected with Wireshark and similar tools.
+//
+// Encapsulation
+//
+// Files written by package pcapng contain a single section, with a
+// single interface whose link-type is, by default, LinkTypeUser0
+// (147, "DLT_USER0"). Every packet contains the raw bytes as they
+// were transferred over the serial line, without any additional
+// header. Packet direction is recorded in the packet's epb_flags
+// option (inbound or outbound), and packet timestamps have
+// microsecond resolution. To dissect the packets in Wireshark, map
+// the user link-type to the appropriate dissector (Preferences >
+// Protocols > DLT_USER; e.g. User 0 to "mbrtu" for Modbus RTU
+// traffic).
+//
+// Grouping bytes to packets
+//
+// Serial traffic is a byte stream; the Writer groups bytes to packets
+// either by idle gap (bytes separated by less than Writer.IdleGap
+// belong to the same packet), or by using a framer (Writer.Split),
+// or both. If neither is given, every chunk of data passed to the
+// Writer becomes a packet. Bytes flowing in each direction are
+// grouped separately. If the framer returns an error, or advances
+// past the data given to it, the pending data are written as a
+// packet, and framing starts afresh.
+//
+// Sources
+//
+// Data can be passed to the Writer directly (Writer.WriteData), from
+// a serial.Port's tracer (see Writer.Tracer), or from a sniffer (the
+// Writer is a sniff.Recorder).
+package pcapng
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/sniff"
+)
+
+// LinkTypeUser0 is the first of the link-types reserved for private
+// use (LINKTYPE_USER0 to LINKTYPE_USER15 are 147 to 162).
+const LinkTypeUser0 = 147
+
+// DefaultSnapLen is the default maximum packet length
+const DefaultSnapLen = 65535
+
+// Dir is the direction of a packet. Its values are those of the
+// direction bits in the pcapng epb_flags option.
+type Dir int
+
+const (
+	DirUnknown Dir = 0 // Direction not known
+	Inbound    Dir = 1 // Received (read from the port)
+	Outbound   Dir = 2 // Transmitted (written to the port)
+)
+
+// Block types and option codes
+const (
+	blkSHB = 0x0A0D0D0A // Section Header Block
+	blkIDB = 0x00000001 // Interface Description Block
+	blkEPB = 0x00000006 // Enhanced Packet Block
+
+	byteOrderMagic = 0x1A2B3C4D
+
+	optEnd       = 0
+	optIfName    = 2
+	optIfTsresol = 9
+	optEpbFlags  = 2
+)
+
+// pending is the data accumulated for a packet
+type pending struct {
+	buf   []byte
+	first time.Time // Time of first byte
+	last  time.Time // Time of last byte
+}
+
+// Writer writes serial traffic to a pcapng file. Fields LinkType,
+// IfName, SnapLen, IdleGap, and Split must be set (if required)
+// before the first data are written. The methods of Writer can be
+// called concurrently.
+//
+// Data are buffered in the Writer until a packet is complete; call
+// Flush or Close to write out incomplete packets. A packet grouped by
+// idle gap is complete when data (in any direction) are written more
+// than IdleGap after its last byte.
+type Writer struct {
+	LinkType int             // Zero means LinkTypeUser0
+	IfName   string          // Interface name (e.g. port name); optional
+	SnapLen  int             // Max packet length; zero means DefaultSnapLen
+	IdleGap  time.Duration   // Group bytes by idle gap (if > 0)
+	Split    bufio.SplitFunc // Group bytes by framer (if not nil)
+
+	mu     sync.Mutex
+	w      io.Writer
+	header bool
+	pend   [3]pending // Indexed by Dir
+	err    error
+	b      []byte // Block-encoding scratch buffer
+}
+
+// NewWriter returns a Writer that writes a pcapng capture to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+func (w *Writer) snapLen() int {
+	if w.SnapLen <= 0 {
+		return DefaultSnapLen
+	}
+	return w.SnapLen
+}
+
+// WriteData writes b, transferred in direction dir at time t, to the
+// capture. Data passed in successive calls (for the same direction)
+// may be grouped into a single packet, or split across several, as
+// described in the package documentation. The first error
+// encountered writing to the underlying writer is returned by this,
+// and every subsequent call.
+func (w *Writer) WriteData(t time.Time, dir Dir, b []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if dir < DirUnknown || dir > Outbound {
+		dir = DirUnknown
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if w.IdleGap > 0 {
+		w.flushIdle(t)
+	}
+	p := &w.pend[dir]
+	if len(p.buf) == 0 {
+		p.first = t
+	}
+	p.buf = append(p.buf, b...)
+	p.last = t
+
+	if w.Split != nil {
+		for len(p.buf) > 0 && w.err == nil {
+			adv, _, err := w.Split(p.buf, false)
+			if err != nil || adv > len(p.buf) {
+				// Framer gave up (or misbehaved); write
+				// everything as a packet, and start afresh.
+				adv = len(p.buf)
+			}
+			if adv <= 0 {
+				break
+			}
+			w.emit(dir, adv)
+			p.first = t
+		}
+	} else if w.IdleGap <= 0 {
+		w.emit(dir, len(p.buf))
+	}
+	for snap := w.snapLen(); len(p.buf) >= snap && w.err == nil; {
+		w.emit(dir, snap)
+	}
+	return w.err
+}
+
+// flushIdle writes out the pending packets whose last byte is older
+// than IdleGap at time t.
+func (w *Writer) flushIdle(t time.Time) {
+	w.flushIf(func(p *pending) bool {
+		return t.Sub(p.last) >= w.IdleGap
+	})
+}
+
+// flushIf writes out the pending packets selected by f, in the order
+// of their first bytes.
+func (w *Writer) flushIf(f func(p *pending) bool) {
+	var dirs []int
+	for d := range w.pend {
+		p := &w.pend[d]
+		if len(p.buf) == 0 || !f(p) {
+			continue
+		}
+		i := len(dirs)
+		dirs = append(dirs, d)
+		for ; i > 0 && p.first.Before(w.pend[dirs[i-1]].first); i-- {
+			dirs[i] = dirs[i-1]
+		}
+		dirs[i] = d
+	}
+	for _, d := range dirs {
+		w.emit(Dir(d), len(w.pend[d].buf))
+	}
+}
+
+// Flush writes out all pending (possibly incomplete) packets
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+	w.flushIf(func(p *pending) bool { return true })
+	return w.err
+}
+
+// Close flushes the Writer. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
+
+// emit writes the first n bytes pending for direction dir as a
+// packet, and removes them.
+func (w *Writer) emit(dir Dir, n int) {
+	p := &w.pend[dir]
+	if w.err == nil {
+		if !w.header {
+			w.writeHeader()
+		}
+		w.writePacket(p.first, dir, p.buf[:n])
+	}
+	p.buf = p.buf[:copy(p.buf, p.buf[n:])]
+}
+
+func (w *Writer) writeHeader() {
+	w.header = true
+
+	b := w.b[:0]
+	b = le32(b, byteOrderMagic)
+	b = le16(b, 1) // Major version
+	b = le16(b, 0) // Minor version
+	b = le32(b, 0xffffffff)
+	b = le32(b, 0xffffffff) // Section length: unspecified
+	w.b = b
+	w.writeBlock(blkSHB)
+
+	lt := w.LinkType
+	if lt == 0 {
+		lt = LinkTypeUser0
+	}
+	b = w.b[:0]
+	b = le16(b, uint16(lt))
+	b = le16(b, 0) // Reserved
+	b = le32(b, uint32(w.snapLen()))
+	if w.IfName != "" {
+		b = option(b, optIfName, []byte(w.IfName))
+	}
+	b = option(b, optIfTsresol, []byte{6}) // Microseconds
+	b = option(b, optEnd, nil)
+	w.b = b
+	w.writeBlock(blkIDB)
+}
+
+func (w *Writer) writePacket(t time.Time, dir Dir, data []byte) {
+	ts := uint64(t.UnixNano() / 1000)
+	b := w.b[:0]
+	b = le32(b, 0) // Interface ID
+	b = le32(b, uint32(ts>>32))
+	b = le32(b, uint32(ts))
+	b = le32(b, uint32(len(data))) // Captured length
+	b = le32(b, uint32(len(data))) // Original length
+	b = append(b, data...)
+	b = pad(b)
+	if dir != DirUnknown {
+		b = option(b, optEpbFlags, le32(nil, uint32(dir)))
+		b = option(b, optEnd, nil)
+	}
+	w.b = b
+	w.writeBlock(blkEPB)
+}
+
+// writeBlock writes a block of type typ, with body w.b
+func (w *Writer) writeBlock(typ uint32) {
+	if w.err != nil {
+		return
+	}
+	tl := uint32(12 + len(w.b))
+	blk := make([]byte, 0, tl)
+	blk = le32(blk, typ)
+	blk = le32(blk, tl)
+	blk = append(blk, w.b...)
+	blk = le32(blk, tl)
+	_, w.err = w.w.Write(blk)
+}
+
+func le16(b []byte, v uint16) []byte {
+	return append(b, byte(v), byte(v>>8))
+}
+
+func le32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func option(b []byte, code uint16, v []byte) []byte {
+	b = le16(b, code)
+	b = le16(b, uint16(len(v)))
+	b = append(b, v...)
+	return pad(b)
+}
+
+// Record writes the data of a sniffer event to the capture, so that
+// a Writer can be used as a sniff.Recorder. Data forwarded from port
+// A to port B are recorded as outbound, and from B to A as inbound.
+// Modem events are ignored.
+func (w *Writer) Record(e *sniff.Event) error {
+	if e.Kind != sniff.KindData {
+		return nil
+	}
+	dir := Outbound
+	if e.Dir == sniff.BtoA {
+		dir = Inbound
+	}
+	return w.WriteData(e.Time, dir, e.Data)
+}
+
+// Tracer returns a serial.Tracer that writes the data read from, and
+// written to, a serial.Port to the capture (as inbound and outbound
+// packets respectively). Other traced operations are ignored. Since
+// tracers cannot return errors, write errors are reported by the
+// next call to WriteData, Flush, or Close.
+func (w *Writer) Tracer() serial.Tracer {
+	return serial.TracerFunc(func(e *serial.TraceEvent) {
+		switch e.Op {
+		case serial.TraceRead:
+			if e.N > 0 {
+				w.WriteData(e.Time, Inbound, e.Data)
+			}
+		case serial.TraceWrite:
+			if e.N > 0 {
+				w.WriteData(e.Time, Outbound, e.Data)
+			}
+		}
+	})
+}
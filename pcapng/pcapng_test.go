@@ -0,0 +1,239 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package pcapng
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/sniff"
+)
+
+type packet struct {
+	t    time.Time
+	dir  Dir
+	data string
+}
+
+// parse parses a pcapng capture, as written by Writer, and returns
+// the link-type, the interface name, and the packets it contains.
+func parse(t *testing.T, b []byte) (lt int, ifname string, pkts []packet) {
+	le := binary.LittleEndian
+	opts := func(b []byte, f func(code int, v []byte)) {
+		for len(b) >= 4 {
+			code, l := int(le.Uint16(b)), int(le.Uint16(b[2:]))
+			if code == optEnd {
+				return
+			}
+			f(code, b[4:4+l])
+			b = b[4+(l+3)&^3:]
+		}
+	}
+	nblk := 0
+	for len(b) > 0 {
+		if len(b) < 12 {
+			t.Fatalf("Short block")
+		}
+		typ, tl := le.Uint32(b), le.Uint32(b[4:])
+		if tl%4 != 0 || int(tl) > len(b) || le.Uint32(b[tl-4:]) != tl {
+			t.Fatalf("Bad block length: %d", tl)
+		}
+		body := b[8 : tl-4]
+		switch {
+		case nblk == 0:
+			if typ != blkSHB || le.Uint32(body) != byteOrderMagic ||
+				le.Uint16(body[4:]) != 1 {
+				t.Fatalf("Bad section header")
+			}
+		case nblk == 1:
+			if typ != blkIDB {
+				t.Fatalf("Bad interface description")
+			}
+			lt = int(le.Uint16(body))
+			opts(body[8:], func(code int, v []byte) {
+				switch code {
+				case optIfName:
+					ifname = string(v)
+				case optIfTsresol:
+					if v[0] != 6 {
+						t.Fatalf("Bad tsresol: %d", v[0])
+					}
+				}
+			})
+		default:
+			if typ != blkEPB || le.Uint32(body) != 0 {
+				t.Fatalf("Bad packet block")
+			}
+			ts := uint64(le.Uint32(body[4:]))<<32 |
+				uint64(le.Uint32(body[8:]))
+			cl := int(le.Uint32(body[12:]))
+			if ol := int(le.Uint32(body[16:])); ol != cl {
+				t.Fatalf("Bad lengths: %d, %d", cl, ol)
+			}
+			p := packet{
+				t:    time.Unix(0, int64(ts)*1000),
+				data: string(body[20 : 20+cl]),
+			}
+			opts(body[20+(cl+3)&^3:], func(code int, v []byte) {
+				if code == optEpbFlags {
+					p.dir = Dir(le.Uint32(v) & 3)
+				}
+			})
+			pkts = append(pkts, p)
+		}
+		nblk++
+		b = b[tl:]
+	}
+	return lt, ifname, pkts
+}
+
+func checkPackets(t *testing.T, got, exp []packet) {
+	if len(got) != len(exp) {
+		t.Fatalf("Got %d packets, expected %d: %+v",
+			len(got), len(exp), got)
+	}
+	for i := range exp {
+		if !got[i].t.Equal(exp[i].t) || got[i].dir != exp[i].dir ||
+			got[i].data != exp[i].data {
+			t.Fatalf("Packet %d: got %+v, expected %+v",
+				i, got[i], exp[i])
+		}
+	}
+}
+
+var t0 = time.Date(2015, 6, 1, 10, 20, 30, 123456000, time.UTC)
+
+func ms(n int) time.Time { return t0.Add(time.Duration(n) * time.Millisecond) }
+
+func TestChunks(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.IfName = "/dev/ttyS0"
+	w.LinkType = LinkTypeUser0 + 2
+	w.WriteData(ms(0), Outbound, []byte("hello"))
+	w.WriteData(ms(1), Inbound, []byte("world!"))
+	w.WriteData(ms(2), DirUnknown, []byte("x"))
+	if err := w.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	lt, ifname, pkts := parse(t, buf.Bytes())
+	if lt != LinkTypeUser0+2 || ifname != "/dev/ttyS0" {
+		t.Fatalf("Interface: %d, %q", lt, ifname)
+	}
+	checkPackets(t, pkts, []packet{
+		{ms(0), Outbound, "hello"},
+		{ms(1), Inbound, "world!"},
+		{ms(2), DirUnknown, "x"},
+	})
+}
+
+func TestIdleGap(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.IdleGap = 5 * time.Millisecond
+	w.WriteData(ms(0), Outbound, []byte("req"))
+	w.WriteData(ms(2), Outbound, []byte("uest"))
+	w.WriteData(ms(10), Inbound, []byte("res"))
+	w.WriteData(ms(12), Inbound, []byte("ponse"))
+	w.WriteData(ms(13), Outbound, []byte("more"))
+	w.WriteData(ms(30), Outbound, []byte("again"))
+	w.Flush()
+	_, _, pkts := parse(t, buf.Bytes())
+	checkPackets(t, pkts, []packet{
+		{ms(0), Outbound, "request"},
+		{ms(10), Inbound, "response"},
+		{ms(13), Outbound, "more"},
+		{ms(30), Outbound, "again"},
+	})
+}
+
+func TestSplit(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Split = bufio.ScanLines
+	w.SnapLen = 8
+	w.WriteData(ms(0), Inbound, []byte("AT\r"))
+	w.WriteData(ms(1), Inbound, []byte("\nOK\r\nER"))
+	w.WriteData(ms(2), Inbound, []byte("ROR\r\n0123456789"))
+	w.Close()
+	_, _, pkts := parse(t, buf.Bytes())
+	checkPackets(t, pkts, []packet{
+		{ms(0), Inbound, "AT\r\n"},
+		{ms(1), Inbound, "OK\r\n"},
+		{ms(1), Inbound, "ERROR\r\n"},
+		{ms(2), Inbound, "01234567"},
+		{ms(2), Inbound, "89"},
+	})
+}
+
+func TestSplitAdvance(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	// Claims to consume more than it is given
+	w.Split = func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < 3 {
+			return 0, nil, nil
+		}
+		return len(data) + 10, nil, nil
+	}
+	w.WriteData(ms(0), Inbound, []byte("ab"))
+	w.WriteData(ms(1), Inbound, []byte("cd"))
+	if err := w.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	_, _, pkts := parse(t, buf.Bytes())
+	checkPackets(t, pkts, []packet{
+		{ms(0), Inbound, "abcd"},
+	})
+}
+
+func TestSources(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	tr := w.Tracer()
+	tr.Trace(&serial.TraceEvent{Time: ms(0), Op: serial.TraceWrite,
+		Data: []byte("ping"), N: 4})
+	tr.Trace(&serial.TraceEvent{Time: ms(1), Op: serial.TraceRead,
+		Err: serial.ErrTimeout})
+	tr.Trace(&serial.TraceEvent{Time: ms(2), Op: serial.TraceRead,
+		Data: []byte("pong"), N: 4})
+	tr.Trace(&serial.TraceEvent{Time: ms(3), Op: serial.TraceFlush})
+	w.Record(&sniff.Event{Time: ms(4), Kind: sniff.KindData,
+		Dir: sniff.AtoB, Data: []byte("a")})
+	w.Record(&sniff.Event{Time: ms(5), Kind: sniff.KindModem,
+		Dir: sniff.AtoB, Modem: serial.ModemCTS})
+	w.Record(&sniff.Event{Time: ms(6), Kind: sniff.KindData,
+		Dir: sniff.BtoA, Data: []byte("b")})
+	w.Close()
+	_, _, pkts := parse(t, buf.Bytes())
+	checkPackets(t, pkts, []packet{
+		{ms(0), Outbound, "ping"},
+		{ms(2), Inbound, "pong"},
+		{ms(4), Outbound, "a"},
+		{ms(6), Inbound, "b"},
+	})
+}
+
+type failWriter struct{}
+
+var errFail = errors.New("write failed")
+
+func (failWriter) Write(b []byte) (int, error) { return 0, errFail }
+
+func TestError(t *testing.T) {
+	w := NewWriter(failWriter{})
+	if err := w.WriteData(ms(0), Inbound, []byte("x")); err != errFail {
+		t.Fatalf("WriteData: %v != %v", err, errFail)
+	}
+	if err := w.Close(); err != errFail {
+		t.Fatalf("Close: %v != %v", err, errFail)
+	}
+}
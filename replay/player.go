@@ -0,0 +1,392 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package replay
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/deadline"
+)
+
+func init() {
+	serial.Register("replay", open)
+}
+
+// open opens a replay transport for URLs of the form:
+// "replay://<path>[?scale=<factor>]"
+func open(name string) (serial.Transport, error) {
+	_, addr, _ := serial.SplitURL(name)
+	path, query := addr, ""
+	if i := strings.IndexByte(addr, '?'); i >= 0 {
+		path, query = addr[:i], addr[i+1:]
+	}
+	q, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	scale := 1.0
+	if s := q.Get("scale"); s != "" {
+		scale, err = strconv.ParseFloat(s, 64)
+		if err != nil || scale < 0 {
+			return nil, fmt.Errorf("replay: invalid scale: %s", s)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p, err := NewPlayer(f)
+	if err != nil {
+		return nil, err
+	}
+	p.Scale = scale
+	return p, nil
+}
+
+// Divergence is the error returned when the output of the program
+// differs from the recorded output.
+type Divergence struct {
+	Offset   int64     // Output-stream offset of the first differing byte
+	Time     time.Time // Recorded time of the write expected at Offset
+	Expected []byte    // Recorded output at Offset (up to 16 bytes)
+	Got      []byte    // Program output at Offset (up to 16 bytes)
+}
+
+func (d *Divergence) Error() string {
+	exp, got := "end of output", "end of output"
+	if len(d.Expected) > 0 {
+		exp = hexString(d.Expected)
+	}
+	if len(d.Got) > 0 {
+		got = hexString(d.Got)
+	}
+	return fmt.Sprintf("replay: output diverges at offset %d "+
+		"(recorded at %s): expected %s, got %s",
+		d.Offset, d.Time.Format(timeFormat), exp, got)
+}
+
+// divContext is the max number of bytes in Divergence.Expected and
+// Divergence.Got
+const divContext = 16
+
+// input is recorded input data (a read operation)
+type input struct {
+	t    time.Time
+	data []byte
+	wend int64 // Output that must be written before serving it
+	wi   int   // Index of last write before it (-1 if none)
+}
+
+// Player replays a recorded session. It implements the
+// serial.Transport interface; wrap it with serial.NewPort to use it
+// as a serial.Port. Field Scale must be set (if required) before the
+// first operation. The timing of replay starts with the first Read or
+// Write.
+type Player struct {
+	// Multiplies the recorded delays. 1 replays with the original
+	// timing, 0.5 twice as fast, 0 as fast as possible.
+	Scale float64
+
+	t0     time.Time   // Time of first logged operation
+	in     []input     // Recorded input
+	out    []byte      // Recorded output stream
+	wends  []int64     // End offsets of writes in out
+	wtimes []time.Time // Recorded times of writes
+	conf   serial.Conf
+
+	mu     sync.Mutex
+	start  time.Time   // Start of replay
+	ri     int         // Next input to serve
+	roff   int         // Offset in in[ri].data
+	woff   int64       // Output written so far
+	wdone  []time.Time // Replay times of writes completed
+	div    *Divergence
+	modem  serial.ModemLines
+	notify chan struct{} // Closed (and replaced) on output, and on Close
+	closed bool
+	rdl    *deadline.Deadline
+}
+
+// NewPlayer reads a session log from r, and returns a Player that
+// replays it. Nominal timing (Scale) is 1.
+func NewPlayer(r io.Reader) (*Player, error) {
+	evs, err := readLog(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Player{
+		Scale:  1,
+		notify: make(chan struct{}),
+		rdl:    deadline.New(),
+	}
+	confSeen := false
+	for i, e := range evs {
+		if i == 0 {
+			p.t0 = e.t
+		}
+		switch e.op {
+		case "read":
+			p.in = append(p.in, input{t: e.t, data: e.data,
+				wend: int64(len(p.out)), wi: len(p.wends) - 1})
+		case "write":
+			p.out = append(p.out, e.data...)
+			p.wends = append(p.wends, int64(len(p.out)))
+			p.wtimes = append(p.wtimes, e.t)
+		case "conf":
+			if !confSeen {
+				p.conf = e.conf
+				confSeen = true
+			}
+		}
+	}
+	return p, nil
+}
+
+// begin starts the replay clock, if not already started. Must be
+// called with p.mu held.
+func (p *Player) begin() {
+	if p.start.IsZero() {
+		p.start = time.Now()
+	}
+}
+
+// avail returns the replay time at which input in becomes available,
+// or false if the program has not yet written the output that
+// precedes it. Must be called with p.mu held.
+func (p *Player) avail(in *input) (time.Time, bool) {
+	if p.woff < in.wend {
+		return time.Time{}, false
+	}
+	ref, rt := p.start, p.t0
+	if in.wi >= 0 {
+		ref, rt = p.wdone[in.wi], p.wtimes[in.wi]
+	}
+	d := time.Duration(float64(in.t.Sub(rt)) * p.Scale)
+	return ref.Add(d), true
+}
+
+// Read serves the recorded input, as described in the package
+// documentation.
+func (p *Player) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return 0, serial.ErrClosed
+		}
+		if p.ri >= len(p.in) {
+			p.mu.Unlock()
+			return 0, serial.ErrEOF
+		}
+		p.begin()
+		in := &p.in[p.ri]
+		at, ok := p.avail(in)
+		var wait time.Duration
+		if ok {
+			wait = at.Sub(time.Now())
+			if wait <= 0 {
+				n = copy(b, in.data[p.roff:])
+				p.roff += n
+				if p.roff == len(in.data) {
+					p.ri, p.roff = p.ri+1, 0
+				}
+				p.mu.Unlock()
+				return n, nil
+			}
+		}
+		notify := p.notify
+		p.mu.Unlock()
+
+		var timer *time.Timer
+		var tc <-chan time.Time
+		if ok {
+			timer = time.NewTimer(wait)
+			tc = timer.C
+		}
+		select {
+		case <-tc:
+		case <-notify:
+		case <-p.rdl.Wait():
+			err = serial.ErrTimeout
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write compares b with the recorded output. If they differ, it
+// returns the number of matching bytes and a *Divergence error. Once
+// output has diverged, every Write returns the same error.
+func (p *Player) Write(b []byte) (n int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, serial.ErrClosed
+	}
+	if p.div != nil {
+		return 0, p.div
+	}
+	p.begin()
+	exp := p.out[p.woff:]
+	for n < len(b) && n < len(exp) && b[n] == exp[n] {
+		n++
+	}
+	if n > 0 {
+		p.woff += int64(n)
+		now := time.Now()
+		for len(p.wdone) < len(p.wends) &&
+			p.wends[len(p.wdone)] <= p.woff {
+			p.wdone = append(p.wdone, now)
+		}
+		p.wakeup()
+	}
+	if n < len(b) {
+		p.div = p.divergence(b[n:])
+		return n, p.div
+	}
+	return n, nil
+}
+
+// divergence returns a Divergence for output got, written at the
+// current output offset. Must be called with p.mu held.
+func (p *Player) divergence(got []byte) *Divergence {
+	d := &Divergence{Offset: p.woff}
+	exp := p.out[p.woff:]
+	if len(exp) > divContext {
+		exp = exp[:divContext]
+	}
+	if len(got) > divContext {
+		got = got[:divContext]
+	}
+	d.Expected = append([]byte(nil), exp...)
+	d.Got = append([]byte(nil), got...)
+	if i := len(p.wdone); i < len(p.wtimes) {
+		d.Time = p.wtimes[i]
+	} else if len(p.wtimes) > 0 {
+		d.Time = p.wtimes[len(p.wtimes)-1]
+	}
+	return d
+}
+
+// wakeup wakes up blocked Reads. Must be called with p.mu held.
+func (p *Player) wakeup() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// Err returns the divergence detected so far, if any. If the program
+// has not written all the recorded output (yet), Err returns a
+// *Divergence error describing the missing output.
+func (p *Player) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.div != nil {
+		return p.div
+	}
+	if p.woff < int64(len(p.out)) {
+		return p.divergence(nil)
+	}
+	return nil
+}
+
+// Close stops the replay, and returns the same as Err. Blocked Read
+// calls return serial.ErrClosed.
+func (p *Player) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return serial.ErrClosed
+	}
+	p.closed = true
+	p.wakeup()
+	p.mu.Unlock()
+	return p.Err()
+}
+
+// SetReadDeadline sets the deadline for Read operations
+func (p *Player) SetReadDeadline(t time.Time) error {
+	p.rdl.Set(t)
+	return nil
+}
+
+// SetWriteDeadline is a no-op; Write never blocks.
+func (p *Player) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// GetConf returns the port configuration. Initially this is the
+// configuration at the start of the recording (if logged).
+func (p *Player) GetConf() (serial.Conf, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conf, nil
+}
+
+// ConfSome changes the port configuration (as returned by GetConf).
+// The configuration is not verified against the recording.
+func (p *Player) ConfSome(conf serial.Conf, flags serial.ConfFlags) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if flags&serial.ConfBaudrate != 0 {
+		p.conf.Baudrate = conf.Baudrate
+	}
+	if flags&serial.ConfDatabits != 0 {
+		p.conf.Databits = conf.Databits
+	}
+	if flags&serial.ConfParity != 0 {
+		p.conf.Parity = conf.Parity
+	}
+	if flags&serial.ConfStopbits != 0 {
+		p.conf.Stopbits = conf.Stopbits
+	}
+	if flags&serial.ConfFlow != 0 {
+		p.conf.Flow = conf.Flow
+	}
+	if flags&serial.ConfNoReset != 0 {
+		p.conf.NoReset = conf.NoReset
+	}
+	return nil
+}
+
+// Flush, FlushIn, FlushOut, and SendBreak are no-ops.
+
+func (p *Player) Flush() error     { return nil }
+func (p *Player) FlushIn() error   { return nil }
+func (p *Player) FlushOut() error  { return nil }
+func (p *Player) SendBreak() error { return nil }
+
+// GetModem returns the output modem lines, as last set by SetModem.
+// Input lines are never asserted.
+func (p *Player) GetModem() (serial.ModemLines, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modem, nil
+}
+
+// SetModem sets the output modem lines (as returned by GetModem)
+func (p *Player) SetModem(lines, mask serial.ModemLines) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	mask &= serial.ModemDTR | serial.ModemRTS
+	p.modem = p.modem&^mask | lines&mask
+	return nil
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package replay records serial-port sessions, and replays them
+// offline. A Recorder saves a timestamped log of everything read
+// from and written to a serial.Port (plus configuration changes and
+// control operations). A Player implements the serial.Transport
+// interface by replaying such a log: it serves the recorded input
+// data, with the original (or scaled) timing, and verifies that the
+// program writes the same data that were recorded, reporting the
+// first divergence.
+//
+// To record a session:
+//
+//   rec, err := replay.Record(port, logFile)
+//   ...
+//   err = rec.Stop()
+//
+// To replay it, open the log as a port:
+//
+//   port, err := serial.Open("replay:///path/to/log")
+//
+// or, to get access to the Player:
+//
+//   pl, err := replay.NewPlayer(logFile)
+//   port := serial.NewPort("replay", pl)
+//
+// The "replay" URL scheme is registered when package replay is
+// imported. Query parameter "scale" sets Player.Scale (e.g.
+// "replay:///path/to/log?scale=0").
+//
+// Replay
+//
+// Input data recorded after an output (write) operation are served
+// only after the program writes the respective output, and no sooner
+// than the recorded delay between the two (multiplied by
+// Player.Scale). Output data are compared, as a byte stream, with the
+// recorded output; the way they are split into Write calls does not
+// matter. On the first difference, Write returns a *Divergence
+// error. After all recorded input is served, Read returns
+// serial.ErrEOF. Close returns a *Divergence error if the program has
+// not written all the recorded output.
+//
+// Log format
+//
+// Logs are text files with one operation per line. Each line has the
+// form:
+//
+//   <time> <op> [<args>]
+//
+// where <time> is in RFC 3339 form with microsecond resolution, and
+// <op> is one of:
+//
+//   read <hex bytes>        Data read
+//   write <hex bytes>       Data written
+//   conf <conf>             Configuration (in serial.ParseConf form)
+//   flush, flushin, flushout, break, close
+//   setmodem <lines>/<mask> Modem lines set
+//
+// Lines starting with '#' are comments. The first conf line (if any)
+// has the port's configuration when recording started. For example:
+//
+//   # replay log
+//   2015-06-01T10:20:30.000000Z conf 9600,8N1,none
+//   2015-06-01T10:20:30.001234Z write 01 03 00 00 00 0a c5 cd
+//   2015-06-01T10:20:30.025000Z read 01 03 14 00 00
+package replay
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// timeFormat is the format of log timestamps
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+const logHeader = "# replay log\n"
+
+// ErrFormat is returned by NewPlayer for malformed logs
+var ErrFormat = errors.New("replay: malformed log")
+
+// Recorder records the operations performed on a serial.Port to a
+// log. It is a serial.Tracer.
+type Recorder struct {
+	port *serial.Port
+
+	mu  sync.Mutex
+	w   *bufio.Writer
+	err error
+}
+
+// Record starts recording the operations performed on port to w. It
+// writes the port's current configuration to the log, and installs
+// the Recorder as the port's tracer (replacing any other tracer).
+func Record(port *serial.Port, w io.Writer) (*Recorder, error) {
+	r := &Recorder{port: port, w: bufio.NewWriter(w)}
+	r.w.WriteString(logHeader)
+	if c, err := port.GetConf(); err == nil {
+		r.line(time.Now(), "conf", confString(c, serial.ConfAll))
+	}
+	if err := r.w.Flush(); err != nil {
+		return nil, err
+	}
+	port.SetTracer(r)
+	return r, nil
+}
+
+// Trace records operation e. Write errors are reported by Stop.
+func (r *Recorder) Trace(e *serial.TraceEvent) {
+	op := e.Op.String()
+	var arg string
+	switch e.Op {
+	case serial.TraceRead, serial.TraceWrite:
+		if e.N == 0 {
+			return
+		}
+		arg = hexString(e.Data)
+	case serial.TraceConf:
+		if e.Err != nil {
+			return
+		}
+		c, flags := e.Conf, e.Flags
+		if fl := flags & serial.ConfFormat; fl != 0 &&
+			fl != serial.ConfFormat {
+			// Log the whole format, as configured, since
+			// ParseConf accepts no partial format.
+			if pc, err := r.port.GetConf(); err == nil {
+				c.Databits = pc.Databits
+				c.Parity = pc.Parity
+				c.Stopbits = pc.Stopbits
+				flags |= serial.ConfFormat
+			}
+		}
+		arg = confString(c, flags)
+	case serial.TraceSetModem:
+		arg = e.Lines.String() + "/" + e.Mask.String()
+	}
+	r.mu.Lock()
+	r.line(e.Time, op, arg)
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	r.mu.Unlock()
+}
+
+func (r *Recorder) line(t time.Time, op, arg string) {
+	r.w.WriteString(t.Format(timeFormat))
+	r.w.WriteByte(' ')
+	r.w.WriteString(op)
+	if arg != "" {
+		r.w.WriteByte(' ')
+		r.w.WriteString(arg)
+	}
+	r.w.WriteByte('\n')
+}
+
+// Stop stops recording (removes the port's tracer). It returns the
+// first error encountered writing the log, if any.
+func (r *Recorder) Stop() error {
+	r.port.SetTracer(nil)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err == nil {
+		r.err = r.w.Flush()
+	}
+	return r.err
+}
+
+func hexString(b []byte) string {
+	s := make([]byte, 0, len(b)*3)
+	for i, c := range b {
+		if i > 0 {
+			s = append(s, ' ')
+		}
+		s = append(s, "0123456789abcdef"[c>>4], "0123456789abcdef"[c&0xf])
+	}
+	return string(s)
+}
+
+// confString formats the parameters in c selected by flags, in the
+// form accepted by serial.ParseConf. serial.FlowOther cannot be
+// represented in this form, and is omitted.
+func confString(c serial.Conf, flags serial.ConfFlags) string {
+	if c.Flow == serial.FlowOther {
+		flags &^= serial.ConfFlow
+	}
+	return c.StringSome(flags)
+}
+
+// event is a log entry
+type event struct {
+	t     time.Time
+	op    string
+	data  []byte           // read, write
+	conf  serial.Conf      // conf
+	flags serial.ConfFlags // conf
+}
+
+// readLog reads and parses a log
+func readLog(r io.Reader) ([]event, error) {
+	var evs []event
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		e, ok := parseEvent(l)
+		if !ok {
+			return nil, fmt.Errorf("%v: line %d", ErrFormat, n)
+		}
+		evs = append(evs, e)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return evs, nil
+}
+
+func parseEvent(l string) (e event, ok bool) {
+	f := strings.SplitN(l, " ", 3)
+	if len(f) < 2 {
+		return e, false
+	}
+	var err error
+	if e.t, err = time.Parse(time.RFC3339Nano, f[0]); err != nil {
+		return e, false
+	}
+	e.op = f[1]
+	arg := ""
+	if len(f) == 3 {
+		arg = f[2]
+	}
+	switch e.op {
+	case "read", "write":
+		e.data, err = hex.DecodeString(strings.Replace(arg, " ", "", -1))
+		if err != nil || len(e.data) == 0 {
+			return e, false
+		}
+	case "conf":
+		if arg == "" {
+			// Nothing representable was configured
+			return e, true
+		}
+		e.conf, e.flags, err = serial.ParseConf(arg)
+		if err != nil {
+			return e, false
+		}
+	}
+	return e, true
+}
@@ -0,0 +1,265 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package replay
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+const testLog = `# replay log
+2015-06-01T10:20:30.000000Z conf 9600,8N1,none
+2015-06-01T10:20:30.010000Z write 41 54 0d
+2015-06-01T10:20:30.060000Z read 0d 0a 4f 4b
+2015-06-01T10:20:30.070000Z read 0d 0a
+2015-06-01T10:20:30.080000Z conf 115200
+2015-06-01T10:20:30.090000Z write 41 54 49 0d
+2015-06-01T10:20:30.100000Z read 78
+`
+
+func newPlayer(t *testing.T, log string, scale float64) *Player {
+	p, err := NewPlayer(strings.NewReader(log))
+	if err != nil {
+		t.Fatal("NewPlayer:", err)
+	}
+	p.Scale = scale
+	return p
+}
+
+func readAll(t *testing.T, p io.Reader, n int) string {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(p, b); err != nil {
+		t.Fatal("Read:", err)
+	}
+	return string(b)
+}
+
+func TestReplay(t *testing.T) {
+	p := newPlayer(t, testLog, 1)
+	port := serial.NewPort("replay", p)
+	if c, _ := port.GetConf(); c.Baudrate != 9600 || c.Databits != 8 {
+		t.Fatalf("Initial conf: %v", c)
+	}
+
+	// Input is not served before the output preceding it
+	port.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := port.Read(make([]byte, 1)); err != serial.ErrTimeout {
+		t.Fatalf("Read before write: %v", err)
+	}
+	port.SetReadDeadline(time.Time{})
+
+	port.Write([]byte("A"))
+	if _, err := port.Write([]byte("T\r")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	start := time.Now()
+	if s := readAll(t, port, 6); s != "\r\nOK\r\n" {
+		t.Fatalf("Read: %q", s)
+	}
+	if d := time.Since(start); d < 55*time.Millisecond {
+		t.Fatalf("Input served too early: %v", d)
+	}
+
+	port.ConfSome(serial.Conf{Baudrate: 115200}, serial.ConfBaudrate)
+	if c, _ := port.GetConf(); c.Baudrate != 115200 || c.Databits != 8 {
+		t.Fatalf("Conf: %v", c)
+	}
+	if _, err := port.Write([]byte("ATI\r")); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if s := readAll(t, port, 1); s != "x" {
+		t.Fatalf("Read: %q", s)
+	}
+	if _, err := port.Read(make([]byte, 1)); err != serial.ErrEOF {
+		t.Fatalf("Read at end: %v", err)
+	}
+	if err := port.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+}
+
+func TestScale(t *testing.T) {
+	p := newPlayer(t, testLog, 0)
+	p.Write([]byte("AT\r"))
+	start := time.Now()
+	readAll(t, p, 6)
+	if d := time.Since(start); d > 20*time.Millisecond {
+		t.Fatalf("Scale 0 delays input: %v", d)
+	}
+	p.Close()
+}
+
+func TestDivergence(t *testing.T) {
+	p := newPlayer(t, testLog, 0)
+	p.Write([]byte("AT\r"))
+	readAll(t, p, 6)
+	n, err := p.Write([]byte("ATZ\r"))
+	d, ok := err.(*Divergence)
+	if n != 2 || !ok {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	exp := &Divergence{
+		Offset:   5,
+		Time:     time.Date(2015, 6, 1, 10, 20, 30, 90e6, time.UTC),
+		Expected: []byte("I\r"),
+		Got:      []byte("Z\r"),
+	}
+	if d.Offset != exp.Offset || !d.Time.Equal(exp.Time) ||
+		!bytes.Equal(d.Expected, exp.Expected) ||
+		!bytes.Equal(d.Got, exp.Got) {
+		t.Fatalf("Divergence: %+v", d)
+	}
+	if _, err := p.Write([]byte("I\r")); err != d {
+		t.Fatalf("Write after divergence: %v", err)
+	}
+	if err := p.Close(); err != d {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Missing output
+	p = newPlayer(t, testLog, 0)
+	p.Write([]byte("AT"))
+	err = p.Close()
+	if d, ok := err.(*Divergence); !ok || d.Offset != 2 ||
+		string(d.Expected) != "\rATI\r" || d.Got != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if !strings.Contains(err.Error(),
+		"expected 0d 41 54 49 0d, got end of output") {
+		t.Fatalf("Error: %v", err)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	var log bytes.Buffer
+	port := serial.NewPort("replay", newPlayer(t, testLog, 0))
+	rec, err := Record(port, &log)
+	if err != nil {
+		t.Fatal("Record:", err)
+	}
+	port.Write([]byte("AT\r"))
+	readAll(t, port, 6)
+	port.ConfSome(serial.Conf{Baudrate: 115200}, serial.ConfBaudrate)
+	port.ConfSome(serial.Conf{Databits: 7}, serial.ConfDatabits)
+	port.SetModem(serial.ModemDTR, serial.ModemDTR)
+	port.Write([]byte("ATI\r"))
+	readAll(t, port, 1)
+	port.Close()
+	if err := rec.Stop(); err != nil {
+		t.Fatal("Stop:", err)
+	}
+
+	var ops []string
+	for _, l := range strings.Split(log.String(), "\n") {
+		if f := strings.SplitN(l, " ", 2); len(f) == 2 && l[0] != '#' {
+			ops = append(ops, f[1])
+		}
+	}
+	exp := []string{
+		"conf 9600,8N1,none,reset",
+		"write 41 54 0d",
+		"read 0d 0a 4f 4b",
+		"read 0d 0a",
+		"conf 115200",
+		"conf 7N1",
+		"setmodem DTR/DTR",
+		"write 41 54 49 0d",
+		"read 78",
+		"close",
+	}
+	if strings.Join(ops, "\n") != strings.Join(exp, "\n") {
+		t.Fatalf("Log:\n%s", log.String())
+	}
+
+	// The recording replays
+	p, err := NewPlayer(&log)
+	if err != nil {
+		t.Fatal("NewPlayer:", err)
+	}
+	p.Scale = 0
+	p.Write([]byte("AT\rATI\r"))
+	if s := readAll(t, p, 7); s != "\r\nOK\r\nx" {
+		t.Fatalf("Read: %q", s)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+}
+
+func TestConfSome(t *testing.T) {
+	p := newPlayer(t, "2015-06-01T10:20:30Z conf 9600,8E2,none\n", 0)
+	all := serial.ConfAll &^ serial.ConfNoReset
+	for _, x := range []struct {
+		conf  serial.Conf
+		flags serial.ConfFlags
+		s     string
+	}{
+		{serial.Conf{Databits: 7}, serial.ConfDatabits, "9600,7E2,none"},
+		{serial.Conf{Parity: serial.ParityOdd}, serial.ConfParity,
+			"9600,7O2,none"},
+		{serial.Conf{Stopbits: 1}, serial.ConfStopbits, "9600,7O1,none"},
+		{serial.Conf{Baudrate: 115200, Flow: serial.FlowRTSCTS},
+			serial.ConfBaudrate | serial.ConfFlow, "115200,7O1,rtscts"},
+		{serial.Conf{Databits: 8, Stopbits: 1}, serial.ConfFormat,
+			"115200,8N1,rtscts"},
+	} {
+		if err := p.ConfSome(x.conf, x.flags); err != nil {
+			t.Fatal("ConfSome:", err)
+		}
+		c, _ := p.GetConf()
+		if s := c.StringSome(all); s != x.s {
+			t.Fatalf("ConfSome %b: %q != %q", x.flags, s, x.s)
+		}
+	}
+}
+
+func TestOpen(t *testing.T) {
+	f, err := ioutil.TempFile("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(testLog)
+	f.Close()
+
+	port, err := serial.Open("replay://" + f.Name() + "?scale=0")
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	port.Write([]byte("AT\r"))
+	if s := readAll(t, port, 4); s != "\r\nOK" {
+		t.Fatalf("Read: %q", s)
+	}
+	port.Close()
+
+	_, err = serial.Open("replay://" + f.Name() + "?scale=x")
+	if err == nil {
+		t.Fatal("Open succeeded with bad scale")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	for _, l := range []string{
+		"2015-06-01T10:20:30Z",
+		"yesterday write 41",
+		"2015-06-01T10:20:30Z write",
+		"2015-06-01T10:20:30Z read 4x",
+		"2015-06-01T10:20:30Z conf 9600,9X1",
+	} {
+		_, err := NewPlayer(strings.NewReader("# c\n\n" + l + "\n"))
+		if err == nil || !strings.HasPrefix(err.Error(),
+			ErrFormat.Error()+": line 3") {
+			t.Fatalf("%q: %v", l, err)
+		}
+	}
+}
@@ -0,0 +1,841 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package rfc2217
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/deadline"
+)
+
+// Errors returned by Client functions and methods, in addition to
+// the errors defined by package serial (serial.ErrClosed,
+// serial.ErrTimeout, etc.)
+var (
+	// ErrRefused is returned by Dial and NewClient if the server
+	// refuses to enable the COM-PORT-OPTION.
+	ErrRefused = errors.New("rfc2217: server refused COM-PORT-OPTION")
+	// ErrNoResponse is returned by configuration methods if the
+	// server does not respond to a request in time.
+	ErrNoResponse = errors.New("rfc2217: no response from server")
+)
+
+// Client implements serial.Transport
+var _ serial.Transport = (*Client)(nil)
+
+func init() {
+	serial.Register("rfc2217", func(name string) (serial.Transport, error) {
+		_, addr, _ := serial.SplitURL(name)
+		return Dial(addr)
+	})
+}
+
+// ReplyTimeout is the time the client waits for the server to
+// respond to option negotiation and configuration requests.
+var ReplyTimeout = 5 * time.Second
+
+// rxBufMax is the maximum number of received data bytes buffered by
+// the client. When the buffer fills, the client stops reading from
+// the connection until the user reads some data.
+const rxBufMax = 64 * 1024
+
+// Client is a connection to a serial port accessed through an RFC
+// 2217 server. Its API mirrors the API of serial.Port. In addition
+// it provides methods for controlling the modem-control lines, and
+// for receiving modem-state and line-state notifications from the
+// server. It is safe to call Client methods concurrently.
+type Client struct {
+	conn net.Conn
+
+	wmu sync.Mutex // Serializes writes to conn
+	cmu sync.Mutex // Serializes configuration requests
+
+	mu         sync.Mutex
+	opts       options
+	noReset    bool
+	modem      ModemState
+	line       LineState
+	modemCh    []chan<- ModemState
+	lineCh     []chan<- LineState
+	suspended  chan struct{} // Non-nil while suspended by server
+	negotiated chan struct{} // Closed when COM-PORT-OPTION resolved
+	negDone    bool
+	sigPending bool   // Our signature request is pending
+	rbuf       []byte // Received data not yet read
+	rerr       error  // Sticky receive error
+
+	rready  chan struct{} // Signaled when rbuf or rerr changes
+	rspace  chan struct{} // Signaled when rbuf is drained
+	replies chan []byte   // Server responses to requests
+	rdone   chan struct{} // Closed when receiver exits
+
+	rdl, wdl  *deadline.Deadline
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial connects to the RFC 2217 server at address addr (in the form
+// "host:port") and negotiates the use of the COM-PORT-OPTION.
+func Dial(addr string) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, ReplyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return NewClient(conn)
+}
+
+// NewClient returns a Client that uses conn to talk to an RFC 2217
+// server. It negotiates the use of the COM-PORT-OPTION and the other
+// Telnet options required for transparent operation (BINARY,
+// SUPPRESS-GO-AHEAD). If the negotiation fails, conn is closed and an
+// error is returned.
+func NewClient(conn net.Conn) (*Client, error) {
+	c := &Client{
+		conn:       conn,
+		negotiated: make(chan struct{}),
+		rready:     make(chan struct{}, 1),
+		rspace:     make(chan struct{}, 1),
+		replies:    make(chan []byte, 16),
+		rdone:      make(chan struct{}),
+		rdl:        deadline.New(),
+		wdl:        deadline.New(),
+		closed:     make(chan struct{}),
+	}
+	c.mu.Lock()
+	req := c.opts.request(cmdWILL, optComPort, optBinary, optSGA)
+	req = append(req, c.opts.request(cmdDO, optBinary, optSGA)...)
+	c.mu.Unlock()
+
+	go c.receiver()
+
+	if err := c.writeRaw(req); err != nil {
+		c.Close()
+		return nil, err
+	}
+	timer := time.NewTimer(ReplyTimeout)
+	defer timer.Stop()
+	select {
+	case <-c.negotiated:
+	case <-timer.C:
+		c.Close()
+		return nil, ErrNoResponse
+	case <-c.rdone:
+		c.Close()
+		return nil, c.receiveErr()
+	}
+	c.mu.Lock()
+	ok := c.opts.local[optComPort] == optOn
+	c.mu.Unlock()
+	if !ok {
+		c.Close()
+		return nil, ErrRefused
+	}
+	return c, nil
+}
+
+func localOpt(opt byte) bool {
+	return opt == optComPort || opt == optBinary || opt == optSGA
+}
+
+func remoteOpt(opt byte) bool {
+	return opt == optBinary || opt == optSGA || opt == optEcho
+}
+
+// command is called by the parser (from the receiver goroutine) for
+// option negotiation commands.
+func (c *Client) command(cmd, opt byte) {
+	c.mu.Lock()
+	reply := c.opts.negotiate(cmd, opt, localOpt, remoteOpt)
+	if opt == optComPort && !c.negDone &&
+		c.opts.local[optComPort] != optWant {
+		c.negDone = true
+		close(c.negotiated)
+	}
+	c.mu.Unlock()
+	if reply != nil {
+		c.writeRaw(reply)
+	}
+}
+
+// subneg is called by the parser (from the receiver goroutine) for
+// COM-PORT-OPTION subnegotiations.
+func (c *Client) subneg(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] {
+	case srvOffset + cpSignature:
+		c.mu.Lock()
+		pending := c.sigPending
+		c.mu.Unlock()
+		if len(b) == 1 && !pending {
+			// Server asks for our signature
+			c.writeRaw(subneg(cpSignature,
+				[]byte(DefaultSignature)...))
+			return
+		}
+	case srvOffset + cpNotifyModemstate:
+		if len(b) >= 2 {
+			c.mu.Lock()
+			c.modem = ModemState(b[1])
+			for _, ch := range c.modemCh {
+				select {
+				case ch <- c.modem:
+				default:
+				}
+			}
+			c.mu.Unlock()
+		}
+		return
+	case srvOffset + cpNotifyLinestate:
+		if len(b) >= 2 {
+			c.mu.Lock()
+			c.line = LineState(b[1])
+			for _, ch := range c.lineCh {
+				select {
+				case ch <- c.line:
+				default:
+				}
+			}
+			c.mu.Unlock()
+		}
+		return
+	case srvOffset + cpFlowSuspend:
+		c.mu.Lock()
+		if c.suspended == nil {
+			c.suspended = make(chan struct{})
+		}
+		c.mu.Unlock()
+		return
+	case srvOffset + cpFlowResume:
+		c.mu.Lock()
+		if c.suspended != nil {
+			close(c.suspended)
+			c.suspended = nil
+		}
+		c.mu.Unlock()
+		return
+	}
+	r := make([]byte, len(b))
+	copy(r, b)
+	select {
+	case c.replies <- r:
+	default:
+		// Nobody is waiting for so many replies. Drop it.
+	}
+}
+
+// receiver runs as a separate goroutine. It reads from the
+// connection, decodes the Telnet stream, and buffers the received
+// data.
+func (c *Client) receiver() {
+	var p parser
+	buf := make([]byte, 4096)
+	var data []byte
+	for {
+		n, err := c.conn.Read(buf)
+		if n > 0 {
+			data = p.parse(data[:0], buf[:n], c)
+			if len(data) > 0 && !c.deliver(data) {
+				err = io.ErrClosedPipe
+			}
+		}
+		if err != nil {
+			c.mu.Lock()
+			if err == io.EOF {
+				c.rerr = serial.ErrEOF
+			} else {
+				c.rerr = c.mapErr(err)
+			}
+			c.mu.Unlock()
+			signal(c.rready)
+			close(c.rdone)
+			return
+		}
+	}
+}
+
+// deliver appends data to the receive buffer, blocking while the
+// buffer is full. Returns false if the client was closed.
+func (c *Client) deliver(data []byte) bool {
+	c.mu.Lock()
+	for len(c.rbuf) >= rxBufMax {
+		c.mu.Unlock()
+		select {
+		case <-c.rspace:
+		case <-c.closed:
+			return false
+		}
+		c.mu.Lock()
+	}
+	c.rbuf = append(c.rbuf, data...)
+	c.mu.Unlock()
+	signal(c.rready)
+	return true
+}
+
+func signal(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Client) receiveErr() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.rerr
+}
+
+func (c *Client) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// mapErr maps errors returned by conn to the respective package
+// serial errors.
+func (c *Client) mapErr(err error) error {
+	if c.isClosed() {
+		return serial.ErrClosed
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return serial.ErrTimeout
+	}
+	return err
+}
+
+// writeRaw writes b (which must be already escaped) to the
+// connection.
+func (c *Client) writeRaw(b []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write(b)
+	if err != nil {
+		return c.mapErr(err)
+	}
+	return nil
+}
+
+// request sends COM-PORT-OPTION command cmd with the given
+// parameters, and waits for the server's response. It returns the
+// parameters of the response.
+func (c *Client) request(cmd byte, param ...byte) ([]byte, error) {
+	c.cmu.Lock()
+	defer c.cmu.Unlock()
+
+	// Discard stale responses to previous (timed-out) requests
+	for len(c.replies) > 0 {
+		<-c.replies
+	}
+	if err := c.writeRaw(subneg(cmd, param...)); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(ReplyTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case r := <-c.replies:
+			if r[0] == srvOffset+cmd {
+				return r[1:], nil
+			}
+		case <-timer.C:
+			return nil, ErrNoResponse
+		case <-c.closed:
+			return nil, serial.ErrClosed
+		case <-c.rdone:
+			return nil, c.receiveErr()
+		}
+	}
+}
+
+// requestByte is like request, but for commands with a single-byte
+// parameter and a single-byte response.
+func (c *Client) requestByte(cmd byte, v byte) (byte, error) {
+	r, err := c.request(cmd, v)
+	if err != nil {
+		return 0, err
+	}
+	if len(r) < 1 {
+		return 0, fmt.Errorf("rfc2217: short response to command %d",
+			cmd)
+	}
+	return r[0], nil
+}
+
+// Close closes the connection to the server. It is safe to call
+// Close concurrently with any other Client method. Close cancels
+// ongoing (blocked) Read and Write operations and makes them return
+// serial.ErrClosed.
+func (c *Client) Close() error {
+	err := serial.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// GetConf returns the serial port's configuration parameters as
+// reported by the server. Conf.NoReset has no RFC 2217 equivalent;
+// it reports the value last set with ConfSome.
+func (c *Client) GetConf() (conf serial.Conf, err error) {
+	r, err := c.request(cpSetBaudrate, u32(0)...)
+	if err != nil {
+		return conf, err
+	}
+	if len(r) < 4 {
+		return conf, errors.New("rfc2217: short baudrate response")
+	}
+	conf.Baudrate = int(getU32(r))
+
+	v, err := c.requestByte(cpSetDatasize, 0)
+	if err != nil {
+		return conf, err
+	}
+	conf.Databits = int(v)
+
+	v, err = c.requestByte(cpSetParity, parRequest)
+	if err != nil {
+		return conf, err
+	}
+	var ok bool
+	if conf.Parity, ok = parityFromRFC(v); !ok {
+		return conf, errors.New("rfc2217: cannot decode parity")
+	}
+
+	v, err = c.requestByte(cpSetStopsize, stopRequest)
+	if err != nil {
+		return conf, err
+	}
+	if conf.Stopbits, ok = stopbitsFromRFC(v); !ok {
+		return conf, errors.New("rfc2217: cannot decode stopbits")
+	}
+
+	v, err = c.requestByte(cpSetControl, ctlFlowRequest)
+	if err != nil {
+		return conf, err
+	}
+	conf.Flow = flowFromRFC(v)
+
+	c.mu.Lock()
+	conf.NoReset = c.noReset
+	c.mu.Unlock()
+	return conf, nil
+}
+
+// ConfSome configures the serial port using some of the parameters in
+// the Conf structure, based on the value of the flags argument. Each
+// parameter is sent to the server with the respective SET-xxx
+// command. If the server does not accept a value (i.e. responds with
+// a different one), an error is returned.
+func (c *Client) ConfSome(conf serial.Conf, flags serial.ConfFlags) error {
+	if flags&serial.ConfBaudrate != 0 {
+		if conf.Baudrate <= 0 {
+			return fmt.Errorf("rfc2217: invalid baudrate value: %d",
+				conf.Baudrate)
+		}
+		r, err := c.request(cpSetBaudrate, u32(uint32(conf.Baudrate))...)
+		if err != nil {
+			return err
+		}
+		if len(r) < 4 || int(getU32(r)) != conf.Baudrate {
+			return fmt.Errorf("rfc2217: baudrate %d not accepted",
+				conf.Baudrate)
+		}
+	}
+
+	if flags&serial.ConfDatabits != 0 {
+		if conf.Databits < 5 || conf.Databits > 8 {
+			return fmt.Errorf("rfc2217: invalid databits value: %d",
+				conf.Databits)
+		}
+		err := c.set(cpSetDatasize, byte(conf.Databits), "databits")
+		if err != nil {
+			return err
+		}
+	}
+
+	if flags&serial.ConfParity != 0 {
+		v, ok := parityToRFC(conf.Parity)
+		if !ok {
+			return errors.New("rfc2217: invalid parity mode: " +
+				conf.Parity.String())
+		}
+		if err := c.set(cpSetParity, v, "parity"); err != nil {
+			return err
+		}
+	}
+
+	if flags&serial.ConfStopbits != 0 {
+		v, ok := stopbitsToRFC(conf.Stopbits)
+		if !ok {
+			return fmt.Errorf("rfc2217: invalid stopbits value: %d",
+				conf.Stopbits)
+		}
+		if err := c.set(cpSetStopsize, v, "stopbits"); err != nil {
+			return err
+		}
+	}
+
+	if flags&serial.ConfFlow != 0 {
+		v, ok := flowToRFC(conf.Flow)
+		if !ok {
+			return errors.New("rfc2217: invalid flow-control mode: " +
+				conf.Flow.String())
+		}
+		if err := c.set(cpSetControl, v, "flow-control"); err != nil {
+			return err
+		}
+	}
+
+	if flags&serial.ConfNoReset != 0 {
+		c.mu.Lock()
+		c.noReset = conf.NoReset
+		c.mu.Unlock()
+	}
+
+	return nil
+}
+
+// set sends a single-byte-parameter command and verifies that the
+// server accepted the value.
+func (c *Client) set(cmd byte, v byte, what string) error {
+	r, err := c.requestByte(cmd, v)
+	if err != nil {
+		return err
+	}
+	if r != v {
+		return fmt.Errorf("rfc2217: %s value not accepted", what)
+	}
+	return nil
+}
+
+// Conf configures the serial port using the parameters in the Conf
+// structure.
+func (c *Client) Conf(conf serial.Conf) error {
+	return c.ConfSome(conf, serial.ConfAll)
+}
+
+// Read is compatible with the Read method of the io.Reader
+// interface. In addition Read honors the timeout set by
+// Client.SetDeadline and Client.SetReadDeadline. If no data are read
+// before the timeout expires Read returns with err ==
+// serial.ErrTimeout (and n == 0).
+func (c *Client) Read(b []byte) (n int, err error) {
+	for {
+		if c.isClosed() {
+			return 0, serial.ErrClosed
+		}
+		c.mu.Lock()
+		if len(c.rbuf) > 0 {
+			n = copy(b, c.rbuf)
+			c.rbuf = c.rbuf[n:]
+			if len(c.rbuf) == 0 {
+				c.rbuf = nil
+			}
+			c.mu.Unlock()
+			signal(c.rspace)
+			return n, nil
+		}
+		err = c.rerr
+		c.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-c.rready:
+		case <-c.rdl.Wait():
+			return 0, serial.ErrTimeout
+		case <-c.closed:
+			return 0, serial.ErrClosed
+		}
+	}
+}
+
+// Write is compatible with the Write method of the io.Writer
+// interface. In addition Write honors the timeout set by
+// Client.SetDeadline and Client.SetWriteDeadline. If less than
+// len(p) data are writen before the timeout expires Write returns
+// with err == serial.ErrTimeout (and n < len(p)). While the server
+// has suspended the transmission of data (FLOWCONTROL-SUSPEND), Write
+// blocks.
+func (c *Client) Write(b []byte) (n int, err error) {
+	for {
+		if c.isClosed() {
+			return 0, serial.ErrClosed
+		}
+		c.mu.Lock()
+		s := c.suspended
+		c.mu.Unlock()
+		if s == nil {
+			break
+		}
+		select {
+		case <-s:
+		case <-c.wdl.Wait():
+			return 0, serial.ErrTimeout
+		case <-c.closed:
+			return 0, serial.ErrClosed
+		}
+	}
+
+	eb := escape(make([]byte, 0, len(b)+len(b)/16), b)
+	c.wmu.Lock()
+	m, err := c.conn.Write(eb)
+	c.wmu.Unlock()
+	if err != nil {
+		return unescapedLen(b, m), c.mapErr(err)
+	}
+	return len(b), nil
+}
+
+// unescapedLen returns how many bytes of b are contained in the
+// first m bytes of the escaped version of b.
+func unescapedLen(b []byte, m int) int {
+	n, pos := 0, 0
+	for _, c := range b {
+		if c == cmdIAC {
+			pos += 2
+		} else {
+			pos++
+		}
+		if pos > m {
+			break
+		}
+		n++
+	}
+	return n
+}
+
+// SetDeadline sets the deadline for both Read and Write operations.
+// See serial.Port.SetDeadline for details.
+func (c *Client) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for Read operations. See also
+// SetDeadline.
+func (c *Client) SetReadDeadline(t time.Time) error {
+	if c.isClosed() {
+		return serial.ErrClosed
+	}
+	c.rdl.Set(t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write operations. See also
+// SetDeadline.
+func (c *Client) SetWriteDeadline(t time.Time) error {
+	if c.isClosed() {
+		return serial.ErrClosed
+	}
+	c.wdl.Set(t)
+	return c.mapErr(c.conn.SetWriteDeadline(t))
+}
+
+// purge discards data buffered locally (if in) and asks the server to
+// discard the buffers selected by v.
+func (c *Client) purge(v byte, in bool) error {
+	if in {
+		c.mu.Lock()
+		c.rbuf = nil
+		c.mu.Unlock()
+		signal(c.rspace)
+	}
+	_, err := c.requestByte(cpPurgeData, v)
+	return err
+}
+
+// Flush discards any unread data in the receive buffers, as well as
+// any unsent data in the transmit buffers (using the PURGE-DATA
+// command).
+func (c *Client) Flush() error {
+	return c.purge(purgeBoth, true)
+}
+
+// FlushIn discards any unread data in the receive buffers.
+func (c *Client) FlushIn() error {
+	return c.purge(purgeRx, true)
+}
+
+// FlushOut discards any unsent data in the transmit buffers.
+func (c *Client) FlushOut() error {
+	return c.purge(purgeTx, false)
+}
+
+// control sends a SET-CONTROL command with value v and verifies that
+// the server responded with value exp.
+func (c *Client) control(v, exp byte, what string) error {
+	r, err := c.requestByte(cpSetControl, v)
+	if err != nil {
+		return err
+	}
+	if r != exp {
+		return fmt.Errorf("rfc2217: %s not accepted", what)
+	}
+	return nil
+}
+
+// SetBreak turns the break signal on the serial port on or off.
+func (c *Client) SetBreak(on bool) error {
+	if on {
+		return c.control(ctlBreakOn, ctlBreakOn, "break on")
+	}
+	return c.control(ctlBreakOff, ctlBreakOff, "break off")
+}
+
+// SendBreak sends a break signal, lasting approximately 0.25 seconds.
+func (c *Client) SendBreak() error {
+	if err := c.SetBreak(true); err != nil {
+		return err
+	}
+	time.Sleep(250 * time.Millisecond)
+	return c.SetBreak(false)
+}
+
+// SetDTR asserts (on == true) or de-asserts the DTR modem-control
+// line.
+func (c *Client) SetDTR(on bool) error {
+	if on {
+		return c.control(ctlDTROn, ctlDTROn, "DTR on")
+	}
+	return c.control(ctlDTROff, ctlDTROff, "DTR off")
+}
+
+// SetRTS asserts (on == true) or de-asserts the RTS modem-control
+// line.
+func (c *Client) SetRTS(on bool) error {
+	if on {
+		return c.control(ctlRTSOn, ctlRTSOn, "RTS on")
+	}
+	return c.control(ctlRTSOff, ctlRTSOff, "RTS off")
+}
+
+// GetModem returns the state of the serial port's modem lines. The
+// state of the output lines (DTR, RTS) is requested from the
+// server. The state of the input lines is the one most recently
+// reported by the server (see ModemState).
+func (c *Client) GetModem() (serial.ModemLines, error) {
+	ml := modemFromRFC(c.ModemState())
+	v, err := c.requestByte(cpSetControl, ctlDTRRequest)
+	if err != nil {
+		return 0, err
+	}
+	if v == ctlDTROn {
+		ml |= serial.ModemDTR
+	}
+	v, err = c.requestByte(cpSetControl, ctlRTSRequest)
+	if err != nil {
+		return 0, err
+	}
+	if v == ctlRTSOn {
+		ml |= serial.ModemRTS
+	}
+	return ml, nil
+}
+
+// SetModem asserts or de-asserts the modem-control lines selected by
+// mask, according to the respective bits in lines. Only
+// serial.ModemDTR and serial.ModemRTS may be set in mask.
+func (c *Client) SetModem(lines, mask serial.ModemLines) error {
+	if mask&^(serial.ModemDTR|serial.ModemRTS) != 0 {
+		return errors.New("rfc2217: invalid modem-lines mask: " +
+			mask.String())
+	}
+	if mask&serial.ModemDTR != 0 {
+		if err := c.SetDTR(lines&serial.ModemDTR != 0); err != nil {
+			return err
+		}
+	}
+	if mask&serial.ModemRTS != 0 {
+		if err := c.SetRTS(lines&serial.ModemRTS != 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ModemState returns the modem state most recently reported by the
+// server.
+func (c *Client) ModemState() ModemState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.modem
+}
+
+// LineState returns the line state most recently reported by the
+// server.
+func (c *Client) LineState() LineState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.line
+}
+
+// NotifyModem causes the modem states reported by the server to be
+// relayed to channel ch. The client does not block sending to ch; if
+// ch is not ready to receive, the notification is dropped. Callers
+// should use a buffered channel, or call ModemState to get the most
+// recent state.
+func (c *Client) NotifyModem(ch chan<- ModemState) {
+	c.mu.Lock()
+	c.modemCh = append(c.modemCh, ch)
+	c.mu.Unlock()
+}
+
+// NotifyLine causes the line states reported by the server to be
+// relayed to channel ch. See NotifyModem for details.
+func (c *Client) NotifyLine(ch chan<- LineState) {
+	c.mu.Lock()
+	c.lineCh = append(c.lineCh, ch)
+	c.mu.Unlock()
+}
+
+// SetModemStateMask sets the mask the server applies to modem-state
+// changes before notifying the client. Only changes in the bits set
+// in mask are reported. The server's default mask is 0xff.
+func (c *Client) SetModemStateMask(mask ModemState) error {
+	_, err := c.requestByte(cpSetModemstateMask, byte(mask))
+	return err
+}
+
+// SetLineStateMask sets the mask the server applies to line-state
+// changes before notifying the client. Only changes in the bits set
+// in mask are reported. The server's default mask is 0.
+func (c *Client) SetLineStateMask(mask LineState) error {
+	_, err := c.requestByte(cpSetLinestateMask, byte(mask))
+	return err
+}
+
+// Signature requests and returns the server's signature (a text
+// string identifying the server). While the request is pending, an
+// empty signature from the server is taken as its reply, not as a
+// request for ours.
+func (c *Client) Signature() (string, error) {
+	c.mu.Lock()
+	c.sigPending = true
+	c.mu.Unlock()
+	r, err := c.request(cpSignature)
+	c.mu.Lock()
+	c.sigPending = false
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+	return string(r), nil
+}
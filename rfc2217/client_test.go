@@ -0,0 +1,263 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package rfc2217
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// fakeServer is a minimal in-process RFC 2217 server. It keeps the
+// port settings in memory and echoes back all data it receives.
+type fakeServer struct {
+	ln   net.Listener
+	mu   sync.Mutex
+	conn net.Conn
+	opts options
+	baud uint32
+	size byte
+	par  byte
+	stop byte
+	flow byte
+	sig  string
+}
+
+func newFakeServer(t *testing.T) *fakeServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	s := &fakeServer{ln: ln, baud: 9600, size: 8, par: parNone,
+		stop: stop1, flow: ctlFlowNone, sig: "fake"}
+	go s.serve()
+	return s
+}
+
+func (s *fakeServer) addr() string { return s.ln.Addr().String() }
+
+func (s *fakeServer) close() { s.ln.Close() }
+
+func (s *fakeServer) send(b []byte) {
+	s.mu.Lock()
+	c := s.conn
+	s.mu.Unlock()
+	c.Write(b)
+}
+
+func (s *fakeServer) serve() {
+	conn, err := s.ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
+	var p parser
+	buf := make([]byte, 1024)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		data := p.parse(nil, buf[:n], s)
+		if len(data) > 0 {
+			conn.Write(escape(nil, data))
+		}
+	}
+}
+
+func (s *fakeServer) command(cmd, opt byte) {
+	local := func(o byte) bool { return o == optBinary || o == optSGA }
+	remote := func(o byte) bool {
+		return o == optComPort || o == optBinary || o == optSGA
+	}
+	if r := s.opts.negotiate(cmd, opt, local, remote); r != nil {
+		s.conn.Write(r)
+	}
+}
+
+func (s *fakeServer) subneg(b []byte) {
+	var r []byte
+	switch b[0] {
+	case cpSetBaudrate:
+		if v := getU32(b[1:]); v != 0 {
+			s.baud = v
+		}
+		r = u32(s.baud)
+	case cpSetDatasize:
+		if b[1] != 0 {
+			s.size = b[1]
+		}
+		r = []byte{s.size}
+	case cpSetParity:
+		if b[1] != 0 {
+			s.par = b[1]
+		}
+		r = []byte{s.par}
+	case cpSetStopsize:
+		if b[1] != 0 {
+			s.stop = b[1]
+		}
+		r = []byte{s.stop}
+	case cpSetControl:
+		switch b[1] {
+		case ctlFlowRequest:
+			r = []byte{s.flow}
+		case ctlFlowNone, ctlFlowXONXOFF, ctlFlowHardware:
+			s.flow = b[1]
+			r = []byte{s.flow}
+		default:
+			r = b[1:2]
+		}
+	case cpSignature:
+		s.mu.Lock()
+		r = []byte(s.sig)
+		s.mu.Unlock()
+	default:
+		r = b[1:]
+	}
+	s.conn.Write(subneg(b[0]+srvOffset, r...))
+}
+
+func TestConf(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+	c, err := Dial(s.addr())
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	conf := serial.Conf{
+		Baudrate: 115200,
+		Databits: 7,
+		Stopbits: 2,
+		Parity:   serial.ParityEven,
+		Flow:     serial.FlowRTSCTS,
+	}
+	if err := c.Conf(conf); err != nil {
+		t.Fatal("Conf:", err)
+	}
+	c1, err := c.GetConf()
+	if err != nil {
+		t.Fatal("GetConf:", err)
+	}
+	if c1 != conf {
+		t.Fatalf("%v != %v", c1, conf)
+	}
+	if err := c.ConfSome(serial.Conf{Databits: 9},
+		serial.ConfDatabits); err == nil {
+		t.Fatal("ConfSome: invalid databits accepted")
+	}
+	if err := c.SetDTR(false); err != nil {
+		t.Fatal("SetDTR:", err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal("Flush:", err)
+	}
+	sig, err := c.Signature()
+	if err != nil {
+		t.Fatal("Signature:", err)
+	}
+	if sig != "fake" {
+		t.Fatalf("Signature: %q != %q", sig, "fake")
+	}
+
+	// An empty signature is a reply, not a request for ours
+	s.mu.Lock()
+	s.sig = ""
+	s.mu.Unlock()
+	if sig, err := c.Signature(); err != nil || sig != "" {
+		t.Fatalf("Empty signature: %q, %v", sig, err)
+	}
+}
+
+func TestData(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+	c, err := Dial(s.addr())
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	out := []byte{'a', cmdIAC, 'b', cmdIAC, cmdIAC, cmdSE, 0, 'c'}
+	n, err := c.Write(out)
+	if err != nil || n != len(out) {
+		t.Fatalf("Write: %d, %v", n, err)
+	}
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	in := make([]byte, len(out))
+	if _, err := io.ReadFull(c, in); err != nil {
+		t.Fatal("Read:", err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Fatalf("Read: % x != % x", in, out)
+	}
+}
+
+func TestTimeoutClose(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+	c, err := Dial(s.addr())
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+
+	c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	b := make([]byte, 1)
+	if _, err := c.Read(b); err != serial.ErrTimeout {
+		t.Fatalf("Read: %v != %v", err, serial.ErrTimeout)
+	}
+	c.SetReadDeadline(time.Time{})
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		c.Close()
+	}()
+	if _, err := c.Read(b); err != serial.ErrClosed {
+		t.Fatalf("Read: %v != %v", err, serial.ErrClosed)
+	}
+	if err := c.Close(); err != serial.ErrClosed {
+		t.Fatalf("Close: %v != %v", err, serial.ErrClosed)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	s := newFakeServer(t)
+	defer s.close()
+	c, err := Dial(s.addr())
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	ch := make(chan ModemState, 1)
+	c.NotifyModem(ch)
+	ms := ModemCD | ModemDeltaCD | ModemCTS
+	s.send(subneg(srvOffset+cpNotifyModemstate, byte(ms)))
+	select {
+	case m := <-ch:
+		if m != ms {
+			t.Fatalf("ModemState: %v != %v", m, ms)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No modem-state notification")
+	}
+	if m := c.ModemState(); m != ms {
+		t.Fatalf("ModemState: %v != %v", m, ms)
+	}
+	if s := ms.String(); s != "DeltaCD|CTS|CD" {
+		t.Fatalf("ModemState.String: %q", s)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Conversions between serial.Conf field values and COM-PORT-OPTION
+// command parameters.
+
+package rfc2217
+
+import "github.com/npat-efault/serial"
+
+func parityToRFC(p serial.ParityMode) (byte, bool) {
+	switch p {
+	case serial.ParityNone:
+		return parNone, true
+	case serial.ParityOdd:
+		return parOdd, true
+	case serial.ParityEven:
+		return parEven, true
+	case serial.ParityMark:
+		return parMark, true
+	case serial.ParitySpace:
+		return parSpace, true
+	default:
+		return 0, false
+	}
+}
+
+func parityFromRFC(v byte) (serial.ParityMode, bool) {
+	switch v {
+	case parNone:
+		return serial.ParityNone, true
+	case parOdd:
+		return serial.ParityOdd, true
+	case parEven:
+		return serial.ParityEven, true
+	case parMark:
+		return serial.ParityMark, true
+	case parSpace:
+		return serial.ParitySpace, true
+	default:
+		return 0, false
+	}
+}
+
+func stopbitsToRFC(s int) (byte, bool) {
+	switch s {
+	case 1:
+		return stop1, true
+	case 2:
+		return stop2, true
+	default:
+		return 0, false
+	}
+}
+
+func stopbitsFromRFC(v byte) (int, bool) {
+	switch v {
+	case stop1:
+		return 1, true
+	case stop2:
+		return 2, true
+	default:
+		// 1.5 stopbits cannot be represented in serial.Conf
+		return 0, false
+	}
+}
+
+func flowToRFC(f serial.FlowMode) (byte, bool) {
+	switch f {
+	case serial.FlowNone:
+		return ctlFlowNone, true
+	case serial.FlowXONXOFF:
+		return ctlFlowXONXOFF, true
+	case serial.FlowRTSCTS:
+		return ctlFlowHardware, true
+	default:
+		return 0, false
+	}
+}
+
+func flowFromRFC(v byte) serial.FlowMode {
+	switch v {
+	case ctlFlowNone:
+		return serial.FlowNone
+	case ctlFlowXONXOFF:
+		return serial.FlowXONXOFF
+	case ctlFlowHardware:
+		return serial.FlowRTSCTS
+	default:
+		return serial.FlowOther
+	}
+}
+
+// modemToRFC converts the state of the modem-status lines in ml to a
+// ModemState (with no delta bits set).
+func modemToRFC(ml serial.ModemLines) ModemState {
+	var m ModemState
+	if ml&serial.ModemCTS != 0 {
+		m |= ModemCTS
+	}
+	if ml&serial.ModemDSR != 0 {
+		m |= ModemDSR
+	}
+	if ml&serial.ModemRI != 0 {
+		m |= ModemRI
+	}
+	if ml&serial.ModemDCD != 0 {
+		m |= ModemCD
+	}
+	return m
+}
+
+// modemFromRFC converts the modem-status lines in m to
+// serial.ModemLines.
+func modemFromRFC(m ModemState) serial.ModemLines {
+	var ml serial.ModemLines
+	if m&ModemCTS != 0 {
+		ml |= serial.ModemCTS
+	}
+	if m&ModemDSR != 0 {
+		ml |= serial.ModemDSR
+	}
+	if m&ModemRI != 0 {
+		ml |= serial.ModemRI
+	}
+	if m&ModemCD != 0 {
+		ml |= serial.ModemDCD
+	}
+	return ml
+}
@@ -0,0 +1,648 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package rfc2217
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// Port is the interface to the local serial port exported by a
+// Server. It is satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	GetConf() (conf serial.Conf, err error)
+	ConfSome(conf serial.Conf, flags serial.ConfFlags) error
+	Flush() error
+	FlushIn() error
+	FlushOut() error
+	SendBreak() error
+	GetModem() (serial.ModemLines, error)
+	SetModem(lines, mask serial.ModemLines) error
+}
+
+// counterPort is implemented by Ports that can report error
+// counters. The server uses them to generate line-state
+// notifications.
+type counterPort interface {
+	GetCounters() (serial.Counters, error)
+}
+
+// Policy selects how a Server handles connections from new clients
+// while already serving one.
+type Policy int
+
+const (
+	// PolicyReject rejects (closes) new connections while a
+	// client is being served.
+	PolicyReject Policy = iota
+	// PolicyTakeover disconnects the client being served and
+	// serves the new one.
+	PolicyTakeover
+)
+
+var policyStr = [...]string{"PolicyReject", "PolicyTakeover"}
+
+func (p Policy) String() string {
+	if p >= 0 && int(p) < len(policyStr) {
+		return policyStr[p]
+	}
+	return fmt.Sprintf("Policy(%d)", p)
+}
+
+// ErrServerClosed is returned by Server.Serve after a call to
+// Server.Close.
+var ErrServerClosed = errors.New("rfc2217: server closed")
+
+// DefaultPollInterval is the default interval at which the server
+// polls the local port for modem-state and line-state changes.
+const DefaultPollInterval = 100 * time.Millisecond
+
+// DefaultSignature is the signature sent by servers with no
+// Signature set, and by clients when the server asks for theirs.
+const DefaultSignature = "github.com/npat-efault/serial/rfc2217"
+
+// Server exports a local serial port to RFC 2217 clients. A server
+// serves a single client at a time; what happens when another client
+// connects is determined by Policy. Configuration requests from the
+// client are translated to Port.ConfSome calls, and changes in the
+// port's modem-status lines (and, if the Port supports error
+// counters, line-state changes) are forwarded to the client.
+type Server struct {
+	Port         Port          // The exported port
+	Policy       Policy        // Policy for concurrent clients
+	PollInterval time.Duration // Zero means DefaultPollInterval
+	Signature    string        // Empty means DefaultSignature
+
+	mu     sync.Mutex
+	cur    *session
+	ln     net.Listener
+	closed bool
+}
+
+// Serve accepts connections on listener ln and serves them, until
+// ln fails or the server is closed. It always returns a non-nil
+// error; after Close it returns ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.ln = ln
+	s.mu.Unlock()
+
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(50 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// ServeConn serves a single client connection, subject to the
+// server's Policy. It returns when the session with the client ends.
+func (s *Server) ServeConn(conn net.Conn) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		conn.Close()
+		return ErrServerClosed
+	}
+	old := s.cur
+	if old != nil && s.Policy != PolicyTakeover {
+		s.mu.Unlock()
+		conn.Close()
+		return errors.New("rfc2217: port busy")
+	}
+	ss := newSession(s, conn)
+	s.cur = ss
+	s.mu.Unlock()
+
+	if old != nil {
+		// Wait for the old session to release the port.
+		old.stop()
+		<-old.done
+	}
+	err := ss.run()
+
+	s.mu.Lock()
+	if s.cur == ss {
+		s.cur = nil
+	}
+	s.mu.Unlock()
+	return err
+}
+
+// Close closes the listener passed to Serve and terminates the
+// session with the current client (if any). It does not close the
+// Port.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	ln, cur := s.ln, s.cur
+	s.mu.Unlock()
+	var err error
+	if ln != nil {
+		err = ln.Close()
+	}
+	if cur != nil {
+		cur.stop()
+		<-cur.done
+	}
+	return err
+}
+
+func (s *Server) pollInterval() time.Duration {
+	if s.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return s.PollInterval
+}
+
+func (s *Server) signature() string {
+	if s.Signature == "" {
+		return DefaultSignature
+	}
+	return s.Signature
+}
+
+// session is a connection with a single client
+type session struct {
+	srv  *Server
+	port Port
+	conn net.Conn
+
+	wmu sync.Mutex // Serializes writes to conn
+
+	mu        sync.Mutex
+	opts      options
+	modemMask ModemState
+	lineMask  LineState
+	suspended bool
+	breakOn   bool
+
+	quit     chan struct{}
+	quitOnce sync.Once
+	done     chan struct{}
+}
+
+func newSession(s *Server, conn net.Conn) *session {
+	return &session{
+		srv:       s,
+		port:      s.Port,
+		conn:      conn,
+		modemMask: 0xff,
+		quit:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+}
+
+// stop asks the session to terminate
+func (ss *session) stop() {
+	ss.quitOnce.Do(func() {
+		close(ss.quit)
+		ss.conn.Close()
+	})
+}
+
+func (ss *session) stopped() bool {
+	select {
+	case <-ss.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// run runs the session until the client disconnects or the session
+// is stopped.
+func (ss *session) run() error {
+	defer close(ss.done)
+
+	ss.mu.Lock()
+	req := ss.opts.request(cmdWILL, optBinary, optSGA)
+	req = append(req, ss.opts.request(cmdDO,
+		optComPort, optBinary, optSGA)...)
+	ss.mu.Unlock()
+	if err := ss.writeRaw(req); err != nil {
+		ss.stop()
+		return err
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ss.portToNet()
+	}()
+	err := ss.netToPort()
+	ss.stop()
+	wg.Wait()
+	return err
+}
+
+func (ss *session) writeRaw(b []byte) error {
+	ss.wmu.Lock()
+	defer ss.wmu.Unlock()
+	_, err := ss.conn.Write(b)
+	return err
+}
+
+// netToPort reads from the connection, handles Telnet commands, and
+// writes data to the port.
+func (ss *session) netToPort() error {
+	var p parser
+	buf := make([]byte, 4096)
+	var data []byte
+	for {
+		n, err := ss.conn.Read(buf)
+		if n > 0 {
+			data = p.parse(data[:0], buf[:n], ss)
+			if len(data) > 0 {
+				if err := ss.writePort(data); err != nil {
+					return err
+				}
+			}
+		}
+		if err != nil {
+			if ss.stopped() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// writePort writes b to the port. It uses a write deadline so that
+// it can notice when the session is stopped, even if the port is
+// blocked by flow-control.
+func (ss *session) writePort(b []byte) error {
+	for len(b) > 0 {
+		ss.port.SetWriteDeadline(time.Now().Add(ss.srv.pollInterval()))
+		n, err := ss.port.Write(b)
+		b = b[n:]
+		if err != nil && err != serial.ErrTimeout {
+			return err
+		}
+		if ss.stopped() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// portToNet reads from the port and writes data to the
+// connection. It also polls the port for modem and line state
+// changes.
+func (ss *session) portToNet() {
+	defer ss.port.SetReadDeadline(time.Time{})
+	defer ss.port.SetWriteDeadline(time.Time{})
+
+	buf := make([]byte, 4096)
+	ebuf := make([]byte, 0, 2*len(buf))
+	modem, modemOK := ss.modemState()
+	if modemOK {
+		ss.notifyModem(modem, 0xff)
+	}
+	cnt, cntOK := ss.counters()
+	for !ss.stopped() {
+		ss.mu.Lock()
+		suspended := ss.suspended
+		ss.mu.Unlock()
+		if suspended {
+			select {
+			case <-time.After(ss.srv.pollInterval()):
+			case <-ss.quit:
+				return
+			}
+		} else {
+			ss.port.SetReadDeadline(time.Now().Add(ss.srv.pollInterval()))
+			n, err := ss.port.Read(buf)
+			if n > 0 {
+				ebuf = escape(ebuf[:0], buf[:n])
+				if err := ss.writeRaw(ebuf); err != nil {
+					ss.stop()
+					return
+				}
+			}
+			if err != nil && err != serial.ErrTimeout {
+				ss.stop()
+				return
+			}
+		}
+		if modemOK {
+			m, ok := ss.modemState()
+			if ok && m != modem {
+				d := modemDelta(modem, m)
+				ss.notifyModem(m|d, m^modem|d)
+				modem = m
+			}
+		}
+		if cntOK {
+			c, ok := ss.counters()
+			if ok && c != cnt {
+				ss.notifyLine(lineDelta(cnt, c))
+				cnt = c
+			}
+		}
+	}
+}
+
+// modemState reads the modem lines of the port and returns them as
+// a ModemState (with no delta bits set).
+func (ss *session) modemState() (ModemState, bool) {
+	ml, err := ss.port.GetModem()
+	if err != nil {
+		return 0, false
+	}
+	return modemToRFC(ml), true
+}
+
+// modemDelta computes the delta bits for a transition from modem
+// state o to n.
+func modemDelta(o, n ModemState) ModemState {
+	var d ModemState
+	if (o^n)&ModemCTS != 0 {
+		d |= ModemDeltaCTS
+	}
+	if (o^n)&ModemDSR != 0 {
+		d |= ModemDeltaDSR
+	}
+	if (o^n)&ModemCD != 0 {
+		d |= ModemDeltaCD
+	}
+	if o&ModemRI != 0 && n&ModemRI == 0 {
+		d |= ModemTrailRI
+	}
+	return d
+}
+
+// notifyModem sends a NOTIFY-MODEMSTATE to the client, if any of the
+// changed bits (lines and deltas) are selected by the client's
+// modem-state mask.
+func (ss *session) notifyModem(m, changed ModemState) {
+	ss.mu.Lock()
+	mask := ss.modemMask
+	ss.mu.Unlock()
+	if changed&mask == 0 {
+		return
+	}
+	ss.writeRaw(subneg(srvOffset+cpNotifyModemstate, byte(m&mask)))
+}
+
+func (ss *session) counters() (serial.Counters, bool) {
+	cp, ok := ss.port.(counterPort)
+	if !ok {
+		return serial.Counters{}, false
+	}
+	c, err := cp.GetCounters()
+	if err != nil {
+		return serial.Counters{}, false
+	}
+	return c, true
+}
+
+// lineDelta returns the line-state events indicated by the change
+// of the error counters from o to n.
+func lineDelta(o, n serial.Counters) LineState {
+	var l LineState
+	if n.Overrun != o.Overrun || n.BufOverrun != o.BufOverrun {
+		l |= LineOverrun
+	}
+	if n.Parity != o.Parity {
+		l |= LineParity
+	}
+	if n.Frame != o.Frame {
+		l |= LineFraming
+	}
+	if n.Break != o.Break {
+		l |= LineBreak
+	}
+	return l
+}
+
+// notifyLine sends a NOTIFY-LINESTATE to the client, if any of the
+// events in l are selected by the client's line-state mask.
+func (ss *session) notifyLine(l LineState) {
+	ss.mu.Lock()
+	mask := ss.lineMask
+	ss.mu.Unlock()
+	if l&mask == 0 {
+		return
+	}
+	ss.writeRaw(subneg(srvOffset+cpNotifyLinestate, byte(l&mask)))
+}
+
+func srvLocalOpt(opt byte) bool {
+	return opt == optBinary || opt == optSGA
+}
+
+func srvRemoteOpt(opt byte) bool {
+	return opt == optComPort || opt == optBinary || opt == optSGA
+}
+
+// command is called by the parser for option negotiation commands.
+func (ss *session) command(cmd, opt byte) {
+	ss.mu.Lock()
+	reply := ss.opts.negotiate(cmd, opt, srvLocalOpt, srvRemoteOpt)
+	ss.mu.Unlock()
+	if reply != nil {
+		ss.writeRaw(reply)
+	}
+}
+
+// subneg is called by the parser for COM-PORT-OPTION
+// subnegotiations. It carries out the client's requests and
+// responds to them.
+func (ss *session) subneg(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	cmd, param := b[0], b[1:]
+	var r []byte
+	switch cmd {
+	case cpSignature:
+		if len(param) != 0 {
+			// Client's signature. Nothing to do.
+			return
+		}
+		r = []byte(ss.srv.signature())
+	case cpSetBaudrate:
+		if len(param) < 4 {
+			return
+		}
+		if v := getU32(param); v != 0 {
+			ss.port.ConfSome(serial.Conf{Baudrate: int(v)},
+				serial.ConfBaudrate)
+		}
+		conf, _ := ss.port.GetConf()
+		r = u32(uint32(conf.Baudrate))
+	case cpSetDatasize:
+		if len(param) < 1 {
+			return
+		}
+		if v := param[0]; v != 0 {
+			ss.port.ConfSome(serial.Conf{Databits: int(v)},
+				serial.ConfDatabits)
+		}
+		conf, _ := ss.port.GetConf()
+		r = []byte{byte(conf.Databits)}
+	case cpSetParity:
+		if len(param) < 1 {
+			return
+		}
+		if p, ok := parityFromRFC(param[0]); ok {
+			ss.port.ConfSome(serial.Conf{Parity: p},
+				serial.ConfParity)
+		}
+		conf, _ := ss.port.GetConf()
+		v, _ := parityToRFC(conf.Parity)
+		r = []byte{v}
+	case cpSetStopsize:
+		if len(param) < 1 {
+			return
+		}
+		if s, ok := stopbitsFromRFC(param[0]); ok {
+			ss.port.ConfSome(serial.Conf{Stopbits: s},
+				serial.ConfStopbits)
+		}
+		conf, _ := ss.port.GetConf()
+		v, _ := stopbitsToRFC(conf.Stopbits)
+		r = []byte{v}
+	case cpSetControl:
+		if len(param) < 1 {
+			return
+		}
+		r = []byte{ss.control(param[0])}
+	case cpFlowSuspend, cpFlowResume:
+		ss.mu.Lock()
+		ss.suspended = cmd == cpFlowSuspend
+		ss.mu.Unlock()
+		return
+	case cpSetLinestateMask:
+		if len(param) < 1 {
+			return
+		}
+		ss.mu.Lock()
+		ss.lineMask = LineState(param[0])
+		ss.mu.Unlock()
+		r = param[:1]
+	case cpSetModemstateMask:
+		if len(param) < 1 {
+			return
+		}
+		ss.mu.Lock()
+		ss.modemMask = ModemState(param[0])
+		ss.mu.Unlock()
+		r = param[:1]
+	case cpPurgeData:
+		if len(param) < 1 {
+			return
+		}
+		switch param[0] {
+		case purgeRx:
+			ss.port.FlushIn()
+		case purgeTx:
+			ss.port.FlushOut()
+		case purgeBoth:
+			ss.port.Flush()
+		}
+		r = param[:1]
+	default:
+		// Unknown command, or notification. Ignore.
+		return
+	}
+	ss.writeRaw(subneg(srvOffset+cmd, r...))
+}
+
+// control carries out a SET-CONTROL request with value v, and returns
+// the value of the response.
+func (ss *session) control(v byte) byte {
+	switch v {
+	case ctlFlowNone, ctlFlowXONXOFF, ctlFlowHardware:
+		ss.port.ConfSome(serial.Conf{Flow: flowFromRFC(v)},
+			serial.ConfFlow)
+		fallthrough
+	case ctlFlowRequest:
+		conf, _ := ss.port.GetConf()
+		r, ok := flowToRFC(conf.Flow)
+		if !ok {
+			r = ctlFlowNone
+		}
+		return r
+	case ctlBreakOn:
+		// Port supports only fixed-length breaks.
+		ss.port.SendBreak()
+		ss.mu.Lock()
+		ss.breakOn = true
+		ss.mu.Unlock()
+		return v
+	case ctlBreakOff:
+		ss.mu.Lock()
+		ss.breakOn = false
+		ss.mu.Unlock()
+		return v
+	case ctlBreakRequest:
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		if ss.breakOn {
+			return ctlBreakOn
+		}
+		return ctlBreakOff
+	case ctlDTROn, ctlDTROff:
+		ss.port.SetModem(onOff(v == ctlDTROn, serial.ModemDTR),
+			serial.ModemDTR)
+		fallthrough
+	case ctlDTRRequest:
+		ml, _ := ss.port.GetModem()
+		if ml&serial.ModemDTR != 0 {
+			return ctlDTROn
+		}
+		return ctlDTROff
+	case ctlRTSOn, ctlRTSOff:
+		ss.port.SetModem(onOff(v == ctlRTSOn, serial.ModemRTS),
+			serial.ModemRTS)
+		fallthrough
+	case ctlRTSRequest:
+		ml, _ := ss.port.GetModem()
+		if ml&serial.ModemRTS != 0 {
+			return ctlRTSOn
+		}
+		return ctlRTSOff
+	default:
+		// Inbound flow-control and DCD/DTR/DSR flow-control
+		// cannot be set independently. Report the current
+		// outbound setting.
+		return ss.control(ctlFlowRequest)
+	}
+}
+
+func onOff(on bool, l serial.ModemLines) serial.ModemLines {
+	if on {
+		return l
+	}
+	return 0
+}
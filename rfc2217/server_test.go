@@ -0,0 +1,349 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package rfc2217
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/deadline"
+)
+
+// fakePort is an in-memory Port. Data sent with put are returned by
+// Read; data written are collected and returned by get.
+type fakePort struct {
+	in   chan []byte
+	rbuf []byte
+	rdl  *deadline.Deadline
+
+	mu    sync.Mutex
+	out   bytes.Buffer
+	conf  serial.Conf
+	modem serial.ModemLines
+	flush int
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{
+		in:   make(chan []byte, 16),
+		rdl:  deadline.New(),
+		conf: serial.Conf{Baudrate: 9600, Databits: 8, Stopbits: 1},
+	}
+}
+
+func (p *fakePort) put(b []byte) { p.in <- b }
+
+func (p *fakePort) get(n int) []byte {
+	for i := 0; i < 200; i++ {
+		p.mu.Lock()
+		if p.out.Len() >= n {
+			b := p.out.Next(n)
+			p.mu.Unlock()
+			return b
+		}
+		p.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if len(p.rbuf) == 0 {
+		select {
+		case p.rbuf = <-p.in:
+		case <-p.rdl.Wait():
+			return 0, serial.ErrTimeout
+		}
+	}
+	n := copy(b, p.rbuf)
+	p.rbuf = p.rbuf[n:]
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.Write(b)
+}
+
+func (p *fakePort) SetReadDeadline(t time.Time) error {
+	p.rdl.Set(t)
+	return nil
+}
+
+func (p *fakePort) SetWriteDeadline(t time.Time) error { return nil }
+
+func (p *fakePort) GetConf() (serial.Conf, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.conf, nil
+}
+
+func (p *fakePort) ConfSome(c serial.Conf, flags serial.ConfFlags) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if flags&serial.ConfBaudrate != 0 {
+		p.conf.Baudrate = c.Baudrate
+	}
+	if flags&serial.ConfDatabits != 0 {
+		p.conf.Databits = c.Databits
+	}
+	if flags&serial.ConfParity != 0 {
+		p.conf.Parity = c.Parity
+	}
+	if flags&serial.ConfStopbits != 0 {
+		p.conf.Stopbits = c.Stopbits
+	}
+	if flags&serial.ConfFlow != 0 {
+		p.conf.Flow = c.Flow
+	}
+	return nil
+}
+
+func (p *fakePort) Flush() error {
+	p.mu.Lock()
+	p.flush++
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *fakePort) FlushIn() error   { return p.Flush() }
+func (p *fakePort) FlushOut() error  { return p.Flush() }
+func (p *fakePort) SendBreak() error { return nil }
+
+func (p *fakePort) GetModem() (serial.ModemLines, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modem, nil
+}
+
+func (p *fakePort) SetModem(lines, mask serial.ModemLines) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modem = p.modem&^mask | lines&mask
+	return nil
+}
+
+func (p *fakePort) setModem(m serial.ModemLines) {
+	p.mu.Lock()
+	p.modem = m
+	p.mu.Unlock()
+}
+
+func startServer(t *testing.T, p Port, pol Policy) (*Server, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	s := &Server{Port: p, Policy: pol,
+		PollInterval: 10 * time.Millisecond, Signature: "test"}
+	go s.Serve(ln)
+	return s, ln.Addr().String()
+}
+
+func TestServerConf(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	defer s.Close()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	conf := serial.Conf{Baudrate: 57600, Databits: 7, Stopbits: 2,
+		Parity: serial.ParityOdd, Flow: serial.FlowXONXOFF}
+	if err := c.Conf(conf); err != nil {
+		t.Fatal("Conf:", err)
+	}
+	pc, _ := p.GetConf()
+	if pc != conf {
+		t.Fatalf("Port conf: %v != %v", pc, conf)
+	}
+	cc, err := c.GetConf()
+	if err != nil {
+		t.Fatal("GetConf:", err)
+	}
+	if cc != conf {
+		t.Fatalf("Client conf: %v != %v", cc, conf)
+	}
+	if err := c.SetRTS(true); err != nil {
+		t.Fatal("SetRTS:", err)
+	}
+	if m, _ := p.GetModem(); m != serial.ModemRTS {
+		t.Fatalf("Modem: %v != %v", m, serial.ModemRTS)
+	}
+	if err := c.FlushIn(); err != nil {
+		t.Fatal("FlushIn:", err)
+	}
+	if sig, err := c.Signature(); err != nil || sig != "test" {
+		t.Fatalf("Signature: %q, %v", sig, err)
+	}
+}
+
+func TestServerSignature(t *testing.T) {
+	p := newFakePort()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	s := &Server{Port: p}
+	go s.Serve(ln)
+	defer s.Close()
+	c, err := Dial(ln.Addr().String())
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+	if sig, err := c.Signature(); err != nil || sig != DefaultSignature {
+		t.Fatalf("Signature: %q, %v", sig, err)
+	}
+}
+
+func TestServerData(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	defer s.Close()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	out := []byte{0, cmdIAC, cmdIAC, 'x', cmdIAC, cmdSB, 'y'}
+	if _, err := c.Write(out); err != nil {
+		t.Fatal("Write:", err)
+	}
+	if b := p.get(len(out)); !bytes.Equal(b, out) {
+		t.Fatalf("Port got: % x != % x", b, out)
+	}
+
+	p.put(out)
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	in := make([]byte, len(out))
+	if _, err := io.ReadFull(c, in); err != nil {
+		t.Fatal("Read:", err)
+	}
+	if !bytes.Equal(in, out) {
+		t.Fatalf("Client got: % x != % x", in, out)
+	}
+}
+
+func TestServerModem(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	defer s.Close()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	ch := make(chan ModemState, 4)
+	c.NotifyModem(ch)
+	p.setModem(serial.ModemDCD | serial.ModemCTS)
+	exp := ModemCD | ModemCTS | ModemDeltaCD | ModemDeltaCTS
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case m := <-ch:
+			if m == exp {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("No modem notification %v (last %v)",
+				exp, c.ModemState())
+		}
+	}
+}
+
+func TestServerModemDeltaMask(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	defer s.Close()
+	c, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c.Close()
+
+	if err := c.SetModemStateMask(ModemDeltaCTS); err != nil {
+		t.Fatal("SetModemStateMask:", err)
+	}
+	ch := make(chan ModemState, 4)
+	c.NotifyModem(ch)
+	p.setModem(serial.ModemCTS)
+	select {
+	case m := <-ch:
+		if m != ModemDeltaCTS {
+			t.Fatalf("Modem notification %v != %v", m,
+				ModemDeltaCTS)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("No modem notification")
+	}
+}
+
+func TestServerPolicy(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	c1, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	if _, err := Dial(addr); err == nil {
+		t.Fatal("Dial: second client accepted")
+	}
+	c1.Close()
+	s.Close()
+
+	s, addr = startServer(t, p, PolicyTakeover)
+	defer s.Close()
+	c1, err = Dial(addr)
+	if err != nil {
+		t.Fatal("Dial:", err)
+	}
+	defer c1.Close()
+	c2, err := Dial(addr)
+	if err != nil {
+		t.Fatal("Dial (takeover):", err)
+	}
+	defer c2.Close()
+	c1.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := c1.Read(make([]byte, 1)); err != serial.ErrEOF {
+		t.Fatalf("Read (old client): %v != %v", err, serial.ErrEOF)
+	}
+}
+
+func TestOpenURL(t *testing.T) {
+	p := newFakePort()
+	s, addr := startServer(t, p, PolicyReject)
+	defer s.Close()
+	sp, err := serial.Open("rfc2217://" + addr)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	defer sp.Close()
+	if err := sp.ConfSome(serial.Conf{Baudrate: 19200},
+		serial.ConfBaudrate); err != nil {
+		t.Fatal("ConfSome:", err)
+	}
+	if c, _ := p.GetConf(); c.Baudrate != 19200 {
+		t.Fatalf("Baudrate: %d != %d", c.Baudrate, 19200)
+	}
+	if err := sp.SetModem(serial.ModemDTR,
+		serial.ModemDTR|serial.ModemRTS); err != nil {
+		t.Fatal("SetModem:", err)
+	}
+	if m, err := sp.GetModem(); err != nil || m != serial.ModemDTR {
+		t.Fatalf("GetModem: %v, %v", m, err)
+	}
+}
@@ -0,0 +1,379 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package rfc2217 implements the Telnet Com Port Control Option (RFC
+// 2217), which allows serial ports attached to network device
+// servers to be accessed and configured over a TCP connection.
+//
+// The Client type connects to an RFC 2217 server and exposes an API
+// identical to the one provided by serial.Port: Read and Write with
+// deadlines and safe cancelation, port configuration using
+// serial.Conf, buffer flushing, plus methods for controlling the
+// modem-control lines and for receiving the modem-state and
+// line-state notifications sent by the server.
+//
+// References
+//
+//   [1] RFC 854: Telnet Protocol Specification
+//   (https://tools.ietf.org/html/rfc854)
+//
+//   [2] RFC 2217: Telnet Com Port Control Option
+//   (https://tools.ietf.org/html/rfc2217)
+package rfc2217
+
+import "fmt"
+
+// Telnet commands (RFC 854)
+const (
+	cmdSE   = 240 // End of subnegotiation parameters
+	cmdNOP  = 241 // No operation
+	cmdSB   = 250 // Begin subnegotiation
+	cmdWILL = 251
+	cmdWONT = 252
+	cmdDO   = 253
+	cmdDONT = 254
+	cmdIAC  = 255 // Interpret as command
+)
+
+// Telnet options
+const (
+	optBinary  = 0  // RFC 856
+	optEcho    = 1  // RFC 857
+	optSGA     = 3  // RFC 858, Suppress Go Ahead
+	optComPort = 44 // RFC 2217
+)
+
+// COM-PORT-OPTION subnegotiation commands, as sent by the client. The
+// server responds using the same codes plus srvOffset.
+const (
+	cpSignature         = 0
+	cpSetBaudrate       = 1
+	cpSetDatasize       = 2
+	cpSetParity         = 3
+	cpSetStopsize       = 4
+	cpSetControl        = 5
+	cpNotifyLinestate   = 6
+	cpNotifyModemstate  = 7
+	cpFlowSuspend       = 8
+	cpFlowResume        = 9
+	cpSetLinestateMask  = 10
+	cpSetModemstateMask = 11
+	cpPurgeData         = 12
+
+	srvOffset = 100
+)
+
+// Values for the SET-PARITY command
+const (
+	parRequest = 0
+	parNone    = 1
+	parOdd     = 2
+	parEven    = 3
+	parMark    = 4
+	parSpace   = 5
+)
+
+// Values for the SET-STOPSIZE command
+const (
+	stopRequest = 0
+	stop1       = 1
+	stop2       = 2
+	stop15      = 3
+)
+
+// Values for the SET-CONTROL command
+const (
+	ctlFlowRequest  = 0
+	ctlFlowNone     = 1
+	ctlFlowXONXOFF  = 2
+	ctlFlowHardware = 3
+	ctlBreakRequest = 4
+	ctlBreakOn      = 5
+	ctlBreakOff     = 6
+	ctlDTRRequest   = 7
+	ctlDTROn        = 8
+	ctlDTROff       = 9
+	ctlRTSRequest   = 10
+	ctlRTSOn        = 11
+	ctlRTSOff       = 12
+)
+
+// Values for the PURGE-DATA command
+const (
+	purgeRx   = 1 // Access-server receive buffer
+	purgeTx   = 2 // Access-server transmit buffer
+	purgeBoth = 3
+)
+
+// ModemState is a bitmask encoding the state of the serial port's
+// modem-status lines, as reported by the server with NOTIFY-MODEMSTATE.
+type ModemState byte
+
+const (
+	ModemDeltaCTS ModemState = 1 << iota // CTS changed
+	ModemDeltaDSR                        // DSR changed
+	ModemTrailRI                         // RI trailing edge detected
+	ModemDeltaCD                         // CD changed
+	ModemCTS                             // Clear To Send
+	ModemDSR                             // Data Set Ready
+	ModemRI                              // Ring Indicator
+	ModemCD                              // Carrier Detect
+)
+
+var modemStateStr = [...]string{
+	"DeltaCTS", "DeltaDSR", "TrailRI", "DeltaCD",
+	"CTS", "DSR", "RI", "CD",
+}
+
+func (m ModemState) String() string {
+	return bitsString(byte(m), modemStateStr[:])
+}
+
+// LineState is a bitmask encoding the state of the serial port's
+// line, as reported by the server with NOTIFY-LINESTATE.
+type LineState byte
+
+const (
+	LineDataReady LineState = 1 << iota // Data ready
+	LineOverrun                         // Overrun error
+	LineParity                          // Parity error
+	LineFraming                         // Framing error
+	LineBreak                           // Break detected
+	LineTHRE                            // Transfer holding register empty
+	LineTSRE                            // Transfer shift register empty
+	LineTimeout                         // Timeout error
+)
+
+var lineStateStr = [...]string{
+	"DataReady", "Overrun", "Parity", "Framing",
+	"Break", "THRE", "TSRE", "Timeout",
+}
+
+func (l LineState) String() string {
+	return bitsString(byte(l), lineStateStr[:])
+}
+
+func bitsString(b byte, names []string) string {
+	if b == 0 {
+		return "0"
+	}
+	s := ""
+	for i, n := range names {
+		if b&(1<<uint(i)) != 0 {
+			if s != "" {
+				s += "|"
+			}
+			s += n
+		}
+	}
+	return s
+}
+
+// handler receives the Telnet commands and subnegotiations decoded
+// by a parser.
+type handler interface {
+	// command is called for WILL, WONT, DO, and DONT
+	// commands. Other commands are ignored.
+	command(cmd, opt byte)
+	// subneg is called for COM-PORT-OPTION subnegotiations
+	// (IAC SB 44 ... IAC SE), with the data between the option
+	// code and IAC SE, unescaped. Subnegotiations for other
+	// options are ignored.
+	subneg(b []byte)
+}
+
+// Parser states
+const (
+	psData = iota
+	psIAC
+	psCmd
+	psSB
+	psSBIAC
+)
+
+// maxSubneg limits the length of subnegotiations accepted by the
+// parser. Longer ones are truncated.
+const maxSubneg = 256
+
+// parser decodes a Telnet data stream.
+type parser struct {
+	state int
+	cmd   byte
+	sb    []byte
+}
+
+// parse decodes the Telnet stream in b. Data bytes are appended to
+// dst and the result is returned. Commands and subnegotiations are
+// passed to h. Parser state is retained between calls, so Telnet
+// sequences may be split arbitrarily.
+func (p *parser) parse(dst, b []byte, h handler) []byte {
+	for _, c := range b {
+		switch p.state {
+		case psData:
+			if c == cmdIAC {
+				p.state = psIAC
+			} else {
+				dst = append(dst, c)
+			}
+		case psIAC:
+			switch c {
+			case cmdIAC:
+				dst = append(dst, c)
+				p.state = psData
+			case cmdWILL, cmdWONT, cmdDO, cmdDONT:
+				p.cmd = c
+				p.state = psCmd
+			case cmdSB:
+				p.sb = p.sb[:0]
+				p.state = psSB
+			default:
+				p.state = psData
+			}
+		case psCmd:
+			h.command(p.cmd, c)
+			p.state = psData
+		case psSB:
+			if c == cmdIAC {
+				p.state = psSBIAC
+			} else if len(p.sb) < maxSubneg {
+				p.sb = append(p.sb, c)
+			}
+		case psSBIAC:
+			switch c {
+			case cmdIAC:
+				if len(p.sb) < maxSubneg {
+					p.sb = append(p.sb, c)
+				}
+				p.state = psSB
+			case cmdSE:
+				if len(p.sb) > 0 && p.sb[0] == optComPort {
+					h.subneg(p.sb[1:])
+				}
+				p.state = psData
+			default:
+				// Protocol violation. Abort subneg.
+				p.state = psData
+			}
+		}
+	}
+	return dst
+}
+
+// escape appends b to dst, doubling IAC bytes, and returns the result.
+func escape(dst, b []byte) []byte {
+	for _, c := range b {
+		if c == cmdIAC {
+			dst = append(dst, cmdIAC)
+		}
+		dst = append(dst, c)
+	}
+	return dst
+}
+
+// subneg returns the encoded COM-PORT-OPTION subnegotiation for
+// command cmd with the given parameters.
+func subneg(cmd byte, param ...byte) []byte {
+	b := []byte{cmdIAC, cmdSB, optComPort, cmd}
+	b = escape(b, param)
+	return append(b, cmdIAC, cmdSE)
+}
+
+// u32 encodes v as a 4-byte big-endian (network order) value
+func u32(v uint32) []byte {
+	return []byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}
+}
+
+// getU32 decodes a 4-byte big-endian value.
+func getU32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 |
+		uint32(b[2])<<8 | uint32(b[3])
+}
+
+// Option negotiation state (per option and direction).
+type optState int
+
+const (
+	optOff  optState = iota // Disabled
+	optWant                 // Requested, waiting for response
+	optOn                   // Enabled
+)
+
+// options tracks the state of Telnet options negotiated over a
+// connection. Local options are ones we perform (WILL/WONT), remote
+// options are ones the peer performs (DO/DONT). Only the options for
+// which the supported functions return true are ever enabled.
+type options struct {
+	local  [256]optState
+	remote [256]optState
+}
+
+// negotiate updates the option state upon reception of command cmd
+// for option opt, and returns the reply to be sent (or nil, if none
+// is required). Arguments local and remote report whether an option
+// is supported locally or remotely respectively.
+func (o *options) negotiate(cmd, opt byte,
+	local, remote func(opt byte) bool) []byte {
+
+	switch cmd {
+	case cmdDO:
+		switch o.local[opt] {
+		case optOff:
+			if !local(opt) {
+				return []byte{cmdIAC, cmdWONT, opt}
+			}
+			o.local[opt] = optOn
+			return []byte{cmdIAC, cmdWILL, opt}
+		case optWant:
+			o.local[opt] = optOn
+		}
+	case cmdDONT:
+		switch o.local[opt] {
+		case optOn:
+			o.local[opt] = optOff
+			return []byte{cmdIAC, cmdWONT, opt}
+		case optWant:
+			o.local[opt] = optOff
+		}
+	case cmdWILL:
+		switch o.remote[opt] {
+		case optOff:
+			if !remote(opt) {
+				return []byte{cmdIAC, cmdDONT, opt}
+			}
+			o.remote[opt] = optOn
+			return []byte{cmdIAC, cmdDO, opt}
+		case optWant:
+			o.remote[opt] = optOn
+		}
+	case cmdWONT:
+		switch o.remote[opt] {
+		case optOn:
+			o.remote[opt] = optOff
+			return []byte{cmdIAC, cmdDONT, opt}
+		case optWant:
+			o.remote[opt] = optOff
+		}
+	}
+	return nil
+}
+
+// request marks options as requested and returns the commands that
+// must be sent to request them. Argument cmd must be either cmdWILL
+// (for local options) or cmdDO (for remote options).
+func (o *options) request(cmd byte, opts ...byte) []byte {
+	var b []byte
+	for _, opt := range opts {
+		switch cmd {
+		case cmdWILL:
+			o.local[opt] = optWant
+		case cmdDO:
+			o.remote[opt] = optWant
+		default:
+			panic(fmt.Sprintf("rfc2217: bad request command %d", cmd))
+		}
+		b = append(b, cmdIAC, cmd, opt)
+	}
+	return b
+}
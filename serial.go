@@ -28,17 +28,57 @@
 //
 // Addition of support for other systems is certainly possible, and
 // mostly welcome.
+//
+// Transports
+//
+// Besides local serial-port devices, Open can open serial ports
+// accessed through other transports, selected by URL scheme. Raw TCP
+// ("tcp://host:port") and Unix-domain socket ("unix:///path")
+// transports are always available. Other packages may register
+// additional transports (see Register); for example, importing
+// package "rfc2217" (github.com/npat-efault/serial/rfc2217) makes
+// "rfc2217://host:port" ports available. Transports that cannot carry
+// out an operation (e.g. setting the baudrate of a raw TCP
+// connection) return ErrUnsupported.
+//
+// Tracing
+//
+// The data transferred and the operations performed on a port can be
+// traced by installing a Tracer with Port.SetTracer. NewHexTracer
+// returns a tracer that writes hex / ASCII dumps to an io.Writer;
+// NewSlogTracer returns one that logs to a log/slog Logger.
 package serial
 
 import (
 	"fmt"
+	"sync/atomic"
 	"time"
 )
 
 // Port is a serial port
 type Port struct {
 	Name string // Name used at Port.Open
-	*port
+	impl
+	tr atomic.Value // Holds tracerBox (see SetTracer)
+}
+
+// impl is the interface implemented by the system-specific port type
+// (see files serial_<implementation>.go), and by the adapter for
+// Transports (see transport.go).
+type impl interface {
+	close() error
+	getConf() (conf Conf, err error)
+	confSome(conf Conf, flags ConfFlags) error
+	read(b []byte) (n int, err error)
+	write(b []byte) (n int, err error)
+	setDeadline(t time.Time) error
+	setReadDeadline(t time.Time) error
+	setWriteDeadline(t time.Time) error
+	flush(q flushSel) error
+	sendBreak() error
+	getModem() (ModemLines, error)
+	setModem(lines, mask ModemLines) error
+	getCounters() (Counters, error)
 }
 
 // ParityMode encodes the supported bit-parity modes
@@ -108,12 +148,22 @@ type Conf struct {
 // calls "raw-mode" (transparent operation, without character
 // translation or other processing). Other port settings (baudratre,
 // character format, flow-control, etc.) are not altered.
+//
+// If name is a URL of the form "scheme://address", the port is
+// opened using the transport registered for the scheme (see
+// Register). Transports for the "tcp" (e.g. "tcp://host:port") and
+// "unix" (e.g. "unix:///path/to/socket") schemes are always
+// available. Other names are taken to be the names (paths) of local
+// serial-port devices.
 func Open(name string) (port *Port, err error) {
+	if scheme, _, ok := SplitURL(name); ok {
+		return openURL(scheme, name)
+	}
 	p, err := open(name)
 	if err != nil {
 		return nil, err
 	}
-	return &Port{Name: name, port: p}, nil
+	return &Port{Name: name, impl: p}, nil
 }
 
 // Close closes the port. Unless the port has been configured with
@@ -124,13 +174,15 @@ func Open(name string) (port *Port, err error) {
 // method. Close will cancel ongoing (blocked) Read and Write
 // operations, and make them return ErrClosed.
 func (p *Port) Close() error {
-	return p.port.close()
+	err := p.impl.close()
+	p.traceErr(TraceClose, err)
+	return err
 }
 
 // GetConf returns the serial port's configuration parameters as a
 // Conf structure.
 func (p *Port) GetConf() (conf Conf, err error) {
-	return p.port.getConf()
+	return p.impl.getConf()
 }
 
 // ConfFlags are flags controlling which parameters to configure
@@ -150,13 +202,18 @@ const (
 // ConfSome configures the serial port using some of the parameters in
 // the Conf structure, based on the value of the flags argument.
 func (p *Port) ConfSome(conf Conf, flags ConfFlags) error {
-	return p.port.confSome(conf, flags)
+	err := p.impl.confSome(conf, flags)
+	if t := p.tracer(); t != nil {
+		t.Trace(&TraceEvent{Time: time.Now(), Port: p.Name,
+			Op: TraceConf, Err: err, Conf: conf, Flags: flags})
+	}
+	return err
 }
 
 // Conf configures the serial port using the parameters in the Conf
 // structure
 func (p *Port) Conf(conf Conf) error {
-	return p.port.confSome(conf, ConfAll)
+	return p.ConfSome(conf, ConfAll)
 }
 
 // Read is compatible with the Read method of the io.Reader
@@ -165,7 +222,12 @@ func (p *Port) Conf(conf Conf) error {
 // before the timeout expires Read returns with err == ErrTimeout (and
 // n == 0).
 func (p *Port) Read(b []byte) (n int, err error) {
-	return p.port.read(b)
+	n, err = p.impl.read(b)
+	if t := p.tracer(); t != nil {
+		t.Trace(&TraceEvent{Time: time.Now(), Port: p.Name,
+			Op: TraceRead, Err: err, Data: b[:n], N: n})
+	}
+	return n, err
 }
 
 // Write is compatible with the Write method of the io.Writer
@@ -174,7 +236,12 @@ func (p *Port) Read(b []byte) (n int, err error) {
 // data are writen before the timeout expires Write returns with err
 // == ErrTimeout (and n < len(p)).
 func (p *Port) Write(b []byte) (n int, err error) {
-	return p.port.write(b)
+	n, err = p.impl.write(b)
+	if t := p.tracer(); t != nil {
+		t.Trace(&TraceEvent{Time: time.Now(), Port: p.Name,
+			Op: TraceWrite, Err: err, Data: b[:n], N: n})
+	}
+	return n, err
 }
 
 // SetDeadline sets the deadline for both Read and Write operations on
@@ -191,19 +258,19 @@ func (p *Port) Write(b []byte) (n int, err error) {
 // A zero value for t, cancels (removes) the existing deadline.
 //
 func (p *Port) SetDeadline(t time.Time) error {
-	return p.port.setDeadline(t)
+	return p.impl.setDeadline(t)
 }
 
 // SetReadDeadline sets the deadline for Read operations. See also
 // SetDeadline.
 func (p *Port) SetReadDeadline(t time.Time) error {
-	return p.port.setReadDeadline(t)
+	return p.impl.setReadDeadline(t)
 }
 
 // SetWriteDeadline sets the deadline for Write operations. See also
 // SetDeadline.
 func (p *Port) SetWriteDeadline(t time.Time) error {
-	return p.port.setWriteDeadline(t)
+	return p.impl.setWriteDeadline(t)
 }
 
 type flushSel int
@@ -217,17 +284,107 @@ const (
 // Flush discards any unread data in the serial port's receive
 // buffers, as well as any unsent data in the transmit buffers.
 func (p *Port) Flush() error {
-	return p.port.flush(flushInOut)
+	err := p.impl.flush(flushInOut)
+	p.traceErr(TraceFlush, err)
+	return err
 }
 
 // FlushIn discards any unread data in the serial port's receive
 // buffers.
 func (p *Port) FlushIn() error {
-	return p.port.flush(flushIn)
+	err := p.impl.flush(flushIn)
+	p.traceErr(TraceFlushIn, err)
+	return err
 }
 
 // FlushOut discards any unsent data in the serial port's transmit
 // buffers.
 func (p *Port) FlushOut() error {
-	return p.port.flush(flushOut)
+	err := p.impl.flush(flushOut)
+	p.traceErr(TraceFlushOut, err)
+	return err
+}
+
+// SendBreak sends a break signal (a continuous stream of zero bits)
+// lasting between 0.25 and 0.5 seconds.
+func (p *Port) SendBreak() error {
+	err := p.impl.sendBreak()
+	p.traceErr(TraceBreak, err)
+	return err
+}
+
+// ModemLines is a bitmask encoding the state of the modem-control
+// (output) and modem-status (input) lines.
+type ModemLines int
+
+const (
+	ModemDTR ModemLines = 1 << iota // Data Terminal Ready (output)
+	ModemRTS                        // Request To Send (output)
+	ModemCTS                        // Clear To Send (input)
+	ModemDSR                        // Data Set Ready (input)
+	ModemDCD                        // Data Carrier Detect (input)
+	ModemRI                         // Ring Indicator (input)
+)
+
+var modemLinesStr = [...]string{
+	"DTR", "RTS", "CTS", "DSR", "DCD", "RI",
+}
+
+func (m ModemLines) String() string {
+	if m == 0 {
+		return "0"
+	}
+	s := ""
+	for i, n := range modemLinesStr {
+		if m&(1<<uint(i)) != 0 {
+			if s != "" {
+				s += "|"
+			}
+			s += n
+		}
+	}
+	return s
+}
+
+// GetModem returns the state of the serial port's modem lines. Lines
+// that are asserted have their respective bits set. For devices that
+// have no modem lines (e.g. ptys), GetModem and SetModem return
+// ErrUnsupported.
+func (p *Port) GetModem() (ModemLines, error) {
+	return p.impl.getModem()
+}
+
+// SetModem asserts or de-asserts the modem-control (output) lines
+// selected by mask, according to the respective bits in
+// lines. Output lines not selected by mask are not affected. Only
+// ModemDTR and ModemRTS may be set in mask.
+func (p *Port) SetModem(lines, mask ModemLines) error {
+	err := p.impl.setModem(lines, mask)
+	if t := p.tracer(); t != nil {
+		t.Trace(&TraceEvent{Time: time.Now(), Port: p.Name,
+			Op: TraceSetModem, Err: err, Lines: lines, Mask: mask})
+	}
+	return err
+}
+
+// Counters are the serial port's error and interrupt counters, as
+// maintained by the system's serial driver. Counters are cumulative;
+// to detect events compare the values returned by successive calls to
+// Port.GetCounters.
+type Counters struct {
+	Rx, Tx     int // Number of characters received and transmitted
+	Frame      int // Framing errors
+	Overrun    int // Hardware overrun errors
+	Parity     int // Parity errors
+	Break      int // Breaks received
+	BufOverrun int // Receive-buffer overrun errors
+	CTS, DSR   int // Modem-status line transitions
+	DCD, RI    int
+}
+
+// GetCounters returns the serial port's error and interrupt
+// counters. On systems (or for devices) that do not maintain such
+// counters, GetCounters returns ErrUnsupported.
+func (p *Port) GetCounters() (Counters, error) {
+	return p.impl.getCounters()
 }
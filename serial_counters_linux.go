@@ -0,0 +1,58 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// +build linux
+
+// Error and interrupt counters for Linux, using the TIOCGICOUNT
+// ioctl.
+
+package serial
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// icounter mirrors the Linux kernel's struct serial_icounter_struct
+type icounter struct {
+	cts, dsr, rng, dcd int32
+	rx, tx             int32
+	frame, overrun     int32
+	parity, brk        int32
+	bufOverrun         int32
+	reserved           [9]int32
+}
+
+func (p *port) getCounters() (Counters, error) {
+	var ic icounter
+
+	if err := p.fd.Lock(); err != nil {
+		return Counters{}, ErrClosed
+	}
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(p.fd.Sysfd()),
+		uintptr(unix.TIOCGICOUNT), uintptr(unsafe.Pointer(&ic)))
+	p.fd.Unlock()
+	if errno != 0 {
+		if errno == unix.EINVAL || errno == unix.ENOTTY {
+			// Driver does not maintain counters (e.g. pty)
+			return Counters{}, ErrUnsupported
+		}
+		return Counters{}, newErr("tiocgicount: " + errno.Error())
+	}
+	return Counters{
+		Rx:         int(ic.rx),
+		Tx:         int(ic.tx),
+		Frame:      int(ic.frame),
+		Overrun:    int(ic.overrun),
+		Parity:     int(ic.parity),
+		Break:      int(ic.brk),
+		BufOverrun: int(ic.bufOverrun),
+		CTS:        int(ic.cts),
+		DSR:        int(ic.dsr),
+		DCD:        int(ic.dcd),
+		RI:         int(ic.rng),
+	}, nil
+}
@@ -0,0 +1,18 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// +build freebsd netbsd openbsd darwin dragonfly solaris
+
+// Error and interrupt counters are not available on these systems.
+
+package serial
+
+func (p *port) getCounters() (Counters, error) {
+	if err := p.fd.Lock(); err != nil {
+		return Counters{}, ErrClosed
+	}
+	p.fd.Unlock()
+	return Counters{}, ErrUnsupported
+}
@@ -17,6 +17,7 @@ import (
 
 	"github.com/npat-efault/poller"
 	"github.com/npat-efault/serial/termios"
+	"golang.org/x/sys/unix"
 )
 
 type port struct {
@@ -323,7 +324,7 @@ func (p *port) setReadDeadline(t time.Time) error {
 }
 
 func (p *port) setWriteDeadline(t time.Time) error {
-	err := p.fd.SetReadDeadline(t)
+	err := p.fd.SetWriteDeadline(t)
 	if err == poller.ErrClosed {
 		err = ErrClosed
 	}
@@ -353,3 +354,91 @@ func (p *port) flush(q flushSel) error {
 	}
 	return nil
 }
+
+func (p *port) sendBreak() error {
+	if err := p.fd.Lock(); err != nil {
+		return ErrClosed
+	}
+	defer p.fd.Unlock()
+	err := termios.SendBreak(p.fd.Sysfd())
+	if err != nil {
+		return newErr("tcsendbreak: " + err.Error())
+	}
+	return nil
+}
+
+// Mapping between ModemLines bits and TIOCM_xxx bits
+var modemBits = []struct {
+	m ModemLines
+	t int
+}{
+	{ModemDTR, unix.TIOCM_DTR},
+	{ModemRTS, unix.TIOCM_RTS},
+	{ModemCTS, unix.TIOCM_CTS},
+	{ModemDSR, unix.TIOCM_DSR},
+	{ModemDCD, unix.TIOCM_CD},
+	{ModemRI, unix.TIOCM_RI},
+}
+
+func (p *port) getModem() (ModemLines, error) {
+	if err := p.fd.Lock(); err != nil {
+		return 0, ErrClosed
+	}
+	defer p.fd.Unlock()
+	t, err := unix.IoctlGetInt(p.fd.Sysfd(), unix.TIOCMGET)
+	if err != nil {
+		if err == unix.EINVAL || err == unix.ENOTTY {
+			// Device has no modem lines (e.g. pty)
+			return 0, ErrUnsupported
+		}
+		return 0, newErr("tiocmget: " + err.Error())
+	}
+	var m ModemLines
+	for _, b := range modemBits {
+		if t&b.t != 0 {
+			m |= b.m
+		}
+	}
+	return m, nil
+}
+
+func (p *port) setModem(lines, mask ModemLines) error {
+	if mask&^(ModemDTR|ModemRTS) != 0 {
+		return newErr("invalid modem-lines mask: " + mask.String())
+	}
+	var set, clr int
+	for _, b := range modemBits {
+		if mask&b.m == 0 {
+			continue
+		}
+		if lines&b.m != 0 {
+			set |= b.t
+		} else {
+			clr |= b.t
+		}
+	}
+
+	if err := p.fd.Lock(); err != nil {
+		return ErrClosed
+	}
+	defer p.fd.Unlock()
+	if set != 0 {
+		err := unix.IoctlSetPointerInt(p.fd.Sysfd(), unix.TIOCMBIS, set)
+		if err == unix.EINVAL || err == unix.ENOTTY {
+			return ErrUnsupported
+		}
+		if err != nil {
+			return newErr("tiocmbis: " + err.Error())
+		}
+	}
+	if clr != 0 {
+		err := unix.IoctlSetPointerInt(p.fd.Sysfd(), unix.TIOCMBIC, clr)
+		if err == unix.EINVAL || err == unix.ENOTTY {
+			return ErrUnsupported
+		}
+		if err != nil {
+			return newErr("tiocmbic: " + err.Error())
+		}
+	}
+	return nil
+}
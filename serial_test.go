@@ -8,6 +8,7 @@ package serial
 import (
 	"os"
 	"testing"
+	"time"
 )
 
 var dev = os.Getenv("TEST_SERIAL_DEV")
@@ -313,3 +314,133 @@ func TestNoReset(t *testing.T) {
 		t.Fatal("Close:", err)
 	}
 }
+
+func TestModem(t *testing.T) {
+	if dev == "" {
+		t.Skip("No TEST_SERIAL_DEV variable set.")
+	}
+	p, err := Open(dev)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	m0, err := p.GetModem()
+	if err != nil {
+		// Some devices (e.g. ptys) have no modem lines
+		t.Logf("GetModem: %v (OK?)", err)
+		p.Close()
+		return
+	}
+
+	for _, y := range []ModemLines{0, ModemDTR, ModemRTS,
+		ModemDTR | ModemRTS} {
+		err := p.SetModem(y, ModemDTR|ModemRTS)
+		if err != nil {
+			t.Fatalf("SetModem %v: %v", y, err)
+		}
+		m, err := p.GetModem()
+		if err != nil {
+			t.Fatalf("GetModem %v: %v", y, err)
+		}
+		if m&(ModemDTR|ModemRTS) != y {
+			t.Fatalf("Modem: %v != %v", m&(ModemDTR|ModemRTS), y)
+		}
+	}
+	if err := p.SetModem(0, ModemCTS); err == nil {
+		t.Fatal("SetModem: input line accepted")
+	}
+	err = p.SetModem(m0, ModemDTR|ModemRTS)
+	if err != nil {
+		t.Fatal("SetModem:", err)
+	}
+
+	err = p.Close()
+	if err != nil {
+		t.Fatal("Close:", err)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	if dev == "" {
+		t.Skip("No TEST_SERIAL_DEV variable set.")
+	}
+	p, err := Open(dev)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	_, err = p.GetCounters()
+	if err != nil {
+		if err != ErrUnsupported {
+			t.Fatal("GetCounters:", err)
+		}
+		t.Logf("GetCounters: %v (OK?)", err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal("Close:", err)
+	}
+}
+
+func TestSendBreak(t *testing.T) {
+	if dev == "" {
+		t.Skip("No TEST_SERIAL_DEV variable set.")
+	}
+	p, err := Open(dev)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	err = p.SendBreak()
+	if err != nil {
+		t.Fatal("SendBreak:", err)
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal("Close:", err)
+	}
+	if err := p.SendBreak(); err != ErrClosed {
+		t.Fatal("SendBreak on closed port:", err)
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	if dev == "" {
+		t.Skip("No TEST_SERIAL_DEV variable set.")
+	}
+	p, err := Open(dev)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	// Setting (or clearing) the write deadline must not affect
+	// the read deadline.
+	err = p.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if err != nil {
+		t.Fatal("SetReadDeadline:", err)
+	}
+	err = p.SetWriteDeadline(time.Time{})
+	if err != nil {
+		t.Fatal("SetWriteDeadline:", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		b := make([]byte, 256)
+		for {
+			_, err := p.Read(b)
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+	}()
+	select {
+	case err := <-done:
+		if err != ErrTimeout {
+			t.Fatal("Read:", err)
+		}
+	case <-time.After(2 * time.Second):
+		p.Close()
+		t.Fatal("Read deadline cleared by SetWriteDeadline")
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal("Close:", err)
+	}
+}
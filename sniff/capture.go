@@ -0,0 +1,171 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Capture files.
+
+package sniff
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// timeFormat is the format of capture-file timestamps
+const timeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+const captureHeader = "# sniff capture\n"
+
+// ErrFormat is returned by Reader.Next for malformed capture-file
+// lines.
+var ErrFormat = errors.New("sniff: malformed capture file")
+
+// Writer is a Recorder that writes events to a capture file
+type Writer struct {
+	w      *bufio.Writer
+	header bool
+}
+
+// NewWriter returns a Writer that writes events to w
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// Record writes event e to the capture file. Every event is flushed
+// to the underlying writer before Record returns.
+func (w *Writer) Record(e *Event) error {
+	if !w.header {
+		w.w.WriteString(captureHeader)
+		w.header = true
+	}
+	w.w.WriteString(e.Time.Format(timeFormat))
+	w.w.WriteByte(' ')
+	w.w.WriteString(e.Dir.String())
+	w.w.WriteByte(' ')
+	w.w.WriteString(e.Kind.String())
+	w.w.WriteByte(' ')
+	switch e.Kind {
+	case KindData:
+		for i, c := range e.Data {
+			if i > 0 {
+				w.w.WriteByte(' ')
+			}
+			fmt.Fprintf(w.w, "%02x", c)
+		}
+	case KindModem:
+		w.w.WriteString(e.Modem.String())
+	}
+	w.w.WriteByte('\n')
+	return w.w.Flush()
+}
+
+// Reader reads events from a capture file
+type Reader struct {
+	s    *bufio.Scanner
+	line int
+}
+
+// NewReader returns a Reader that reads events from r
+func NewReader(r io.Reader) *Reader {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	return &Reader{s: s}
+}
+
+// Next returns the next event from the capture file. At the end of
+// the file it returns io.EOF.
+func (r *Reader) Next() (*Event, error) {
+	for r.s.Scan() {
+		r.line++
+		l := strings.TrimSpace(r.s.Text())
+		if l == "" || l[0] == '#' {
+			continue
+		}
+		e, ok := parseEvent(l)
+		if !ok {
+			return nil, fmt.Errorf("%v: line %d", ErrFormat, r.line)
+		}
+		return e, nil
+	}
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+func parseEvent(l string) (*Event, bool) {
+	f := strings.SplitN(l, " ", 4)
+	if len(f) < 3 {
+		return nil, false
+	}
+	var e Event
+	var err error
+	if e.Time, err = time.Parse(time.RFC3339Nano, f[0]); err != nil {
+		return nil, false
+	}
+	switch f[1] {
+	case AtoB.String():
+		e.Dir = AtoB
+	case BtoA.String():
+		e.Dir = BtoA
+	default:
+		return nil, false
+	}
+	arg := ""
+	if len(f) == 4 {
+		arg = f[3]
+	}
+	switch f[2] {
+	case KindData.String():
+		e.Kind = KindData
+		e.Data, err = hex.DecodeString(strings.Replace(arg, " ", "", -1))
+		if err != nil {
+			return nil, false
+		}
+	case KindModem.String():
+		e.Kind = KindModem
+		var ok bool
+		if e.Modem, ok = parseModem(arg); !ok {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+	return &e, true
+}
+
+var modemLines = []serial.ModemLines{
+	serial.ModemDTR, serial.ModemRTS, serial.ModemCTS,
+	serial.ModemDSR, serial.ModemDCD, serial.ModemRI,
+}
+
+// parseModem parses modem lines in the form returned by
+// serial.ModemLines.String
+func parseModem(s string) (serial.ModemLines, bool) {
+	var m serial.ModemLines
+	if s == "0" {
+		return 0, true
+	}
+	for _, n := range strings.Split(s, "|") {
+		ok := false
+		for _, l := range modemLines {
+			if n == l.String() {
+				m |= l
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return 0, false
+		}
+	}
+	return m, true
+}
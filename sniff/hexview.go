@@ -0,0 +1,76 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Live hex / ASCII view.
+
+package sniff
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// hexViewWidth is the number of bytes per HexView line
+const hexViewWidth = 16
+
+// HexView is a Recorder that writes events to w in human-readable
+// form: data as hex / ASCII dumps, modem changes as line names. For
+// example:
+//
+//   10:20:30.123456 A>B  01 03 00 00 00 0a c5 cd                   |........|
+//   10:20:30.150012 B>A  modem CTS|DSR
+//
+// Events with more than 16 bytes of data span several lines.
+type HexView struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// NewHexView returns a HexView that writes to w
+func NewHexView(w io.Writer) *HexView {
+	return &HexView{w: w}
+}
+
+// Record writes event e to the view
+func (v *HexView) Record(e *Event) error {
+	b := &v.buf
+	b.Reset()
+	prefix := fmt.Sprintf("%s %s  ", e.Time.Format("15:04:05.000000"), e.Dir)
+	switch e.Kind {
+	case KindData:
+		d := e.Data
+		for len(d) > 0 {
+			n := len(d)
+			if n > hexViewWidth {
+				n = hexViewWidth
+			}
+			b.WriteString(prefix)
+			for i := 0; i < hexViewWidth; i++ {
+				if i < n {
+					fmt.Fprintf(b, "%02x ", d[i])
+				} else {
+					b.WriteString("   ")
+				}
+			}
+			b.WriteString(" |")
+			for _, c := range d[:n] {
+				if c < 0x20 || c > 0x7e {
+					c = '.'
+				}
+				b.WriteByte(c)
+			}
+			b.WriteString("|\n")
+			d = d[n:]
+		}
+	case KindModem:
+		b.WriteString(prefix)
+		b.WriteString("modem ")
+		b.WriteString(e.Modem.String())
+		b.WriteByte('\n')
+	}
+	_, err := b.WriteTo(v.w)
+	return err
+}
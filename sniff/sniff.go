@@ -0,0 +1,396 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package sniff implements a passive serial-line sniffer. A Sniffer
+// sits between two devices, connected to two serial ports (A and B),
+// transparently forwards data between them, and mirrors their
+// modem-control lines. Everything forwarded is reported to a
+// Recorder as timestamped Events. Recorders are provided for writing
+// capture files and for a live hex / ASCII view.
+//
+// The ports must be configured identically (see Open). Typical use:
+//
+//   pa, pb, err := sniff.Open("/dev/ttyUSB0", "/dev/ttyUSB1",
+//           conf, serial.ConfAll)
+//   ...
+//   s := &sniff.Sniffer{A: pa, B: pb,
+//           Recorder: sniff.NewHexView(os.Stdout)}
+//   err = s.Run()
+//
+// Capture files
+//
+// Capture files are text files with one event per line. Each line
+// has the form:
+//
+//   <time> <dir> data <hex bytes>
+//   <time> <dir> modem <lines>
+//
+// where <time> is in RFC 3339 form with microsecond resolution, <dir>
+// is "A>B" or "B>A", and <lines> is in the form returned by
+// serial.ModemLines.String. Lines starting with '#' are comments. For
+// example:
+//
+//   # sniff capture
+//   2015-06-01T10:20:30.123456Z A>B data 01 03 00 00 00 0a c5 cd
+//   2015-06-01T10:20:30.150012Z B>A modem CTS|DSR
+package sniff
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/npat-efault/serial"
+)
+
+// Port is the interface to the serial ports bridged by a Sniffer. It
+// is satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	GetModem() (serial.ModemLines, error)
+	SetModem(lines, mask serial.ModemLines) error
+}
+
+// Dir is the direction of an Event
+type Dir int
+
+const (
+	AtoB Dir = iota // From port A to port B
+	BtoA            // From port B to port A
+)
+
+var dirStr = [...]string{AtoB: "A>B", BtoA: "B>A"}
+
+func (d Dir) String() string {
+	if d >= 0 && int(d) < len(dirStr) {
+		return dirStr[d]
+	}
+	return fmt.Sprintf("Dir(%d)", d)
+}
+
+// Kind is the kind of an Event
+type Kind int
+
+const (
+	KindData  Kind = iota // Data forwarded
+	KindModem             // Modem-status lines changed
+)
+
+var kindStr = [...]string{KindData: "data", KindModem: "modem"}
+
+func (k Kind) String() string {
+	if k >= 0 && int(k) < len(kindStr) {
+		return kindStr[k]
+	}
+	return fmt.Sprintf("Kind(%d)", k)
+}
+
+// Event is something that happened on the sniffed line. For data
+// events, Dir is the direction the data were forwarded to. For modem
+// events, Dir is AtoB if the modem-status lines of port A changed
+// (and were mirrored to port B), and BtoA if those of port B changed.
+type Event struct {
+	Time  time.Time         // When the data were received
+	Kind  Kind              // Event kind
+	Dir   Dir               // Event direction
+	Data  []byte            // Data forwarded (KindData)
+	Modem serial.ModemLines // New state of the status lines (KindModem)
+}
+
+// Recorder is implemented by event sinks. Record is called for every
+// event, from a single goroutine. If it returns an error, the Sniffer
+// stops.
+type Recorder interface {
+	Record(e *Event) error
+}
+
+type multiRecorder []Recorder
+
+func (m multiRecorder) Record(e *Event) error {
+	for _, r := range m {
+		if err := r.Record(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MultiRecorder returns a Recorder that passes events to all the
+// recorders given, in order.
+func MultiRecorder(r ...Recorder) Recorder {
+	return multiRecorder(append([]Recorder(nil), r...))
+}
+
+// DefaultPollInterval is the default interval at which the sniffer
+// polls the ports for modem-status line changes.
+const DefaultPollInterval = 10 * time.Millisecond
+
+// statusLines are the modem-status (input) lines
+const statusLines = serial.ModemCTS | serial.ModemDSR | serial.ModemDCD |
+	serial.ModemRI
+
+// eventQueueLen is the number of events that can be queued for the
+// recorder before forwarding blocks.
+const eventQueueLen = 1024
+
+// Sniffer forwards data between ports A and B, and reports them to
+// Recorder. Forwarding happens as soon as data are received;
+// recording is decoupled from it, so that a slow recorder does not
+// delay forwarding (unless it falls behind considerably).
+//
+// Unless NoModem is set, the sniffer also mirrors the modem-status
+// lines of each port to the modem-control lines of the other, as a
+// null-modem cable would: CTS is mirrored to RTS, and DSR to DTR. DCD
+// and RI cannot be mirrored, but their changes are reported. Ports
+// that do not support modem lines (GetModem returns
+// serial.ErrUnsupported) are not mirrored.
+type Sniffer struct {
+	A, B         Port
+	Recorder     Recorder      // May be nil
+	PollInterval time.Duration // Zero means DefaultPollInterval
+	NoModem      bool          // Do not mirror modem lines
+
+	mu     sync.Mutex
+	quit   chan struct{}
+	closed bool
+}
+
+func (s *Sniffer) pollInterval() time.Duration {
+	if s.PollInterval <= 0 {
+		return DefaultPollInterval
+	}
+	return s.PollInterval
+}
+
+func (s *Sniffer) init() {
+	s.mu.Lock()
+	if s.quit == nil {
+		s.quit = make(chan struct{})
+	}
+	s.mu.Unlock()
+}
+
+func (s *Sniffer) stopped() bool {
+	select {
+	case <-s.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close stops the sniffer. It does not close the ports.
+func (s *Sniffer) Close() error {
+	s.init()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.quit)
+	}
+	return nil
+}
+
+// Run forwards data until the sniffer is closed, or an error occurs
+// (reading from, or writing to, the ports, or recording). It returns
+// nil if stopped by Close, or the first error encountered otherwise.
+func (s *Sniffer) Run() error {
+	s.init()
+	evc := make(chan *Event, eventQueueLen)
+	errc := make(chan error, 3)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errc <- s.forward(s.A, s.B, AtoB, evc)
+	}()
+	go func() {
+		defer wg.Done()
+		errc <- s.forward(s.B, s.A, BtoA, evc)
+	}()
+	go func() {
+		errc <- s.record(evc)
+	}()
+
+	// Stop everything when the first forwarder exits. The
+	// recorder exits after the forwarders, when evc is closed.
+	err := <-errc
+	s.Close()
+	wg.Wait()
+	close(evc)
+	for i := 0; i < 2; i++ {
+		if e := <-errc; err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// record passes events from evc to the recorder, until evc is
+// closed. After a recorder error, events are discarded.
+func (s *Sniffer) record(evc <-chan *Event) error {
+	var err error
+	for e := range evc {
+		if err != nil || s.Recorder == nil {
+			continue
+		}
+		if err = s.Recorder.Record(e); err != nil {
+			s.Close()
+		}
+	}
+	return err
+}
+
+// forward reads data from port src and writes them to port dst. It
+// also polls src for modem-status line changes and mirrors them to
+// dst.
+func (s *Sniffer) forward(src, dst Port, dir Dir, evc chan<- *Event) error {
+	defer src.SetReadDeadline(time.Time{})
+	defer dst.SetWriteDeadline(time.Time{})
+
+	poll := s.pollInterval()
+	mirror := !s.NoModem
+	var modem serial.ModemLines
+	var lastPoll time.Time
+	if mirror {
+		m, err := src.GetModem()
+		if err != nil {
+			if err != serial.ErrUnsupported {
+				return err
+			}
+			mirror = false
+		} else {
+			m &= statusLines
+			if err := s.mirror(dst, m); err != nil {
+				return err
+			}
+			modem, lastPoll = m, time.Now()
+			evc <- &Event{Time: lastPoll, Kind: KindModem,
+				Dir: dir, Modem: m}
+		}
+	}
+
+	buf := make([]byte, 4096)
+	for !s.stopped() {
+		src.SetReadDeadline(time.Now().Add(poll))
+		n, err := src.Read(buf)
+		if n > 0 {
+			t := time.Now()
+			if err := s.write(dst, buf[:n]); err != nil {
+				return err
+			}
+			evc <- &Event{Time: t, Kind: KindData, Dir: dir,
+				Data: append([]byte(nil), buf[:n]...)}
+		}
+		if err != nil && err != serial.ErrTimeout {
+			if s.stopped() {
+				return nil
+			}
+			return err
+		}
+		if mirror && time.Since(lastPoll) >= poll {
+			m, err := src.GetModem()
+			if err != nil {
+				return err
+			}
+			lastPoll = time.Now()
+			if m &= statusLines; m != modem {
+				if err := s.mirror(dst, m); err != nil {
+					return err
+				}
+				modem = m
+				evc <- &Event{Time: lastPoll, Kind: KindModem,
+					Dir: dir, Modem: m}
+			}
+		}
+	}
+	return nil
+}
+
+// mirror sets the modem-control lines of dst according to the
+// modem-status lines m of the other port.
+func (s *Sniffer) mirror(dst Port, m serial.ModemLines) error {
+	var l serial.ModemLines
+	if m&serial.ModemCTS != 0 {
+		l |= serial.ModemRTS
+	}
+	if m&serial.ModemDSR != 0 {
+		l |= serial.ModemDTR
+	}
+	err := dst.SetModem(l, serial.ModemRTS|serial.ModemDTR)
+	if err == serial.ErrUnsupported {
+		err = nil
+	}
+	return err
+}
+
+// write writes b to dst. It uses a write deadline so that it can
+// notice when the sniffer is stopped, even if dst is blocked by
+// flow-control.
+func (s *Sniffer) write(dst Port, b []byte) error {
+	for len(b) > 0 {
+		dst.SetWriteDeadline(time.Now().Add(s.pollInterval()))
+		n, err := dst.Write(b)
+		b = b[n:]
+		if err != nil && err != serial.ErrTimeout {
+			return err
+		}
+		if s.stopped() {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Open opens the serial ports named a and b, for use with a
+// Sniffer. It configures port a with the parameters in conf selected
+// by flags, and then configures port b identically to port a. The
+// NoReset setting is applied to both ports.
+func Open(a, b string, conf serial.Conf, flags serial.ConfFlags) (pa, pb *serial.Port, err error) {
+	pa, err = serial.Open(a)
+	if err != nil {
+		return nil, nil, err
+	}
+	pb, err = serial.Open(b)
+	if err != nil {
+		pa.Close()
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			pa.Close()
+			pb.Close()
+		}
+	}()
+	if flags != 0 {
+		if err = pa.ConfSome(conf, flags); err != nil {
+			return nil, nil, err
+		}
+	}
+	ca, err := pa.GetConf()
+	if err != nil {
+		return nil, nil, err
+	}
+	if flags&serial.ConfNoReset == 0 {
+		flags = serial.ConfAll &^ serial.ConfNoReset
+	} else {
+		flags = serial.ConfAll
+	}
+	if err = pb.ConfSome(ca, flags); err != nil {
+		return nil, nil, err
+	}
+	cb, err := pb.GetConf()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cb.NoReset = ca.NoReset; cb != ca {
+		return nil, nil, fmt.Errorf("%s: cannot configure as %s (%v); got %v",
+			b, a, ca, cb)
+	}
+	return pa, pb, nil
+}
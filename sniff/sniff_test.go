@@ -0,0 +1,283 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package sniff
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial"
+	"github.com/npat-efault/serial/internal/deadline"
+)
+
+// fakePort is an in-memory Port. Data sent with put are returned by
+// Read; data written are collected and returned by get. The state of
+// the modem-status lines is set with setModem.
+type fakePort struct {
+	in   chan []byte
+	rbuf []byte
+	rdl  *deadline.Deadline
+
+	mu    sync.Mutex
+	out   bytes.Buffer
+	modem serial.ModemLines
+}
+
+func newFakePort() *fakePort {
+	return &fakePort{in: make(chan []byte, 16), rdl: deadline.New()}
+}
+
+func (p *fakePort) put(b []byte) { p.in <- b }
+
+func (p *fakePort) get(n int) []byte {
+	for i := 0; i < 200; i++ {
+		p.mu.Lock()
+		if p.out.Len() >= n {
+			b := p.out.Next(n)
+			p.mu.Unlock()
+			return b
+		}
+		p.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nil
+}
+
+func (p *fakePort) Read(b []byte) (int, error) {
+	if len(p.rbuf) == 0 {
+		select {
+		case p.rbuf = <-p.in:
+		case <-p.rdl.Wait():
+			return 0, serial.ErrTimeout
+		}
+	}
+	n := copy(b, p.rbuf)
+	p.rbuf = p.rbuf[n:]
+	return n, nil
+}
+
+func (p *fakePort) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.out.Write(b)
+}
+
+func (p *fakePort) SetReadDeadline(t time.Time) error {
+	p.rdl.Set(t)
+	return nil
+}
+
+func (p *fakePort) SetWriteDeadline(t time.Time) error { return nil }
+
+func (p *fakePort) GetModem() (serial.ModemLines, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.modem, nil
+}
+
+func (p *fakePort) SetModem(lines, mask serial.ModemLines) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.modem = p.modem&^mask | lines&mask
+	return nil
+}
+
+func (p *fakePort) setModem(lines, mask serial.ModemLines) {
+	p.SetModem(lines, mask)
+}
+
+func (p *fakePort) getModem() serial.ModemLines {
+	m, _ := p.GetModem()
+	return m
+}
+
+// eventLog is a Recorder that collects events
+type eventLog struct {
+	mu sync.Mutex
+	ev []*Event
+}
+
+func (l *eventLog) Record(e *Event) error {
+	l.mu.Lock()
+	l.ev = append(l.ev, e)
+	l.mu.Unlock()
+	return nil
+}
+
+func (l *eventLog) events() []*Event {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]*Event(nil), l.ev...)
+}
+
+func startSniffer(t *testing.T, s *Sniffer) <-chan error {
+	errc := make(chan error, 1)
+	go func() { errc <- s.Run() }()
+	return errc
+}
+
+func stopSniffer(t *testing.T, s *Sniffer, errc <-chan error) {
+	s.Close()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("Run: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Run did not return after Close")
+	}
+}
+
+func TestForward(t *testing.T) {
+	a, b := newFakePort(), newFakePort()
+	var log eventLog
+	s := &Sniffer{A: a, B: b, Recorder: &log, NoModem: true}
+	errc := startSniffer(t, s)
+
+	t0 := time.Now()
+	a.put([]byte("hello"))
+	if d := b.get(5); string(d) != "hello" {
+		t.Fatalf("A>B: got %q", d)
+	}
+	b.put([]byte("world"))
+	if d := a.get(5); string(d) != "world" {
+		t.Fatalf("B>A: got %q", d)
+	}
+	stopSniffer(t, s, errc)
+
+	ev := log.events()
+	if len(ev) != 2 {
+		t.Fatalf("Got %d events, expected 2", len(ev))
+	}
+	if ev[0].Kind != KindData || ev[0].Dir != AtoB ||
+		string(ev[0].Data) != "hello" {
+		t.Fatalf("Bad event 0: %+v", ev[0])
+	}
+	if ev[1].Kind != KindData || ev[1].Dir != BtoA ||
+		string(ev[1].Data) != "world" {
+		t.Fatalf("Bad event 1: %+v", ev[1])
+	}
+	if ev[0].Time.Before(t0) || ev[1].Time.Before(ev[0].Time) {
+		t.Fatalf("Bad event times: %v, %v", ev[0].Time, ev[1].Time)
+	}
+}
+
+func TestModem(t *testing.T) {
+	a, b := newFakePort(), newFakePort()
+	a.setModem(serial.ModemCTS, serial.ModemCTS)
+	var log eventLog
+	s := &Sniffer{A: a, B: b, Recorder: &log,
+		PollInterval: 5 * time.Millisecond}
+	errc := startSniffer(t, s)
+
+	wait := func(p *fakePort, mask, want serial.ModemLines) {
+		for i := 0; i < 200; i++ {
+			if p.getModem()&mask == want {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("Modem lines: got %v, expected %v",
+			p.getModem()&mask, want)
+	}
+	out := serial.ModemRTS | serial.ModemDTR
+	// Initial state mirrored
+	wait(b, out, serial.ModemRTS)
+	wait(a, out, 0)
+	// Changes mirrored
+	a.setModem(serial.ModemDSR, serial.ModemCTS|serial.ModemDSR)
+	wait(b, out, serial.ModemDTR)
+	b.setModem(serial.ModemCTS|serial.ModemDCD,
+		serial.ModemCTS|serial.ModemDCD)
+	wait(a, out, serial.ModemRTS)
+	stopSniffer(t, s, errc)
+
+	var got []string
+	for _, e := range log.events() {
+		if e.Kind != KindModem {
+			t.Fatalf("Unexpected event: %+v", e)
+		}
+		got = append(got, e.Dir.String()+" "+e.Modem.String())
+	}
+	exp := []string{"A>B CTS", "B>A 0", "A>B DSR", "B>A CTS|DCD"}
+	// The two initial events may come in any order
+	if len(got) == len(exp) && got[0] == exp[1] {
+		got[0], got[1] = got[1], got[0]
+	}
+	if strings.Join(got, ", ") != strings.Join(exp, ", ") {
+		t.Fatalf("Events: got %v, expected %v", got, exp)
+	}
+}
+
+func TestCapture(t *testing.T) {
+	t0 := time.Date(2015, 6, 1, 10, 20, 30, 123456789, time.UTC)
+	ev := []*Event{
+		{Time: t0, Kind: KindData, Dir: AtoB,
+			Data: []byte{0x01, 0x03, 0x00, 0x0a, 0xc5, 0xcd}},
+		{Time: t0.Add(26 * time.Millisecond), Kind: KindModem,
+			Dir: BtoA, Modem: serial.ModemCTS | serial.ModemDSR},
+		{Time: t0.Add(30 * time.Millisecond), Kind: KindModem,
+			Dir: AtoB, Modem: 0},
+	}
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, e := range ev {
+		if err := w.Record(e); err != nil {
+			t.Fatalf("Record: %v", err)
+		}
+	}
+	exp := "# sniff capture\n" +
+		"2015-06-01T10:20:30.123456Z A>B data 01 03 00 0a c5 cd\n" +
+		"2015-06-01T10:20:30.149456Z B>A modem CTS|DSR\n" +
+		"2015-06-01T10:20:30.153456Z A>B modem 0\n"
+	if buf.String() != exp {
+		t.Fatalf("Capture:\n%s\nexpected:\n%s", buf.String(), exp)
+	}
+
+	r := NewReader(&buf)
+	for i, e := range ev {
+		re, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if !re.Time.Equal(e.Time.Truncate(time.Microsecond)) ||
+			re.Kind != e.Kind || re.Dir != e.Dir ||
+			!bytes.Equal(re.Data, e.Data) || re.Modem != e.Modem {
+			t.Fatalf("Event %d: got %+v, expected %+v", i, re, e)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("Next: got %v, expected EOF", err)
+	}
+
+	r = NewReader(strings.NewReader("2015-06-01T10:20:30Z A>B data zz\n"))
+	if _, err := r.Next(); err == nil {
+		t.Fatalf("Malformed line accepted")
+	}
+}
+
+func TestHexView(t *testing.T) {
+	t0 := time.Date(2015, 6, 1, 10, 20, 30, 123456000, time.UTC)
+	var buf bytes.Buffer
+	v := NewHexView(&buf)
+	v.Record(&Event{Time: t0, Kind: KindData, Dir: BtoA,
+		Data: []byte("0123456789abcdef\r\n")})
+	v.Record(&Event{Time: t0, Kind: KindModem, Dir: AtoB,
+		Modem: serial.ModemDCD})
+	exp := "10:20:30.123456 B>A  " +
+		"30 31 32 33 34 35 36 37 38 39 61 62 63 64 65 66  " +
+		"|0123456789abcdef|\n" +
+		"10:20:30.123456 B>A  0d 0a " + strings.Repeat("   ", 14) +
+		" |..|\n" +
+		"10:20:30.123456 A>B  modem DCD\n"
+	if buf.String() != exp {
+		t.Fatalf("HexView:\n%s\nexpected:\n%s", buf.String(), exp)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// Tracing of port operations.
+
+package serial
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TraceOp identifies the Port operation reported by a TraceEvent
+type TraceOp int
+
+const (
+	TraceRead     TraceOp = iota // Read
+	TraceWrite                   // Write
+	TraceConf                    // ConfSome, Conf
+	TraceFlush                   // Flush
+	TraceFlushIn                 // FlushIn
+	TraceFlushOut                // FlushOut
+	TraceBreak                   // SendBreak
+	TraceSetModem                // SetModem
+	TraceClose                   // Close
+)
+
+var traceOpStr = [...]string{
+	TraceRead: "read", TraceWrite: "write", TraceConf: "conf",
+	TraceFlush: "flush", TraceFlushIn: "flushin",
+	TraceFlushOut: "flushout", TraceBreak: "break",
+	TraceSetModem: "setmodem", TraceClose: "close",
+}
+
+func (op TraceOp) String() string {
+	if op >= 0 && int(op) < len(traceOpStr) {
+		return traceOpStr[op]
+	}
+	return "TraceOp(" + strconv.Itoa(int(op)) + ")"
+}
+
+// TraceEvent describes a completed Port operation. Only the fields
+// relevant to the operation are set.
+type TraceEvent struct {
+	Time  time.Time  // When the operation completed
+	Port  string     // Port name (Port.Name)
+	Op    TraceOp    // Operation
+	Err   error      // Error returned by the operation
+	Data  []byte     // TraceRead, TraceWrite: Data transferred
+	N     int        // TraceRead, TraceWrite: Bytes transferred
+	Conf  Conf       // TraceConf: Configuration requested
+	Flags ConfFlags  // TraceConf: Parameters requested
+	Lines ModemLines // TraceSetModem: Lines requested
+	Mask  ModemLines // TraceSetModem: Mask requested
+}
+
+// Tracer is implemented by receivers of Port trace events (see
+// Port.SetTracer). Trace is called synchronously, after each traced
+// operation completes, and possibly from several goroutines
+// concurrently. The event, and the data it points to, are only valid
+// during the call; tracers must copy whatever they want to keep.
+type Tracer interface {
+	Trace(e *TraceEvent)
+}
+
+// TracerFunc is an adapter that allows the use of ordinary functions
+// as Tracers.
+type TracerFunc func(e *TraceEvent)
+
+// Trace calls f(e)
+func (f TracerFunc) Trace(e *TraceEvent) { f(e) }
+
+// tracerBox allows storing Tracers of any type in an atomic.Value
+type tracerBox struct{ t Tracer }
+
+// SetTracer installs t as the port's tracer. From then on, t is
+// called after every Read and Write operation, as well as after
+// every configuration (ConfSome, Conf) and control (Flush, FlushIn,
+// FlushOut, SendBreak, SetModem, Close) operation. Operations that
+// only query the port (GetConf, GetModem, etc.) and deadline settings
+// are not traced. Calling SetTracer with a nil t, removes the
+// tracer. SetTracer can be called at any time, concurrently with
+// other operations.
+func (p *Port) SetTracer(t Tracer) {
+	p.tr.Store(tracerBox{t})
+}
+
+func (p *Port) tracer() Tracer {
+	b, _ := p.tr.Load().(tracerBox)
+	return b.t
+}
+
+// traceErr reports operation op, which returned err, to the port's
+// tracer (if any).
+func (p *Port) traceErr(op TraceOp, err error) {
+	if t := p.tracer(); t != nil {
+		t.Trace(&TraceEvent{Time: time.Now(), Port: p.Name,
+			Op: op, Err: err})
+	}
+}
+
+// hexTracer is the Tracer returned by NewHexTracer
+type hexTracer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewHexTracer returns a Tracer that writes trace events to w, in
+// human-readable form, with the data read and written as hex / ASCII
+// dumps. For example:
+//
+//   10:20:30.123456 /dev/ttyUSB0 conf 9600,8N1
+//   10:20:30.123502 /dev/ttyUSB0 write 5
+//   00000000  68 65 6c 6c 6f                                    |hello|
+//   10:20:31.123610 /dev/ttyUSB0 read 0: timeout
+//
+// Writes to w are serialized.
+func NewHexTracer(w io.Writer) Tracer {
+	return &hexTracer{w: w}
+}
+
+func (h *hexTracer) Trace(e *TraceEvent) {
+	s := e.Time.Format("15:04:05.000000") + " " + e.Port + " " +
+		e.Op.String()
+	switch e.Op {
+	case TraceRead, TraceWrite:
+		s += " " + strconv.Itoa(e.N)
+	case TraceConf:
+		s += " " + e.Conf.StringSome(e.Flags)
+	case TraceSetModem:
+		s += fmt.Sprintf(" %v/%v", e.Lines, e.Mask)
+	}
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	s += "\n"
+	if len(e.Data) > 0 {
+		s += hex.Dump(e.Data)
+	}
+	h.mu.Lock()
+	io.WriteString(h.w, s)
+	h.mu.Unlock()
+}
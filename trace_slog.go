@@ -0,0 +1,64 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// +build go1.21
+
+// Tracing to structured logs (log/slog).
+
+package serial
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+)
+
+// slogTracer is the Tracer returned by NewSlogTracer
+type slogTracer struct {
+	l     *slog.Logger
+	level slog.Level
+}
+
+// NewSlogTracer returns a Tracer that logs trace events to l, at the
+// given level. Each event is logged with message "serial <op>" (e.g.
+// "serial write") and attributes:
+//
+//   port   Port name
+//   n      Bytes transferred (read, write)
+//   data   Data transferred, hex-encoded (read, write)
+//   conf   Configuration requested, as by Conf.String (conf)
+//   lines  Modem lines requested (setmodem)
+//   mask   Modem lines mask (setmodem)
+//   err    Error returned by the operation (if any)
+//
+// The event time is used as the record time. Events are not formatted
+// if l is not enabled for level.
+func NewSlogTracer(l *slog.Logger, level slog.Level) Tracer {
+	return &slogTracer{l: l, level: level}
+}
+
+func (s *slogTracer) Trace(e *TraceEvent) {
+	ctx := context.Background()
+	h := s.l.Handler()
+	if !h.Enabled(ctx, s.level) {
+		return
+	}
+	r := slog.NewRecord(e.Time, s.level, "serial "+e.Op.String(), 0)
+	r.AddAttrs(slog.String("port", e.Port))
+	switch e.Op {
+	case TraceRead, TraceWrite:
+		r.AddAttrs(slog.Int("n", e.N),
+			slog.String("data", hex.EncodeToString(e.Data)))
+	case TraceConf:
+		r.AddAttrs(slog.String("conf", e.Conf.StringSome(e.Flags)))
+	case TraceSetModem:
+		r.AddAttrs(slog.String("lines", e.Lines.String()),
+			slog.String("mask", e.Mask.String()))
+	}
+	if e.Err != nil {
+		r.AddAttrs(slog.String("err", e.Err.Error()))
+	}
+	h.Handle(ctx, r)
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// +build go1.21
+
+package serial
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestSlogTracer(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+	}))
+	tr := NewSlogTracer(l, slog.LevelDebug)
+	t0 := time.Date(2015, 6, 1, 10, 20, 30, 0, time.UTC)
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceWrite,
+		Data: []byte{0x01, 0xff}, N: 2})
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceSetModem,
+		Lines: ModemRTS, Mask: ModemRTS, Err: ErrClosed})
+	exp := `time=2015-06-01T10:20:30.000Z level=DEBUG ` +
+		`msg="serial write" port=p n=2 data=01ff` + "\n" +
+		`time=2015-06-01T10:20:30.000Z level=DEBUG ` +
+		`msg="serial setmodem" port=p lines=RTS mask=RTS ` +
+		`err="` + ErrClosed.Error() + `"` + "\n"
+	if buf.String() != exp {
+		t.Fatalf("Log:\n%s\nexpected:\n%s", buf.String(), exp)
+	}
+
+	buf.Reset()
+	tr = NewSlogTracer(l, slog.LevelDebug-1)
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceClose})
+	if buf.Len() != 0 {
+		t.Fatalf("Logged below handler level: %s", buf.String())
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+package serial
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTrace(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer ln.Close()
+	go echoServer(ln)
+	p, err := Open("tcp://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+
+	var mu sync.Mutex
+	var ev []TraceEvent
+	p.SetTracer(TracerFunc(func(e *TraceEvent) {
+		c := *e
+		c.Data = append([]byte(nil), e.Data...)
+		mu.Lock()
+		ev = append(ev, c)
+		mu.Unlock()
+	}))
+
+	p.Write([]byte("hello"))
+	p.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 5)
+	io.ReadFull(p, b)
+	p.ConfSome(Conf{Baudrate: 9600}, ConfBaudrate)
+	p.GetConf() // Not traced
+	p.FlushIn()
+	p.SetModem(ModemDTR, ModemDTR|ModemRTS)
+	p.Close()
+	p.SetTracer(nil)
+	p.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	var ops []string
+	var rx []byte
+	for _, e := range ev {
+		if e.Port != p.Name || e.Time.IsZero() {
+			t.Fatalf("Bad event: %+v", e)
+		}
+		if e.Op == TraceRead {
+			// Reads may be split
+			rx = append(rx, e.Data...)
+			if len(ops) > 0 && ops[len(ops)-1] == "read" {
+				continue
+			}
+		}
+		ops = append(ops, e.Op.String())
+		switch e.Op {
+		case TraceWrite:
+			if e.N != 5 || string(e.Data) != "hello" || e.Err != nil {
+				t.Fatalf("Bad write event: %+v", e)
+			}
+		case TraceConf:
+			if e.Conf.Baudrate != 9600 || e.Flags != ConfBaudrate ||
+				e.Err != ErrUnsupported {
+				t.Fatalf("Bad conf event: %+v", e)
+			}
+		case TraceSetModem:
+			if e.Lines != ModemDTR || e.Mask != ModemDTR|ModemRTS {
+				t.Fatalf("Bad setmodem event: %+v", e)
+			}
+		}
+	}
+	if string(rx) != "hello" {
+		t.Fatalf("Traced reads: %q", rx)
+	}
+	exp := "write read conf flushin setmodem close"
+	if s := strings.Join(ops, " "); s != exp {
+		t.Fatalf("Traced ops: %q != %q", s, exp)
+	}
+}
+
+func TestHexTracer(t *testing.T) {
+	var buf bytes.Buffer
+	tr := NewHexTracer(&buf)
+	t0 := time.Date(2015, 6, 1, 10, 20, 30, 123456000, time.UTC)
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceConf,
+		Conf: Conf{Baudrate: 9600, Databits: 8, Stopbits: 1},
+		Flags: ConfBaudrate | ConfFormat})
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceWrite,
+		Data: []byte("hello"), N: 5})
+	tr.Trace(&TraceEvent{Time: t0, Port: "p", Op: TraceRead,
+		Err: ErrTimeout})
+	exp := "10:20:30.123456 p conf 9600,8N1\n" +
+		"10:20:30.123456 p write 5\n" +
+		"00000000  68 65 6c 6c 6f" + strings.Repeat(" ", 36) +
+		"|hello|\n" +
+		"10:20:30.123456 p read 0: " + ErrTimeout.Error() + "\n"
+	if buf.String() != exp {
+		t.Fatalf("Hex trace:\n%s\nexpected:\n%s", buf.String(), exp)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// Support for alternative (non-local) serial-port transports, and the
+// registry used to select them by URL scheme.
+
+package serial
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport is the interface implemented by alternative serial-port
+// transports, such as serial ports accessed over network
+// connections. A Transport can be used wherever a Port can, by
+// wrapping it with NewPort, or by registering it (see Register) and
+// opening it by URL with Open.
+//
+// The semantics of Transport methods are identical to the respective
+// Port methods. In particular: Read and Write must honor the
+// deadlines and return ErrTimeout when they expire, Close must cancel
+// blocked Read and Write operations (which then return ErrClosed),
+// and operations the transport cannot carry out (e.g. configuring the
+// baudrate of a raw TCP connection) must return ErrUnsupported.
+//
+// If the Transport also has a method:
+//
+//   GetCounters() (Counters, error)
+//
+// it is used by Port.GetCounters. Otherwise Port.GetCounters returns
+// ErrUnsupported.
+type Transport interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	Close() error
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+	GetConf() (conf Conf, err error)
+	ConfSome(conf Conf, flags ConfFlags) error
+	Flush() error
+	FlushIn() error
+	FlushOut() error
+	SendBreak() error
+	GetModem() (ModemLines, error)
+	SetModem(lines, mask ModemLines) error
+}
+
+// OpenFunc is the type of the functions registered with Register for
+// opening transports. Argument name is the complete name (URL) passed
+// to Open.
+type OpenFunc func(name string) (Transport, error)
+
+var registry = struct {
+	sync.Mutex
+	m map[string]OpenFunc
+}{m: map[string]OpenFunc{
+	"tcp":  openTCP,
+	"unix": openUnix,
+}}
+
+// Register makes a transport available by URL scheme. After
+// registration, calling Open with a name of the form
+// "scheme://address" calls open to open the transport. Packages
+// implementing transports usually register them in their init
+// functions. Registering a scheme twice replaces the previous
+// registration.
+func Register(scheme string, open OpenFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.m[strings.ToLower(scheme)] = open
+}
+
+// Schemes returns the URL schemes for which transports are
+// registered.
+func Schemes() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	s := make([]string, 0, len(registry.m))
+	for k := range registry.m {
+		s = append(s, k)
+	}
+	return s
+}
+
+// SplitURL splits a transport URL of the form "scheme://address" to
+// its scheme and address parts. If name is not of this form, ok is
+// false. It can be used by OpenFuncs to extract the address.
+func SplitURL(name string) (scheme, addr string, ok bool) {
+	i := strings.Index(name, "://")
+	if i <= 0 {
+		return "", "", false
+	}
+	scheme = name[:i]
+	for _, c := range scheme {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+			'0' <= c && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return "", "", false
+		}
+	}
+	return scheme, name[i+3:], true
+}
+
+func openURL(scheme, name string) (*Port, error) {
+	registry.Lock()
+	open := registry.m[strings.ToLower(scheme)]
+	registry.Unlock()
+	if open == nil {
+		return nil, newErr("open: unknown transport: " + scheme)
+	}
+	t, err := open(name)
+	if err != nil {
+		return nil, err
+	}
+	return NewPort(name, t), nil
+}
+
+// NewPort returns a Port that uses Transport t for all its
+// operations. Argument name becomes the Port's Name. Closing the Port
+// closes the Transport.
+func NewPort(name string, t Transport) *Port {
+	return &Port{Name: name, impl: transport{t}}
+}
+
+// transport adapts a Transport to the internal impl interface
+type transport struct {
+	t Transport
+}
+
+func (t transport) close() error { return t.t.Close() }
+
+func (t transport) getConf() (Conf, error) { return t.t.GetConf() }
+
+func (t transport) confSome(conf Conf, flags ConfFlags) error {
+	return t.t.ConfSome(conf, flags)
+}
+
+func (t transport) read(b []byte) (int, error) { return t.t.Read(b) }
+
+func (t transport) write(b []byte) (int, error) { return t.t.Write(b) }
+
+func (t transport) setDeadline(d time.Time) error {
+	if err := t.t.SetReadDeadline(d); err != nil {
+		return err
+	}
+	return t.t.SetWriteDeadline(d)
+}
+
+func (t transport) setReadDeadline(d time.Time) error {
+	return t.t.SetReadDeadline(d)
+}
+
+func (t transport) setWriteDeadline(d time.Time) error {
+	return t.t.SetWriteDeadline(d)
+}
+
+func (t transport) flush(q flushSel) error {
+	switch q {
+	case flushIn:
+		return t.t.FlushIn()
+	case flushOut:
+		return t.t.FlushOut()
+	case flushInOut:
+		return t.t.Flush()
+	default:
+		return newErr("invalid flush selector")
+	}
+}
+
+func (t transport) sendBreak() error { return t.t.SendBreak() }
+
+func (t transport) getModem() (ModemLines, error) { return t.t.GetModem() }
+
+func (t transport) setModem(lines, mask ModemLines) error {
+	return t.t.SetModem(lines, mask)
+}
+
+func (t transport) getCounters() (Counters, error) {
+	if c, ok := t.t.(interface {
+		GetCounters() (Counters, error)
+	}); ok {
+		return c.GetCounters()
+	}
+	return Counters{}, ErrUnsupported
+}
@@ -0,0 +1,134 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+// Transports for serial ports exposed as raw TCP or Unix-domain
+// sockets (e.g. QEMU character devices, or ser2net in raw mode).
+
+package serial
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// dialTimeout limits the time spent connecting socket transports
+const dialTimeout = 10 * time.Second
+
+func openTCP(name string) (Transport, error) {
+	_, addr, _ := SplitURL(name)
+	return dialSock("tcp", addr)
+}
+
+func openUnix(name string) (Transport, error) {
+	_, addr, _ := SplitURL(name)
+	return dialSock("unix", addr)
+}
+
+func dialSock(network, addr string) (Transport, error) {
+	conn, err := net.DialTimeout(network, addr, dialTimeout)
+	if err != nil {
+		return nil, newErr("open: " + err.Error())
+	}
+	return &sockTransport{conn: conn}, nil
+}
+
+// sockTransport is a Transport over a stream socket. Since there is no
+// serial port configuration to speak of, all configuration and control
+// operations return ErrUnsupported.
+type sockTransport struct {
+	conn   net.Conn
+	mu     sync.Mutex
+	closed bool
+}
+
+func (s *sockTransport) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// mapErr maps errors returned by conn to the respective package
+// errors.
+func (s *sockTransport) mapErr(err error) error {
+	if err == nil {
+		return nil
+	}
+	if s.isClosed() {
+		return ErrClosed
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return ErrTimeout
+	}
+	return err
+}
+
+func (s *sockTransport) Read(b []byte) (n int, err error) {
+	n, err = s.conn.Read(b)
+	if err == io.EOF {
+		return n, ErrEOF
+	}
+	return n, s.mapErr(err)
+}
+
+func (s *sockTransport) Write(b []byte) (n int, err error) {
+	n, err = s.conn.Write(b)
+	return n, s.mapErr(err)
+}
+
+func (s *sockTransport) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return ErrClosed
+	}
+	s.closed = true
+	s.mu.Unlock()
+	err := s.conn.Close()
+	if err != nil {
+		return newErr("close: " + err.Error())
+	}
+	return nil
+}
+
+func (s *sockTransport) SetReadDeadline(t time.Time) error {
+	return s.mapErr(s.conn.SetReadDeadline(t))
+}
+
+func (s *sockTransport) SetWriteDeadline(t time.Time) error {
+	return s.mapErr(s.conn.SetWriteDeadline(t))
+}
+
+// unsupported returns ErrClosed if the transport is closed, or
+// ErrUnsupported otherwise.
+func (s *sockTransport) unsupported() error {
+	if s.isClosed() {
+		return ErrClosed
+	}
+	return ErrUnsupported
+}
+
+func (s *sockTransport) GetConf() (Conf, error) {
+	return Conf{}, s.unsupported()
+}
+
+func (s *sockTransport) ConfSome(conf Conf, flags ConfFlags) error {
+	return s.unsupported()
+}
+
+func (s *sockTransport) Flush() error    { return s.unsupported() }
+func (s *sockTransport) FlushIn() error  { return s.unsupported() }
+func (s *sockTransport) FlushOut() error { return s.unsupported() }
+
+func (s *sockTransport) SendBreak() error { return s.unsupported() }
+
+func (s *sockTransport) GetModem() (ModemLines, error) {
+	return 0, s.unsupported()
+}
+
+func (s *sockTransport) SetModem(lines, mask ModemLines) error {
+	return s.unsupported()
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE.txt file.
+
+package serial
+
+import (
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// echoServer accepts a single connection on ln and echoes back
+// everything it receives.
+func echoServer(ln net.Listener) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	io.Copy(conn, conn)
+	conn.Close()
+}
+
+func testSocket(t *testing.T, name string) {
+	p, err := Open(name)
+	if err != nil {
+		t.Fatal("Open:", err)
+	}
+	if p.Name != name {
+		t.Fatalf("Name: %q != %q", p.Name, name)
+	}
+
+	msg := []byte("hello\x00\xff")
+	if _, err := p.Write(msg); err != nil {
+		t.Fatal("Write:", err)
+	}
+	p.SetReadDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, len(msg))
+	if _, err := io.ReadFull(p, b); err != nil {
+		t.Fatal("Read:", err)
+	}
+	if string(b) != string(msg) {
+		t.Fatalf("Read: %q != %q", b, msg)
+	}
+
+	p.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := p.Read(b); err != ErrTimeout {
+		t.Fatalf("Read: %v != %v", err, ErrTimeout)
+	}
+	p.SetReadDeadline(time.Time{})
+
+	if _, err := p.GetConf(); err != ErrUnsupported {
+		t.Fatalf("GetConf: %v != %v", err, ErrUnsupported)
+	}
+	if err := p.ConfSome(Conf{Baudrate: 9600},
+		ConfBaudrate); err != ErrUnsupported {
+		t.Fatalf("ConfSome: %v != %v", err, ErrUnsupported)
+	}
+	if _, err := p.GetModem(); err != ErrUnsupported {
+		t.Fatalf("GetModem: %v != %v", err, ErrUnsupported)
+	}
+	if _, err := p.GetCounters(); err != ErrUnsupported {
+		t.Fatalf("GetCounters: %v != %v", err, ErrUnsupported)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		p.Close()
+	}()
+	if _, err := p.Read(b); err != ErrClosed {
+		t.Fatalf("Read: %v != %v", err, ErrClosed)
+	}
+	if err := p.Close(); err != ErrClosed {
+		t.Fatalf("Close: %v != %v", err, ErrClosed)
+	}
+}
+
+func TestTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer ln.Close()
+	go echoServer(ln)
+	testSocket(t, "tcp://"+ln.Addr().String())
+}
+
+func TestUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	if err != nil {
+		t.Fatal("TempDir:", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal("Listen:", err)
+	}
+	defer ln.Close()
+	go echoServer(ln)
+	testSocket(t, "unix://"+path)
+}
+
+func TestRegister(t *testing.T) {
+	if _, err := Open("nosuch://x"); err == nil {
+		t.Fatal("Open: unknown scheme accepted")
+	}
+	var got string
+	Register("test", func(name string) (Transport, error) {
+		got = name
+		return nil, ErrUnsupported
+	})
+	if _, err := Open("TEST://addr"); err != ErrUnsupported {
+		t.Fatalf("Open: %v != %v", err, ErrUnsupported)
+	}
+	if got != "TEST://addr" {
+		t.Fatalf("OpenFunc name: %q", got)
+	}
+
+	for _, c := range []struct {
+		name, scheme, addr string
+		ok                 bool
+	}{
+		{"/dev/ttyS0", "", "", false},
+		{"tcp://host:23", "tcp", "host:23", true},
+		{"unix:///tmp/s", "unix", "/tmp/s", true},
+		{"/dev/odd://name", "", "", false},
+	} {
+		s, a, ok := SplitURL(c.name)
+		if s != c.scheme || a != c.addr || ok != c.ok {
+			t.Fatalf("SplitURL %q: %q, %q, %v", c.name, s, a, ok)
+		}
+	}
+}
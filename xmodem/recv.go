@@ -0,0 +1,159 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package xmodem
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Receive receives data with XMODEM, and writes them to w. Unless
+// Checksum is set, it requests CRCs, and falls back to checksums if
+// the sender does not respond. Blocks of any size are accepted. It
+// returns the number of bytes written to w, including the padding of
+// the last block.
+func (t *Transfer) Receive(ctx context.Context, w io.Writer) (int64,
+	error) {
+
+	c := t.newConn(ctx)
+	n, err := c.recvData(w, !t.Checksum, false, "", -1)
+	if err != nil {
+		c.abort(err)
+	}
+	return n, err
+}
+
+// recvBlock waits for a block, or an EOT, until timeout. It returns
+// the block's start character (SOH, STX, or EOT), number, and
+// contents. For blocks that are truncated, or fail the checks, it
+// returns errBadBlock.
+func (c *conn) recvBlock(crc bool, timeout time.Duration) (hdr, num byte,
+	data []byte, err error) {
+
+	dl := time.Now().Add(timeout)
+	for {
+		b, err := c.readByte(dl)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		switch b {
+		case EOT:
+			return EOT, 0, nil, nil
+		case CAN:
+			if ok, err := c.canceled(); ok || err != nil {
+				if err == nil {
+					err = ErrCanceled
+				}
+				return 0, 0, nil, err
+			}
+		case SOH, STX:
+			l := 128
+			if b == STX {
+				l = 1024
+			}
+			p := c.blk[:2+l+1]
+			if crc {
+				p = c.blk[:2+l+2]
+			}
+			err := c.readFull(p, time.Now().Add(c.t.timeout()))
+			if err == errTimeout {
+				return 0, 0, nil, errBadBlock
+			}
+			if err != nil {
+				return 0, 0, nil, err
+			}
+			data := p[2 : 2+l]
+			if crc {
+				v := xfer.CRC16(0, data)
+				if p[2+l] != byte(v>>8) || p[2+l+1] != byte(v) {
+					return 0, 0, nil, errBadBlock
+				}
+			} else if p[2+l] != sum(data) {
+				return 0, 0, nil, errBadBlock
+			}
+			if p[0] != ^p[1] {
+				return 0, 0, nil, errBadBlock
+			}
+			return b, p[0], data, nil
+		}
+	}
+}
+
+// recvData receives data blocks, numbered from 1, up to an EOT, and
+// writes them to w. If size is not negative, data beyond it are
+// discarded. The transfer is started by requesting CRCs, or
+// checksums. For YMODEM, only CRCs are used, and the first EOT is
+// NAKed, as the protocol requires.
+func (c *conn) recvData(w io.Writer, crc, ymodem bool, name string,
+	size int64) (int64, error) {
+
+	start := byte(CRC)
+	if !crc {
+		start = NAK
+	}
+	reply := start
+	started, eot := false, false
+	expect := byte(1)
+	errs := 0
+	var n int64
+	for {
+		if errs > c.t.retries() {
+			return n, ErrRetries
+		}
+		if err := c.write(reply); err != nil {
+			return n, err
+		}
+		timeout := c.t.timeout()
+		if !started && crc && !ymodem {
+			timeout = crcInterval
+		}
+		hdr, num, data, err := c.recvBlock(crc, timeout)
+		switch {
+		case err == errTimeout:
+			errs++
+			if !started && crc && !ymodem && errs >= crcTries {
+				crc, start = false, NAK
+			}
+			reply = NAK
+			if !started {
+				reply = start
+			}
+		case err == errBadBlock:
+			errs++
+			if err := c.purge(); err != nil {
+				return n, err
+			}
+			reply = NAK
+		case err != nil:
+			return n, err
+		case hdr == EOT:
+			if ymodem && !eot {
+				eot, reply = true, NAK
+				continue
+			}
+			return n, c.write(ACK)
+		case num == expect-1:
+			// Repeated block; our ACK was lost.
+			reply = ACK
+		case num != expect:
+			return n, ErrProtocol
+		default:
+			if size >= 0 && int64(len(data)) > size-n {
+				data = data[:size-n]
+			}
+			if _, err := w.Write(data); err != nil {
+				return n, err
+			}
+			n += int64(len(data))
+			c.t.progress(name, n, size)
+			expect++
+			started, errs, reply = true, 0, ACK
+		}
+	}
+}
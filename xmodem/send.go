@@ -0,0 +1,169 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package xmodem
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Send sends the data read from r with XMODEM, XMODEM-CRC, or
+// XMODEM-1K (if Block1K is set), depending on what the receiver
+// requests.
+func (t *Transfer) Send(ctx context.Context, r io.Reader) error {
+	c := t.newConn(ctx)
+	crc, err := c.waitStart()
+	if err == nil {
+		err = c.sendData(r, crc, t.Block1K, "", -1)
+	}
+	if err != nil {
+		c.abort(err)
+	}
+	return err
+}
+
+// waitStart waits for the receiver to request the transfer to start,
+// and returns true if it requests CRCs.
+func (c *conn) waitStart() (bool, error) {
+	for try := 0; try < c.t.retries(); try++ {
+		dl := time.Now().Add(c.t.timeout())
+		for {
+			b, err := c.readByte(dl)
+			if err == errTimeout {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+			switch b {
+			case CRC:
+				return true, nil
+			case NAK:
+				return false, nil
+			case CAN:
+				if ok, err := c.canceled(); ok || err != nil {
+					if err == nil {
+						err = ErrCanceled
+					}
+					return false, err
+				}
+			}
+		}
+	}
+	return false, ErrRetries
+}
+
+// response waits for the receiver to acknowledge a block. It returns
+// ACK or NAK (a CRC request is taken as a NAK).
+func (c *conn) response() (byte, error) {
+	dl := time.Now().Add(c.t.timeout())
+	for {
+		b, err := c.readByte(dl)
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case ACK, NAK:
+			return b, nil
+		case CRC:
+			return NAK, nil
+		case CAN:
+			if ok, err := c.canceled(); ok || err != nil {
+				if err == nil {
+					err = ErrCanceled
+				}
+				return 0, err
+			}
+		}
+	}
+}
+
+// send sends b, and waits for it to be acknowledged, retrying if
+// required.
+func (c *conn) send(b []byte) error {
+	for try := 0; try <= c.t.retries(); try++ {
+		if err := c.write(b...); err != nil {
+			return err
+		}
+		r, err := c.response()
+		if err == nil && r == ACK {
+			return nil
+		}
+		if err != nil && err != errTimeout {
+			return err
+		}
+	}
+	return ErrRetries
+}
+
+// block returns block number num, with contents data padded to size
+// with pad characters.
+func block(num byte, data []byte, size int, pad byte, crc bool) []byte {
+	b := make([]byte, 0, 3+size+2)
+	if size == 1024 {
+		b = append(b, STX)
+	} else {
+		b = append(b, SOH)
+	}
+	b = append(b, num, ^num)
+	b = append(b, data...)
+	for len(b) < 3+size {
+		b = append(b, pad)
+	}
+	if crc {
+		v := xfer.CRC16(0, b[3:])
+		return append(b, byte(v>>8), byte(v))
+	}
+	return append(b, sum(b[3:]))
+}
+
+// sendData sends the data read from r, in blocks numbered from 1,
+// followed by EOT. If 1K blocks are used, the last few bytes are sent
+// in 128-byte blocks, if this is shorter. The name and size of the
+// file are only used for progress reports.
+func (c *conn) sendData(r io.Reader, crc, use1K bool, name string,
+	size int64) error {
+
+	bs := 128
+	if use1K && crc {
+		bs = 1024
+	}
+	buf := make([]byte, bs)
+	num := byte(1)
+	var n int64
+	for {
+		m, rerr := io.ReadFull(r, buf)
+		data := buf[:m]
+		for len(data) > 0 {
+			l := 128
+			if bs == 1024 && len(data) > 7*128 {
+				l = 1024
+			}
+			d := data
+			if len(d) > l {
+				d = d[:l]
+			}
+			err := c.send(block(num, d, l, SUB, crc))
+			if err != nil {
+				return err
+			}
+			num++
+			n += int64(len(d))
+			data = data[len(d):]
+			c.t.progress(name, n, size)
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+	return c.send([]byte{EOT})
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package xmodem implements the XMODEM and YMODEM file-transfer
+// protocols over serial ports.
+//
+// XMODEM transfers a single file in 128-byte blocks, protected by an
+// 8-bit checksum, or (XMODEM-CRC) a CRC-16. XMODEM-1K uses 1024-byte
+// blocks, with CRC-16. The receiver cannot tell the exact size of the
+// file; the last block is padded with SUB (0x1a) characters, which
+// are passed to the receiver's writer. YMODEM (batch) transfers any
+// number of files, in XMODEM-1K blocks, each preceded by a header
+// block with the file's name, size, modification time, and mode.
+// Received files are truncated to the size in the header.
+//
+// Timeouts and cancelation
+//
+// The protocol timeouts are implemented with port read deadlines.
+// Transfer.Timeout is the time to wait for a response (or for the
+// next block), before retrying. After Transfer.Retries consecutive
+// errors or timeouts, the transfer is aborted with ErrRetries. Port
+// reads are issued with short deadlines, so that the Context passed
+// to the transfer methods is checked frequently; if it is canceled,
+// the transfer is aborted with the context's error. When a transfer
+// is aborted, a sequence of CAN characters is sent to the remote
+// end. If the remote end aborts the transfer (by sending two
+// consecutive CAN characters), ErrCanceled is returned.
+package xmodem
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Port is the interface of the ports transfers run over. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Protocol characters
+const (
+	SOH = 0x01 // Start of 128-byte block
+	STX = 0x02 // Start of 1024-byte block
+	EOT = 0x04 // End of transmission
+	ACK = 0x06 // Block received
+	NAK = 0x15 // Block not received. Start checksum transfer.
+	CAN = 0x18 // Cancel transfer
+	SUB = 0x1a // Padding
+	CRC = 'C'  // Start CRC transfer
+)
+
+// Defaults for Transfer fields
+const (
+	DefaultTimeout = 10 * time.Second
+	DefaultRetries = 10
+)
+
+// Errors returned by transfers
+var (
+	ErrCanceled = errors.New("xmodem: transfer canceled by remote")
+	ErrRetries  = errors.New("xmodem: too many errors")
+	ErrProtocol = errors.New("xmodem: protocol error")
+	ErrName     = errors.New("xmodem: file name too long")
+)
+
+// Internal errors
+var (
+	errTimeout  = errors.New("xmodem: timeout")
+	errBadBlock = errors.New("xmodem: bad block")
+)
+
+const (
+	// Interval and tries for requesting a CRC transfer, before
+	// falling back to checksums (XMODEM receive).
+	crcInterval = 3 * time.Second
+	crcTries    = 3
+)
+
+// Silence that ends discarding input after a bad block
+var purgeGap = 1 * time.Second
+
+// Sent to abort a transfer
+var cancelSeq = []byte{CAN, CAN, CAN, CAN, CAN, CAN, CAN, CAN}
+
+// Transfer sends and receives files over a port. Fields must be set
+// before calling the transfer methods. A Transfer can be used for
+// several transfers, one at a time.
+type Transfer struct {
+	// Send XMODEM-1K blocks, if the receiver requests CRCs (YMODEM
+	// always does).
+	Block1K bool
+	// Request checksums instead of CRCs (XMODEM receive)
+	Checksum bool
+
+	Timeout time.Duration // Zero means DefaultTimeout
+	Retries int           // Zero means DefaultRetries
+
+	// If not nil, Progress is called after each block is sent or
+	// received, with the file's name (empty for XMODEM) and size
+	// (-1 if unknown), and the number of bytes transferred so far.
+	Progress func(name string, n, size int64)
+
+	port Port
+}
+
+// NewTransfer returns a Transfer that runs over port p
+func NewTransfer(p Port) *Transfer {
+	return &Transfer{port: p}
+}
+
+func (t *Transfer) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return t.Timeout
+}
+
+func (t *Transfer) retries() int {
+	if t.Retries <= 0 {
+		return DefaultRetries
+	}
+	return t.Retries
+}
+
+func (t *Transfer) progress(name string, n, size int64) {
+	if t.Progress != nil {
+		t.Progress(name, n, size)
+	}
+}
+
+// conn is a single transfer over the port
+type conn struct {
+	t    *Transfer
+	port Port
+	r    *xfer.Reader
+	blk  []byte // Block buffer
+}
+
+func (t *Transfer) newConn(ctx context.Context) *conn {
+	return &conn{t: t, port: t.port,
+		r:   xfer.NewReader(t.port, ctx, 1100, errTimeout),
+		blk: make([]byte, 2+1024+2)}
+}
+
+// read reads into b until deadline dl. It returns errTimeout if dl
+// expires, or the context's error if it is canceled.
+func (c *conn) read(b []byte, dl time.Time) (int, error) {
+	return c.r.Read(b, dl)
+}
+
+func (c *conn) readByte(dl time.Time) (byte, error) {
+	return c.r.GetByte(dl)
+}
+
+func (c *conn) readFull(b []byte, dl time.Time) error {
+	for len(b) > 0 {
+		n, err := c.read(b, dl)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (c *conn) write(b ...byte) error {
+	c.port.SetWriteDeadline(time.Now().Add(c.t.timeout()))
+	_, err := c.port.Write(b)
+	return err
+}
+
+// purge discards input until the line is silent for purgeGap (but no
+// longer than the timeout).
+func (c *conn) purge() error {
+	c.r.Discard()
+	end := time.Now().Add(c.t.timeout())
+	var b [64]byte
+	for {
+		dl := time.Now().Add(purgeGap)
+		if end.Before(dl) {
+			dl = end
+		}
+		_, err := c.read(b[:], dl)
+		if err == errTimeout {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// canceled is called after a CAN is received, and checks if a second
+// one follows.
+func (c *conn) canceled() (bool, error) {
+	b, err := c.readByte(time.Now().Add(time.Second))
+	if err != nil && err != errTimeout {
+		return false, err
+	}
+	return err == nil && b == CAN, nil
+}
+
+// abort notifies the remote end that the transfer is aborted because
+// of err.
+func (c *conn) abort(err error) {
+	if err == ErrCanceled {
+		return
+	}
+	c.port.SetWriteDeadline(time.Now().Add(c.t.timeout()))
+	c.port.Write(cancelSeq)
+}
+
+// sum returns the 8-bit checksum of b
+func sum(b []byte) byte {
+	var s byte
+	for _, c := range b {
+		s += c
+	}
+	return s
+}
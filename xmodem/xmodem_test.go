@@ -0,0 +1,216 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package xmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+var bg = context.Background()
+
+func init() {
+	purgeGap = 20 * time.Millisecond
+}
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func TestXMODEM(t *testing.T) {
+	tests := []struct {
+		block1K, checksum bool
+		size              int
+		blocks            int // Blocks written
+	}{
+		{false, false, 1000, 8},
+		{false, true, 1000, 8},
+		{false, false, 0, 0},
+		{true, false, 3000, 3},
+		{true, false, 2048 + 500, 2 + 4},
+		{true, true, 2048, 16},
+	}
+	for _, tst := range tests {
+		ps, pr := pipe.New()
+		s, r := NewTransfer(ps), NewTransfer(pr)
+		s.Block1K, r.Checksum = tst.block1K, tst.checksum
+		var blocks int
+		var sent int64
+		s.Progress = func(name string, n, size int64) {
+			blocks++
+			sent = n
+		}
+		data := testData(tst.size)
+		errc := make(chan error, 1)
+		go func() { errc <- s.Send(bg, bytes.NewReader(data)) }()
+		var out bytes.Buffer
+		n, err := r.Receive(bg, &out)
+		if err != nil {
+			t.Fatalf("%+v: Receive: %v", tst, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("%+v: Send: %v", tst, err)
+		}
+		if blocks != tst.blocks || sent != int64(tst.size) {
+			t.Fatalf("%+v: Sent %d bytes in %d blocks", tst, sent,
+				blocks)
+		}
+		b := out.Bytes()
+		if int(n) != len(b) || len(b)%128 != 0 ||
+			!bytes.Equal(b[:tst.size], data) ||
+			len(bytes.Trim(b[tst.size:], "\x1a")) != 0 {
+			t.Fatalf("%+v: Received %d bytes", tst, n)
+		}
+	}
+}
+
+func TestYMODEM(t *testing.T) {
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	mtime := time.Unix(1437000000, 0)
+	files := []File{
+		{Name: "a.bin", Size: 3000, ModTime: mtime, Mode: 0644,
+			Data: bytes.NewReader(testData(3000))},
+		{Name: "empty", Size: 0},
+		{Name: "unknown", Size: -1,
+			Data: bytes.NewReader(testData(100))},
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- s.SendBatch(bg, files) }()
+
+	var got []*File
+	var bufs []*bytes.Buffer
+	err := r.ReceiveBatch(bg, func(f *File) (io.Writer, error) {
+		got = append(got, f)
+		bufs = append(bufs, new(bytes.Buffer))
+		return bufs[len(bufs)-1], nil
+	})
+	if err != nil {
+		t.Fatal("ReceiveBatch:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("SendBatch:", err)
+	}
+	if len(got) != 3 {
+		t.Fatalf("Received %d files", len(got))
+	}
+	f := got[0]
+	if f.Name != "a.bin" || f.Size != 3000 ||
+		!f.ModTime.Equal(mtime) || f.Mode != 0644 ||
+		!bytes.Equal(bufs[0].Bytes(), testData(3000)) {
+		t.Fatalf("File 0: %+v, %d bytes", f, bufs[0].Len())
+	}
+	if f = got[1]; f.Name != "empty" || f.Size != 0 || bufs[1].Len() != 0 {
+		t.Fatalf("File 1: %+v, %d bytes", f, bufs[1].Len())
+	}
+	// Size unknown: Padding is received
+	if f = got[2]; f.Name != "unknown" || f.Size != -1 ||
+		bufs[2].Len() != 128 ||
+		!bytes.Equal(bufs[2].Bytes()[:100], testData(100)) {
+		t.Fatalf("File 2: %+v, %d bytes", f, bufs[2].Len())
+	}
+}
+
+func TestRetransmit(t *testing.T) {
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	// Corrupt the 2nd and 3rd blocks once, and the ACK of the 4th
+	var sw, rw int
+	ps.Corrupt = func(b []byte) {
+		if sw++; sw == 2 || sw == 4 {
+			b[len(b)/2] ^= 0x55
+		}
+	}
+	pr.Corrupt = func(b []byte) {
+		if rw++; rw == 7 {
+			b[0] = 0
+		}
+	}
+	r.Timeout = 100 * time.Millisecond
+	s.Timeout = 100 * time.Millisecond
+	data := testData(128 * 6)
+	errc := make(chan error, 1)
+	go func() { errc <- s.Send(bg, bytes.NewReader(data)) }()
+	var out bytes.Buffer
+	if _, err := r.Receive(bg, &out); err != nil {
+		t.Fatal("Receive:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("Send:", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("Received %d bytes", out.Len())
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// Canceled by remote
+	ps, pr := pipe.New()
+	s := NewTransfer(ps)
+	pr.Write([]byte{CRC})
+	pr.Write([]byte{CAN, CAN})
+	err := s.Send(bg, bytes.NewReader(testData(1000)))
+	if err != ErrCanceled {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Canceled by context
+	ps, pr = pipe.New()
+	r := NewTransfer(pr)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := r.Receive(ctx, &bytes.Buffer{}); err != context.Canceled {
+		t.Fatalf("Receive: %v", err)
+	}
+	var b bytes.Buffer
+	ps.SetReadDeadline(time.Now())
+	for buf := make([]byte, 256); ; {
+		n, err := ps.Read(buf)
+		b.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if !bytes.HasSuffix(b.Bytes(), cancelSeq) {
+		t.Fatalf("Sent % x", b.Bytes())
+	}
+
+	// Too many retries
+	ps, pr = pipe.New()
+	s = NewTransfer(ps)
+	s.Timeout, s.Retries = 10*time.Millisecond, 2
+	if err := s.Send(bg, &bytes.Buffer{}); err != ErrRetries {
+		t.Fatalf("Send: %v", err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	f := &File{Name: "x", Size: 10, ModTime: time.Unix(8, 0), Mode: 0755}
+	b, _ := f.header()
+	if string(b) != "x\x0010 10 100755" {
+		t.Fatalf("Header: %q", b)
+	}
+	g := parseHeader(append(b, 0, 0))
+	if g.Name != "x" || g.Size != 10 || g.ModTime.Unix() != 8 ||
+		g.Mode != 0755 {
+		t.Fatalf("Parsed: %+v", g)
+	}
+	if parseHeader(make([]byte, 128)) != nil {
+		t.Fatal("End-of-batch header not recognized")
+	}
+	f.Name = string(make([]byte, 1024))
+	if _, err := f.header(); err != ErrName {
+		t.Fatalf("Long name: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package xmodem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// File is a file transferred with YMODEM
+type File struct {
+	Name    string
+	Size    int64       // -1 if unknown
+	ModTime time.Time   // Zero if unknown
+	Mode    os.FileMode // Permission bits; zero if unknown
+
+	// Contents of the file, for SendBatch (nil means empty).
+	// Ignored by ReceiveBatch.
+	Data io.Reader
+}
+
+// header returns the contents of the header block for f
+func (f *File) header() ([]byte, error) {
+	b := append([]byte(f.Name), 0)
+	if f.Size >= 0 {
+		var mtime int64
+		if !f.ModTime.IsZero() {
+			mtime = f.ModTime.Unix()
+		}
+		var mode uint32
+		if f.Mode != 0 {
+			mode = 0100000 | uint32(f.Mode.Perm())
+		}
+		b = append(b, fmt.Sprintf("%d %o %o", f.Size, mtime, mode)...)
+	}
+	if len(b) >= 1024 {
+		return nil, ErrName
+	}
+	return b, nil
+}
+
+// parseHeader parses the contents of header block b. It returns nil
+// for the (empty) block that ends a batch. Fields that cannot be
+// parsed are left unknown.
+func parseHeader(b []byte) *File {
+	i := bytes.IndexByte(b, 0)
+	if i <= 0 {
+		return nil
+	}
+	f := &File{Name: string(b[:i]), Size: -1}
+	b = b[i+1:]
+	if i = bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) > 0 {
+		if v, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			f.Size = v
+		}
+	}
+	if len(fields) > 1 {
+		v, err := strconv.ParseInt(fields[1], 8, 64)
+		if err == nil && v > 0 {
+			f.ModTime = time.Unix(v, 0)
+		}
+	}
+	if len(fields) > 2 {
+		if v, err := strconv.ParseUint(fields[2], 8, 32); err == nil {
+			f.Mode = os.FileMode(v).Perm()
+		}
+	}
+	return f
+}
+
+// SendBatch sends files with YMODEM. Data are read from the Data
+// field of each file. If a file's Size is known, exactly that many
+// bytes should be readable from Data.
+func (t *Transfer) SendBatch(ctx context.Context, files []File) error {
+	c := t.newConn(ctx)
+	err := c.sendBatch(files)
+	if err != nil {
+		c.abort(err)
+	}
+	return err
+}
+
+func (c *conn) sendBatch(files []File) error {
+	for i := range files {
+		f := &files[i]
+		hdr, err := f.header()
+		if err != nil {
+			return err
+		}
+		crc, err := c.waitStart()
+		if err != nil {
+			return err
+		}
+		l := 128
+		if len(hdr) > 128 {
+			l = 1024
+		}
+		if err := c.send(block(0, hdr, l, 0, crc)); err != nil {
+			return err
+		}
+		if crc, err = c.waitStart(); err != nil {
+			return err
+		}
+		r := f.Data
+		if r == nil {
+			r = bytes.NewReader(nil)
+		}
+		if err := c.sendData(r, crc, true, f.Name, f.Size); err != nil {
+			return err
+		}
+	}
+	crc, err := c.waitStart()
+	if err != nil {
+		return err
+	}
+	return c.send(block(0, nil, 128, 0, crc))
+}
+
+// ReceiveBatch receives files with YMODEM. For each file, it calls
+// create with the file's header information, and writes the file's
+// contents to the writer it returns. If create returns an error, the
+// transfer is aborted, and the error is returned. The file names are
+// as sent by the remote end; create should check them before use.
+func (t *Transfer) ReceiveBatch(ctx context.Context,
+	create func(f *File) (io.Writer, error)) error {
+
+	c := t.newConn(ctx)
+	err := c.recvBatch(create)
+	if err != nil {
+		c.abort(err)
+	}
+	return err
+}
+
+func (c *conn) recvBatch(create func(f *File) (io.Writer, error)) error {
+	for {
+		f, err := c.recvHeader()
+		if err != nil || f == nil {
+			return err
+		}
+		w, err := create(f)
+		if err != nil {
+			return err
+		}
+		_, err = c.recvData(w, true, true, f.Name, f.Size)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// recvHeader requests, and receives a header block. It returns nil
+// at the end of the batch.
+func (c *conn) recvHeader() (*File, error) {
+	errs := 0
+	for {
+		if errs > c.t.retries() {
+			return nil, ErrRetries
+		}
+		if err := c.write(CRC); err != nil {
+			return nil, err
+		}
+		hdr, num, data, err := c.recvBlock(true, c.t.timeout())
+		switch {
+		case err == errTimeout:
+			errs++
+		case err == errBadBlock:
+			errs++
+			if err := c.purge(); err != nil {
+				return nil, err
+			}
+		case err != nil:
+			return nil, err
+		case hdr == EOT:
+			// Repeated EOT of the previous file; our ACK was
+			// lost.
+			if err := c.write(ACK); err != nil {
+				return nil, err
+			}
+		case num != 0:
+			return nil, ErrProtocol
+		default:
+			return parseHeader(data), c.write(ACK)
+		}
+	}
+}
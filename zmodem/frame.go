@@ -0,0 +1,345 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package zmodem
+
+import (
+	"encoding/hex"
+	"hash/crc32"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Special characters
+const (
+	zpad   = '*'  // Header lead-in
+	zdle   = 0x18 // Escape (same as CAN)
+	can    = 0x18
+	zbin   = 'A' // Binary header, CRC-16
+	zhex   = 'B' // Hex header, CRC-16
+	zbin32 = 'C' // Binary header, CRC-32
+	zrub0  = 'l' // Escaped 0x7f
+	zrub1  = 'm' // Escaped 0xff
+	xon    = 0x11
+	xoff   = 0x13
+	dle    = 0x10
+)
+
+// Data subpacket ends (following a ZDLE)
+const (
+	zcrce = 'h' // Frame ends, header follows
+	zcrcg = 'i' // Frame continues nonstop
+	zcrcq = 'j' // Frame continues, ZACK expected
+	zcrcw = 'k' // Frame ends, ZACK expected
+)
+
+// Header types
+const (
+	zrqinit    = 0
+	zrinit     = 1
+	zsinit     = 2
+	zack       = 3
+	zfile      = 4
+	zskip      = 5
+	znak       = 6
+	zabort     = 7
+	zfin       = 8
+	zrpos      = 9
+	zdata      = 10
+	zeof       = 11
+	zferr      = 12
+	zcrc       = 13
+	zchallenge = 14
+	zcompl     = 15
+	zcan       = 16
+	zfreecnt   = 17
+	zcommand   = 18
+)
+
+// ZRINIT flags (ZF0)
+const (
+	canfdx  = 0x01 // Full duplex
+	canovio = 0x02 // Can receive data during disk I/O
+	canfc32 = 0x20 // Can use CRC-32
+	escctl  = 0x40 // Wants control characters escaped
+)
+
+// ZFILE conversion option (ZF0): Binary transfer
+const zcbin = 1
+
+// header is a ZMODEM header: type, and four data bytes. For position
+// headers, the data are the position, least significant byte first.
+// For others, the flags ZF3 to ZF0.
+type header struct {
+	typ  byte
+	data [4]byte
+}
+
+func posHeader(typ byte, pos int64) header {
+	return header{typ, [4]byte{byte(pos), byte(pos >> 8),
+		byte(pos >> 16), byte(pos >> 24)}}
+}
+
+func (h header) pos() int64 {
+	return int64(h.data[0]) | int64(h.data[1])<<8 |
+		int64(h.data[2])<<16 | int64(h.data[3])<<24
+}
+
+// zf0 returns the ZF0 flags
+func (h header) zf0() byte {
+	return h.data[3]
+}
+
+// escape appends b to dst, escaped with ZDLE as required
+func (c *conn) escape(dst []byte, b ...byte) []byte {
+	for _, v := range b {
+		esc := false
+		switch v {
+		case zdle, dle, dle | 0x80, xon, xon | 0x80, xoff, xoff | 0x80:
+			esc = true
+		case '\r', '\r' | 0x80:
+			esc = c.last&0x7f == '@'
+		default:
+			esc = c.escctl && v&0x60 == 0
+		}
+		if esc {
+			v ^= 0x40
+			dst = append(dst, zdle, v)
+		} else {
+			dst = append(dst, v)
+		}
+		c.last = v
+	}
+	return dst
+}
+
+// hexHeader returns header h, in hex form
+func hexHeader(h header) []byte {
+	b := append([]byte{h.typ}, h.data[:]...)
+	v := xfer.CRC16(0, b)
+	b = append(b, byte(v>>8), byte(v))
+	out := append([]byte{zpad, zpad, zdle, zhex},
+		hex.EncodeToString(b)...)
+	out = append(out, '\r', '\n'|0x80)
+	if h.typ != zack && h.typ != zfin {
+		out = append(out, xon)
+	}
+	return out
+}
+
+// binHeader returns header h, in binary form (with CRC-32, if
+// enabled).
+func (c *conn) binHeader(h header) []byte {
+	b := append([]byte{h.typ}, h.data[:]...)
+	out := []byte{zpad, zdle, zbin}
+	if c.crc32 {
+		out[2] = zbin32
+		v := crc32.ChecksumIEEE(b)
+		b = append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+	} else {
+		v := xfer.CRC16(0, b)
+		b = append(b, byte(v>>8), byte(v))
+	}
+	return c.escape(out, b...)
+}
+
+// subpacket appends a data subpacket with data b, ending with end, to
+// dst.
+func (c *conn) subpacket(dst []byte, b []byte, end byte) []byte {
+	dst = c.escape(dst, b...)
+	dst = append(dst, zdle, end)
+	c.last = end
+	if c.crc32 {
+		v := crc32.Update(crc32.ChecksumIEEE(b), crc32.IEEETable,
+			[]byte{end})
+		return c.escape(dst, byte(v), byte(v>>8), byte(v>>16),
+			byte(v>>24))
+	}
+	v := xfer.CRC16(xfer.CRC16(0, b), []byte{end})
+	return c.escape(dst, byte(v>>8), byte(v))
+}
+
+// readZ reads a byte, decoding ZDLE escapes and dropping unescaped
+// flow-control characters. For data-subpacket ends, it returns the
+// end character with bit 8 set. Five consecutive CANs cancel the
+// transfer.
+func (c *conn) readZ(dl time.Time) (int, error) {
+	for {
+		b, err := c.readByte(dl)
+		if err != nil {
+			return 0, err
+		}
+		switch b {
+		case xon, xon | 0x80, xoff, xoff | 0x80:
+			continue
+		case zdle:
+		default:
+			return int(b), nil
+		}
+		for cans := 1; ; {
+			b, err := c.readByte(dl)
+			if err != nil {
+				return 0, err
+			}
+			switch b {
+			case xon, xon | 0x80, xoff, xoff | 0x80:
+				continue
+			case can:
+				if cans++; cans >= 5 {
+					return 0, ErrCanceled
+				}
+				continue
+			case zcrce, zcrcg, zcrcq, zcrcw:
+				return int(b) | 0x100, nil
+			case zrub0:
+				return 0x7f, nil
+			case zrub1:
+				return 0xff, nil
+			}
+			if b&0x60 == 0x40 {
+				return int(b ^ 0x40), nil
+			}
+			return 0, errBad
+		}
+	}
+}
+
+// readZBytes reads len(b) bytes with readZ. Subpacket ends are
+// errors.
+func (c *conn) readZBytes(b []byte, dl time.Time) error {
+	for i := range b {
+		v, err := c.readZ(dl)
+		if err != nil {
+			return err
+		}
+		if v > 0xff {
+			return errBad
+		}
+		b[i] = byte(v)
+	}
+	return nil
+}
+
+// readHeader waits for a header until dl, skipping anything else
+// received. It returns errBad for headers that fail the CRC check.
+// If the header is binary, with CRC-32, the data subpackets following
+// it use CRC-32 too; this is recorded in rx32.
+func (c *conn) readHeader(dl time.Time) (h header, rx32 bool, err error) {
+	cans := 0
+	for {
+		b, err := c.readByte(dl)
+		if err != nil {
+			return h, false, err
+		}
+		if b == can {
+			if cans++; cans >= 5 {
+				return h, false, ErrCanceled
+			}
+		} else {
+			cans = 0
+		}
+		if b&0x7f != zpad {
+			continue
+		}
+		// Skip further ZPADs; a ZDLE must follow
+		for b&0x7f == zpad {
+			if b, err = c.readByte(dl); err != nil {
+				return h, false, err
+			}
+		}
+		if b != zdle {
+			continue
+		}
+		b, err = c.readByte(dl)
+		if err != nil {
+			return h, false, err
+		}
+		var p [9]byte
+		switch b & 0x7f {
+		case zbin:
+			if err := c.readZBytes(p[:7], dl); err != nil {
+				return h, false, err
+			}
+			if xfer.CRC16(0, p[:7]) != 0 {
+				return h, false, errBad
+			}
+		case zbin32:
+			if err := c.readZBytes(p[:9], dl); err != nil {
+				return h, false, err
+			}
+			v := crc32.ChecksumIEEE(p[:5])
+			if p[5] != byte(v) || p[6] != byte(v>>8) ||
+				p[7] != byte(v>>16) || p[8] != byte(v>>24) {
+				return h, false, errBad
+			}
+			rx32 = true
+		case zhex:
+			var x [14]byte
+			for i := range x {
+				v, err := c.readByte(dl)
+				if err != nil {
+					return h, false, err
+				}
+				x[i] = v & 0x7f
+			}
+			if _, err := hex.Decode(p[:7], x[:]); err != nil {
+				return h, false, errBad
+			}
+			if xfer.CRC16(0, p[:7]) != 0 {
+				return h, false, errBad
+			}
+		default:
+			continue
+		}
+		h.typ = p[0]
+		copy(h.data[:], p[1:5])
+		return h, rx32, nil
+	}
+}
+
+// readSubpacket reads a data subpacket, with CRC-32 if rx32 is set.
+// It returns the data (valid until the next call), and the end
+// character.
+func (c *conn) readSubpacket(rx32 bool, dl time.Time) ([]byte, byte,
+	error) {
+
+	b := c.dbuf[:0]
+	for {
+		v, err := c.readZ(dl)
+		if err != nil {
+			return nil, 0, err
+		}
+		if v <= 0xff {
+			if len(b) == cap(b) {
+				return nil, 0, errBad
+			}
+			b = append(b, byte(v))
+			continue
+		}
+		end := byte(v)
+		var p [4]byte
+		if rx32 {
+			if err := c.readZBytes(p[:4], dl); err != nil {
+				return nil, 0, err
+			}
+			v := crc32.Update(crc32.ChecksumIEEE(b),
+				crc32.IEEETable, []byte{end})
+			if p[0] != byte(v) || p[1] != byte(v>>8) ||
+				p[2] != byte(v>>16) || p[3] != byte(v>>24) {
+				return nil, 0, errBad
+			}
+		} else {
+			if err := c.readZBytes(p[:2], dl); err != nil {
+				return nil, 0, err
+			}
+			v := xfer.CRC16(xfer.CRC16(0, b), []byte{end})
+			if p[0] != byte(v>>8) || p[1] != byte(v) {
+				return nil, 0, errBad
+			}
+		}
+		return b, end, nil
+	}
+}
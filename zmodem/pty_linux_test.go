@@ -0,0 +1,73 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// +build linux
+
+package zmodem
+
+import (
+	"bytes"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+
+	"github.com/npat-efault/serial"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pty pair. The master side is returned as an
+// *os.File, the slave as a *serial.Port (in raw mode).
+func openPty() (*os.File, *serial.Port, error) {
+	// O_NONBLOCK, so that the runtime poller is used, and read
+	// deadlines work.
+	m, err := os.OpenFile("/dev/ptmx",
+		os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd := int(m.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	n, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	s, err := serial.Open("/dev/pts/" + strconv.Itoa(n))
+	if err != nil {
+		m.Close()
+		return nil, nil, err
+	}
+	return m, s, nil
+}
+
+func TestPty(t *testing.T) {
+	m, s, err := openPty()
+	if err != nil {
+		t.Skip("Cannot open pty:", err)
+	}
+	defer m.Close()
+	defer s.Close()
+
+	data := testData(50000)
+	files := []File{{Name: "pty.bin", Size: int64(len(data)),
+		Data: bytes.NewReader(data)}}
+	var rcv received
+	run(t, NewTransfer(m), NewTransfer(s), files, rcv.create)
+	if len(rcv.files) != 1 || !bytes.Equal(rcv.data[0].Bytes(), data) {
+		t.Fatalf("Received %d files", len(rcv.files))
+	}
+
+	// And the other way around
+	files[0].Data.Seek(0, 0)
+	rcv = received{}
+	run(t, NewTransfer(s), NewTransfer(m), files, rcv.create)
+	if len(rcv.files) != 1 || !bytes.Equal(rcv.data[0].Bytes(), data) {
+		t.Fatalf("Received %d files", len(rcv.files))
+	}
+}
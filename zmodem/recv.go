@@ -0,0 +1,241 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Receive receives files with ZMODEM. For each file, it calls create
+// with the file's header information. Create returns the writer the
+// file's contents are written to, and the offset to start the
+// transfer from: To resume an interrupted transfer, create opens the
+// partially received file for appending, and returns its size. To
+// skip the file, create returns ErrSkip. If create returns any other
+// error, the transfer is aborted, and the error is returned. The file
+// names are as sent by the remote end; create should check them
+// before use.
+func (t *Transfer) Receive(ctx context.Context,
+	create func(f *File) (w io.Writer, offset int64, err error)) error {
+
+	c := t.newConn(ctx)
+	err := c.receive(create)
+	if err != nil {
+		c.abort(err)
+	}
+	return err
+}
+
+// parseInfo parses file-information subpacket data b. Fields that
+// cannot be parsed are left unknown.
+func parseInfo(b []byte) *File {
+	f := &File{Size: -1}
+	i := bytes.IndexByte(b, 0)
+	if i < 0 {
+		f.Name = string(b)
+		return f
+	}
+	f.Name = string(b[:i])
+	b = b[i+1:]
+	if i = bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) > 0 {
+		if v, err := strconv.ParseInt(fields[0], 10, 64); err == nil {
+			f.Size = v
+		}
+	}
+	if len(fields) > 1 {
+		v, err := strconv.ParseInt(fields[1], 8, 64)
+		if err == nil && v > 0 {
+			f.ModTime = time.Unix(v, 0)
+		}
+	}
+	if len(fields) > 2 {
+		if v, err := strconv.ParseUint(fields[2], 8, 32); err == nil {
+			f.Mode = os.FileMode(v).Perm()
+		}
+	}
+	return f
+}
+
+func (c *conn) rinit() []byte {
+	h := header{typ: zrinit}
+	h.data[3] = canfdx | canovio | canfc32
+	if c.escctl {
+		h.data[3] |= escctl
+	}
+	return hexHeader(h)
+}
+
+func (c *conn) receive(create func(f *File) (io.Writer, int64,
+	error)) error {
+
+	reply := c.rinit()
+	errs := 0
+	for {
+		if errs > c.t.retries() {
+			return ErrRetries
+		}
+		if reply != nil {
+			if err := c.write(reply); err != nil {
+				return err
+			}
+		}
+		h, rx32, err := c.readHeader(time.Now().Add(c.t.timeout()))
+		if err == errTimeout || err == errBad {
+			errs++
+			reply = c.rinit()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		reply = nil
+		switch h.typ {
+		case zrqinit, zeof:
+			reply = c.rinit()
+		case zsinit:
+			if h.zf0()&escctl != 0 {
+				c.escctl = true
+			}
+			_, _, err := c.readSubpacket(rx32,
+				time.Now().Add(c.t.timeout()))
+			if err != nil {
+				errs++
+				reply = hexHeader(header{typ: znak})
+				continue
+			}
+			reply = hexHeader(header{typ: zack})
+		case zfile:
+			b, _, err := c.readSubpacket(rx32,
+				time.Now().Add(c.t.timeout()))
+			if err != nil {
+				errs++
+				reply = hexHeader(header{typ: znak})
+				continue
+			}
+			f := parseInfo(b)
+			w, pos, err := create(f)
+			if err == ErrSkip {
+				reply = hexHeader(header{typ: zskip})
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if err := c.recvFile(f, w, pos); err != nil {
+				return err
+			}
+			errs = 0
+			reply = c.rinit()
+		case zfin:
+			err := c.write(hexHeader(header{typ: zfin}))
+			if err != nil {
+				return err
+			}
+			// Over and out. Not essential.
+			var oo [2]byte
+			dl := time.Now().Add(time.Second)
+			for i := range oo {
+				oo[i], err = c.readByte(dl)
+				if err != nil {
+					break
+				}
+			}
+			return nil
+		case zcan, zabort:
+			return ErrCanceled
+		case zferr:
+			return ErrRemote
+		}
+	}
+}
+
+// recvFile receives the contents of file f, starting at pos, and
+// writes them to w.
+func (c *conn) recvFile(f *File, w io.Writer, pos int64) error {
+	rpos := func() []byte { return hexHeader(posHeader(zrpos, pos)) }
+	reply := rpos()
+	errs := 0
+	for {
+		if errs > c.t.retries() {
+			return ErrRetries
+		}
+		if reply != nil {
+			if err := c.write(reply); err != nil {
+				return err
+			}
+			reply = nil
+		}
+		h, rx32, err := c.readHeader(time.Now().Add(c.t.timeout()))
+		if err == errTimeout || err == errBad {
+			errs++
+			reply = rpos()
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		switch h.typ {
+		case zdata:
+			if h.pos() != pos {
+				// Data sent before our last ZRPOS
+				errs++
+				reply = rpos()
+				continue
+			}
+			for {
+				b, end, err := c.readSubpacket(rx32,
+					time.Now().Add(c.t.timeout()))
+				if err == errTimeout || err == errBad {
+					errs++
+					reply = rpos()
+					break
+				}
+				if err != nil {
+					return err
+				}
+				if _, err := w.Write(b); err != nil {
+					return err
+				}
+				pos += int64(len(b))
+				errs = 0
+				c.t.progress(f, pos)
+				if end == zcrcq || end == zcrcw {
+					ack := hexHeader(posHeader(zack, pos))
+					if err := c.write(ack); err != nil {
+						return err
+					}
+				}
+				if end == zcrce || end == zcrcw {
+					break
+				}
+			}
+		case zeof:
+			if h.pos() == pos {
+				return nil
+			}
+			// Data were lost
+			reply = rpos()
+		case zfile:
+			// The sender missed our ZRPOS. Skip the subpacket.
+			c.readSubpacket(rx32, time.Now().Add(c.t.timeout()))
+			reply = rpos()
+		case zcan, zabort:
+			return ErrCanceled
+		case zferr:
+			return ErrRemote
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Send sends files with ZMODEM. Data are read from the Data field of
+// each file. Files the receiver skips are not considered errors.
+func (t *Transfer) Send(ctx context.Context, files []File) error {
+	c := t.newConn(ctx)
+	err := c.send(files)
+	if err != nil {
+		c.abort(err)
+	}
+	return err
+}
+
+func (c *conn) send(files []File) error {
+	// Start the receiver (if it is auto-started), and wait for it
+	// to initialize.
+	if err := c.write([]byte("rz\r")); err != nil {
+		return err
+	}
+	var rinit header
+	for try := 0; ; try++ {
+		if try > c.t.retries() {
+			return ErrRetries
+		}
+		if err := c.write(hexHeader(header{typ: zrqinit})); err != nil {
+			return err
+		}
+		h, err := c.waitHeader()
+		if err == errTimeout || err == errBad {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.typ == zrinit {
+			rinit = h
+			break
+		}
+		if h.typ == zchallenge {
+			h.typ = zack
+			if err := c.write(hexHeader(h)); err != nil {
+				return err
+			}
+		}
+	}
+	flags := rinit.zf0()
+	c.crc32 = flags&canfc32 != 0
+	c.escctl = c.escctl || flags&escctl != 0
+	// Receiver buffer size; zero means it can receive nonstop.
+	rxbuf := int(rinit.data[0]) | int(rinit.data[1])<<8
+
+	for i := range files {
+		f := &files[i]
+		if f.Data == nil {
+			f.Data = bytes.NewReader(nil)
+		}
+		if err := c.sendFile(f, len(files)-i, rxbuf); err != nil {
+			return err
+		}
+	}
+
+	for try := 0; ; try++ {
+		if try > c.t.retries() {
+			return ErrRetries
+		}
+		if err := c.write(hexHeader(header{typ: zfin})); err != nil {
+			return err
+		}
+		h, err := c.waitHeader()
+		if err == errTimeout || err == errBad {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if h.typ == zfin {
+			return c.write([]byte("OO"))
+		}
+	}
+}
+
+// waitHeader waits for a header until the timeout. ZCAN and ZABORT
+// headers are reported as ErrCanceled, and ZFERR as ErrRemote.
+func (c *conn) waitHeader() (header, error) {
+	h, _, err := c.readHeader(time.Now().Add(c.t.timeout()))
+	if err != nil {
+		return h, err
+	}
+	switch h.typ {
+	case zcan, zabort:
+		return h, ErrCanceled
+	case zferr:
+		return h, ErrRemote
+	}
+	return h, nil
+}
+
+// info returns the file-information subpacket data for f. left is
+// the number of files left to send, including f.
+func (f *File) info(left int) ([]byte, error) {
+	b := append([]byte(f.Name), 0)
+	if f.Size >= 0 {
+		var mtime int64
+		if !f.ModTime.IsZero() {
+			mtime = f.ModTime.Unix()
+		}
+		var mode uint32
+		if f.Mode != 0 {
+			mode = 0100000 | uint32(f.Mode.Perm())
+		}
+		b = append(b, fmt.Sprintf("%d %o %o 0 %d", f.Size, mtime,
+			mode, left)...)
+	}
+	if len(b) >= 1024 {
+		return nil, ErrName
+	}
+	return append(b, 0), nil
+}
+
+// sendFile offers file f to the receiver, and sends it, starting
+// from the position the receiver requests.
+func (c *conn) sendFile(f *File, left, rxbuf int) error {
+	info, err := f.info(left)
+	if err != nil {
+		return err
+	}
+	var hdr header
+	hdr.data[3] = zcbin
+	for try := 0; ; try++ {
+		if try > c.t.retries() {
+			return ErrRetries
+		}
+		b := c.binHeader(header{typ: zfile, data: hdr.data})
+		b = c.subpacket(b, info, zcrcw)
+		if err := c.write(b); err != nil {
+			return err
+		}
+		for resend := false; !resend; {
+			h, err := c.waitHeader()
+			if err == errTimeout || err == errBad {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			switch h.typ {
+			case zrpos:
+				return c.sendData(f, h.pos(), rxbuf)
+			case zskip:
+				return nil
+			case zcrc:
+				// Receiver requests the file's CRC
+				v, err := fileCRC(f.Data)
+				if err != nil {
+					return err
+				}
+				h = posHeader(zcrc, int64(v))
+				if err := c.write(hexHeader(h)); err != nil {
+					return err
+				}
+			case zrinit:
+				// Stale, from before the ZFILE
+			default:
+				resend = true
+			}
+		}
+	}
+}
+
+// fileCRC returns the CRC-32 of the data read from r
+func fileCRC(r io.ReadSeeker) (uint32, error) {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, r); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// sendData sends the contents of f, starting at pos, followed by
+// ZEOF. rxbuf is the receiver's buffer size (zero for unlimited).
+func (c *conn) sendData(f *File, pos int64, rxbuf int) error {
+	buf := make([]byte, c.t.blockSize())
+	out := make([]byte, 0, 2*len(buf)+16)
+	window := int64(c.t.Window)
+	errs := 0
+	for {
+		// (Re)start the data frame at pos
+		if errs > c.t.retries() {
+			return ErrRetries
+		}
+		if _, err := f.Data.Seek(pos, io.SeekStart); err != nil {
+			return err
+		}
+		err := c.write(c.binHeader(posHeader(zdata, pos)))
+		if err != nil {
+			return err
+		}
+		acked, sent := pos, 0
+		restart := false
+		eof := false
+		for !eof && !restart {
+			n, err := io.ReadFull(f.Data, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+			sent += n
+			var end byte
+			switch {
+			case eof:
+				end = zcrce
+			case rxbuf > 0 && sent >= rxbuf:
+				end = zcrcw
+			case window > 0 && pos+int64(n)-acked >= window:
+				end = zcrcq
+			default:
+				end = zcrcg
+			}
+			out = c.subpacket(out[:0], buf[:n], end)
+			if err := c.write(out); err != nil {
+				return err
+			}
+			pos += int64(n)
+			c.t.progress(f, pos)
+
+			switch end {
+			case zcrcw, zcrcq:
+				// Wait for the receiver to catch up
+				h, err := c.waitHeader()
+				if err != nil && err != errTimeout &&
+					err != errBad {
+					return err
+				}
+				switch {
+				case err == nil && h.typ == zack:
+					acked = h.pos()
+					// After ZCRCW, a new frame starts
+					restart = end == zcrcw
+				case err == nil && h.typ == zrpos:
+					pos, restart = h.pos(), true
+					errs++
+				default:
+					pos, restart = acked, true
+					errs++
+				}
+			case zcrcg:
+				// Check for error reports from the receiver
+				ok, err := c.pending()
+				if err != nil {
+					return err
+				}
+				if !ok {
+					break
+				}
+				dl := time.Now().Add(xfer.PollInterval)
+				h, _, err := c.readHeader(dl)
+				if err != nil && err != errTimeout &&
+					err != errBad {
+					return err
+				}
+				if err == nil && h.typ == zrpos {
+					pos, restart = h.pos(), true
+					errs++
+				} else if err == nil && h.typ == zack {
+					acked = h.pos()
+				}
+			}
+		}
+		if restart {
+			continue
+		}
+
+		for {
+			if errs > c.t.retries() {
+				return ErrRetries
+			}
+			err := c.write(c.binHeader(posHeader(zeof, pos)))
+			if err != nil {
+				return err
+			}
+			h, err := c.waitHeader()
+			if err == errTimeout || err == errBad {
+				errs++
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			switch h.typ {
+			case zrinit, zskip:
+				return nil
+			case zrpos:
+				pos, restart = h.pos(), true
+				errs++
+			}
+			if restart {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,201 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+// Package zmodem implements the ZMODEM file-transfer protocol over
+// serial ports (or any other transport that supports read and write
+// deadlines).
+//
+// Files are sent in data subpackets, protected by 32-bit CRCs (or
+// 16-bit ones, if the receiver does not support CRC-32), and
+// escaped with ZDLE so that flow-control characters are never sent.
+// The sender streams data without waiting for acknowledgements; if
+// the receiver detects an error, it asks the sender to resume from
+// the last good position (with a ZRPOS header). The same mechanism
+// allows interrupted transfers to be resumed: when the receiver
+// already has part of a file, it asks the sender to start from the
+// respective offset. Transfer.Window limits the amount of data the
+// sender sends without an acknowledgement.
+//
+// Timeouts and cancelation
+//
+// As in package github.com/npat-efault/serial/xmodem, the protocol
+// timeouts are implemented with port read deadlines, and the Context
+// passed to the transfer methods is checked frequently. Aborted
+// transfers are signaled to the remote end with a sequence of CAN
+// characters. If the remote end aborts the transfer, ErrCanceled is
+// returned.
+package zmodem
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/npat-efault/serial/internal/xfer"
+)
+
+// Port is the interface of the ports transfers run over. It is
+// satisfied by *serial.Port.
+type Port interface {
+	Read(b []byte) (n int, err error)
+	Write(b []byte) (n int, err error)
+	SetReadDeadline(t time.Time) error
+	SetWriteDeadline(t time.Time) error
+}
+
+// Defaults for Transfer fields
+const (
+	DefaultTimeout   = 10 * time.Second
+	DefaultRetries   = 10
+	DefaultBlockSize = 1024
+	MaxBlockSize     = 8192
+)
+
+// Errors returned by transfers
+var (
+	ErrCanceled = errors.New("zmodem: transfer canceled by remote")
+	ErrRetries  = errors.New("zmodem: too many errors")
+	ErrProtocol = errors.New("zmodem: protocol error")
+	ErrRemote   = errors.New("zmodem: remote file error")
+	ErrName     = errors.New("zmodem: file name too long")
+)
+
+// ErrSkip is returned by the create function passed to
+// Transfer.Receive to skip a file.
+var ErrSkip = errors.New("zmodem: skip file")
+
+// Internal errors
+var (
+	errTimeout = errors.New("zmodem: timeout")
+	errBad     = errors.New("zmodem: bad header or subpacket")
+)
+
+// Sent to abort a transfer
+var cancelSeq = []byte{
+	can, can, can, can, can, can, can, can,
+	8, 8, 8, 8, 8, 8, 8, 8, 8, 8,
+}
+
+// File is a file transferred with ZMODEM
+type File struct {
+	Name    string
+	Size    int64       // -1 if unknown
+	ModTime time.Time   // Zero if unknown
+	Mode    os.FileMode // Permission bits; zero if unknown
+
+	// Contents of the file, for Send (nil means empty). Seeking
+	// is required for error recovery and for resuming transfers.
+	// Ignored by Receive.
+	Data io.ReadSeeker
+}
+
+// Transfer sends and receives files over a port. Fields must be set
+// before calling the transfer methods. A Transfer can be used for
+// several transfers, one at a time.
+type Transfer struct {
+	// Max data sent without acknowledgement; zero means no limit
+	// (but the receiver may impose one).
+	Window int
+	// Data subpacket size; zero means DefaultBlockSize. Max is
+	// MaxBlockSize.
+	BlockSize int
+	// Escape all control characters (besides the ones always
+	// escaped). Set this if the line is not transparent to them.
+	// It is also enabled if the remote end requests it.
+	EscapeCtl bool
+
+	Timeout time.Duration // Zero means DefaultTimeout
+	Retries int           // Zero means DefaultRetries
+
+	// If not nil, Progress is called after each data subpacket
+	// is sent or received, with the file's name and size (-1 if
+	// unknown), and the current position in the file.
+	Progress func(name string, pos, size int64)
+
+	port Port
+}
+
+// NewTransfer returns a Transfer that runs over port p
+func NewTransfer(p Port) *Transfer {
+	return &Transfer{port: p}
+}
+
+func (t *Transfer) timeout() time.Duration {
+	if t.Timeout <= 0 {
+		return DefaultTimeout
+	}
+	return t.Timeout
+}
+
+func (t *Transfer) retries() int {
+	if t.Retries <= 0 {
+		return DefaultRetries
+	}
+	return t.Retries
+}
+
+func (t *Transfer) blockSize() int {
+	if t.BlockSize <= 0 {
+		return DefaultBlockSize
+	}
+	if t.BlockSize > MaxBlockSize {
+		return MaxBlockSize
+	}
+	return t.BlockSize
+}
+
+func (t *Transfer) progress(f *File, pos int64) {
+	if t.Progress != nil {
+		t.Progress(f.Name, pos, f.Size)
+	}
+}
+
+// conn is a single transfer over the port
+type conn struct {
+	t      *Transfer
+	port   Port
+	r      *xfer.Reader
+	dbuf   []byte // Data-subpacket buffer
+	crc32  bool   // Use CRC-32 for the headers and data we send
+	escctl bool   // Escape all control characters
+	last   byte   // Last byte sent (for escaping)
+}
+
+func (t *Transfer) newConn(ctx context.Context) *conn {
+	return &conn{t: t, port: t.port, escctl: t.EscapeCtl,
+		r:    xfer.NewReader(t.port, ctx, 1024, errTimeout),
+		dbuf: make([]byte, 0, MaxBlockSize)}
+}
+
+// readByte reads a byte until deadline dl. It returns errTimeout if
+// dl expires, or the context's error if it is canceled.
+func (c *conn) readByte(dl time.Time) (byte, error) {
+	return c.r.GetByte(dl)
+}
+
+// pending returns true if input is available, without waiting
+func (c *conn) pending() (bool, error) {
+	if err := c.r.Fill(time.Now()); err != nil {
+		return false, err
+	}
+	return c.r.Buffered() > 0, nil
+}
+
+func (c *conn) write(b []byte) error {
+	c.port.SetWriteDeadline(time.Now().Add(c.t.timeout()))
+	_, err := c.port.Write(b)
+	return err
+}
+
+// abort notifies the remote end that the transfer is aborted because
+// of err.
+func (c *conn) abort(err error) {
+	if err == ErrCanceled {
+		return
+	}
+	c.write(cancelSeq)
+}
@@ -0,0 +1,246 @@
+// Copyright (c) 2015, Nick Patavalis (npat@efault.net).
+// All rights reserved.
+// Use of this source code is governed by a BSD-style license that can
+// be found in the LICENSE file.
+
+package zmodem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/npat-efault/serial/internal/pipe"
+)
+
+var bg = context.Background()
+
+func testData(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+// received collects the files received
+type received struct {
+	files []*File
+	data  []*bytes.Buffer
+}
+
+func (r *received) create(f *File) (io.Writer, int64, error) {
+	r.files = append(r.files, f)
+	r.data = append(r.data, new(bytes.Buffer))
+	return r.data[len(r.data)-1], 0, nil
+}
+
+// run sends files from s to r, and returns the files received
+func run(t *testing.T, s, r *Transfer, files []File,
+	create func(f *File) (io.Writer, int64, error)) {
+
+	errc := make(chan error, 1)
+	go func() { errc <- s.Send(bg, files) }()
+	if err := r.Receive(bg, create); err != nil {
+		t.Fatal("Receive:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("Send:", err)
+	}
+}
+
+func TestHeader(t *testing.T) {
+	b := hexHeader(header{typ: zrqinit})
+	if string(b) != "**\x18B00000000000000\r\x8a\x11" {
+		t.Fatalf("ZRQINIT: %q", b)
+	}
+	for _, crc32 := range []bool{false, true} {
+		ps, pr := pipe.New()
+		c := NewTransfer(ps).newConn(bg)
+		c.crc32 = crc32
+		h := posHeader(zdata, 0x1318117f)
+		b := c.binHeader(h)
+		b = c.subpacket(b, []byte("\x18\x10\x11\x13\x7f\xff@\r"), zcrcw)
+		ps.Write(append([]byte("junk*"), b...))
+
+		c = NewTransfer(pr).newConn(bg)
+		g, rx32, err := c.readHeader(time.Now().Add(time.Second))
+		if err != nil || g != h || rx32 != crc32 {
+			t.Fatalf("Header: %+v, %v, %v", g, rx32, err)
+		}
+		dl := time.Now().Add(time.Second)
+		d, end, err := c.readSubpacket(rx32, dl)
+		if string(d) != "\x18\x10\x11\x13\x7f\xff@\r" || end != zcrcw ||
+			err != nil {
+			t.Fatalf("Subpacket: %q, %c, %v", d, end, err)
+		}
+	}
+
+	// Escaping
+	c := NewTransfer(nil).newConn(bg)
+	b = c.escape(nil, 0x18, 0x11, 0x91, 'a', '@', '\r', 0x01)
+	if string(b) != "\x18X\x18Q\x18\xd1a@\x18M\x01" {
+		t.Fatalf("Escaped: %q", b)
+	}
+	c.escctl = true
+	if b = c.escape(nil, 0x01, 0x7f); string(b) != "\x18A\x7f" {
+		t.Fatalf("Escaped (ctl): %q", b)
+	}
+}
+
+func TestTransfer(t *testing.T) {
+	mtime := time.Unix(1437000000, 0)
+	files := []File{
+		{Name: "a.bin", Size: 20000, ModTime: mtime, Mode: 0600,
+			Data: bytes.NewReader(testData(20000))},
+		{Name: "empty", Size: 0},
+		{Name: "b.bin", Size: -1,
+			Data: bytes.NewReader(testData(1000))},
+	}
+	for _, tst := range []struct {
+		window, block int
+		escctl        bool
+	}{
+		{0, 0, false},
+		{4096, 512, false},
+		{0, 8192, true},
+	} {
+		ps, pr := pipe.New()
+		s, r := NewTransfer(ps), NewTransfer(pr)
+		s.Window, s.BlockSize = tst.window, tst.block
+		r.EscapeCtl = tst.escctl
+		var last int64
+		s.Progress = func(name string, pos, size int64) {
+			if name == "a.bin" {
+				last = pos
+			}
+		}
+		var rcv received
+		run(t, s, r, files, rcv.create)
+
+		if len(rcv.files) != 3 {
+			t.Fatalf("%+v: Received %d files", tst, len(rcv.files))
+		}
+		f := rcv.files[0]
+		if f.Name != "a.bin" || f.Size != 20000 ||
+			!f.ModTime.Equal(mtime) || f.Mode != 0600 ||
+			!bytes.Equal(rcv.data[0].Bytes(), testData(20000)) {
+			t.Fatalf("%+v: File 0: %+v, %d bytes", tst, f,
+				rcv.data[0].Len())
+		}
+		if f = rcv.files[1]; f.Name != "empty" || f.Size != 0 ||
+			rcv.data[1].Len() != 0 {
+			t.Fatalf("%+v: File 1: %+v", tst, f)
+		}
+		if f = rcv.files[2]; f.Name != "b.bin" || f.Size != -1 ||
+			!bytes.Equal(rcv.data[2].Bytes(), testData(1000)) {
+			t.Fatalf("%+v: File 2: %+v", tst, f)
+		}
+		if last != 20000 {
+			t.Fatalf("%+v: Progress: %d", tst, last)
+		}
+		for i := range files {
+			files[i].Data.Seek(0, io.SeekStart)
+		}
+	}
+}
+
+func TestResume(t *testing.T) {
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	data := testData(10000)
+	files := []File{{Name: "a", Size: 10000,
+		Data: bytes.NewReader(data)}}
+	var first int64 = -1
+	s.Progress = func(name string, pos, size int64) {
+		if first < 0 {
+			first = pos
+		}
+	}
+	buf := bytes.NewBuffer(append([]byte(nil), data[:3000]...))
+	run(t, s, r, files, func(f *File) (io.Writer, int64, error) {
+		return buf, int64(buf.Len()), nil
+	})
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("Received %d bytes", buf.Len())
+	}
+	if first != 3000+DefaultBlockSize {
+		t.Fatalf("Started at %d", first)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	files := []File{
+		{Name: "skip", Size: 100, Data: bytes.NewReader(testData(100))},
+		{Name: "keep", Size: 200, Data: bytes.NewReader(testData(200))},
+	}
+	var rcv received
+	run(t, s, r, files, func(f *File) (io.Writer, int64, error) {
+		if f.Name == "skip" {
+			return nil, 0, ErrSkip
+		}
+		return rcv.create(f)
+	})
+	if len(rcv.files) != 1 || rcv.files[0].Name != "keep" ||
+		!bytes.Equal(rcv.data[0].Bytes(), testData(200)) {
+		t.Fatalf("Received %d files", len(rcv.files))
+	}
+}
+
+func TestErrors(t *testing.T) {
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	s.Timeout, r.Timeout = 200*time.Millisecond, 200*time.Millisecond
+	s.BlockSize = 256
+	// Corrupt some data subpackets
+	var n int
+	ps.Corrupt = func(b []byte) {
+		if n++; n == 5 || n == 6 || n == 20 {
+			b[len(b)/2] ^= 0x01
+		}
+	}
+	data := testData(10000)
+	files := []File{{Name: "a", Size: 10000,
+		Data: bytes.NewReader(data)}}
+	var rcv received
+	run(t, s, r, files, rcv.create)
+	if !bytes.Equal(rcv.data[0].Bytes(), data) {
+		t.Fatalf("Received %d bytes", rcv.data[0].Len())
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// Receiver aborts
+	ps, pr := pipe.New()
+	s, r := NewTransfer(ps), NewTransfer(pr)
+	errc := make(chan error, 1)
+	files := []File{{Name: "a", Size: 100,
+		Data: bytes.NewReader(testData(100))}}
+	go func() { errc <- s.Send(bg, files) }()
+	errDisk := errors.New("disk full")
+	err := r.Receive(bg, func(f *File) (io.Writer, int64, error) {
+		return nil, 0, errDisk
+	})
+	if err != errDisk {
+		t.Fatalf("Receive: %v", err)
+	}
+	if err := <-errc; err != ErrCanceled {
+		t.Fatalf("Send: %v", err)
+	}
+
+	// Canceled by context
+	_, pr = pipe.New()
+	r = NewTransfer(pr)
+	ctx, cancel := context.WithCancel(bg)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = r.Receive(ctx, func(f *File) (io.Writer, int64, error) {
+		return nil, 0, ErrSkip
+	})
+	if err != context.Canceled {
+		t.Fatalf("Receive: %v", err)
+	}
+}